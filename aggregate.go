@@ -0,0 +1,360 @@
+package samql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// aggregator accumulates a value across multiple records.
+type aggregator interface {
+	// add adds the value of the function argument for a record to the
+	// aggregate. The value is nil for count(*).
+	add(v interface{})
+	// value returns the current value of the aggregate.
+	value() interface{}
+}
+
+// aggregatorFunc returns a new aggregator. It is used to create independent
+// aggregators for the same SELECT field.
+type aggregatorFunc func() aggregator
+
+// aggregates associates the supported aggregate function names with their
+// aggregatorFunc. The function arguments are evaluated by the Query, so that
+// the records of several inputs, with their keywords bound by Bind, are
+// added to the same aggregators.
+var aggregates = map[string]aggregatorFunc{
+	"count": func() aggregator { return &countAgg{} },
+	"sum":   func() aggregator { return &sumAgg{} },
+	"mean":  func() aggregator { return &meanAgg{} },
+	"min":   func() aggregator { return &extremeAgg{less: true} },
+	"max":   func() aggregator { return &extremeAgg{} },
+}
+
+// isAggregate returns true if expr is a call to an aggregate function. Calls
+// with an array tag argument, e.g. sum(ZC:B), are record level functions.
+func isAggregate(expr ql.Expr) bool {
+	c, ok := expr.(*ql.Call)
+	if !ok {
+		return false
+	}
+	if len(c.Args) == 1 {
+		if ref, ok := c.Args[0].(*ql.VarRef); ok && isArrayTag(ref.Val) {
+			return false
+		}
+	}
+	_, ok = aggregates[c.Cmd]
+	return ok
+}
+
+// isArrayTag returns true if name is a tag of type B, e.g. ZC:B.
+func isArrayTag(name string) bool {
+	return len(name) == 4 && name[3] == 'B' && validTag.MatchString(name)
+}
+
+// newAggregatorFunc returns an aggregatorFunc for the aggregate function call
+// c, the valueFunc of its argument, which is nil for count(*), and the type
+// of its values. Variable references in the argument that match a key in
+// vars are resolved to the corresponding value.
+func newAggregatorFunc(c *ql.Call, vars map[string]interface{}) (aggregatorFunc, valueFunc, ColumnType, error) {
+	if len(c.Args) != 1 {
+		return nil, nil, UnknownColumn, fmt.Errorf("samql: %s expects 1 argument, got %d",
+			c.Cmd, len(c.Args))
+	}
+
+	// Only count accepts DISTINCT, e.g. count(DISTINCT UMI).
+	if c.Distinct {
+		if c.Cmd != "count" {
+			return nil, nil, UnknownColumn, fmt.Errorf("samql: %s does not accept DISTINCT", c.Cmd)
+		}
+		if _, ok := c.Args[0].(*ql.Wildcard); ok {
+			return nil, nil, UnknownColumn, fmt.Errorf("samql: count does not accept DISTINCT *")
+		}
+		arg, err := newValueFunc(c.Args[0], vars)
+		if err != nil {
+			return nil, nil, UnknownColumn, err
+		}
+		return func() aggregator {
+			return &distinctAgg{seen: make(map[string]bool)}
+		}, arg, IntColumn, nil
+	}
+
+	// Only count accepts a wildcard argument.
+	if _, ok := c.Args[0].(*ql.Wildcard); ok {
+		if c.Cmd != "count" {
+			return nil, nil, UnknownColumn, fmt.Errorf("samql: %s does not accept *", c.Cmd)
+		}
+		return aggregates[c.Cmd], nil, IntColumn, nil
+	}
+
+	arg, typ, err := newTypedValueFunc(c.Args[0], vars)
+	if err != nil {
+		return nil, nil, UnknownColumn, err
+	}
+	// Booleans are summed as 1 and 0, e.g. sum(REVERSE).
+	if (c.Cmd == "sum" || c.Cmd == "mean") && typ == StringColumn {
+		return nil, nil, UnknownColumn, fmt.Errorf("samql: %s requires a number, found %s %s",
+			c.Cmd, typ, c.Args[0])
+	}
+	return aggregates[c.Cmd], arg, aggregateType(c.Cmd, typ), nil
+}
+
+// aggregateType returns the type of the values of the aggregate function name
+// for an argument of type arg.
+func aggregateType(name string, arg ColumnType) ColumnType {
+	switch name {
+	case "count":
+		return IntColumn
+	case "mean":
+		return DoubleColumn
+	case "sum":
+		if arg == IntColumn {
+			return IntColumn
+		}
+		return DoubleColumn
+	}
+	return arg
+}
+
+// Aggregation accumulates the aggregate functions of a Query across records.
+// Records are grouped by the GROUP BY dimensions of the query, if any.
+type Aggregation struct {
+	q      *Query
+	groups map[string]*group
+	order  []*group // groups in the order they were first encountered.
+}
+
+// group holds the aggregators for records that share the same dimension
+// values.
+type group struct {
+	keys []interface{}
+	aggs []aggregator
+}
+
+// NewAggregation returns a new Aggregation for the aggregate functions of q.
+func (q *Query) NewAggregation() *Aggregation {
+	return &Aggregation{q: q, groups: make(map[string]*group)}
+}
+
+// Add adds rec to the aggregates of the group that rec belongs to. Records
+// of new groups are ignored once the groups have reached the LIMIT of the
+// query.
+func (a *Aggregation) Add(rec *sam.Record) {
+	a.AddWith(a.q, rec)
+}
+
+// AddWith is similar to Add but evaluates the GROUP BY dimensions and the
+// arguments of the aggregate functions with q, which must be the query of a
+// or a copy of it returned by Bind. It is used to aggregate the records of
+// several inputs, each with the query bound to its input.
+func (a *Aggregation) AddWith(q *Query, rec *sam.Record) {
+	keys := make([]interface{}, len(q.dims))
+	for i, fn := range q.dims {
+		keys[i] = fn(rec)
+	}
+
+	g := a.group(keys)
+	if g == nil {
+		return
+	}
+	for i, agg := range g.aggs {
+		if agg == nil {
+			continue
+		}
+		var v interface{}
+		if arg := q.args[i]; arg != nil {
+			v = arg(rec)
+		}
+		agg.add(v)
+	}
+}
+
+// group returns the group for the dimension values keys, creating it if it
+// does not exist. It returns nil if the group does not exist and the groups
+// have reached the LIMIT of the query.
+func (a *Aggregation) group(keys []interface{}) *group {
+	k := groupKey(keys)
+	if g, ok := a.groups[k]; ok {
+		return g
+	}
+
+	if limit := a.q.Limit(); limit > 0 && len(a.order) >= limit {
+		return nil
+	}
+	g := &group{keys: keys, aggs: make([]aggregator, len(a.q.aggs))}
+	for i, fn := range a.q.aggs {
+		if fn != nil {
+			g.aggs[i] = fn()
+		}
+	}
+	a.groups[k] = g
+	a.order = append(a.order, g)
+	return g
+}
+
+// Rows returns the result of the aggregation. Each row holds one value for
+// each selected column and corresponds to one group. Groups are returned in
+// the order they were first encountered, up to the LIMIT of the query. Without
+// GROUP BY a single row is returned, even if no records were added.
+func (a *Aggregation) Rows() [][]interface{} {
+	if len(a.q.dims) == 0 && len(a.order) == 0 {
+		a.group(nil)
+	}
+
+	rows := make([][]interface{}, len(a.order))
+	for r, g := range a.order {
+		row := make([]interface{}, len(g.aggs))
+		for i, agg := range g.aggs {
+			switch {
+			case agg != nil:
+				row[i] = agg.value()
+			case a.q.dimIdx[i] >= 0:
+				row[i] = g.keys[a.q.dimIdx[i]]
+			default:
+				row[i] = a.q.columns[i](nil)
+			}
+		}
+		rows[r] = row
+	}
+	return rows
+}
+
+// groupKey returns a string that uniquely identifies the dimension values
+// keys.
+func groupKey(keys []interface{}) string {
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%T:%v\x00", k, k)
+	}
+	return b.String()
+}
+
+// countAgg counts records.
+type countAgg struct {
+	n int
+}
+
+func (a *countAgg) add(v interface{})  { a.n++ }
+func (a *countAgg) value() interface{} { return a.n }
+
+// distinctAgg counts distinct values.
+type distinctAgg struct {
+	seen map[string]bool
+}
+
+func (a *distinctAgg) add(v interface{}) {
+	a.seen[groupKey([]interface{}{v})] = true
+}
+
+func (a *distinctAgg) value() interface{} { return len(a.seen) }
+
+// sumAgg sums numeric values. The sum is an integer if all values are
+// integers.
+type sumAgg struct {
+	isum    int
+	fsum    float64
+	isFloat bool
+}
+
+func (a *sumAgg) add(v interface{}) {
+	switch v := v.(type) {
+	case int:
+		a.isum += v
+	default:
+		f, _ := toFloat(v)
+		a.fsum += f
+		a.isFloat = true
+	}
+}
+
+func (a *sumAgg) value() interface{} {
+	if a.isFloat {
+		return a.fsum + float64(a.isum)
+	}
+	return a.isum
+}
+
+// meanAgg calculates the arithmetic mean of numeric values. The mean of no
+// values is nil, as the minimum of no values.
+type meanAgg struct {
+	sum float64
+	n   int
+}
+
+func (a *meanAgg) add(v interface{}) {
+	f, _ := toFloat(v)
+	a.sum += f
+	a.n++
+}
+
+func (a *meanAgg) value() interface{} {
+	if a.n == 0 {
+		return nil
+	}
+	return a.sum / float64(a.n)
+}
+
+// extremeAgg keeps the minimum, if less is true, or maximum value. The
+// extreme of no values is nil.
+type extremeAgg struct {
+	less bool
+	val  interface{}
+}
+
+func (a *extremeAgg) add(v interface{}) {
+	if a.val == nil {
+		a.val = v
+		return
+	}
+	if c := compareValues(v, a.val); (a.less && c < 0) || (!a.less && c > 0) {
+		a.val = v
+	}
+}
+
+func (a *extremeAgg) value() interface{} { return a.val }
+
+// toFloat converts the numeric value v to float64. It returns false if v is
+// not numeric.
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// compareValues compares a and b and returns -1, 0 or 1 if a is less than,
+// equal to or greater than b. Numbers are compared numerically and all other
+// values by their string representation.
+func compareValues(a, b interface{}) int {
+	if sa, ok := a.(string); ok {
+		if sb, ok := b.(string); ok {
+			return strings.Compare(sa, sb)
+		}
+	}
+	fa, okA := toFloat(a)
+	fb, okB := toFloat(b)
+	if okA && okB {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
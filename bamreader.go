@@ -0,0 +1,262 @@
+package samql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/sam"
+)
+
+// BAMReader reads the records of a BAM file. Unlike bam.Reader it can decode
+// records into existing sam.Records with ReadInto, reusing the memory of
+// their fields, so that reading does not allocate for each record. It is
+// used by OpenSource for BAM files.
+type BAMReader struct {
+	r         *bgzf.Reader
+	h         *sam.Header
+	buf       []byte
+	lastChunk bgzf.Chunk
+}
+
+// The BAMReader satisfies Source.
+var _ Source = (*BAMReader)(nil)
+
+// NewBAMReader returns a new BAMReader that reads from r with read
+// concurrency rd. If rd is zero concurrency is set to GOMAXPROCS, as with
+// bam.NewReader.
+func NewBAMReader(r io.Reader, rd int) (*BAMReader, error) {
+	bg, err := bgzf.NewReader(r, rd)
+	if err != nil {
+		return nil, err
+	}
+	h, _ := sam.NewHeader(nil, nil)
+	if err := h.DecodeBinary(bg); err != nil {
+		return nil, err
+	}
+	br := &BAMReader{r: bg, h: h, buf: make([]byte, 0x1000)}
+	br.lastChunk.End = bg.LastChunk().End
+	return br, nil
+}
+
+// Header returns the SAM header of br.
+func (br *BAMReader) Header() *sam.Header {
+	return br.h
+}
+
+// Read returns the next record of br. It returns nil and io.EOF when br is
+// exhausted.
+func (br *BAMReader) Read() (*sam.Record, error) {
+	rec := &sam.Record{}
+	if err := br.ReadInto(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// ReadInto decodes the next record of br into rec, overwriting all its
+// fields. The Cigar, Seq, Qual and AuxFields of rec are decoded into their
+// existing memory, if large enough, so rec must not be retained, nor share
+// those slices with other records, across calls. It returns io.EOF when br
+// is exhausted.
+func (br *BAMReader) ReadInto(rec *sam.Record) error {
+	b, err := br.block()
+	if err != nil {
+		return err
+	}
+	if len(b) < bamFixedSize {
+		return errShortBlock
+	}
+
+	refID := int32(binary.LittleEndian.Uint32(b[0:]))
+	rec.Pos = int(int32(binary.LittleEndian.Uint32(b[4:])))
+	nLen := int(b[8])
+	rec.MapQ = b[9]
+	nCigar := int(binary.LittleEndian.Uint16(b[12:]))
+	rec.Flags = sam.Flags(binary.LittleEndian.Uint16(b[14:]))
+	lSeq := int(int32(binary.LittleEndian.Uint32(b[16:])))
+	nextRefID := int32(binary.LittleEndian.Uint32(b[20:]))
+	rec.MatePos = int(int32(binary.LittleEndian.Uint32(b[24:])))
+	rec.TempLen = int(int32(binary.LittleEndian.Uint32(b[28:])))
+
+	if nLen < 1 {
+		return fmt.Errorf("samql: invalid BAM read name length: %d", nLen)
+	}
+	if lSeq < 0 {
+		return fmt.Errorf("samql: invalid BAM sequence length: %d", lSeq)
+	}
+	b = b[bamFixedSize:]
+	if len(b) < nLen+4*nCigar+(lSeq+1)/2+lSeq {
+		return errTruncatedRecord
+	}
+
+	// Comparing the name with the string conversion does not allocate, so
+	// the name is kept if unchanged, e.g. for the mates of a pair.
+	if name := b[:nLen-1]; rec.Name != string(name) {
+		rec.Name = string(name)
+	}
+	b = b[nLen:]
+
+	rec.Cigar = rec.Cigar[:0]
+	for i := 0; i < nCigar; i++ {
+		rec.Cigar = append(rec.Cigar, sam.CigarOp(binary.LittleEndian.Uint32(b[4*i:])))
+	}
+	b = b[4*nCigar:]
+
+	rec.Seq.Length = lSeq
+	rec.Seq.Seq = rec.Seq.Seq[:0]
+	for _, d := range b[:(lSeq+1)/2] {
+		rec.Seq.Seq = append(rec.Seq.Seq, sam.Doublet(d))
+	}
+	b = b[(lSeq+1)/2:]
+	rec.Qual = append(rec.Qual[:0], b[:lSeq]...)
+	b = b[lSeq:]
+
+	if rec.AuxFields, err = decodeAux(rec.AuxFields, b); err != nil {
+		return err
+	}
+
+	refs := br.h.Refs()
+	if rec.Ref, err = refByID(refs, refID); err != nil {
+		return err
+	}
+	if rec.MateRef, err = refByID(refs, nextRefID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LastChunk returns the bgzf.Chunk of the record of the last Read or
+// ReadInto. It is only valid if that returned a nil error.
+func (br *BAMReader) LastChunk() bgzf.Chunk {
+	return br.lastChunk
+}
+
+// Close closes br. It does not close the io.Reader of br.
+func (br *BAMReader) Close() error {
+	return br.r.Close()
+}
+
+// The errors of BAM records whose data are shorter than their sizes, e.g. in
+// a truncated or corrupt file. They are corrupt input errors of Reader.
+var (
+	errShortBlock      = errors.New("samql: invalid BAM record: short block")
+	errShortBlockSize  = errors.New("samql: invalid BAM record: short block size")
+	errBlockSize       = errors.New("samql: invalid BAM record: invalid block size")
+	errTruncatedRecord = errors.New("samql: truncated BAM record")
+)
+
+// bamFixedSize is the size of the fixed length fields of a BAM record that
+// follow the block size.
+const bamFixedSize = 32
+
+// block reads the next record into the buffer of br and returns its data,
+// which is valid until the next call.
+func (br *BAMReader) block() ([]byte, error) {
+	n, err := io.ReadFull(br.r, br.buf[:4])
+	// The chunk is only valid after the first read of the record.
+	tx := br.r.Begin()
+	defer func() {
+		br.lastChunk = tx.End()
+	}()
+	if err != nil {
+		return nil, err
+	}
+	if n != 4 {
+		return nil, errShortBlockSize
+	}
+	size := int(int32(binary.LittleEndian.Uint32(br.buf)))
+	if size == 0 {
+		return nil, io.EOF
+	}
+	if size < 0 {
+		return nil, errBlockSize
+	}
+	if size > cap(br.buf) {
+		br.buf = make([]byte, size)
+	}
+	if _, err := io.ReadFull(br.r, br.buf[:size]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return br.buf[:size], nil
+}
+
+// refByID returns the reference of refs with BAM id, or nil for -1.
+func refByID(refs []*sam.Reference, id int32) (*sam.Reference, error) {
+	if id == -1 {
+		return nil, nil
+	}
+	if id < -1 || int(id) >= len(refs) {
+		return nil, errors.New("samql: BAM reference id out of range")
+	}
+	return refs[id], nil
+}
+
+// auxSizes are the sizes of the values of the fixed size aux types. Zero
+// terminated and array types are -1.
+var auxSizes = [256]int{
+	'A': 1,
+	'c': 1, 'C': 1,
+	's': 2, 'S': 2,
+	'i': 4, 'I': 4,
+	'f': 4,
+	'Z': -1,
+	'H': -1,
+	'B': -1,
+}
+
+// decodeAux decodes the binary aux data into aa, as bam.Reader does. The
+// data is copied into the memory that backs aa, if large enough. The fields
+// are sliced from a single array with its whole capacity so that the array
+// can be recovered from the first field on the next call.
+func decodeAux(aa []sam.Aux, data []byte) ([]sam.Aux, error) {
+	var backing []byte
+	if len(aa) > 0 {
+		backing = aa[0][:0]
+	}
+	aa = aa[:0]
+	if len(data) == 0 {
+		return aa, nil
+	}
+	backing = append(backing, data...)
+
+	for i := 0; i+2 < len(backing); {
+		t := backing[i+2]
+		switch j := auxSizes[t]; {
+		case j > 0:
+			j += 3
+			if i+j > len(backing) {
+				return nil, fmt.Errorf("samql: truncated aux data for type %q", t)
+			}
+			aa = append(aa, sam.Aux(backing[i:i+j]))
+			i += j
+		case j < 0 && (t == 'Z' || t == 'H'):
+			j := bytes.IndexByte(backing[i:], 0)
+			if j == -1 {
+				return nil, errors.New("samql: invalid zero terminated aux data: no zero")
+			}
+			aa = append(aa, sam.Aux(backing[i:i+j]))
+			i += j + 1
+		case j < 0:
+			if i+8 > len(backing) {
+				return nil, errors.New("samql: truncated aux array")
+			}
+			length := binary.LittleEndian.Uint32(backing[i+4:])
+			j = int(length)*auxSizes[backing[i+3]] + 8
+			if auxSizes[backing[i+3]] <= 0 || j < 0 || i+j > len(backing) {
+				return nil, fmt.Errorf("samql: invalid array length for aux data: %d", length)
+			}
+			aa = append(aa, sam.Aux(backing[i:i+j]))
+			i += j
+		default:
+			return nil, fmt.Errorf("samql: unrecognised optional field type: %q", t)
+		}
+	}
+	return aa, nil
+}
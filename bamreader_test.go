@@ -0,0 +1,206 @@
+package samql
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+// auxSamData has records with aux tags of all types.
+const auxSamData = `@SQ	SN:chr1	LN:45
+r001	99	chr1	7	30	8M2I4M1D3M	=	37	39	TTAGATAAAGGATACTG	IIIIIIIIIIIIIIIII	NM:i:1	XA:A:x	XC:i:-3	XS:i:300	XI:i:70000	XF:f:0.5	MD:Z:TAT	XH:H:1AE301	ZB:B:c,1,-2	ZS:B:S,1,2,3	ZF:B:f,1.5,2.5
+r002	0	chr1	9	30	3S6M1P1I4M	*	0	0	AAAAGATAAGGATA	*
+r003	4	*	0	0	*	*	0	0	*	*	MD:Z:T
+`
+
+// newBAM returns the SAM data encoded as BAM, with each record repeated n times.
+func newBAM(t testing.TB, data string, n int) []byte {
+	sr, err := sam.NewReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	bw, err := bam.NewWriter(&buf, sr.Header(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		rec, err := sr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if err := bw.Write(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBAMReaderReadInto(t *testing.T) {
+	for _, tt := range []struct {
+		Test string
+		Data string
+	}{
+		{Test: "Records", Data: samData},
+		{Test: "Aux", Data: auxSamData},
+	} {
+		data := newBAM(t, tt.Data, 1)
+		want, err := bam.NewReader(bytes.NewReader(data), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := NewBAMReader(bytes.NewReader(data), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Header().Refs()) != len(want.Header().Refs()) {
+			t.Errorf("%s: references=%d want %d", tt.Test,
+				len(got.Header().Refs()), len(want.Header().Refs()))
+		}
+
+		// All records are decoded into rec to test that no field of a
+		// previous record is carried over.
+		var rec sam.Record
+		for i := 0; ; i++ {
+			wrec, werr := want.Read()
+			err := got.ReadInto(&rec)
+			if err != werr {
+				t.Fatalf("%s: record %d: error=%v want %v", tt.Test, i, err, werr)
+			}
+			if err != nil {
+				break
+			}
+			gtxt, _ := rec.MarshalText()
+			wtxt, _ := wrec.MarshalText()
+			if string(gtxt) != string(wtxt) {
+				t.Errorf("%s: record %d:\n%s\nwant\n%s", tt.Test, i, gtxt, wtxt)
+			}
+			if rec.Ref.Name() != wrec.Ref.Name() || rec.MateRef.Name() != wrec.MateRef.Name() {
+				t.Errorf("%s: record %d: references differ", tt.Test, i)
+			}
+			if got.LastChunk() != want.LastChunk() {
+				t.Errorf("%s: record %d: chunk=%v want %v", tt.Test, i, got.LastChunk(), want.LastChunk())
+			}
+		}
+		got.Close()
+		want.Close()
+	}
+}
+
+func TestBAMReaderAllocs(t *testing.T) {
+	br, err := NewBAMReader(bytes.NewReader(newBAM(t, auxSamData[:strings.Index(auxSamData, "r002")], 1000)), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer br.Close()
+
+	var rec sam.Record
+	if err := br.ReadInto(&rec); err != nil {
+		t.Fatal(err)
+	}
+	allocs := testing.AllocsPerRun(500, func() {
+		if err := br.ReadInto(&rec); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("allocations per record=%v want 0", allocs)
+	}
+}
+
+func TestReaderReadInto(t *testing.T) {
+	dir, err := ioutil.TempDir("", "samql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bam")
+	if err := ioutil.WriteFile(path, newBAM(t, samData, 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Test string
+		Open func() (Source, error)
+	}{
+		{Test: "BAM", Open: func() (Source, error) { return OpenSource(path) }},
+		{Test: "SAM", Open: func() (Source, error) { return OpenSource("mem://foo") }},
+	} {
+		src, err := tt.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := Where("RNAME = 'chr1'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewReader(src)
+		r.AppendFilter(filter)
+		var last Progress
+		r.OnProgress = func(p Progress) { last = p }
+
+		var names []string
+		var rec sam.Record
+		for {
+			err := r.ReadInto(&rec)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: unexpected error %q", tt.Test, err)
+			}
+			names = append(names, rec.Name)
+		}
+		if got, want := strings.Join(names, ","), "r001,r002,r003,r001"; got != want {
+			t.Errorf("%s: records=%s want %s", tt.Test, got, want)
+		}
+		if !last.Done || last.Read != 8 || last.Matched != 4 {
+			t.Errorf("%s: progress=%+v", tt.Test, last)
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("%s: unexpected close error %q", tt.Test, err)
+		}
+	}
+}
+
+func BenchmarkBAMReaderRead(b *testing.B) {
+	data := newBAM(b, samData, 1000)
+	b.Run("Read", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			br, _ := NewBAMReader(bytes.NewReader(data), 1)
+			for {
+				if _, err := br.Read(); err != nil {
+					break
+				}
+			}
+		}
+	})
+	b.Run("ReadInto", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			br, _ := NewBAMReader(bytes.NewReader(data), 1)
+			var rec sam.Record
+			for {
+				if err := br.ReadInto(&rec); err != nil {
+					break
+				}
+			}
+		}
+	})
+}
@@ -1,12 +1,83 @@
 package bamx
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
 	"io"
+	"sort"
+	"strings"
 
 	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/csi"
 	"github.com/biogo/hts/sam"
 )
 
+// index returns the chunks of a BAM file that overlap a region.
+type index interface {
+	Chunks(ref *sam.Reference, beg, end int) ([]bgzf.Chunk, error)
+	// placedEnd returns the end of the last record with a reference and
+	// false if there are none.
+	placedEnd() (bgzf.Offset, bool)
+}
+
+// baiIndex wraps a bam.Index to satisfy index. The Index is nil if the BAM
+// file has no records with a reference.
+type baiIndex struct {
+	*bam.Index
+}
+
+// Chunks returns the chunks that overlap the region [beg, end) of ref.
+func (i baiIndex) Chunks(ref *sam.Reference, beg, end int) ([]bgzf.Chunk, error) {
+	if i.Index == nil {
+		return nil, nil
+	}
+	return i.Index.Chunks(ref, beg, end)
+}
+
+// placedEnd returns the end of the last record with a reference.
+func (i baiIndex) placedEnd() (bgzf.Offset, bool) {
+	if i.Index == nil {
+		return bgzf.Offset{}, false
+	}
+	return lastEnd(i.NumRefs(), func(id int) (bgzf.Chunk, bool) {
+		stats, ok := i.ReferenceStats(id)
+		return stats.Chunk, ok
+	})
+}
+
+// csiIndex wraps a csi.Index to satisfy index.
+type csiIndex struct {
+	*csi.Index
+}
+
+// Chunks returns the chunks that overlap the region [beg, end) of ref.
+func (i csiIndex) Chunks(ref *sam.Reference, beg, end int) ([]bgzf.Chunk, error) {
+	return i.Index.Chunks(ref.ID(), beg, end), nil
+}
+
+// placedEnd returns the end of the last record with a reference.
+func (i csiIndex) placedEnd() (bgzf.Offset, bool) {
+	return lastEnd(i.NumRefs(), func(id int) (bgzf.Chunk, bool) {
+		stats, ok := i.ReferenceStats(id)
+		return stats.Chunk, ok
+	})
+}
+
+// lastEnd returns the largest end of the chunks of the records of n
+// references, as given by chunk, and false if no reference has records.
+func lastEnd(n int, chunk func(id int) (bgzf.Chunk, bool)) (bgzf.Offset, bool) {
+	var end bgzf.Offset
+	found := false
+	for id := 0; id < n; id++ {
+		if c, ok := chunk(id); ok && (!found || less(end, c.End)) {
+			end, found = c.End, true
+		}
+	}
+	return end, found
+}
+
 type query struct {
 	rname      string
 	start, end int
@@ -17,45 +88,219 @@ type query struct {
 // safe to query from multiple go routines.
 type Reader struct {
 	*bam.Reader
-	idx   *bam.Index
-	refs  map[string]*sam.Reference
-	query query
-	iter  *bam.Iterator
+	idx     index
+	refs    map[string]*sam.Reference
+	queries []query
+	chunks  []bgzf.Chunk
+	iter    *bam.Iterator
+	scan    bool // read the whole file, as all records are unplaced
+
+	// ChrAlias, if true, matches the reference names of queries that are
+	// not in the header with the same name with or without the chr prefix,
+	// as ChrAlias, e.g. the query chr1 reads reference 1.
+	ChrAlias bool
 }
 
 // New returns a new Reader that encapsulates a bam reader r and an index read
-// from idxio.
+// from idxio. The index can be either BAI or CSI and is detected from its
+// content. CSI indexes should be used for references longer than 2^29 bases.
 func New(br *bam.Reader, idxio io.Reader) (*Reader, error) {
-	idx, err := bam.ReadIndex(idxio)
+	idx, err := ReadIndex(idxio)
 	if err != nil {
 		return nil, err
 	}
-	bx := &Reader{Reader: br, idx: idx}
+	return NewWithIndex(br, idx), nil
+}
+
+// NewWithIndex returns a new Reader that encapsulates a bam reader r and an
+// index that was read before, e.g. to share the index among the readers of
+// the same file.
+func NewWithIndex(br *bam.Reader, idx *Index) *Reader {
+	bx := &Reader{Reader: br, idx: idx.index}
 
 	bx.refs = make(map[string]*sam.Reference)
 	for _, r := range br.Header().Refs() {
 		bx.refs[r.Name()] = r
 	}
-	return bx, nil
+	return bx
+}
+
+// Index is a BAI or CSI index of a BAM file. An Index is not modified by the
+// Readers that use it and can be shared by Readers in multiple go routines.
+type Index struct {
+	index
+}
+
+// ReadIndex reads a BAI or a CSI index from r. The format is detected from
+// its content.
+func ReadIndex(r io.Reader) (*Index, error) {
+	idx, err := readIndex(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Index{idx}, nil
+}
+
+// readIndex reads a BAI or a CSI index from r. CSI indexes are usually BGZF
+// compressed but are also read uncompressed.
+func readIndex(r io.Reader) (index, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("bamx: cannot read index: %v", err)
+	}
+	switch {
+	case bytes.Equal(magic, []byte("BAI\x01")):
+		idx, err := bam.ReadIndex(br)
+		if err != nil {
+			return nil, err
+		}
+		return baiIndex{idx}, nil
+	case magic[0] == 0x1f && magic[1] == 0x8b: // BGZF compressed CSI.
+		bg, err := bgzf.NewReader(br, 1)
+		if err != nil {
+			return nil, err
+		}
+		defer bg.Close()
+		return readCSI(bg)
+	case bytes.Equal(magic, []byte("CSI\x01")):
+		return readCSI(br)
+	}
+	return nil, fmt.Errorf("bamx: unknown index format")
+}
+
+// readCSI reads an uncompressed CSI index from r.
+func readCSI(r io.Reader) (index, error) {
+	idx, err := csi.ReadFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	return csiIndex{idx}, nil
 }
 
 // Read returns the next *sam.Record from r that passes all filters. Returns
 // nil and io.EOF when r is exhausted.
 func (b *Reader) Read() (*sam.Record, error) {
-	if b.iter == nil {
+	if len(b.queries) == 0 || b.scan {
 		return b.Reader.Read()
 	}
+	if b.iter == nil {
+		// Queries with no chunks do not overlap any records.
+		if len(b.chunks) == 0 {
+			return nil, io.EOF
+		}
+		var err error
+		b.chunks = mergeChunks(b.chunks)
+		if b.iter, err = bam.NewIterator(b.Reader, b.chunks); err != nil {
+			return nil, err
+		}
+	}
 	if !b.iter.Next() {
 		return nil, io.EOF
 	}
 	return b.iter.Record(), b.iter.Error()
 }
 
-// AddQuery adds a new range query to the indexed BAM.
+// AddQuery adds a new range query to the indexed BAM. If more than one query
+// is added, records overlapping any of the queries are read in file order and
+// each record is read once. Queries should be added before reading. The
+// reference name * queries the unplaced records, as AddUnplacedQuery.
 func (b *Reader) AddQuery(rname string, start, end int) error {
-	b.query = query{rname, start, end}
+	if rname == "*" {
+		return b.AddUnplacedQuery()
+	}
+	ref, ok := b.refs[rname]
+	if !ok && b.ChrAlias {
+		ref, ok = b.refs[ChrAlias(rname)]
+	}
+	if !ok {
+		return &UnknownRefError{Name: rname, Similar: similarRefs(rname, b.Header())}
+	}
+	return b.addQuery(ref, start, end)
+}
+
+// UnknownRefError is returned by AddQuery for a reference name that is not
+// in the header.
+type UnknownRefError struct {
+	Name string
+	// Similar holds the names of the references of the header that the
+	// name likely refers to, e.g. 1 for chr1.
+	Similar []string
+}
+
+// Error returns the reference name and the similar names, if any.
+func (e *UnknownRefError) Error() string {
+	msg := "bamx: unknown reference " + e.Name
+	if len(e.Similar) > 0 {
+		msg += "; did you mean " + strings.Join(e.Similar, " or ") + "?"
+	}
+	return msg
+}
+
+// ChrAlias returns the name of the same reference in the other of the two
+// common naming conventions, i.e. with the chr prefix, as in UCSC, or
+// without it, as in Ensembl, e.g. 1 for chr1, chrX for X and MT for chrM.
+func ChrAlias(name string) string {
+	switch name {
+	case "chrM":
+		return "MT"
+	case "MT":
+		return "chrM"
+	}
+	if strings.HasPrefix(name, "chr") {
+		return name[3:]
+	}
+	return "chr" + name
+}
+
+// similarRefs returns the names of the references of h that differ from name
+// only in case or in the chr prefix.
+func similarRefs(name string, h *sam.Header) []string {
+	var similar []string
+	alias := ChrAlias(name)
+	for _, r := range h.Refs() {
+		if strings.EqualFold(r.Name(), name) || strings.EqualFold(r.Name(), alias) {
+			similar = append(similar, r.Name())
+		}
+	}
+	return similar
+}
+
+// AddQueryByRefID is similar to AddQuery but the reference is given by its
+// id, i.e. its index in the header, as in BAM records. The id -1 queries the
+// unplaced records, as AddUnplacedQuery.
+func (b *Reader) AddQueryByRefID(id, start, end int) error {
+	if id == -1 {
+		return b.AddUnplacedQuery()
+	}
+	refs := b.Header().Refs()
+	if id < 0 || id >= len(refs) {
+		return fmt.Errorf("bamx: unknown reference id %d", id)
+	}
+	return b.addQuery(refs[id], start, end)
+}
+
+// AddUnplacedQuery adds a query of the unplaced unmapped records, which have
+// no reference and are at the end of coordinate sorted files, as the region *
+// of samtools view. They are read without reading the records before them.
+func (b *Reader) AddUnplacedQuery() error {
+	b.queries = append(b.queries, query{rname: "*"})
+	b.iter = nil
+	end, ok := b.idx.placedEnd()
+	if !ok {
+		// All records are unplaced.
+		b.scan = true
+		return nil
+	}
+	// The chunk extends to the largest virtual offset, so the records are
+	// read until the end of the file.
+	b.chunks = append(b.chunks, bgzf.Chunk{Begin: end,
+		End: bgzf.Offset{File: 1<<47 - 1, Block: 1<<16 - 1}})
+	return nil
+}
 
-	ref := b.refs[rname]
+// addQuery adds the range query of ref.
+func (b *Reader) addQuery(ref *sam.Reference, start, end int) error {
 	if start < 0 {
 		start = 0
 	}
@@ -66,8 +311,90 @@ func (b *Reader) AddQuery(rname string, start, end int) error {
 	if err != nil {
 		return err
 	}
-	b.iter, err = bam.NewIterator(b.Reader, chunks)
-	return err
+
+	b.queries = append(b.queries, query{ref.Name(), start, end})
+	b.chunks = append(b.chunks, chunks...)
+	b.iter = nil
+	return nil
+}
+
+// Mate returns the primary alignment of the mate of rec by querying the index
+// at the mate position. It returns nil if the mate is not found. rec must be
+// read from the same file, as the mate reference is found by its ID, so that
+// the references of either header can be renamed. Mate moves the read offset
+// of the underlying bam reader, so it should not be called on a Reader that
+// is also read with Read. A separate Reader for the same file should be used
+// instead.
+func (b *Reader) Mate(rec *sam.Record) (*sam.Record, error) {
+	if rec.MateRef == nil || rec.MatePos < 0 {
+		return nil, nil
+	}
+	refs := b.Header().Refs()
+	id := rec.MateRef.ID()
+	if id < 0 || id >= len(refs) {
+		return nil, nil
+	}
+	ref := refs[id]
+	chunks, err := b.idx.Chunks(ref, rec.MatePos, rec.MatePos+1)
+	if err != nil || len(chunks) == 0 {
+		return nil, err
+	}
+	it, err := bam.NewIterator(b.Reader, chunks)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	for it.Next() {
+		m := it.Record()
+		if m.Ref.Name() != ref.Name() || m.Pos < rec.MatePos {
+			continue
+		}
+		if m.Pos > rec.MatePos {
+			break
+		}
+		if m.Name == rec.Name && isMate(rec, m) {
+			return m, nil
+		}
+	}
+	return nil, it.Error()
+}
+
+// isMate returns true if m is the primary alignment of the mate of rec.
+func isMate(rec, m *sam.Record) bool {
+	const mateFlags = sam.Read1 | sam.Read2
+	return m.Flags&(sam.Secondary|sam.Supplementary) == 0 &&
+		m.Flags&mateFlags != rec.Flags&mateFlags
+}
+
+// mergeChunks sorts chunks by file offset and merges the overlapping ones.
+func mergeChunks(chunks []bgzf.Chunk) []bgzf.Chunk {
+	if len(chunks) < 2 {
+		return chunks
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return less(chunks[i].Begin, chunks[j].Begin)
+	})
+	merged := chunks[:1]
+	for _, c := range chunks[1:] {
+		last := &merged[len(merged)-1]
+		if less(last.End, c.Begin) {
+			merged = append(merged, c)
+			continue
+		}
+		if less(last.End, c.End) {
+			last.End = c.End
+		}
+	}
+	return merged
+}
+
+// less returns true if offset a is before offset b.
+func less(a, b bgzf.Offset) bool {
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	return a.Block < b.Block
 }
 
 // Close closes the underlying bam reader.
@@ -0,0 +1,219 @@
+package bamx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+func TestUnplacedQuery(t *testing.T) {
+	const n = 2000
+	tests := []struct {
+		Test     string
+		Placed   int
+		Unplaced int
+		Query    func(b *Reader) error
+		Names    []string
+		Err      bool
+	}{
+		{
+			Test:     "Unplaced",
+			Placed:   n,
+			Unplaced: 100,
+			Query:    func(b *Reader) error { return b.AddQuery("*", 0, 0) },
+			Names:    testNames("u", 0, 100),
+		},
+		{
+			Test:   "NoUnplaced",
+			Placed: n,
+			Query:  func(b *Reader) error { return b.AddUnplacedQuery() },
+		},
+		{
+			Test:     "OnlyUnplaced",
+			Unplaced: 100,
+			Query:    func(b *Reader) error { return b.AddUnplacedQuery() },
+			Names:    testNames("u", 0, 100),
+		},
+		{
+			Test:     "OnlyUnplacedRegion",
+			Unplaced: 100,
+			Query:    func(b *Reader) error { return b.AddQuery("chr1", 0, 0) },
+		},
+		{
+			Test:     "UnplacedAndRegion",
+			Placed:   n,
+			Unplaced: 100,
+			Query: func(b *Reader) error {
+				if err := b.AddQuery("*", 0, 0); err != nil {
+					return err
+				}
+				return b.AddQuery("chr2", 0, 0)
+			},
+			Names: append(testNames("chr2", 0, n), testNames("u", 0, 100)...),
+		},
+		{
+			Test:     "RefID",
+			Placed:   n,
+			Unplaced: 100,
+			Query:    func(b *Reader) error { return b.AddQueryByRefID(0, 0, 0) },
+			Names:    testNames("chr1", 0, n),
+		},
+		{
+			Test:     "RefIDUnplaced",
+			Placed:   n,
+			Unplaced: 100,
+			Query:    func(b *Reader) error { return b.AddQueryByRefID(-1, 0, 0) },
+			Names:    testNames("u", 0, 100),
+		},
+		{
+			Test:   "RefIDTooLarge",
+			Placed: n,
+			Query:  func(b *Reader) error { return b.AddQueryByRefID(2, 0, 0) },
+			Err:    true,
+		},
+		{
+			Test:   "RefIDNegative",
+			Placed: n,
+			Query:  func(b *Reader) error { return b.AddQueryByRefID(-2, 0, 0) },
+			Err:    true,
+		},
+	}
+	for _, useCSI := range []bool{false, true} {
+		for _, tt := range tests {
+			name := tt.Test + "/BAI"
+			if useCSI {
+				name = tt.Test + "/CSI"
+			}
+			t.Run(name, func(t *testing.T) {
+				data, idx := newTestBAM(t, tt.Placed, tt.Unplaced, useCSI)
+				br, err := bam.NewReader(bytes.NewReader(data), 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b := NewWithIndex(br, idx)
+				defer b.Close()
+
+				err = tt.Query(b)
+				if tt.Err {
+					if err == nil {
+						t.Fatal("expected error")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				names, err := readNames(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if strings.Join(names, " ") != strings.Join(tt.Names, " ") {
+					t.Errorf("expected %d records, got %d", len(tt.Names), len(names))
+				}
+			})
+		}
+	}
+}
+
+// testNames returns the names of the records of newTestBAM from i to j - 1
+// of the reference ref, or of the unplaced records if ref is u.
+func testNames(ref string, i, j int) []string {
+	var names []string
+	for ; i < j; i++ {
+		if ref == "u" {
+			names = append(names, fmt.Sprintf("u:%d", i))
+			continue
+		}
+		names = append(names, fmt.Sprintf("%s:%d", ref, i*testStep))
+	}
+	return names
+}
+
+// testStep is the distance of the records of newTestBAM.
+const testStep = 128
+
+// newTestBAM returns a coordinate sorted BAM file with placed records on
+// each of the references chr1 and chr2, followed by unplaced records, and
+// its BAI or CSI index. Records are 50 bases long and 128 bases apart, so
+// that none crosses the 16kb intervals of the index, and are named by their
+// reference and position, e.g. chr1:256, or u:n for the nth unplaced record.
+func newTestBAM(t testing.TB, placed, unplaced int, useCSI bool) ([]byte, *Index) {
+	var refs []*sam.Reference
+	for _, name := range []string{"chr1", "chr2"} {
+		ref, err := sam.NewReference(name, "", "", 1000000, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, ref)
+	}
+	h, err := sam.NewHeader(nil, refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SortOrder = sam.Coordinate
+
+	var buf bytes.Buffer
+	bw, err := bam.NewWriter(&buf, h, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq := bytes.Repeat([]byte("A"), 50)
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, len(seq))}
+	write := func(name string, ref *sam.Reference, pos int) {
+		co := cigar
+		if ref == nil {
+			co = nil
+		}
+		rec, err := sam.NewRecord(name, ref, nil, pos, -1, 0, 30, co, seq, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ref == nil {
+			rec.Flags = sam.Unmapped
+		}
+		if err := bw.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ref := range refs {
+		for i := 0; i < placed; i++ {
+			write(fmt.Sprintf("%s:%d", ref.Name(), i*testStep), ref, i*testStep)
+		}
+	}
+	for i := 0; i < unplaced; i++ {
+		write(fmt.Sprintf("u:%d", i), nil, -1)
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var idx bytes.Buffer
+	if err := WriteIndex(&idx, bytes.NewReader(buf.Bytes()), useCSI); err != nil {
+		t.Fatal(err)
+	}
+	index, err := ReadIndex(&idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), index
+}
+
+// readNames returns the names of the records read from r until io.EOF.
+func readNames(r *Reader) ([]string, error) {
+	var names []string
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return names, err
+		}
+		names = append(names, rec.Name)
+	}
+}
@@ -0,0 +1,87 @@
+package bamx
+
+import (
+	"io"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/csi"
+	"github.com/biogo/hts/sam"
+)
+
+// maxBAIPos is the largest position that can be indexed by a BAI index.
+const maxBAIPos = 1<<29 - 1
+
+// NeedsCSI returns true if a reference in h is too long to be indexed by a
+// BAI index.
+func NeedsCSI(h *sam.Header) bool {
+	for _, ref := range h.Refs() {
+		if ref.Len() > maxBAIPos {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteIndex reads the coordinate sorted BAM file from r and writes its index
+// to w. The index is CSI if useCSI is true, otherwise BAI. CSI indexes are
+// BGZF compressed and their depth is chosen to fit the longest reference.
+func WriteIndex(w io.Writer, r io.Reader, useCSI bool) error {
+	br, err := bam.NewReader(r, 1)
+	if err != nil {
+		return err
+	}
+	defer br.Close()
+
+	var add func(rec *sam.Record, c bgzf.Chunk) error
+	var write func() error
+	if useCSI {
+		idx := csi.New(csi.DefaultShift, csiDepth(br.Header()))
+		add = func(rec *sam.Record, c bgzf.Chunk) error {
+			placed := rec.Ref != nil && rec.Pos != -1
+			return idx.Add(rec, c, rec.Flags&sam.Unmapped == 0, placed)
+		}
+		write = func() error {
+			bg := bgzf.NewWriter(w, 1)
+			if err := csi.WriteTo(bg, idx); err != nil {
+				bg.Close()
+				return err
+			}
+			return bg.Close()
+		}
+	} else {
+		idx := &bam.Index{}
+		add = idx.Add
+		write = func() error { return bam.WriteIndex(w, idx) }
+	}
+
+	for {
+		rec, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := add(rec, br.LastChunk()); err != nil {
+			return err
+		}
+	}
+	return write()
+}
+
+// csiDepth returns the CSI index depth that is needed for the references in
+// h with the default minimum shift.
+func csiDepth(h *sam.Header) int {
+	max := 0
+	for _, ref := range h.Refs() {
+		if ref.Len() > max {
+			max = ref.Len()
+		}
+	}
+	depth, ok := csi.MinimumDepthFor(int64(max), csi.DefaultShift)
+	if !ok || depth < csi.DefaultDepth {
+		return csi.DefaultDepth
+	}
+	return int(depth)
+}
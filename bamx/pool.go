@@ -0,0 +1,71 @@
+package bamx
+
+import (
+	"io"
+	"math"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+// Pool creates Readers of the same indexed BAM file that can be read
+// concurrently, e.g. to process each reference in a separate go routine.
+// Each Reader reads the file with its own bam reader through the shared
+// io.ReaderAt, such as an *os.File, so the file is opened once, and all
+// Readers share the index. A Pool is safe for concurrent use.
+type Pool struct {
+	r   io.ReaderAt
+	idx *Index
+	h   *sam.Header
+	rd  int
+}
+
+// NewPool returns a new Pool of the BAM file r with index idx. The Readers
+// of the Pool decompress with concurrency rd, as bam.NewReader. r must
+// support concurrent calls of ReadAt, as *os.File does.
+func NewPool(r io.ReaderAt, idx *Index, rd int) (*Pool, error) {
+	p := &Pool{r: r, idx: idx, rd: rd}
+	br, err := bam.NewReader(p.section(), 1)
+	if err != nil {
+		return nil, err
+	}
+	p.h = br.Header()
+	return p, br.Close()
+}
+
+// section returns a new io.ReadSeeker of the file of p from its start.
+func (p *Pool) section() *io.SectionReader {
+	return io.NewSectionReader(p.r, 0, math.MaxInt64)
+}
+
+// Header returns the header of the BAM file of p. The records read by each
+// Reader of p refer to the references of the header of that Reader, which
+// are equal to, but not the same as, those of this header.
+func (p *Pool) Header() *sam.Header {
+	return p.h
+}
+
+// Reader returns a new Reader of the file of p. It reads the whole file
+// unless queries are added. The Reader must be closed, which does not close
+// the io.ReaderAt of p.
+func (p *Pool) Reader() (*Reader, error) {
+	br, err := bam.NewReader(p.section(), p.rd)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithIndex(br, p.idx), nil
+}
+
+// Query returns a new Reader of the records of the file of p that overlap
+// the region [start, end) of reference rname, as AddQuery.
+func (p *Pool) Query(rname string, start, end int) (*Reader, error) {
+	bx, err := p.Reader()
+	if err != nil {
+		return nil, err
+	}
+	if err := bx.AddQuery(rname, start, end); err != nil {
+		bx.Close()
+		return nil, err
+	}
+	return bx, nil
+}
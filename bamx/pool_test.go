@@ -0,0 +1,87 @@
+package bamx
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+)
+
+func TestPoolQuery(t *testing.T) {
+	// Enough records for several BGZF blocks per reference.
+	data, idx := newTestBAM(t, 5000, 100, false)
+	queries := []struct {
+		rname      string
+		start, end int
+	}{
+		{"chr1", 0, 0},
+		{"chr2", 1000, 300000},
+		{"chr1", 250000, 450000},
+		{"chr2", 0, 0},
+		{"*", 0, 0},
+	}
+
+	// The records read sequentially by a Reader of its own for each query.
+	want := make([][]string, len(queries))
+	for i, q := range queries {
+		br, err := bam.NewReader(bytes.NewReader(data), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bx := NewWithIndex(br, idx)
+		if err := bx.AddQuery(q.rname, q.start, q.end); err != nil {
+			t.Fatal(err)
+		}
+		if want[i], err = readNames(bx); err != nil {
+			t.Fatal(err)
+		}
+		if len(want[i]) == 0 {
+			t.Fatalf("query %v: no records", q)
+		}
+		if err := bx.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := NewPool(bytes.NewReader(data), idx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(p.Header().Refs()); got != 2 {
+		t.Fatalf("expected 2 references, got %d", got)
+	}
+	readers := make([]*Reader, len(queries))
+	for i, q := range queries {
+		if readers[i], err = p.Query(q.rname, q.start, q.end); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := make([][]string, len(queries))
+	errs := make([]error, len(queries))
+	var wg sync.WaitGroup
+	for i := range readers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i], errs[i] = readNames(readers[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, r := range readers {
+		if errs[i] != nil {
+			t.Errorf("query %v: unexpected error %v", queries[i], errs[i])
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("unexpected close error %v", err)
+		}
+		if strings.Join(got[i], " ") != strings.Join(want[i], " ") {
+			t.Errorf("query %v: expected %d records, got %d", queries[i], len(want[i]), len(got[i]))
+		}
+	}
+
+	if _, err := p.Query("chr9", 0, 0); err == nil {
+		t.Error("expected error for unknown reference")
+	}
+}
@@ -0,0 +1,149 @@
+package samql
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+)
+
+// barcodeBases are the bases that are substituted to find the whitelisted
+// barcodes with a single mismatch to a barcode.
+const barcodeBases = "ACGTN"
+
+// BarcodeFilter keeps the records with a barcode tag, e.g. CB, that is in a
+// whitelist of barcodes, as in single-cell preprocessing. Barcodes that are
+// not in the whitelist are corrected if they have a single mismatch to
+// exactly one whitelisted barcode and the maximum distance is 1. A suffix
+// after a dash, e.g. -1 in AAACCTGAGAAACCAT-1, is ignored and kept.
+type BarcodeFilter struct {
+	tag       sam.Tag
+	maxDist   int
+	whitelist map[string]struct{}
+}
+
+// NewBarcodeFilter returns a new BarcodeFilter for the barcodes in whitelist
+// and the tag of type Z with the record barcodes. maxDist is the maximum
+// number of mismatches, 0 or 1, of a barcode to a whitelisted barcode.
+func NewBarcodeFilter(whitelist []string, tag string, maxDist int) (*BarcodeFilter, error) {
+	if len(tag) != 2 {
+		return nil, fmt.Errorf("samql: invalid barcode tag %q", tag)
+	}
+	if maxDist < 0 || maxDist > 1 {
+		return nil, fmt.Errorf("samql: invalid maximum barcode distance %d; must be 0 or 1", maxDist)
+	}
+	f := &BarcodeFilter{
+		tag:       sam.NewTag(tag),
+		maxDist:   maxDist,
+		whitelist: make(map[string]struct{}, len(whitelist)),
+	}
+	for _, bc := range whitelist {
+		f.whitelist[strings.ToUpper(bc)] = struct{}{}
+	}
+	return f, nil
+}
+
+// Match returns the whitelisted barcode that matches barcode and true, or
+// false if no barcode or more than one barcode with a single mismatch
+// matches.
+func (f *BarcodeFilter) Match(barcode string) (string, bool) {
+	bc, suffix := barcode, ""
+	if i := strings.IndexByte(barcode, '-'); i >= 0 {
+		bc, suffix = barcode[:i], barcode[i:]
+	}
+	bc = strings.ToUpper(bc)
+	if _, ok := f.whitelist[bc]; ok {
+		return bc + suffix, true
+	}
+	if f.maxDist == 0 {
+		return "", false
+	}
+
+	match := ""
+	b := []byte(bc)
+	for i, c := range b {
+		for j := 0; j < len(barcodeBases); j++ {
+			if barcodeBases[j] == c {
+				continue
+			}
+			b[i] = barcodeBases[j]
+			if _, ok := f.whitelist[string(b)]; ok {
+				if match != "" {
+					return "", false
+				}
+				match = string(b)
+			}
+		}
+		b[i] = c
+	}
+	if match == "" {
+		return "", false
+	}
+	return match + suffix, true
+}
+
+// Filter returns true if the barcode of rec matches a whitelisted barcode
+// and corrects the barcode of rec, if it has a mismatch. It can be used as a
+// FilterFunc.
+func (f *BarcodeFilter) Filter(rec *sam.Record) bool {
+	for i, aux := range rec.AuxFields {
+		if aux.Tag() != f.tag {
+			continue
+		}
+		bc, ok := aux.Value().(string)
+		if !ok {
+			return false
+		}
+		match, ok := f.Match(bc)
+		if !ok {
+			return false
+		}
+		if match != bc {
+			corrected, err := sam.NewAux(f.tag, match)
+			if err != nil {
+				return false
+			}
+			rec.AuxFields[i] = corrected
+		}
+		return true
+	}
+	return false
+}
+
+// ReadBarcodes reads a barcode whitelist from r. The whitelist has a barcode
+// in the first column of each line. Empty lines and comments are skipped.
+func ReadBarcodes(r io.Reader) ([]string, error) {
+	var barcodes []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		barcodes = append(barcodes, strings.Fields(line)[0])
+	}
+	return barcodes, s.Err()
+}
+
+// ReadBarcodesFile reads the barcode whitelist file at path. Files with a .gz
+// extension are decompressed.
+func ReadBarcodesFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if !strings.HasSuffix(path, ".gz") {
+		return ReadBarcodes(f)
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return ReadBarcodes(gz)
+}
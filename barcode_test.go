@@ -0,0 +1,109 @@
+package samql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+const barcodeData = `# whitelist
+AAACCTGA
+AAACCTGC	extra
+
+TTTGGGCC
+`
+
+func TestReadBarcodes(t *testing.T) {
+	barcodes, err := ReadBarcodes(strings.NewReader(barcodeData))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	want := []string{"AAACCTGA", "AAACCTGC", "TTTGGGCC"}
+	if !reflect.DeepEqual(barcodes, want) {
+		t.Errorf("barcodes=%v want %v", barcodes, want)
+	}
+}
+
+func TestBarcodeFilterMatch(t *testing.T) {
+	barcodes, err := ReadBarcodes(strings.NewReader(barcodeData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Barcode string
+		MaxDist int
+		Want    string
+		OK      bool
+	}{
+		{Barcode: "AAACCTGA", MaxDist: 0, Want: "AAACCTGA", OK: true},
+		{Barcode: "aaacctga", MaxDist: 0, Want: "AAACCTGA", OK: true},
+		{Barcode: "AAACCTGA-1", MaxDist: 1, Want: "AAACCTGA-1", OK: true},
+		{Barcode: "TTTGGGCA", MaxDist: 0},
+		{Barcode: "TTTGGGCA", MaxDist: 1, Want: "TTTGGGCC", OK: true},
+		{Barcode: "TTTGNGCC-1", MaxDist: 1, Want: "TTTGGGCC-1", OK: true},
+		{Barcode: "AAACCTGG", MaxDist: 1}, // One mismatch to two barcodes.
+		{Barcode: "TTTGGGAA", MaxDist: 1},
+		{Barcode: "TTTGGGC", MaxDist: 1},
+		{Barcode: "", MaxDist: 1},
+	} {
+		f, err := NewBarcodeFilter(barcodes, "CB", tt.MaxDist)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := f.Match(tt.Barcode)
+		if got != tt.Want || ok != tt.OK {
+			t.Errorf("%s %d: match=%q, %t want %q, %t",
+				tt.Barcode, tt.MaxDist, got, ok, tt.Want, tt.OK)
+		}
+	}
+}
+
+func TestBarcodeFilter(t *testing.T) {
+	const data = "@SQ\tSN:chr1\tLN:45\n" +
+		"b1\t0\tchr1\t7\t30\t5M\t*\t0\t0\tTTAGA\t*\tCB:Z:AAACCTGA-1\n" +
+		"b2\t0\tchr1\t9\t30\t5M\t*\t0\t0\tAAAAG\t*\tNM:i:0\tCB:Z:TTTGGGCA-1\n" +
+		"b3\t0\tchr1\t9\t30\t5M\t*\t0\t0\tAAAAG\t*\tCB:Z:AAACCTGG-1\n" +
+		"b4\t0\tchr1\t9\t30\t5M\t*\t0\t0\tAAAAG\t*\n" +
+		"b5\t0\tchr1\t9\t30\t5M\t*\t0\t0\tAAAAG\t*\tCB:i:1\n"
+
+	barcodes, err := ReadBarcodes(strings.NewReader(barcodeData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewBarcodeFilter(barcodes, "CB", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := sam.NewReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(sr)
+	r.AppendFilter(f.Filter)
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+
+	var got []string
+	for _, rec := range records {
+		cb, _ := rec.Tag([]byte("CB"))
+		got = append(got, rec.Name+":"+cb.String())
+	}
+	want := []string{"b1:CB:Z:AAACCTGA-1", "b2:CB:Z:TTTGGGCC-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records=%v want %v", got, want)
+	}
+}
+
+func TestNewBarcodeFilterInvalid(t *testing.T) {
+	if _, err := NewBarcodeFilter(nil, "CBZ", 1); err == nil {
+		t.Errorf("expected error for invalid tag")
+	}
+	if _, err := NewBarcodeFilter(nil, "CB", 2); err == nil {
+		t.Errorf("expected error for invalid distance")
+	}
+}
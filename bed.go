@@ -0,0 +1,163 @@
+package samql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+)
+
+// ReadBED reads the regions of a BED file from r. Only the first three
+// columns are used. Empty lines, comments, track or browser lines and zero
+// length regions, that cannot overlap any record, are skipped.
+func ReadBED(r io.Reader) ([]Region, error) {
+	var regions []Region
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") ||
+			strings.HasPrefix(line, "track") ||
+			strings.HasPrefix(line, "browser") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("samql: BED line %d: expected at least 3 columns", n)
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("samql: BED line %d: invalid start: %v", n, err)
+		}
+		end, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("samql: BED line %d: invalid end: %v", n, err)
+		}
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("samql: BED line %d: invalid region %d-%d", n, start, end)
+		}
+		if start == end {
+			continue
+		}
+		regions = append(regions, Region{Rname: fields[0], Start: start, End: end})
+	}
+	return regions, s.Err()
+}
+
+// ReadBEDFile reads the regions of the BED file at path.
+func ReadBEDFile(path string) ([]Region, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadBED(f)
+}
+
+// RegionsFilter returns a FilterFunc that returns true for records that
+// overlap any of the regions in the BED file at bedPath.
+func RegionsFilter(bedPath string) (FilterFunc, error) {
+	regions, err := ReadBEDFile(bedPath)
+	if err != nil {
+		return nil, err
+	}
+	return OverlapFilter(regions), nil
+}
+
+// OverlapFilter returns a FilterFunc that returns true for records that
+// overlap any of regions. Unmapped records do not overlap any region.
+func OverlapFilter(regions []Region) FilterFunc {
+	tree := newIntervalTree(regions)
+	return func(rec *sam.Record) bool {
+		if rec.Ref == nil || rec.Pos < 0 {
+			return false
+		}
+		end := rec.End()
+		if end <= rec.Pos { // Records without a CIGAR occupy one position.
+			end = rec.Pos + 1
+		}
+		return tree.overlaps(rec.Ref.Name(), rec.Pos, end)
+	}
+}
+
+// intervalTree answers overlap queries for regions on multiple references.
+// For each reference the regions are sorted by start and augmented with the
+// maximum end of all preceding regions, i.e. a flattened interval tree.
+type intervalTree map[string]*intervals
+
+// intervals holds the sorted regions of a single reference.
+type intervals struct {
+	starts []int
+	ends   []int
+	maxEnd []int // maxEnd[i] is the maximum of ends[0:i+1].
+	ids    []int // ids[i] is the index of the region in the input regions.
+}
+
+// newIntervalTree returns an intervalTree for regions. Regions with a
+// non-positive End extend to the end of the reference.
+func newIntervalTree(regions []Region) intervalTree {
+	byRef := make(map[string][]int)
+	for i, r := range regions {
+		byRef[r.Rname] = append(byRef[r.Rname], i)
+	}
+
+	t := make(intervalTree, len(byRef))
+	for name, ids := range byRef {
+		sort.SliceStable(ids, func(i, j int) bool {
+			return regions[ids[i]].Start < regions[ids[j]].Start
+		})
+		iv := &intervals{
+			starts: make([]int, len(ids)),
+			ends:   make([]int, len(ids)),
+			maxEnd: make([]int, len(ids)),
+			ids:    ids,
+		}
+		for i, id := range ids {
+			r := regions[id]
+			if r.End <= 0 {
+				r.End = int(^uint(0) >> 1)
+			}
+			iv.starts[i] = r.Start
+			iv.ends[i] = r.End
+			iv.maxEnd[i] = r.End
+			if i > 0 && iv.maxEnd[i-1] > r.End {
+				iv.maxEnd[i] = iv.maxEnd[i-1]
+			}
+		}
+		t[name] = iv
+	}
+	return t
+}
+
+// overlaps returns true if [start, end) on reference rname overlaps any
+// region in t.
+func (t intervalTree) overlaps(rname string, start, end int) bool {
+	found := false
+	t.each(rname, start, end, func(int) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// each calls fn with the index of each region in t that overlaps [start,
+// end) on reference rname, in decreasing order of start, until fn returns
+// false.
+func (t intervalTree) each(rname string, start, end int, fn func(id int) bool) {
+	iv, ok := t[rname]
+	if !ok {
+		return
+	}
+	// Regions after i start at or after end and cannot overlap.
+	i := sort.SearchInts(iv.starts, end) - 1
+	for ; i >= 0 && iv.maxEnd[i] > start; i-- {
+		if iv.ends[i] > start && !fn(iv.ids[i]) {
+			return
+		}
+	}
+}
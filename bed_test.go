@@ -0,0 +1,100 @@
+package samql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+const bedData = `# regions
+track name=test
+chr1	0	8	first
+chr1	30	31
+chr1	20	20
+
+chr2	100	200
+1	0	45
+`
+
+func TestReadBED(t *testing.T) {
+	regions, err := ReadBED(strings.NewReader(bedData))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	want := []Region{
+		{Rname: "chr1", Start: 0, End: 8},
+		{Rname: "chr1", Start: 30, End: 31},
+		{Rname: "chr2", Start: 100, End: 200},
+		{Rname: "1", Start: 0, End: 45},
+	}
+	if !reflect.DeepEqual(regions, want) {
+		t.Errorf("regions=%v want %v", regions, want)
+	}
+
+	for _, data := range []string{
+		"chr1\t10\n",
+		"chr1\ta\t10\n",
+		"chr1\t10\t5\n",
+	} {
+		if _, err := ReadBED(strings.NewReader(data)); err == nil {
+			t.Errorf("%q: expected error", data)
+		}
+	}
+}
+
+func TestOverlapFilter(t *testing.T) {
+	regions, err := ReadBED(strings.NewReader(bedData))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(sr)
+	r.AppendFilter(OverlapFilter(regions))
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+
+	var names []string
+	for _, rec := range records {
+		names = append(names, rec.Name)
+	}
+	want := []string{"r001", "r003", "r005"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("records=%v want %v", names, want)
+	}
+}
+
+func TestIntervalTree(t *testing.T) {
+	tree := newIntervalTree([]Region{
+		{Rname: "a", Start: 0, End: 100},
+		{Rname: "a", Start: 10, End: 20},
+		{Rname: "a", Start: 200, End: 300},
+		{Rname: "b", Start: 50},
+	})
+	for _, tt := range []struct {
+		Rname      string
+		Start, End int
+		Want       bool
+	}{
+		{"a", 50, 60, true},
+		{"a", 100, 200, false},
+		{"a", 99, 100, true},
+		{"a", 299, 400, true},
+		{"a", 300, 400, false},
+		{"b", 0, 50, false},
+		{"b", 1000000, 1000001, true},
+		{"c", 0, 10, false},
+	} {
+		if got := tree.overlaps(tt.Rname, tt.Start, tt.End); got != tt.Want {
+			t.Errorf("%s:%d-%d: overlaps=%t want %t",
+				tt.Rname, tt.Start, tt.End, got, tt.Want)
+		}
+	}
+}
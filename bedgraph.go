@@ -0,0 +1,138 @@
+package samql
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// BedGraphWriter writes the rows of a windowed aggregate query as bedGraph,
+// e.g. for "SELECT RNAME, window(POS, 1000), count(*) FROM aln GROUP BY
+// RNAME, window(POS, 1000)". Each row is written as the reference name, the
+// start and end of the window and the value of the single aggregate.
+type BedGraphWriter struct {
+	w       *bufio.Writer
+	h       *sam.Header
+	rname   int // Column of RNAME.
+	start   int // Column of window.
+	value   int // Column of the aggregate.
+	size    int // Window size.
+	started bool
+}
+
+// NewBedGraphWriter returns a new BedGraphWriter that writes the rows of
+// query q to w. Window ends are limited to the reference lengths in h, if not
+// nil. q must be grouped by RNAME and by window of POS or END and select them
+// with a single aggregate.
+func NewBedGraphWriter(w io.Writer, q *Query, h *sam.Header) (*BedGraphWriter, error) {
+	bw := &BedGraphWriter{w: bufio.NewWriter(w), h: h, rname: -1, start: -1, value: -1}
+	if !q.IsAggregate() {
+		return nil, errors.New("samql: bedGraph requires an aggregate query")
+	}
+	for i, f := range q.Stmt.Fields {
+		switch {
+		case q.aggs[i] != nil:
+			if bw.value >= 0 {
+				return nil, errors.New("samql: bedGraph requires a single aggregate")
+			}
+			bw.value = i
+		case q.dimIdx[i] >= 0 && f.Expr.String() == "RNAME":
+			bw.rname = i
+		case q.dimIdx[i] >= 0:
+			c, ok := f.Expr.(*ql.Call)
+			if !ok || c.Cmd != "window" || len(c.Args) != 2 {
+				return nil, fmt.Errorf("samql: bedGraph cannot group by %s", f.Expr)
+			}
+			size, ok := c.Args[1].(*ql.IntegerLiteral)
+			if !ok || size.Val <= 0 {
+				return nil, fmt.Errorf("samql: bedGraph requires a constant window size: %s", f.Expr)
+			}
+			bw.start, bw.size = i, int(size.Val)
+		}
+	}
+	if bw.rname < 0 || bw.start < 0 || bw.value < 0 {
+		return nil, errors.New("samql: bedGraph requires RNAME, window and an aggregate")
+	}
+	if len(q.Stmt.Dimensions) != 2 {
+		return nil, errors.New("samql: bedGraph requires GROUP BY RNAME and window")
+	}
+	return bw, nil
+}
+
+// WriteRows writes the track line and the bedGraph lines of rows, as
+// returned by Aggregation.Rows, sorted by reference, in the order of h, and
+// start. Unmapped windows and rows without a value are skipped.
+func (w *BedGraphWriter) WriteRows(rows [][]interface{}) error {
+	order := make(map[string]int)
+	lengths := make(map[string]int)
+	if w.h != nil {
+		for i, ref := range w.h.Refs() {
+			order[ref.Name()] = i
+			lengths[ref.Name()] = ref.Len()
+		}
+	}
+
+	type line struct {
+		rname string
+		start int
+		value interface{}
+	}
+	lines := make([]line, 0, len(rows))
+	for _, row := range rows {
+		rname, _ := row[w.rname].(string)
+		start, _ := row[w.start].(int)
+		if rname == "*" || rname == "" || start < 0 || row[w.value] == nil {
+			continue
+		}
+		lines = append(lines, line{rname: rname, start: start, value: row[w.value]})
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		if a.rname != b.rname {
+			oa, oka := order[a.rname]
+			ob, okb := order[b.rname]
+			if oka && okb {
+				return oa < ob
+			}
+			if oka != okb {
+				return oka
+			}
+			return a.rname < b.rname
+		}
+		return a.start < b.start
+	})
+
+	if !w.started {
+		if _, err := fmt.Fprintln(w.w, "track type=bedGraph"); err != nil {
+			return err
+		}
+		w.started = true
+	}
+	for _, l := range lines {
+		end := l.start + w.size
+		if n, ok := lengths[l.rname]; ok && end > n {
+			end = n
+		}
+		if _, err := fmt.Fprintf(w.w, "%s\t%d\t%d\t%s\n", l.rname, l.start, end, formatValue(l.value)); err != nil {
+			return err
+		}
+	}
+	return w.w.Flush()
+}
+
+// formatValue returns the shortest representation of an aggregate value.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,80 @@
+package samql
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestBedGraphWriter(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := NewReader(sr).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Query string
+		Want  string
+	}{
+		{
+			Query: "SELECT RNAME, window(POS, 20), count(*) FROM aln GROUP BY RNAME, window(POS, 20)",
+			Want: "track type=bedGraph\n" +
+				"chr1\t0\t20\t3\n" +
+				"chr1\t20\t40\t1\n" +
+				"chr2\t20\t40\t1\n" +
+				"1\t20\t40\t1\n",
+		},
+		{
+			Query: "SELECT window(POS, 30) AS start, mean(MAPQ), RNAME FROM aln WHERE RNAME = '1' GROUP BY window(POS, 30), RNAME",
+			Want: "track type=bedGraph\n" +
+				"1\t30\t45\t29\n",
+		},
+	} {
+		q, err := NewQuery(tt.Query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		agg := q.NewAggregation()
+		filter := queryFilter(t, q)
+		for _, rec := range records {
+			if filter(rec) {
+				agg.Add(rec)
+			}
+		}
+		var buf bytes.Buffer
+		w, err := NewBedGraphWriter(&buf, q, sr.Header())
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.Query, err)
+			continue
+		}
+		if err := w.WriteRows(agg.Rows()); err != nil {
+			t.Errorf("%s: unexpected error %v", tt.Query, err)
+			continue
+		}
+		if buf.String() != tt.Want {
+			t.Errorf("%s: bedGraph=%q want %q", tt.Query, buf.String(), tt.Want)
+		}
+	}
+
+	for _, query := range []string{
+		"SELECT RNAME, POS FROM aln",
+		"SELECT RNAME, count(*) FROM aln GROUP BY RNAME",
+		"SELECT RNAME, window(POS, 10), count(*), max(MAPQ) FROM aln GROUP BY RNAME, window(POS, 10)",
+		"SELECT RNAME, MAPQ, count(*) FROM aln GROUP BY RNAME, MAPQ",
+		"SELECT RNAME, window(POS, 10), count(*) FROM aln GROUP BY RNAME, window(POS, 10), MAPQ",
+	} {
+		q, err := NewQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewBedGraphWriter(&bytes.Buffer{}, q, nil); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
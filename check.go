@@ -0,0 +1,178 @@
+package samql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/biogo/hts/sam"
+)
+
+// Problems of SAM records found by Validation.
+const (
+	// ProblemInvalid is a record that cannot be parsed, e.g. a SAM line
+	// with a MAPQ above 255 or with CIGAR and SEQ of different lengths.
+	ProblemInvalid = "invalid"
+	// ProblemCigarSeqLength is a CIGAR whose query length differs from the
+	// length of SEQ.
+	ProblemCigarSeqLength = "cigar_seq_length"
+	// ProblemUnpairedFlags is a record without the PAIRED flag that has flags
+	// of paired records, i.e. PROPERPAIR, MATEUNMAPPED, MATEREVERSE, READ1
+	// or READ2.
+	ProblemUnpairedFlags = "unpaired_flags"
+	// ProblemMappedWithoutPos is a mapped record without RNAME or POS.
+	ProblemMappedWithoutPos = "mapped_without_pos"
+	// ProblemMatePos is a pair whose RNEXT or PNEXT differ from the RNAME or
+	// POS of the mate.
+	ProblemMatePos = "mate_pos"
+	// ProblemMateFlags is a pair whose MATEUNMAPPED or MATEREVERSE flags
+	// differ from the UNMAPPED or REVERSE flags of the mate.
+	ProblemMateFlags = "mate_flags"
+	// ProblemMateTlen is a pair whose TLENs are not opposite.
+	ProblemMateTlen = "mate_tlen"
+)
+
+// pairedFlags are the flags that are only valid for paired records.
+const pairedFlags = sam.ProperPair | sam.MateUnmapped | sam.MateReverse | sam.Read1 | sam.Read2
+
+// Validation holds the problems found in SAM records by checking them
+// against the SAM specification. The fields of each record are checked and,
+// if Mates is true, the mate fields of the primary records of each pair are
+// checked against each other. The mates of a pair must be adjacent, e.g. in
+// queryname sorted input, and pairs with a single record, e.g. because its
+// mate was filtered out, are not checked.
+type Validation struct {
+	// Total is the number of records checked.
+	Total int `json:"total"`
+	// Problems holds the number of records, or of pairs for the mate
+	// problems, with each problem, e.g. ProblemCigarSeqLength.
+	Problems map[string]int `json:"problems"`
+	// Examples holds the name of the first record with each problem, or
+	// the location of the first invalid record.
+	Examples map[string]string `json:"examples"`
+
+	// Mates enables the checks of the mate fields of pairs.
+	Mates bool `json:"-"`
+
+	name   string      // name of the records of the current pair
+	r1, r2 *sam.Record // primary records of the current pair
+}
+
+// NewValidation returns a new empty Validation.
+func NewValidation() *Validation {
+	return &Validation{
+		Problems: make(map[string]int),
+		Examples: make(map[string]string),
+	}
+}
+
+// Add checks rec and adds its problems. rec is retained until the records
+// of the next pair are added if v.Mates is true.
+func (v *Validation) Add(rec *sam.Record) {
+	v.Total++
+	if rec.Cigar != nil && rec.Seq.Length > 0 {
+		if _, n := rec.Cigar.Lengths(); n != rec.Seq.Length {
+			v.add(ProblemCigarSeqLength, rec.Name)
+		}
+	}
+	if rec.Flags&sam.Paired == 0 && rec.Flags&pairedFlags != 0 {
+		v.add(ProblemUnpairedFlags, rec.Name)
+	}
+	if rec.Flags&sam.Unmapped == 0 && (rec.Ref == nil || rec.Pos < 0) {
+		v.add(ProblemMappedWithoutPos, rec.Name)
+	}
+
+	if !v.Mates {
+		return
+	}
+	if rec.Name != v.name {
+		v.Flush()
+		v.name = rec.Name
+	}
+	if rec.Flags&sam.Paired == 0 || rec.Flags&(sam.Secondary|sam.Supplementary) != 0 {
+		return
+	}
+	switch {
+	case rec.Flags&sam.Read1 != 0 && rec.Flags&sam.Read2 == 0 && v.r1 == nil:
+		v.r1 = rec
+	case rec.Flags&sam.Read2 != 0 && rec.Flags&sam.Read1 == 0 && v.r2 == nil:
+		v.r2 = rec
+	}
+}
+
+// AddInvalid adds a record that cannot be parsed, such as the lines skipped
+// by a LenientSAMReader. where is its location, e.g. the input and line.
+func (v *Validation) AddInvalid(where string) {
+	v.Total++
+	v.add(ProblemInvalid, where)
+}
+
+// Flush checks the mates of the current pair, if any, and forgets them. It
+// must be called after the last record is added, e.g. at the end of each
+// input, if v.Mates is true.
+func (v *Validation) Flush() {
+	r1, r2 := v.r1, v.r2
+	v.r1, v.r2, v.name = nil, nil, ""
+	if r1 == nil || r2 == nil {
+		return
+	}
+	if !isMateOf(r1, r2) || !isMateOf(r2, r1) {
+		v.add(ProblemMatePos, r1.Name)
+	}
+	if !hasMateFlags(r1, r2) || !hasMateFlags(r2, r1) {
+		v.add(ProblemMateFlags, r1.Name)
+	}
+	if r1.TempLen != -r2.TempLen {
+		v.add(ProblemMateTlen, r1.Name)
+	}
+}
+
+// isMateOf returns true if the RNEXT and PNEXT of rec are the RNAME and POS
+// of mate.
+func isMateOf(rec, mate *sam.Record) bool {
+	return rec.MateRef.Name() == mate.Ref.Name() && rec.MatePos == mate.Pos
+}
+
+// hasMateFlags returns true if the MATEUNMAPPED and MATEREVERSE flags of rec
+// are the UNMAPPED and REVERSE flags of mate.
+func hasMateFlags(rec, mate *sam.Record) bool {
+	return (rec.Flags&sam.MateUnmapped != 0) == (mate.Flags&sam.Unmapped != 0) &&
+		(rec.Flags&sam.MateReverse != 0) == (mate.Flags&sam.Reverse != 0)
+}
+
+// add counts the problem of the record with name.
+func (v *Validation) add(problem, name string) {
+	if v.Problems[problem] == 0 {
+		v.Examples[problem] = name
+	}
+	v.Problems[problem]++
+}
+
+// WriteText writes the problems to w as tab separated lines. The first line
+// is SN with the total number of records, followed by a PROBLEM line with
+// the count and an example record name of each problem, sorted by name.
+func (v *Validation) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "SN\ttotal\t%d\n", v.Total); err != nil {
+		return err
+	}
+	problems := make([]string, 0, len(v.Problems))
+	for p := range v.Problems {
+		problems = append(problems, p)
+	}
+	sort.Strings(problems)
+	for _, p := range problems {
+		if _, err := fmt.Fprintf(w, "PROBLEM\t%s\t%d\t%s\n", p, v.Problems[p],
+			v.Examples[p]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the problems to w as a JSON object.
+func (v *Validation) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,133 @@
+package samql
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+// samDataMates has pairs with consistent and inconsistent mate fields, in
+// queryname order.
+const samDataMates = `@HD	VN:1.6	SO:queryname
+@SQ	SN:chr1	LN:1000
+@SQ	SN:chr2	LN:1000
+r1	99	chr1	10	30	4M	=	50	44	ACGT	*
+r1	355	chr1	20	0	4M	=	50	0	ACGT	*
+r1	147	chr1	50	30	4M	=	10	-44	ACGT	*
+r2	99	chr1	10	30	4M	=	60	44	ACGT	*
+r2	147	chr1	50	30	4M	=	10	-40	ACGT	*
+r3	73	chr1	10	30	4M	=	10	0	ACGT	*
+r3	133	chr1	10	0	*	=	10	0	ACGT	*
+r4	65	chr1	10	30	4M	chr2	10	0	ACGT	*
+r4	145	chr2	10	30	4M	chr1	10	0	ACGT	*
+r5	2	chr1	10	30	4M	*	0	0	ACGT	*
+r6	0	*	0	30	4M	*	0	0	ACGT	*
+r7	73	chr1	10	30	4M	=	10	0	ACGT	*
+`
+
+func TestValidation(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader(samDataMates))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := NewReader(sr).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A CIGAR that does not match SEQ cannot be parsed from SAM.
+	bad := *recs[0]
+	bad.Name = "r8"
+	bad.Flags = 0
+	bad.Cigar = sam.Cigar{sam.NewCigarOp(sam.CigarMatch, 5)}
+	recs = append(recs, &bad)
+
+	tests := []struct {
+		Test     string
+		Mates    bool
+		Problems map[string]int
+		Examples map[string]string
+	}{
+		{
+			Test: "Records",
+			Problems: map[string]int{
+				ProblemInvalid:          1,
+				ProblemCigarSeqLength:   1,
+				ProblemUnpairedFlags:    1,
+				ProblemMappedWithoutPos: 1,
+			},
+			Examples: map[string]string{
+				ProblemInvalid:          "test.sam:3",
+				ProblemCigarSeqLength:   "r8",
+				ProblemUnpairedFlags:    "r5",
+				ProblemMappedWithoutPos: "r6",
+			},
+		},
+		{
+			Test:  "Mates",
+			Mates: true,
+			Problems: map[string]int{
+				ProblemInvalid:          1,
+				ProblemCigarSeqLength:   1,
+				ProblemUnpairedFlags:    1,
+				ProblemMappedWithoutPos: 1,
+				ProblemMatePos:          1,
+				ProblemMateFlags:        1,
+				ProblemMateTlen:         1,
+			},
+			Examples: map[string]string{
+				ProblemInvalid:          "test.sam:3",
+				ProblemCigarSeqLength:   "r8",
+				ProblemUnpairedFlags:    "r5",
+				ProblemMappedWithoutPos: "r6",
+				ProblemMatePos:          "r2",
+				ProblemMateFlags:        "r4",
+				ProblemMateTlen:         "r2",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Test, func(t *testing.T) {
+			v := NewValidation()
+			v.Mates = tt.Mates
+			v.AddInvalid("test.sam:3")
+			for _, rec := range recs {
+				v.Add(rec)
+			}
+			v.Flush()
+			if v.Total != len(recs)+1 {
+				t.Errorf("expected %d records, got %d", len(recs)+1, v.Total)
+			}
+			if !reflect.DeepEqual(v.Problems, tt.Problems) {
+				t.Errorf("expected problems %v, got %v", tt.Problems, v.Problems)
+			}
+			if !reflect.DeepEqual(v.Examples, tt.Examples) {
+				t.Errorf("expected examples %v, got %v", tt.Examples, v.Examples)
+			}
+		})
+	}
+}
+
+func TestValidationWriteText(t *testing.T) {
+	v := NewValidation()
+	v.Total = 10
+	v.add(ProblemMateTlen, "r2")
+	v.add(ProblemCigarSeqLength, "r1")
+	v.add(ProblemCigarSeqLength, "r3")
+	var buf bytes.Buffer
+	if err := v.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "SN\ttotal\t10\n" +
+		"PROBLEM\tcigar_seq_length\t2\tr1\n" +
+		"PROBLEM\tmate_tlen\t1\tr2\n"
+	if got := buf.String(); got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+	if err := v.WriteJSON(ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/sam"
+)
+
+// checkpointReader wraps a BAM reader and periodically reports the virtual
+// offset that follows the last record read. A run that is interrupted can be
+// resumed from the reported offset using --resume-from.
+type checkpointReader struct {
+	*bam.Reader
+	name  string    // name of the input, reported with each checkpoint.
+	every int       // number of records between checkpoints.
+	cnt   int       // number of records read so far.
+	w     io.Writer // destination of checkpoints.
+}
+
+// Read returns the next record from the underlying BAM reader and prints a
+// checkpoint every r.every records.
+func (r *checkpointReader) Read() (*sam.Record, error) {
+	rec, err := r.Reader.Read()
+	if err != nil {
+		return rec, err
+	}
+
+	r.cnt++
+	if r.every > 0 && r.cnt%r.every == 0 {
+		fmt.Fprintf(r.w, "checkpoint\t%s\t%d\t%d\n",
+			r.name, r.cnt, formatVOffset(r.LastChunk().End))
+	}
+	return rec, nil
+}
+
+// formatVOffset returns the BGZF virtual offset off as a single integer, as
+// used by samtools and the BAM index.
+func formatVOffset(off bgzf.Offset) int64 {
+	return off.File<<16 | int64(off.Block)
+}
+
+// parseVOffset is the inverse of formatVOffset.
+func parseVOffset(v int64) bgzf.Offset {
+	return bgzf.Offset{File: v >> 16, Block: uint16(v & 0xffff)}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql"
+)
+
+// explain prints to w how the WHERE clause query, with the named queries of
+// lib expanded, is evaluated with filterOpts and the estimated part of each
+// input that is read. Only the headers of the inputs are read.
+func explain(w io.Writer, query string, inputs []string, lib samql.QueryLibrary, filterOpts []samql.FilterOption) error {
+	if len(query) > 6 && strings.EqualFold(query[:6], "WHERE ") {
+		query = query[6:]
+	}
+	query, err := lib.Expand(query)
+	if err != nil {
+		return err
+	}
+	e, err := samql.Explain(query, filterOpts...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(w, e)
+
+	for _, in := range inputs {
+		h, indexed, err := inputHeader(in)
+		if err != nil {
+			return fmt.Errorf("%s: %v", in, err)
+		}
+		switch {
+		case !e.UseIndex:
+			fmt.Fprintf(w, "input\t%s\tall records\n", in)
+		case !indexed:
+			fmt.Fprintf(w, "input\t%s\tall records; no index\n", in)
+		default:
+			// The unplaced records, at the end of the file, are read
+			// without the records before them.
+			unplaced := ""
+			for _, r := range e.Regions {
+				if r.Rname == "*" {
+					unplaced = " and the unplaced records"
+				}
+			}
+			fmt.Fprintf(w, "input\t%s\t%.2f%% of reference bases%s from the index\n",
+				in, 100*e.Fraction(h), unplaced)
+		}
+	}
+	return nil
+}
+
+// inputHeader returns the header of the input in and whether it is an indexed
+// BAM file.
+func inputHeader(in string) (*sam.Header, bool, error) {
+	if strings.Contains(in, "://") && !samql.IsRemote(in) {
+		src, err := samql.OpenSource(in)
+		if err != nil {
+			return nil, false, err
+		}
+		defer src.Close()
+		return src.Header(), false, nil
+	}
+
+	fh, err := getFileDescriptor(in)
+	if err != nil {
+		return nil, false, err
+	}
+	defer fh.Close()
+	format, rd, err := samql.DetectFormat(fh)
+	if err != nil {
+		return nil, false, err
+	}
+	switch format {
+	case samql.SAM:
+		sr, err := sam.NewReader(rd)
+		if err != nil {
+			return nil, false, err
+		}
+		return sr.Header(), false, nil
+	case samql.BAM:
+		br, err := bam.NewReader(rd, 1)
+		if err != nil {
+			return nil, false, err
+		}
+		defer br.Close()
+		indexed := false
+		if in != "-" {
+			if idxf, err := samql.OpenIndex(in); err == nil {
+				idxf.Close()
+				indexed = true
+			}
+		}
+		return br.Header(), indexed, nil
+	}
+	return nil, false, fmt.Errorf("unsupported %s format", format)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql"
+)
+
+// addProgram appends a @PG line for samql with command line cmd to h. The
+// line follows the last program in h.
+func addProgram(h *sam.Header, cmd string) error {
+	uid, prev := "samql", ""
+	seen := make(map[string]bool)
+	for _, p := range h.Progs() {
+		seen[p.UID()] = true
+		prev = p.UID()
+	}
+	for i := 1; seen[uid]; i++ {
+		uid = fmt.Sprintf("samql.%d", i)
+	}
+	return h.AddProgram(sam.NewProgram(uid, "samql", cmd, prev, VERSION))
+}
+
+// dropPrograms removes all @PG lines from h.
+func dropPrograms(h *sam.Header) error {
+	progs := append([]*sam.Program(nil), h.Progs()...)
+	for i := len(progs) - 1; i >= 0; i-- {
+		if err := h.RemoveProgram(progs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceReadGroups returns a copy of h in which all @RG lines are replaced by
+// the read group line rg and the ID of rg. The leading @RG of rg is optional
+// and tabs can be given as \t, e.g. "ID:g1\tSM:NA12878".
+func replaceReadGroups(h *sam.Header, rg string) (*sam.Header, string, error) {
+	rg = strings.Replace(rg, `\t`, "\t", -1)
+	if !strings.HasPrefix(rg, "@RG\t") {
+		rg = "@RG\t" + rg
+	}
+
+	text, err := h.MarshalText()
+	if err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	for _, line := range strings.SplitAfter(string(text), "\n") {
+		if !strings.HasPrefix(line, "@RG\t") {
+			buf.WriteString(line)
+		}
+	}
+	buf.WriteString(rg + "\n")
+
+	nh, err := sam.NewHeader(buf.Bytes(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	rgs := nh.RGs()
+	if len(rgs) != 1 || rgs[0].Name() == "" {
+		return nil, "", fmt.Errorf("invalid read group %q", rg)
+	}
+	return nh, rgs[0].Name(), nil
+}
+
+// stripUnusedRefs writes the records of readers to a temporary file in dir
+// and returns a copy of h with only the references that are used by the
+// records, either as RNAME or RNEXT, and a source that reads back the
+// records. If tag is not nil, it is called for each record with the index of
+// the reader the record was read from. The returned source must be closed to
+// remove the temporary file.
+func stripUnusedRefs(h *sam.Header, readers []*samql.Reader,
+	tag func(*sam.Record, int) error, dir string) (*sam.Header, samql.Source, error) {
+
+	f, err := ioutil.TempFile(dir, "samql-refs-*.bam")
+	if err != nil {
+		return nil, nil, err
+	}
+	src := &refSource{f: f}
+	fail := func(err error) (*sam.Header, samql.Source, error) {
+		src.Close()
+		return nil, nil, err
+	}
+
+	bw, err := bam.NewWriter(f, h, 1)
+	if err != nil {
+		return fail(err)
+	}
+	used := make(map[string]bool)
+	for i, r := range readers {
+		for {
+			rec, err := r.Read()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				bw.Close()
+				return fail(err)
+			}
+			if tag != nil {
+				if err := tag(rec, i); err != nil {
+					bw.Close()
+					return fail(err)
+				}
+			}
+			used[rec.Ref.Name()] = true
+			used[rec.MateRef.Name()] = true
+			if err := bw.Write(rec); err != nil {
+				bw.Close()
+				return fail(err)
+			}
+		}
+	}
+	if err := bw.Close(); err != nil {
+		return fail(err)
+	}
+
+	src.h = h.Clone()
+	src.refs = make(map[string]*sam.Reference)
+	for _, ref := range append([]*sam.Reference(nil), src.h.Refs()...) {
+		if !used[ref.Name()] {
+			if err := src.h.RemoveReference(ref); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+		src.refs[ref.Name()] = ref
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	if src.br, err = bam.NewReader(f, 1); err != nil {
+		return fail(err)
+	}
+	return src.h, src, nil
+}
+
+// refSource reads records from a temporary BAM file and replaces their
+// references with the references of h that have the same name.
+type refSource struct {
+	f    *os.File
+	br   *bam.Reader
+	h    *sam.Header
+	refs map[string]*sam.Reference
+}
+
+// Header returns the header with the used references.
+func (s *refSource) Header() *sam.Header {
+	return s.h
+}
+
+// Read returns the next record.
+func (s *refSource) Read() (*sam.Record, error) {
+	rec, err := s.br.Read()
+	if err != nil {
+		return nil, err
+	}
+	if rec.Ref != nil {
+		rec.Ref = s.refs[rec.Ref.Name()]
+	}
+	if rec.MateRef != nil {
+		rec.MateRef = s.refs[rec.MateRef.Name()]
+	}
+	return rec, nil
+}
+
+// Close closes and removes the temporary file.
+func (s *refSource) Close() error {
+	if s.br != nil {
+		s.br.Close()
+	}
+	err := s.f.Close()
+	if e := os.Remove(s.f.Name()); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// mergePolicy returns the policy of --merge-refs refs and --merge-groups
+// groups.
+func mergePolicy(refs, groups string) samql.MergePolicy {
+	var p samql.MergePolicy
+	switch refs {
+	case "union":
+		p.Refs = samql.UnionRefs
+	case "strict":
+		p.Refs = samql.StrictRefs
+	default:
+		lg.Fatalf("invalid --merge-refs %q: must be union or strict", refs)
+	}
+	switch groups {
+	case "first":
+		p.Groups = samql.FirstGroups
+	case "union":
+		p.Groups = samql.UnionGroups
+	default:
+		lg.Fatalf("invalid --merge-groups %q: must be first or union", groups)
+	}
+	return p
+}
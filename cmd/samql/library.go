@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+
+	"github.com/maragkakislab/samql"
+)
+
+// queryLibrary returns the query library at path or, if path is empty, the
+// library in the home directory of the user if it exists.
+func queryLibrary(path string) (samql.QueryLibrary, error) {
+	if path == "" {
+		path = samql.DefaultQueryLibraryPath()
+		if _, err := os.Stat(path); path == "" || err != nil {
+			return samql.QueryLibrary{}, nil
+		}
+	}
+	return samql.ReadQueryLibraryFile(path)
+}
+
+// libraryWhere returns the WHERE clause of the named query use of lib,
+// combined with where, and all library references expanded.
+func libraryWhere(lib samql.QueryLibrary, use, where string) (string, error) {
+	if use != "" {
+		q, err := lib.Get(use)
+		if err != nil {
+			return "", err
+		}
+		if where == "" {
+			return q, nil
+		}
+		where = "(" + q + ") AND (" + where + ")"
+	}
+	if where == "" {
+		return "", nil
+	}
+	return lib.Expand(where)
+}
+
+// bindLibrary returns a copy of lib with the bound parameters of its queries
+// replaced by the values in params. Queries with missing parameters are kept
+// unchanged and fail only if used.
+func bindLibrary(lib samql.QueryLibrary, params map[string]interface{}) samql.QueryLibrary {
+	bound := make(samql.QueryLibrary, len(lib))
+	for name, q := range lib {
+		if b, err := samql.BindParams(q, params); err == nil {
+			q = b
+		}
+		bound[name] = q
+	}
+	return bound
+}
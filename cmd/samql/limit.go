@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql"
+)
+
+// limitReader reads from a samql Reader until n records have been read by
+// all limitReaders that share n. Records are read with ReadInto, if
+// requested, so that they are reused as with the underlying Reader.
+type limitReader struct {
+	r *samql.Reader
+	n *int
+}
+
+// limitReaders returns readers that read from readers until n records have
+// been read from all of them together.
+func limitReaders(readers []*samql.Reader, n int) []*samql.Reader {
+	limited := make([]*samql.Reader, len(readers))
+	for i, r := range readers {
+		limited[i] = samql.NewReader(&limitReader{r: r, n: &n})
+	}
+	return limited
+}
+
+// Header returns the header of the underlying Reader.
+func (l *limitReader) Header() *sam.Header {
+	return l.r.Header()
+}
+
+// Read returns the next record or io.EOF if the limit has been reached.
+func (l *limitReader) Read() (*sam.Record, error) {
+	if *l.n <= 0 {
+		return nil, io.EOF
+	}
+	rec, err := l.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	*l.n--
+	return rec, nil
+}
+
+// ReadInto reads the next record into rec or returns io.EOF if the limit has
+// been reached.
+func (l *limitReader) ReadInto(rec *sam.Record) error {
+	if *l.n <= 0 {
+		return io.EOF
+	}
+	if err := l.r.ReadInto(rec); err != nil {
+		return err
+	}
+	*l.n--
+	return nil
+}
+
+// Close closes the underlying Reader.
+func (l *limitReader) Close() error {
+	return l.r.Close()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// Levels of the messages printed by the logger.
+const (
+	quietLevel   = iota // Only errors.
+	normalLevel         // Errors, warnings and information.
+	verboseLevel        // Also details, e.g. the query plan of each input.
+)
+
+// logger is a leveled logger. Messages above its level are discarded.
+type logger struct {
+	l     *log.Logger
+	level int
+}
+
+// lg is the logger of the program. It prints to STDERR.
+var lg = &logger{l: log.New(os.Stderr, "", log.LstdFlags), level: normalLevel}
+
+// Fatalf prints an error and exits with status 1.
+func (l *logger) Fatalf(format string, v ...interface{}) {
+	l.l.Output(2, "error: "+fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+// Errorf prints an error that does not stop the program.
+func (l *logger) Errorf(format string, v ...interface{}) {
+	l.l.Output(2, "error: "+fmt.Sprintf(format, v...))
+}
+
+// Warnf prints a warning unless the level is quiet.
+func (l *logger) Warnf(format string, v ...interface{}) {
+	if l.level >= normalLevel {
+		l.l.Output(2, "warning: "+fmt.Sprintf(format, v...))
+	}
+}
+
+// Infof prints information unless the level is quiet.
+func (l *logger) Infof(format string, v ...interface{}) {
+	if l.level >= normalLevel {
+		l.l.Output(2, fmt.Sprintf(format, v...))
+	}
+}
+
+// Debugf prints details if the level is verbose.
+func (l *logger) Debugf(format string, v ...interface{}) {
+	if l.level >= verboseLevel {
+		l.l.Output(2, fmt.Sprintf(format, v...))
+	}
+}
+
+// setLevel sets the level of l from the verbose and quiet options.
+func (l *logger) setLevel(verbose, quiet bool) {
+	switch {
+	case verbose && quiet:
+		l.Fatalf("--verbose and --quiet cannot be used together")
+	case verbose:
+		l.level = verboseLevel
+	case quiet:
+		l.level = quietLevel
+	}
+}
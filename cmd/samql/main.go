@@ -2,19 +2,23 @@ package main
 
 import (
 	"bufio"
+	"compress/gzip"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"regexp"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
 	arg "github.com/alexflint/go-arg"
 	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/bgzf"
 	"github.com/biogo/hts/sam"
 	"github.com/maragkakislab/samql"
 	"github.com/maragkakislab/samql/bamx"
+	"github.com/maragkakislab/samql/encode"
 )
 
 // VERSION defines the program version.
@@ -23,12 +27,92 @@ const VERSION = "1.7"
 // Opts is the struct with the options that the program accepts.
 // Opts encapsulates common command line options.
 type Opts struct {
-	Input []string `arg:"positional,required" help:"file (- for STDIN)"`
+	Input []string `arg:"positional" help:"file or URL (- for STDIN); arguments after the first that are not files, e.g. chr1:10000-20000, are regions as in samtools view and are combined with --where using AND"`
 	Where string   `arg:"" help:"SQL clause to match records"`
-	Count bool     `arg:"-c" help:"print only the count of matching records"`
-	Sam   bool     `arg:"-S" help:"interpret input as SAM, otherwise BAM"`
+	Query string   `arg:"-Q" help:"SQL SELECT statement, also accepted as the first positional argument; selected columns are printed as TSV, SELECT * prints records"`
+	Count bool     `arg:"-c" help:"print only the count of matching records, or with --by the count for each value; same as the count command"`
+	Stats bool     `arg:"--stats" help:"print flagstat-like statistics of matching records; same as the stats command"`
+	JSON  bool     `arg:"--json" help:"print records or statistics as JSON, one record per line"`
+	Sam   bool     `arg:"-S" help:"interpret input as SAM, otherwise the format is detected"`
 	Parr  int      `arg:"-p" help:"Number of cores for parallelization. Uses all available, if not provided."`
 	OBam  bool     `arg:"-b" help:"Output BAM"`
+
+	OSamGz bool `arg:"--osam-gz" help:"Output gzip compressed SAM"`
+	OBgzf  bool `arg:"--obgzf" help:"Output BGZF compressed SAM, as bgzip"`
+	Level  int  `arg:"--compression-level" help:"compression level of BAM output, from 0, uncompressed for fast piping, to 9; -1 is the default level" default:"-1"`
+
+	RequireFlags string `arg:"-f,--require-flags" help:"only records with all these flags, by name or number, e.g. 0x3 or PAIRED,PROPERPAIR; as samtools view -f; combined with --where using AND"`
+	ExcludeFlags string `arg:"-F,--exclude-flags" help:"only records with none of these flags, by name or number; as samtools view -F"`
+	AnyFlags     string `arg:"--rf" help:"only records with any of these flags, by name or number; as samtools view --rf"`
+	MinMapQ      int    `arg:"-q,--min-mapq" help:"only records with MAPQ of at least this value; as samtools view -q"`
+
+	ResumeFrom int64    `arg:"--resume-from" help:"BAM virtual offset to resume reading the input from; requires a single input"`
+	Checkpoint int      `arg:"--checkpoint" help:"print a resume checkpoint to STDERR every N records read; requires a single input"`
+	Progress   bool     `arg:"--progress" help:"print the records read and matched, the throughput and the percent of each input read to STDERR"`
+	Summary    bool     `arg:"--summary" help:"print the records read, matched and rejected by each filter, the wall time and the throughput to STDERR at the end of the run"`
+	Metrics    string   `arg:"--metrics" help:"write the summary of the run as JSON to this file, or - for STDERR"`
+	Verbose    bool     `arg:"--verbose" help:"print the query plan, the index use and the record counts of each input to STDERR"`
+	Quiet      bool     `arg:"--quiet" help:"do not print warnings"`
+	Lenient    bool     `arg:"--lenient" help:"skip invalid SAM records, e.g. with a MAPQ above 255, an RNAME without an @SQ header line or malformed optional fields, with a warning instead of failing"`
+	Permissive bool     `arg:"--permissive" help:"read the records of truncated or corrupt BAM inputs up to the first error, with a warning, instead of failing"`
+	Merge      bool     `arg:"--merge" help:"merge inputs sorted by coordinate or queryname, as declared in their headers, into sorted output; same as the merge command"`
+	BedGraph   bool     `arg:"--bedgraph" help:"print the result of a --query grouped by RNAME and window(POS, N) with a single aggregate as bedGraph"`
+	Parquet    bool     `arg:"--parquet" help:"write the columns selected by a --query as a Parquet file with typed columns instead of TSV"`
+	DebugFirst int      `arg:"--debug-first" help:"print the value of each subexpression of the WHERE clause and whether the record matches for the first N records to STDERR"`
+	Explain    string   `arg:"--explain" help:"print how this WHERE clause is evaluated and the part of each input that is read, without reading any records"`
+	Validate   bool     `arg:"--validate" help:"check the matching records against the SAM specification, e.g. CIGAR and SEQ lengths, flags and, for queryname sorted inputs, the mate fields of pairs, and print the problems found; same as the validate command"`
+	Queries    string   `arg:"--queries" help:"YAML file with named queries [default: ~/.samql/queries.yaml]"`
+	Use        string   `arg:"--use" help:"match records with this named query; combined with --where using AND"`
+	Param      []string `arg:"--param,separate" help:"bind a query parameter, e.g. minq=30 for $minq; values in single quotes are strings; can be repeated"`
+	SourceTag  string   `arg:"--source-tag" help:"add aux tag (e.g. XS) with the input file name to each output record; RG also adds a read group for each input to the header"`
+	QnameFile  string   `arg:"--qname-file" help:"file with read names, one per line, optionally gzipped; only records with these names are returned; combined with --where using AND"`
+	Invert     bool     `arg:"--invert" help:"return the records whose names are not in --qname-file, e.g. to remove contaminant reads"`
+	WithMates  bool     `arg:"--with-mates" help:"compare the names of --qname-file without their /1 or /2 mate suffixes, so that both mates of a listed read are matched"`
+	Regions    string   `arg:"--regions" help:"BED file with regions; only records overlapping a region are returned"`
+	ChrAlias   bool     `arg:"--chr-alias" help:"match reference names of the query and of --regions that are not in the header of an input with the same name with or without the chr prefix, e.g. chr1 with 1 and chrM with MT"`
+	RefAlias   string   `arg:"--ref-alias" help:"TSV file with the aliases of each reference on a line, e.g. chr1 and 1, or GRCh38 or GRCh37 for the built-in UCSC and Ensembl names; reference names of the query and of --regions are matched to each input and the headers of the inputs are merged with the names of the first input"`
+	Sites      string   `arg:"--sites" help:"VCF file, optionally gzip compressed, with the variant sites used by overlaps_site() and allele_at_site()"`
+	Features   string   `arg:"--features" help:"GTF or GFF file, optionally gzip compressed, with the annotations used by overlaps_feature(), feature_name() and feature_type()"`
+	SortBuffer int      `arg:"--sort-buffer" help:"maximum number of records kept in memory for ORDER BY" default:"1000000"`
+	TmpDir     string   `arg:"--tmp-dir" help:"directory for temporary files"`
+	Pairs      bool     `arg:"--pairs" help:"also print the mate of each matching paired record"`
+	BothMates  bool     `arg:"--both-mates" help:"print paired records only if both mates match; implies --pairs"`
+	FetchPairs bool     `arg:"--fetch-pairs" help:"fetch mates that are not read, e.g. outside the query regions, from the BAM index; implies --pairs"`
+	Sample     float64  `arg:"--sample" help:"fraction of records to keep; records are sampled by QNAME so mates are kept together"`
+	Seed       int64    `arg:"--seed" help:"seed for --sample, the SAMPLE clause and rand()"`
+	Workers    int      `arg:"-w" help:"number of goroutines that evaluate filters; filters are evaluated while reading if less than 2"`
+	By         string   `arg:"--by" help:"write records to a separate file for each value of this expression, e.g. RNAME or CB:Z, or with --count count them; same as the split command"`
+	Prefix     string   `arg:"--prefix" help:"prefix of the files written by --by, e.g. a directory"`
+	MaxOpen    int      `arg:"--max-open" help:"maximum number of files kept open by --by" default:"256"`
+	Output     string   `arg:"-o" help:"write output to this file instead of STDOUT; the file is replaced only if samql succeeds"`
+	Unmatched  string   `arg:"--unmatched" help:"also write the records that do not match the WHERE clause to this file; all records are read"`
+	Out        []string `arg:"--out,separate" help:"write the records that match QUERY to FILE, given as --out FILE QUERY, in a single pass; can be repeated"`
+	WriteIndex bool     `arg:"--write-index" help:"write a BAI index, or CSI for long references, next to the BAM output file; output must be sorted by coordinate"`
+
+	AddPG         bool   `arg:"--add-pg" help:"add a @PG line with the samql command line to the output header"`
+	DropPG        bool   `arg:"--drop-pg" help:"remove all @PG lines from the output header; applied before --add-pg"`
+	ReplaceRG     string `arg:"--replace-rg" help:"replace all @RG lines of the output header with this read group line, e.g. 'ID:g1\\tSM:NA12878', and set the RG tag of all records to its ID"`
+	StripSQUnused bool   `arg:"--strip-sq-unused" help:"remove @SQ lines of references without output records from the header; records are written to a temporary file first"`
+	AssumeSorted  bool   `arg:"--assume-sorted" help:"keep the sort order of sorted inputs in the output header when records may be out of order, e.g. for inputs of consecutive references"`
+	IgnoreOrder   bool   `arg:"--ignore-order" help:"do not warn when records of sorted inputs are written out of order; the output header is marked as unsorted"`
+	MergeRefs     string `arg:"--merge-refs" help:"how the @SQ lines of the inputs are merged in the output header: union, in the order they are found, or strict, which requires the same references in the same order; references with different lengths are an error" default:"union"`
+	MergeGroups   string `arg:"--merge-groups" help:"how the @RG and @PG lines of the inputs are merged in the output header: first, which keeps those of the first input, or union, which keeps those of all inputs, once if identical" default:"first"`
+
+	Set       []string `arg:"--set,separate" help:"set an aux tag of the records that match the WHERE clause, e.g. XF:Z=pass or XL:i=LENGTH*2, and print all records; can be repeated"`
+	StripTags string   `arg:"--strip-tags" help:"comma separated aux tags to remove from output records, e.g. OQ,BI,BD"`
+	KeepTags  string   `arg:"--keep-tags" help:"comma separated aux tags to keep in output records, e.g. NM,MD; all other tags are removed"`
+	SetMapQ   *int     `arg:"--set-mapq" help:"set MAPQ of the records that match the WHERE clause and print all records"`
+	SetFlag   string   `arg:"--set-flag" help:"comma separated flags, by name or number, to set in the records that match the WHERE clause, e.g. DUPLICATE,0x200"`
+	ClearFlag string   `arg:"--clear-flag" help:"comma separated flags, by name or number, to clear in the records that match the WHERE clause"`
+
+	BarcodeWhitelist string `arg:"--barcode-whitelist" help:"file with whitelisted barcodes, one per line; only records with a whitelisted barcode are returned"`
+	BarcodeTag       string `arg:"--barcode-tag" help:"aux tag with the barcode of each record for --barcode-whitelist" default:"CB"`
+	MaxDist          int    `arg:"--max-dist" help:"maximum mismatches, 0 or 1, to correct a barcode to a whitelisted barcode" default:"1"`
+
+	Dedup      bool   `arg:"--dedup" help:"mark duplicates among the records that match the WHERE clause by position, strand and CIGAR; input must be sorted by coordinate; same as the dedup command"`
+	RemoveDups bool   `arg:"--remove-dups" help:"remove duplicates instead of marking them; implies --dedup"`
+	UMITag     string `arg:"--umi-tag" help:"aux tag with the UMI of each record, e.g. RX; records with different UMIs are not duplicates"`
+	UMITags    string `arg:"--umi-tags" help:"comma separated aux tags that the UMI keyword is read from, in order of preference" default:"UB,RX"`
 }
 
 // Version returns the program name and version.
@@ -37,116 +121,1090 @@ func (Opts) Version() string { return "samql " + VERSION }
 // Description returns an extended description of the program.
 func (Opts) Description() string { return "Filters a SAM/BAM file using the SQL clause provided" }
 
-// Range describes a range query.
-type Range struct {
-	Rname      string
-	Start, End int
-}
-
 func main() {
+	cmdLine := strings.Join(os.Args, " ")
+
+	// "samql stats ..." is a shorthand for "samql --stats ...".
+	if len(os.Args) > 1 && os.Args[1] == "stats" {
+		os.Args = append([]string{os.Args[0], "--stats"}, os.Args[2:]...)
+	}
+	// "samql count ..." is a shorthand for "samql --count ...".
+	if len(os.Args) > 1 && os.Args[1] == "count" {
+		os.Args = append([]string{os.Args[0], "--count"}, os.Args[2:]...)
+	}
+	// "samql merge ..." is a shorthand for "samql --merge ...".
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		os.Args = append([]string{os.Args[0], "--merge"}, os.Args[2:]...)
+	}
+	// "samql validate ..." is a shorthand for "samql --validate ...".
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Args = append([]string{os.Args[0], "--validate"}, os.Args[2:]...)
+	}
+	// "samql dedup ..." is a shorthand for "samql --dedup ...".
+	if len(os.Args) > 1 && os.Args[1] == "dedup" {
+		os.Args = append([]string{os.Args[0], "--dedup"}, os.Args[2:]...)
+	}
+	// "samql serve ..." runs the HTTP server.
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Args = append([]string{os.Args[0]}, os.Args[2:]...)
+		serve()
+		return
+	}
+	// "samql split --by EXPR ..." is the same as "samql --by EXPR ...".
+	split := len(os.Args) > 1 && os.Args[1] == "split"
+	if split {
+		os.Args = append([]string{os.Args[0]}, os.Args[2:]...)
+	}
+
+	// "--out FILE QUERY" pairs are parsed as a repeated option.
+	os.Args = teeArgs(os.Args)
+
 	var opts Opts
-	arg.MustParse(&opts)
+	p := arg.MustParse(&opts)
+	lg.setLevel(opts.Verbose, opts.Quiet)
+	handleSignals()
+
+	// A SELECT statement can be given before the inputs instead of with
+	// --query, e.g. samql 'SELECT QNAME, POS FROM aln' test.bam.
+	if opts.Query == "" {
+		opts.Query, opts.Input = selectArg(opts.Input)
+	}
+
+	// Bound parameters, e.g. $minq, are replaced by quoted literals in all
+	// queries, including the named queries of the library.
+	params, err := parseParams(opts.Param)
+	if err != nil {
+		lg.Fatalf("%v", err)
+	}
+	for _, q := range []*string{&opts.Where, &opts.Query, &opts.Explain} {
+		if *q, err = samql.BindParams(*q, params); err != nil {
+			lg.Fatalf("cannot bind parameters: %v", err)
+		}
+	}
+
+	// Named queries of the library can be used with --use or referred to by
+	// name in the WHERE clause.
+	lib, err := queryLibrary(opts.Queries)
+	if err != nil {
+		lg.Fatalf("cannot read query library: %v", err)
+	}
+	lib = bindLibrary(lib, params)
+	if opts.Use != "" && opts.Query != "" {
+		lg.Fatalf("--use cannot be used with --query")
+	}
+	if opts.Where, err = libraryWhere(lib, opts.Use, opts.Where); err != nil {
+		lg.Fatalf("invalid query: %v", err)
+	}
+	// The samtools view options are combined with the WHERE clause.
+	view, err := samtoolsWhere(opts)
+	if err != nil {
+		lg.Fatalf("invalid option %v", err)
+	}
+	// A names file selects the records with the names, as QNAME IN FILE, or
+	// with --invert the records without them.
+	if (opts.Invert || opts.WithMates) && opts.QnameFile == "" {
+		lg.Fatalf("--invert and --with-mates require --qname-file")
+	}
+	if opts.QnameFile != "" {
+		names := samql.NamesWhere(opts.QnameFile, opts.WithMates)
+		if opts.Invert {
+			names = "NOT " + names
+		}
+		if view, err = samql.AndWhere(view, names); err != nil {
+			lg.Fatalf("invalid names file: %v", err)
+		}
+	}
+	// Region arguments, e.g. chr1:10000-20000, select the records that
+	// overlap any of the regions.
+	var argRegions []samql.Region
+	opts.Input, argRegions = regionArgs(opts.Input)
+	if len(argRegions) > 0 {
+		if view, err = samql.AndWhere(view, samql.RegionsWhere(argRegions)); err != nil {
+			lg.Fatalf("invalid region: %v", err)
+		}
+	}
+	if view != "" && opts.Query != "" {
+		lg.Fatalf("-f, -F, --rf, -q, --qname-file and regions cannot be used with --query")
+	}
+	if view != "" {
+		if opts.Where, err = samql.AndWhere(opts.Where, view); err != nil {
+			lg.Fatalf("invalid query: %v", err)
+		}
+	}
+	for i := 1; i < len(opts.Out); i += 2 {
+		q, err := samql.BindParams(opts.Out[i], params)
+		if err != nil {
+			lg.Fatalf("cannot bind parameters: %v", err)
+		}
+		if opts.Out[i], err = libraryWhere(lib, "", q); err != nil {
+			lg.Fatalf("invalid query: %v", err)
+		}
+	}
+
+	// The UMI tags and the features and sites of the annotation functions
+	// must be set before any filters, including those of --explain, are
+	// created. All filters are created with filterOpts.
+	umiTags := strings.Split(opts.UMITags, ",")
+	for _, tag := range umiTags {
+		if len(tag) != 2 {
+			lg.Fatalf("invalid UMI tag: %q", tag)
+		}
+	}
+	filterOpts := []samql.FilterOption{samql.WithSeed(opts.Seed), samql.WithUMITags(umiTags...)}
+	if opts.Features != "" {
+		features, err := samql.ReadGTFFile(opts.Features)
+		if err != nil {
+			lg.Fatalf("cannot read features: %v", err)
+		}
+		filterOpts = append(filterOpts, samql.WithFeatures(samql.NewFeatureSet(features)))
+	}
+	if opts.Sites != "" {
+		sites, err := samql.ReadVCFFile(opts.Sites)
+		if err != nil {
+			lg.Fatalf("cannot read sites: %v", err)
+		}
+		filterOpts = append(filterOpts, samql.WithSites(samql.NewSiteSet(sites)))
+	}
+
+	// Explain the query without reading any records, if requested. Inputs
+	// are optional.
+	if opts.Explain != "" {
+		if err := explain(os.Stdout, opts.Explain, opts.Input, lib, filterOpts); err != nil {
+			lg.Fatalf("cannot explain query: %v", err)
+		}
+		return
+	}
+	if len(opts.Input) == 0 {
+		p.Fail("INPUT is required")
+	}
+	if split && opts.By == "" {
+		lg.Fatalf("split requires --by")
+	}
+	if opts.By != "" && (opts.Stats || opts.JSON) {
+		lg.Fatalf("--by cannot be used with --stats or --json")
+	}
+	if split && opts.Count {
+		lg.Fatalf("split cannot be used with --count; use count --by")
+	}
+	if opts.By != "" && opts.Output != "" && !opts.Count {
+		lg.Fatalf("--by cannot be used with --output; use --prefix")
+	}
+	if len(opts.Out)%2 != 0 {
+		lg.Fatalf("--out requires a file and a query")
+	}
+	if len(opts.Out) > 0 && (opts.Output != "" || opts.By != "" ||
+		opts.Count || opts.Stats) {
+		lg.Fatalf("--out cannot be used with --output, --by, --count or --stats")
+	}
+	if (opts.OBam && (opts.OSamGz || opts.OBgzf)) || (opts.OSamGz && opts.OBgzf) {
+		lg.Fatalf("-b, --osam-gz and --obgzf cannot be used together")
+	}
+	if opts.Level < gzip.DefaultCompression || opts.Level > gzip.BestCompression {
+		lg.Fatalf("invalid compression level %d; must be in [0, 9]", opts.Level)
+	}
+	if (opts.OSamGz || opts.OBgzf) && opts.By != "" {
+		lg.Fatalf("--osam-gz and --obgzf cannot be used with --by")
+	}
+	if opts.Validate && (opts.Count || opts.Stats || opts.By != "" || len(opts.Out) > 0) {
+		lg.Fatalf("--validate cannot be used with --count, --stats, --by or --out")
+	}
+	if opts.WriteIndex && (opts.Output == "" || !opts.OBam || opts.JSON ||
+		opts.Count || opts.Stats) {
+		lg.Fatalf("--write-index requires BAM output to a file with --output")
+	}
+	if opts.AssumeSorted && opts.IgnoreOrder {
+		lg.Fatalf("--assume-sorted and --ignore-order cannot be used together")
+	}
+	if opts.ReplaceRG != "" && opts.SourceTag == "RG" {
+		lg.Fatalf("--replace-rg cannot be used with --source-tag RG")
+	}
+	policy := mergePolicy(opts.MergeRefs, opts.MergeGroups)
+
+	// Field transforms are assignments, as --set.
+	sets, err := transforms(opts)
+	if err != nil {
+		lg.Fatalf("invalid transform: %v", err)
+	}
+	if len(sets) > 0 && (opts.Count || opts.Stats || opts.Pairs ||
+		opts.BothMates || opts.FetchPairs) {
+		lg.Fatalf("--set and transforms cannot be used with --count, --stats or pairs options")
+	}
+	opts.Dedup = opts.Dedup || opts.RemoveDups
+	if opts.Dedup && (len(sets) > 0 || opts.Pairs || opts.BothMates ||
+		opts.FetchPairs) {
+		lg.Fatalf("--dedup cannot be used with --set, transforms or pairs options")
+	}
+	if opts.Unmatched != "" && (len(sets) > 0 || opts.Pairs ||
+		opts.BothMates || opts.FetchPairs || opts.Workers > 1) {
+		lg.Fatalf("--unmatched cannot be used with --set, transforms, pairs options or --workers")
+	}
+	if opts.UMITag != "" && !opts.Dedup {
+		lg.Fatalf("--umi-tag requires --dedup")
+	}
+	// Checkpoints are virtual offsets in a single file.
+	if (opts.ResumeFrom != 0 || opts.Checkpoint > 0) && len(opts.Input) > 1 {
+		lg.Fatalf("--resume-from and --checkpoint cannot be used with more than one input")
+	}
+	if opts.StripTags != "" && opts.KeepTags != "" {
+		lg.Fatalf("--strip-tags and --keep-tags cannot be used together")
+	}
 
 	// Distribute threads to IO.
 	if opts.Parr == 0 {
 		opts.Parr = runtime.GOMAXPROCS(0)
 	}
-	IParr, OParr := distributeParrToIO(opts.Parr, opts.Sam, opts.OBam)
+	if opts.Sample < 0 || opts.Sample > 1 {
+		lg.Fatalf("invalid sample fraction %g; must be in (0, 1]", opts.Sample)
+	}
+
+	IParr, OParr := distributeParrToIO(opts.Parr, opts.Sam,
+		(opts.OBam || opts.OBgzf) && !opts.JSON)
+
+	// A SELECT statement replaces the WHERE clause.
+	where := opts.Where
+	var query *samql.Query
+	if opts.Query != "" {
+		if opts.Where != "" {
+			lg.Fatalf("--query and --where cannot be used together")
+		}
+		var err error
+		if query, err = samql.NewQuery(opts.Query, filterOpts...); err != nil {
+			lg.Fatalf("query parsing failed: %v", err)
+		}
+		if query.IsProjection() && opts.By != "" {
+			lg.Fatalf("--by cannot be used with selected columns")
+		}
+		if query.IsProjection() && opts.WriteIndex {
+			lg.Fatalf("--write-index cannot be used with selected columns")
+		}
+		if opts.BedGraph && !query.IsAggregate() {
+			lg.Fatalf("--bedgraph requires an aggregate query")
+		}
+		if opts.Parquet && !query.IsProjection() {
+			lg.Fatalf("--parquet requires selected columns")
+		}
+		if query.IsSorted() && opts.Merge {
+			lg.Fatalf("--merge cannot be used with ORDER BY")
+		}
+		// Merged and sorted records are no longer associated with
+		// their input.
+		if query.NeedsInput() && len(opts.Input) > 1 && (query.IsSorted() || opts.Merge) {
+			lg.Fatalf("keywords of specific inputs, e.g. SOURCE, cannot be selected with --merge or ORDER BY and several inputs")
+		}
+		if query.IsProjection() && len(opts.Out) > 0 {
+			lg.Fatalf("--out cannot be used with selected columns")
+		}
+		where = query.Where()
+	}
+	if opts.BedGraph && query == nil {
+		lg.Fatalf("--bedgraph requires --query")
+	}
+	if opts.Parquet && (query == nil || opts.BedGraph) {
+		lg.Fatalf("--parquet requires --query and cannot be used with --bedgraph")
+	}
+
+	// The LIMIT clause stops reading after the first matching records.
+	limit := samql.QueryLimit(where)
+	if query != nil {
+		limit = query.Limit()
+	}
+	if limit > 0 && (len(sets) > 0 || opts.Unmatched != "") {
+		lg.Fatalf("LIMIT cannot be used with --set, transforms or --unmatched")
+	}
+
+	// Capture potential region queries early to inform readers creation.
+	// Regions from a BED file take precedence as they are usually more
+	// specific. With --set all records are read and the query selects only
+	// the records that are modified. With --unmatched all records are read.
+	var regions []samql.Region
+	if len(sets) == 0 && opts.Unmatched == "" {
+		regions, _ = samql.QueryRegions(where)
+	}
+	var regionsFilter samql.FilterFunc
+	if opts.Regions != "" {
+		bed, err := samql.ReadBEDFile(opts.Regions)
+		if err != nil {
+			lg.Fatalf("cannot read regions: %v", err)
+		}
+		regions = bed
+		regionsFilter = samql.OverlapFilter(bed)
+	}
 
-	// Capture potential range queries early to inform readers creation.
-	rquery := captureRangeQuery(opts.Where)
+	// Skip the invalid records of SAM inputs, if requested, with a warning.
+	// Validation counts them as problems.
+	var skip func(in string) func(line int64, err error)
+	if opts.Lenient {
+		skip = skipWarner
+	}
+	validation := samql.NewValidation()
+	if opts.Validate {
+		skip = func(in string) func(line int64, err error) {
+			return func(line int64, err error) {
+				lg.Debugf("%s: line %d: %v", in, line, err)
+				validation.AddInvalid(fmt.Sprintf("%s:%d", in, line))
+			}
+		}
+	}
+
+	// Match the reference names of queries that are not in the header of an
+	// input to their aliases, if requested.
+	var aliases samql.RefAliases
+	if opts.RefAlias != "" {
+		var ok bool
+		if aliases, ok = samql.BuiltinRefAliases(opts.RefAlias); !ok {
+			var err error
+			if aliases, err = samql.ReadRefAliasesFile(opts.RefAlias); err != nil {
+				lg.Fatalf("cannot read reference aliases: %v", err)
+			}
+		}
+	}
+	renamer := newRenamer(opts.ChrAlias, aliases)
 
 	// Create samql readers that read from the inputs.
-	readers := getSamqlReaders(opts.Input, opts.Sam, IParr, rquery)
+	readers, indexed := getSamqlReaders(opts.Input, opts.Sam, IParr, regions,
+		opts.ResumeFrom, opts.Checkpoint, opts.Permissive, renamer, skip)
+
+	// The references of the inputs are renamed to their aliases in the first
+	// input, so that the headers are merged with a single name for each.
+	if renamer != nil {
+		for _, r := range readers[1:] {
+			renameRefs(r.Header(), renamer(readers[0].Header()))
+		}
+	}
 	defer func() { // Close all samql readers at the end.
 		for _, r := range readers {
 			if err := r.Close(); err != nil {
-				log.Fatalf("cannot close samql reader: %v", err)
+				lg.Fatalf("cannot close samql reader: %v", err)
 			}
 		}
 	}()
 
+	// Report the progress of reading each input, if requested, and the
+	// record counts of each input in verbose mode. Workers read from the
+	// inputs directly and pairs are filtered after the readers, so the
+	// readers cannot count them.
+	countable := opts.Workers <= 1 && !opts.Pairs && !opts.BothMates && !opts.FetchPairs
+	if opts.Progress && !countable {
+		lg.Fatalf("--progress cannot be used with --workers or pairs options")
+	}
+	// The summary of the run, if requested, counts the records that each
+	// filter rejects, so the filters are named as they are appended.
+	summary := opts.Summary || opts.Metrics != ""
+	if summary && !countable {
+		lg.Fatalf("--summary and --metrics cannot be used with --workers or pairs options")
+	}
+	metrics := newRunMetrics(opts.Input)
+	if opts.Progress || summary || (opts.Verbose && countable) {
+		for i, r := range readers {
+			var report func(samql.Progress)
+			if opts.Progress {
+				report = newProgressReporter(os.Stderr, opts.Input[i]).Report
+			}
+			in, i := opts.Input[i], i
+			r.OnProgress = func(p samql.Progress) {
+				if report != nil {
+					report(p)
+				}
+				if summary {
+					metrics.update(i, p)
+				}
+				if p.Done {
+					lg.Debugf("%s: %d records read, %d matched", in, p.Read, p.Matched)
+				}
+			}
+			r.ProgressEvery = progressEvery
+		}
+	}
+
+	// Keep only records that overlap the provided regions. With --chr-alias
+	// the regions are renamed to the references of each input.
+	if regionsFilter != nil {
+		for i, r := range readers {
+			filter := regionsFilter
+			if renamer != nil {
+				filter = samql.OverlapFilter(renameRegions(regions, renamer(r.Header())))
+			}
+			r.AppendFilter(filter)
+			metrics.addFilter(i, "regions")
+		}
+	}
+
+	// Keep only records with a whitelisted barcode, correcting barcodes with
+	// a mismatch, so that the WHERE clause sees the corrected barcodes.
+	if opts.BarcodeWhitelist != "" {
+		barcodes, err := samql.ReadBarcodesFile(opts.BarcodeWhitelist)
+		if err != nil {
+			lg.Fatalf("cannot read barcode whitelist: %v", err)
+		}
+		bf, err := samql.NewBarcodeFilter(barcodes, opts.BarcodeTag, opts.MaxDist)
+		if err != nil {
+			lg.Fatalf("invalid barcode filter: %v", err)
+		}
+		for i, r := range readers {
+			r.AppendFilter(bf.Filter)
+			metrics.addFilter(i, "barcodes")
+		}
+	}
+
 	// Create new filter based on provided where clause and add it to the
-	// samql readers.
-	if opts.Where != "" {
-		filter, err := samql.Where(opts.Where)
+	// samql readers. The SOURCE keyword is bound to the name of each input and
+	// the read group keywords to the read groups in its header.
+	// Inputs that read only the query regions from an index need only the
+	// residual filter. In pairs mode the filter is applied to read pairs.
+	// With --set the filter selects the records whose tags are set. With
+	// --unmatched the records that do not match are written as filtered.
+	setConds := make([]samql.FilterFunc, len(readers))
+	unmatched := &unmatchedWriter{}
+	// The first records are traced as they are filtered, which requires
+	// that the filters are evaluated in order.
+	tracer := &debugTracer{w: os.Stderr, left: opts.DebugFirst}
+	if opts.DebugFirst > 0 && opts.Workers > 1 {
+		lg.Fatalf("--debug-first cannot be used with --workers")
+	}
+	if where != "" {
+		for i, r := range readers {
+			where := where
+			if renamer != nil {
+				var err error
+				if where, err = samql.RenameRefs(where, renamer(r.Header())); err != nil {
+					lg.Fatalf("filter creation from where clause failed: %v", err)
+				}
+			}
+			diags := samql.Validate(where, r.Header(), filterOpts...)
+			plan, err := samql.PlanHeader(where, inputName(opts.Input[i]), r.Header(), filterOpts...)
+			if err != nil {
+				if len(diags) > 0 {
+					err = diags[0]
+				}
+				lg.Fatalf("filter creation from where clause failed: %v", err)
+			}
+			for _, d := range diags {
+				lg.Warnf("%s: %v", opts.Input[i], d)
+			}
+			filter := plan.Filter
+			if indexed[i] != nil && regionsFilter == nil {
+				filter = plan.Residual
+			}
+			lg.Debugf("%s: query plan %s; residual used: %t", opts.Input[i],
+				plan, indexed[i] != nil && regionsFilter == nil)
+			if opts.DebugFirst > 0 {
+				t, err := samql.NewTracerHeader(where, inputName(opts.Input[i]), r.Header(), filterOpts...)
+				if err != nil {
+					lg.Fatalf("cannot trace where clause: %v", err)
+				}
+				filter = tracer.filter(filter, t)
+			}
+
+			// Counting the records of queries that only select regions
+			// of an indexed file needs only the position of the records,
+			// so their variable length data are not decoded.
+			if indexed[i] != nil && regionsFilter == nil && plan.RegionOnly &&
+				countOnly(opts) {
+				indexed[i].Omit(bam.AllVariableLengthData)
+				lg.Debugf("%s: counting records from the index", opts.Input[i])
+			}
+
+			if len(sets) > 0 {
+				setConds[i] = filter
+				continue
+			}
+			if opts.Unmatched != "" {
+				filter = unmatched.filter(filter, i)
+			}
+
+			if !opts.Pairs && !opts.BothMates && !opts.FetchPairs {
+				r.AppendFilter(filter)
+				metrics.addFilter(i, "where")
+				continue
+			}
+			policy := samql.EitherMate
+			if opts.BothMates {
+				policy = samql.BothMates
+			}
+			pr := samql.PairAware(r, filter, policy)
+			if opts.FetchPairs {
+				if mr := openMateReader(opts.Input[i]); mr != nil {
+					defer mr.Close()
+					// Fetched mates have the names of the input.
+					if renamer != nil && i > 0 {
+						renameRefs(mr.Header(), renamer(readers[0].Header()))
+					}
+					pr.Fetch = mr.Mate
+					pr.FetchFilter = plan.Filter
+				}
+			}
+			readers[i] = samql.NewReader(pr)
+		}
+	}
+
+	// Subsample the records, if requested.
+	if opts.Sample > 0 {
+		for i, r := range readers {
+			r.AppendFilter(samql.Sample(opts.Sample, opts.Seed))
+			metrics.addFilter(i, "sample")
+		}
+	}
+
+	// Evaluate the filters on a pool of workers, if requested. Records are
+	// read ahead of the output, so checkpoints would not correspond to the
+	// records written.
+	if opts.Workers > 1 {
+		if opts.Checkpoint > 0 {
+			lg.Fatalf("--workers cannot be used with --checkpoint")
+		}
+		for i, r := range readers {
+			readers[i] = samql.NewReader(samql.NewPipeline(r, opts.Workers))
+		}
+	}
+
+	// Mark or remove duplicates among the records that pass the filters,
+	// if requested. Each input is deduplicated separately.
+	if opts.Dedup {
+		for i, r := range readers {
+			d, err := samql.NewDeduper(r, opts.UMITag)
+			if err != nil {
+				lg.Fatalf("cannot create deduper: %v", err)
+			}
+			d.Remove = opts.RemoveDups
+			readers[i] = samql.NewReader(d)
+		}
+	}
+
+	// Stop reading when LIMIT records have been read from all inputs.
+	// Sorted and merged records are limited after sorting or merging and
+	// the rows of aggregates are the groups, unless the records are counted.
+	ordered := opts.Merge || query != nil && (query.IsSorted() || query.IsAggregate())
+	if limit > 0 && (opts.Count || opts.Stats || !ordered) {
+		readers = limitReaders(readers, limit)
+	}
+
+	// Create the function that tags records with the input they were read
+	// from, if requested.
+	var tagRecord func(rec *sam.Record, i int) error
+	if opts.SourceTag != "" {
+		if len(opts.SourceTag) != 2 {
+			lg.Fatalf("invalid source tag: %q", opts.SourceTag)
+		}
+		srcTag := sam.NewTag(opts.SourceTag)
+		tagRecord = func(rec *sam.Record, i int) error {
+			return setTag(rec, srcTag, inputName(opts.Input[i]))
+		}
+	}
+
+	// Set the tags and fields of the records that match the WHERE clause, if
+	// requested.
+	if len(sets) > 0 {
+		setter, err := samql.NewSetter(sets...)
 		if err != nil {
-			log.Fatalf("filter creation from where clause failed: %v", err)
+			lg.Fatalf("invalid --set: %v", err)
 		}
-		for _, r := range readers {
-			r.AppendFilter(filter)
+		tagSource := tagRecord
+		tagRecord = func(rec *sam.Record, i int) error {
+			if tagSource != nil {
+				if err := tagSource(rec, i); err != nil {
+					return err
+				}
+			}
+			if setConds[i] != nil && !setConds[i](rec) {
+				return nil
+			}
+			return setter.Set(rec)
+		}
+	}
+
+	// Write the output to a temporary file that replaces the output file
+	// only when all records are written. Failures after this point remove
+	// the temporary file.
+	output := io.Writer(os.Stdout)
+	fatalf := lg.Fatalf
+	var outFile *outputFile
+	if opts.Output != "" {
+		var err error
+		if outFile, err = createOutput(opts.Output); err != nil {
+			lg.Fatalf("cannot create output file: %v", err)
+		}
+		output = outFile
+		fatalf = func(format string, v ...interface{}) {
+			outFile.Abort()
+			lg.Fatalf(format, v...)
+		}
+	}
+	// commit replaces the output file, if any, with the temporary file and
+	// reports the summary of the run, if requested.
+	commit := func() {
+		if outFile != nil {
+			if err := outFile.Commit(); err != nil {
+				lg.Fatalf("cannot write output file: %v", err)
+			}
+		}
+		if summary {
+			if err := metrics.report(opts.Summary, opts.Metrics); err != nil {
+				lg.Fatalf("cannot write metrics: %v", err)
+			}
+		}
+	}
+
+	// Open the file of the records that do not match, which is written while
+	// the records are read, with the merged headers of the inputs.
+	if opts.Unmatched != "" {
+		headers := make([]*sam.Header, len(readers))
+		for i, r := range readers {
+			headers[i] = r.Header()
+		}
+		h, links, err := samql.MergeHeaders(headers, policy)
+		if err != nil {
+			fatalf("cannot merge headers: %v", err)
+		}
+		if err := unmatched.open(opts.Unmatched, h, links, opts, OParr); err != nil {
+			fatalf("cannot create unmatched file: %v", err)
+		}
+		fatalf0, commit0 := fatalf, commit
+		fatalf = func(format string, v ...interface{}) {
+			unmatched.Abort()
+			fatalf0(format, v...)
+		}
+		commit = func() {
+			if err := unmatched.Close(); err != nil {
+				fatalf0("cannot write unmatched file: %v", err)
+			}
+			commit0()
 		}
 	}
 
-	// If only counting is requested do just that.
+	// If only counting is requested do just that. With --by the records are
+	// counted for each value, as with GROUP BY.
+	if opts.Count && opts.By != "" {
+		q, err := samql.NewQuery(fmt.Sprintf("SELECT %s, count(*) FROM aln GROUP BY %s", opts.By, opts.By), filterOpts...)
+		if err != nil {
+			fatalf("invalid --by: %v", err)
+		}
+		qs, err := bindQueries(q, readers, opts.Input)
+		if err != nil {
+			fatalf("invalid --by: %v", err)
+		}
+		stdout := bufio.NewWriter(output)
+		if err := writeTable(stdout, readers, qs); err != nil {
+			fatalf("counting failed: %v", err)
+		}
+		if err := stdout.Flush(); err != nil {
+			fatalf("flashing of stdout cache failed: %v", err)
+		}
+		commit()
+		os.Exit(0)
+	}
 	if opts.Count {
+		// Counted records are not retained, so they are read into a
+		// single record.
 		cnt := 0
+		var rec sam.Record
 		for _, r := range readers {
 			for {
-				_, err := r.Read()
+				err := r.ReadInto(&rec)
 				if err != nil {
 					if err == io.EOF {
 						break
 					}
-					log.Fatalf("filtering failed: %v", err)
+					fatalf("filtering failed: %v", err)
 				}
 				cnt++
 			}
 		}
-		fmt.Println(cnt)
+		fmt.Fprintln(output, cnt)
+		commit()
 		os.Exit(0)
 	}
 
+	// If statistics are requested compute them for all inputs together.
+	if opts.Stats {
+		stats := samql.NewStats()
+		for _, r := range readers {
+			for {
+				rec, err := r.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					fatalf("filtering failed: %v", err)
+				}
+				stats.Add(rec)
+			}
+		}
+		var err error
+		if opts.JSON {
+			err = stats.WriteJSON(output)
+		} else {
+			err = stats.WriteText(output)
+		}
+		if err != nil {
+			fatalf("cannot write statistics: %v", err)
+		}
+		commit()
+		return
+	}
+
+	// Check the records against the SAM specification, if requested. The
+	// mates of pairs are adjacent only in inputs sorted or grouped by
+	// queryname. Invalid SAM records, which cannot be filtered, are
+	// counted as they are skipped. The exit status is 1 if problems are
+	// found.
+	if opts.Validate {
+		for _, r := range readers {
+			h := r.Header()
+			validation.Mates = h.SortOrder == sam.QueryName || h.GroupOrder == sam.GroupQuery
+			for {
+				rec, err := r.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					fatalf("filtering failed: %v", err)
+				}
+				validation.Add(rec)
+			}
+			validation.Flush()
+		}
+		var err error
+		if opts.JSON {
+			err = validation.WriteJSON(output)
+		} else {
+			err = validation.WriteText(output)
+		}
+		if err != nil {
+			fatalf("cannot write validation: %v", err)
+		}
+		commit()
+		if len(validation.Problems) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Create new header by merging all headers.
 	headers := make([]*sam.Header, len(readers))
 	for i, r := range readers {
 		headers[i] = r.Header()
 	}
-	mergedHeader, _, err := sam.MergeHeaders(headers)
+	mergedHeader, links, err := samql.MergeHeaders(headers, policy)
 	if err != nil {
-		log.Fatalf("cannot merge headers: %v", err)
+		fatalf("cannot merge headers: %v", err)
+	}
+
+	// BAM records refer to the references of their input by ID, so they are
+	// relinked to the references of the merged header before any tagging.
+	if links != nil {
+		tagSource := tagRecord
+		tagRecord = func(rec *sam.Record, i int) error {
+			samql.Relink(rec, links[i])
+			if tagSource != nil {
+				return tagSource(rec, i)
+			}
+			return nil
+		}
+	}
+
+	// Records tagged with the input name as RG refer to a read group for
+	// each input, which must be declared in the header.
+	if opts.SourceTag == "RG" {
+		for _, in := range opts.Input {
+			if err := addReadGroup(mergedHeader, inputName(in)); err != nil {
+				fatalf("cannot add read group %s: %v", inputName(in), err)
+			}
+		}
+	}
+
+	// Modify the header, if requested. Records get the ID of the replacing
+	// read group when they are tagged.
+	if opts.DropPG {
+		if err := dropPrograms(mergedHeader); err != nil {
+			fatalf("cannot remove @PG lines: %v", err)
+		}
+	}
+	if opts.ReplaceRG != "" {
+		var id string
+		if mergedHeader, id, err = replaceReadGroups(mergedHeader, opts.ReplaceRG); err != nil {
+			fatalf("cannot replace read groups: %v", err)
+		}
+		rgTag, tagSource := sam.NewTag("RG"), tagRecord
+		tagRecord = func(rec *sam.Record, i int) error {
+			if tagSource != nil {
+				if err := tagSource(rec, i); err != nil {
+					return err
+				}
+			}
+			return setTag(rec, rgTag, id)
+		}
+	}
+	if opts.AddPG {
+		if err := addProgram(mergedHeader, cmdLine); err != nil {
+			fatalf("cannot add @PG line: %v", err)
+		}
+	}
+
+	// Remove aux tags from the records, if requested. Tags are removed after
+	// any other tags are set.
+	if opts.StripTags != "" || opts.KeepTags != "" {
+		var tf *samql.TagFilter
+		if opts.KeepTags != "" {
+			tf, err = samql.KeepTags(strings.Split(opts.KeepTags, ",")...)
+		} else {
+			tf, err = samql.StripTags(strings.Split(opts.StripTags, ",")...)
+		}
+		if err != nil {
+			fatalf("invalid tags: %v", err)
+		}
+		tagSource := tagRecord
+		tagRecord = func(rec *sam.Record, i int) error {
+			if tagSource != nil {
+				if err := tagSource(rec, i); err != nil {
+					return err
+				}
+			}
+			tf.Apply(rec)
+			return nil
+		}
+	}
+
+	// Sort the filtered records, if requested. All inputs are merged into a
+	// single sorted reader. Otherwise the output header keeps the order of
+	// sorted inputs only if their records are written in order.
+	out := readers
+	if query != nil && query.IsSorted() {
+		mergedHeader.SortOrder = query.SortOrder()
+		sorter := query.NewSorter(mergedHeader)
+		sorter.BufferSize = opts.SortBuffer
+		sorter.TempDir = opts.TmpDir
+		src, err := sortReaders(sorter, readers, tagRecord)
+		if err != nil {
+			fatalf("sorting failed: %v", err)
+		}
+		defer func() {
+			if err := src.Close(); err != nil {
+				fatalf("cannot close sorted reader: %v", err)
+			}
+		}()
+		out = []*samql.Reader{samql.NewReader(src)}
+		if limit > 0 {
+			out = limitReaders(out, limit)
+		}
+		tagRecord = nil // Records were tagged before sorting.
+	} else if opts.Merge {
+		order, err := mergeOrder(readers)
+		if err != nil {
+			fatalf("cannot merge: %v", err)
+		}
+		mergedHeader.SortOrder = order
+		src, err := mergeReaders(readers, mergedHeader, order, tagRecord)
+		if err != nil {
+			fatalf("cannot merge: %v", err)
+		}
+		out = []*samql.Reader{samql.NewReader(src)}
+		if limit > 0 && (query == nil || !query.IsAggregate()) {
+			out = limitReaders(out, limit)
+		}
+		tagRecord = nil // Records are tagged before merging.
+	} else {
+		mergedHeader.SortOrder = outputOrder(readers, mergedHeader, opts)
 	}
 
-	// Open a writer that prints to STDOUT.
-	stdout := bufio.NewWriter(os.Stdout)
-	defer func() {
+	// If specific columns are selected print them as a table or, for
+	// windowed aggregates, as bedGraph or, with --parquet, as Parquet.
+	if query != nil && query.IsProjection() {
+		// The query is bound to each input unless the inputs were
+		// merged or sorted into one.
+		qs := []*samql.Query{query}
+		if len(out) == len(readers) {
+			if qs, err = bindQueries(query, out, opts.Input); err != nil {
+				fatalf("query compilation failed: %v", err)
+			}
+		}
+		stdout := bufio.NewWriter(output)
+		if opts.BedGraph {
+			err = writeBedGraph(stdout, out, qs, mergedHeader)
+		} else if opts.Parquet {
+			err = writeParquet(stdout, out, qs)
+		} else {
+			err = writeTable(stdout, out, qs)
+		}
+		if err != nil {
+			fatalf("writing table failed: %v", err)
+		}
 		if err := stdout.Flush(); err != nil {
-			log.Fatalf("flashing of stdout cache failed: %v", err)
+			fatalf("flashing of stdout cache failed: %v", err)
 		}
-	}()
+		commit()
+		return
+	}
 
-	// Open a new SAM/BAM writer.
-	var w writer
-	if opts.OBam {
-		w, err = bam.NewWriter(stdout, mergedHeader, OParr)
+	// Remove the references without output records from the header. The
+	// records are written to a temporary file to find the used references.
+	if opts.StripSQUnused {
+		h, src, err := stripUnusedRefs(mergedHeader, out, tagRecord, opts.TmpDir)
+		if err != nil {
+			fatalf("cannot remove unused references: %v", err)
+		}
+		defer func() {
+			if err := src.Close(); err != nil {
+				lg.Fatalf("cannot remove temporary file: %v", err)
+			}
+		}()
+		mergedHeader = h
+		out = []*samql.Reader{samql.NewReader(src)}
+		tagRecord = nil // Records were tagged before they were written.
+	}
+
+	// Open a new SAM/BAM/JSON writer that prints to the output or, when
+	// splitting, a writer that writes a file for each value or, in tee mode,
+	// a writer that writes the records matching each query to its file.
+	var w recordWriter
+	if len(opts.Out) > 0 {
+		var tw *samql.TeeWriter
+		var files []*outputFile
+		if tw, files, err = newTeeWriter(opts.Out, mergedHeader, opts, OParr, filterOpts); err == nil {
+			w = tw
+			fatalf0, commit0 := fatalf, commit
+			fatalf = func(format string, v ...interface{}) {
+				for _, f := range files {
+					f.Abort()
+				}
+				fatalf0(format, v...)
+			}
+			commit = func() {
+				for _, f := range files {
+					if err := f.Commit(); err != nil {
+						lg.Fatalf("cannot write output file: %v", err)
+					}
+				}
+				commit0()
+			}
+		}
+	} else if opts.By != "" {
+		format, ext := samql.SAM, ".sam"
+		if opts.OBam {
+			format, ext = samql.BAM, ".bam"
+		}
+		var sw *samql.SplitWriter
+		sw, err = samql.NewSplitWriter(opts.By, mergedHeader, format,
+			func(v string) string { return opts.Prefix + v + ext })
+		if sw != nil {
+			sw.MaxOpen = opts.MaxOpen
+			sw.Level = opts.Level
+		}
+		w = sw
+	} else if opts.JSON {
+		w = samql.NewWriter(encode.NewJSONWriter(output))
+	} else if opts.OBam {
+		w, err = samql.NewBAMWriterLevel(output, mergedHeader, opts.Level, OParr)
+	} else if opts.OSamGz {
+		w, err = samql.NewSAMGzipWriter(output, mergedHeader)
+	} else if opts.OBgzf {
+		w, err = samql.NewSAMBGZFWriter(output, mergedHeader, OParr)
 	} else {
-		w, err = sam.NewWriter(stdout, mergedHeader, sam.FlagDecimal)
+		w, err = samql.NewSAMWriter(output, mergedHeader)
 	}
 	if err != nil {
-		log.Fatalf("cannot open SAM/BAM writer: %v", err)
+		fatalf("cannot open SAM/BAM writer: %v", err)
 	}
 
-	// Loop on the filtered records and output.
-	for _, r := range readers {
-		for {
+	// Loop on the filtered records and output. If interrupted, the records
+	// written so far are flushed and the writer is closed, so that BAM
+	// output to STDOUT ends with the EOF block, and the output file is
+	// removed.
+	stopOnInterrupt()
+	for i, r := range out {
+		for !isInterrupted() {
 			rec, err := r.Read()
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
-				log.Fatalf("filtering failed: %v", err)
+				fatalf("filtering failed: %v", err)
+			}
+
+			if tagRecord != nil {
+				if err := tagRecord(rec, i); err != nil {
+					fatalf("cannot tag record %s: %v", rec.Name, err)
+				}
 			}
 
 			if err := w.Write(rec); err != nil {
-				log.Fatalf("write failed: %v for %s", err, rec.Name)
+				fatalf("write failed: %v for %s", err, rec.Name)
 			}
 		}
 
 	}
-	// Close w if it is a bam writer
-	if temp, ok := w.(*bam.Writer); ok {
-		temp.Close()
+	if err := w.Close(); err != nil {
+		fatalf("cannot close SAM/BAM writer: %v", err)
+	}
+	if isInterrupted() {
+		exitInterrupted()
+	}
+	commit()
+
+	// Index the BAM output file, if requested.
+	if opts.WriteIndex {
+		if _, err := writeIndex(opts.Output, bamx.NeedsCSI(mergedHeader)); err != nil {
+			lg.Fatalf("cannot index %s: %v", opts.Output, err)
+		}
+	}
+}
+
+// transforms returns the assignments of --set followed by those of the field
+// transforms.
+func transforms(opts Opts) ([]string, error) {
+	sets := append([]string(nil), opts.Set...)
+	if opts.SetMapQ != nil {
+		sets = append(sets, fmt.Sprintf("MAPQ=%d", *opts.SetMapQ))
+	}
+	if opts.SetFlag != "" || opts.ClearFlag != "" {
+		set, err := parseFlags(opts.SetFlag)
+		if err != nil {
+			return nil, err
+		}
+		clear, err := parseFlags(opts.ClearFlag)
+		if err != nil {
+			return nil, err
+		}
+		// Flags are set before they are cleared.
+		sets = append(sets, fmt.Sprintf("FLAG=((FLAG|%d)|%d)^%d", set, clear, clear))
+	}
+	return sets, nil
+}
+
+// countOnly returns true if only the number of records that pass the filters
+// is printed, so that records are not read beyond the fields that the
+// filters use. Barcodes, duplicates and pairs are determined from other
+// fields of the records.
+func countOnly(opts Opts) bool {
+	return opts.Count && opts.By == "" && opts.BarcodeWhitelist == "" &&
+		!opts.Dedup && opts.Unmatched == "" && !opts.Pairs && !opts.BothMates &&
+		!opts.FetchPairs
+}
+
+// parseFlags returns the bits of the comma separated flag names or numbers in
+// s, e.g. "DUPLICATE,0x200".
+func parseFlags(s string) (int, error) {
+	bits := 0
+	if s == "" {
+		return bits, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		if b, ok := samql.FlagNames[strings.ToUpper(f)]; ok {
+			bits |= int(b)
+			continue
+		}
+		n, err := strconv.ParseUint(f, 0, 16)
+		if err != nil {
+			return 0, fmt.Errorf("unknown flag %q", f)
+		}
+		bits |= int(n)
 	}
+	return bits, nil
+}
+
+// recordWriter is the interface of the writers that output records.
+type recordWriter interface {
+	Write(*sam.Record) error
+	Close() error
 }
 
 // distributeParrToIO distributes the threads P to the SAM/BAM
@@ -175,90 +1233,329 @@ func distributeParrToIO(P int, ISam, OBam bool) (IParr, OParr int) {
 	return IParr, OParr
 }
 
-func captureRangeQuery(where string) *Range {
-	m := regexp.MustCompile(`RNAME\s*=\s*['"]?(.+?)['"]?\b`).FindStringSubmatch(where)
-	if m == nil { // no range query found
-		return nil
-	}
+// sortReaders adds all records from readers to sorter and returns a source
+// that reads them in sorted order. If tag is not nil, it is called for each
+// record with the index of the reader the record was read from.
+func sortReaders(sorter *samql.Sorter, readers []*samql.Reader,
+	tag func(*sam.Record, int) error) (samql.Source, error) {
 
-	rng := &Range{Rname: m[1]}
+	for i, r := range readers {
+		for {
+			rec, err := r.Read()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				sorter.Close()
+				return nil, err
+			}
+			if tag != nil {
+				if err := tag(rec, i); err != nil {
+					sorter.Close()
+					return nil, err
+				}
+			}
+			if err := sorter.Add(rec); err != nil {
+				sorter.Close()
+				return nil, err
+			}
+		}
+	}
+	return sorter.Sort()
+}
 
-	m = regexp.MustCompile(`POS\s*(>|>=|=)\s*(\d+)`).FindStringSubmatch(where)
-	if m != nil {
-		rng.Start, _ = strconv.Atoi(m[2])
+// selectArg splits the SELECT statement that is the first of the positional
+// arguments args, if any, from the inputs. An existing file whose name starts
+// with SELECT is an input.
+func selectArg(args []string) (query string, inputs []string) {
+	if len(args) == 0 {
+		return "", args
+	}
+	fields := strings.Fields(args[0])
+	if len(fields) < 2 || !strings.EqualFold(fields[0], "SELECT") {
+		return "", args
+	}
+	if _, err := os.Stat(args[0]); err == nil {
+		return "", args
 	}
+	return args[0], args[1:]
+}
 
-	m = regexp.MustCompile(`POS\s*(<|<=)\s*(\d+)`).FindStringSubmatch(where)
-	if m == nil {
-		rng.End = -1
-	} else {
-		rng.End, _ = strconv.Atoi(m[2])
+// inputName returns the name that identifies the input src in queries and
+// output records. It is the base name of the file or "-" for STDIN.
+func inputName(src string) string {
+	return filepath.Base(src)
+}
+
+// setTag sets the value of tag t in rec to val, replacing any existing value.
+func setTag(rec *sam.Record, t sam.Tag, val interface{}) error {
+	aux, err := sam.NewAux(t, val)
+	if err != nil {
+		return err
+	}
+	for i, a := range rec.AuxFields {
+		if a.Tag() == t {
+			rec.AuxFields[i] = aux
+			return nil
+		}
 	}
+	rec.AuxFields = append(rec.AuxFields, aux)
+	return nil
+}
 
-	return rng
+// addReadGroup adds a read group with ID name to h, unless it already exists.
+func addReadGroup(h *sam.Header, name string) error {
+	for _, rg := range h.RGs() {
+		if rg.Name() == name {
+			return nil
+		}
+	}
+	rg, err := sam.NewReadGroup(name, "", "", "", "", "", "", "", "", "", time.Time{}, 0)
+	if err != nil {
+		return err
+	}
+	return h.AddReadGroup(rg)
 }
 
 // getFileDescriptor returns a file descriptor that reads from src. It returns
-// os.Stdin if src is "-".
-func getFileDescriptor(src string) (*os.File, error) {
+// os.Stdin if src is "-" and a remote file if src is a remote URL.
+func getFileDescriptor(src string) (io.ReadCloser, error) {
 	if src == "-" {
 		return os.Stdin, nil
 	}
+	if samql.IsRemote(src) {
+		return samql.OpenRemote(src)
+	}
 
 	return os.Open(src)
 }
 
-// getSamqlReaders returns a slice of samql readers that read from the inputs.
-func getSamqlReaders(inputs []string, isSam bool, parr int, rquery *Range) []*samql.Reader {
+// getSamqlReaders returns a slice of samql readers that read from the inputs
+// and, for each reader that reads only the provided regions from an index,
+// the indexed BAM reader or nil otherwise.
+// Inputs are read as SAM if isSam is true, otherwise the format of each input
+// is detected from its contents. Indexed BAM inputs read only the provided
+// regions, if any. If resume is not zero the single input is read starting
+// from the BAM virtual offset resume. If ckpt is positive a checkpoint is
+// printed to STDERR every ckpt records read. Index region queries are not used
+// when resuming or checkpointing, as both require a linear scan of the file.
+// BAM files without the BGZF EOF marker, which are probably truncated, and
+// inputs that turn out to be truncated or corrupt when read are errors, unless
+// permissive is true, in which case their records up to the error are read
+// with a warning. If renamer is not nil, the references of the regions are
+// renamed by the function that it returns for the header of each input, e.g.
+// to their aliases. If skip is not nil, the invalid records of SAM inputs are
+// skipped and passed to the function returned by skip for the input.
+func getSamqlReaders(inputs []string, isSam bool, parr int, regions []samql.Region,
+	resume int64, ckpt int, permissive bool, renamer func(*sam.Header) func(string) string,
+	skip func(in string) func(line int64, err error)) ([]*samql.Reader, []*bamx.Reader) {
 
 	readers := make([]*samql.Reader, len(inputs))
+	indexed := make([]*bamx.Reader, len(inputs))
 	for i, in := range inputs {
+		// Inputs with a URL scheme are opened by the registered sources.
+		// Remote files are read as local files so that their indexes are
+		// used.
+		if strings.Contains(in, "://") && !samql.IsRemote(in) {
+			src, err := samql.OpenSource(in)
+			if err != nil {
+				lg.Fatalf("cannot open source: %v", err)
+			}
+			readers[i] = samql.NewReader(src)
+			continue
+		}
+
 		// Open input SAM/BAM file descriptor for reading.
 		fh, err := getFileDescriptor(in)
 		if err != nil {
-			log.Fatalf("cannot open file: %v", err)
+			lg.Fatalf("cannot open file: %v", err)
+		}
+
+		// Detect the input format, unless SAM is requested explicitly.
+		// Compressed SAM is decompressed in either case.
+		format, rd, err := samql.DetectFormat(fh)
+		if err != nil {
+			lg.Fatalf("cannot detect format of %s: %v", in, err)
+		}
+		if isSam {
+			format = samql.SAM
 		}
 
 		// Create a samql Reader that reads from a SAM, BAM or indexed BAM file.
 		var r *samql.Reader
-		if isSam { // SAM
-			sr, err := sam.NewReader(fh)
+		switch format {
+		case samql.SAM:
+			if resume != 0 || ckpt > 0 {
+				lg.Fatalf("resuming and checkpointing require BAM input")
+			}
+			if skip != nil {
+				lr, err := samql.NewLenientSAMReader(rd)
+				if err != nil {
+					lg.Fatalf("cannot create sam reader: %v", err)
+				}
+				lr.OnSkip = skip(in)
+				r = samql.NewReader(lr)
+				break
+			}
+			// The offsets of compressed SAM are not comparable to the
+			// file size and are not reported.
+			if _, ok := rd.(*gzip.Reader); ok {
+				sr, err := sam.NewReader(rd)
+				if err != nil {
+					lg.Fatalf("cannot create sam reader: %v", err)
+				}
+				r = samql.NewReader(sr)
+				break
+			}
+			sr, err := newOffsetSAMReader(rd)
 			if err != nil {
-				log.Fatalf("cannot create sam reader: %v", err)
+				lg.Fatalf("cannot create sam reader: %v", err)
 			}
 			r = samql.NewReader(sr)
-		} else { // BAM or Indexed BAM
-			br, err := bam.NewReader(fh, parr)
-			if err != nil {
-				log.Fatalf("cannot create bam reader: %v", err)
+		case samql.BAM: // BAM or Indexed BAM
+			checkEOF(in, fh, permissive)
+			// Check if BAM is indexed. Look for file with .bai or .csi
+			// suffix. The index is not used when resuming or
+			// checkpointing.
+			var idxf io.ReadCloser
+			if len(in) > 4 && resume == 0 && ckpt == 0 {
+				idxf, _ = samql.OpenIndex(in)
 			}
-			// Check if BAM is indexed. Look for file with .bai suffix.
-			if len(in) > 4 {
-				idxf, err := os.Open(in + ".bai")
+			// BAM files without an index are read with the samql reader
+			// that reuses records when read with ReadInto.
+			if idxf == nil && resume == 0 && ckpt == 0 {
+				if len(regions) > 0 {
+					lg.Warnf("%s: no index found; reading the whole file", in)
+				}
+				br, err := samql.NewBAMReader(rd, parr)
 				if err != nil {
-					idxf, err = os.Open(in[:len(in)-4] + ".bai")
+					lg.Fatalf("cannot create bam reader: %v", err)
 				}
-				if err == nil { // if index is found
-					idxbr, err := bamx.New(br, bufio.NewReader(idxf))
-					if err != nil {
-						log.Fatalf("opening file failed: %v", err)
-					}
-					if rquery != nil {
-						_ = idxbr.AddQuery(rquery.Rname, rquery.Start, rquery.End)
-					}
-					r = samql.NewReader(idxbr)
+				r = samql.NewReader(br)
+				break
+			}
+			br, err := bam.NewReader(rd, parr)
+			if err != nil {
+				lg.Fatalf("cannot create bam reader: %v", err)
+			}
+			if resume != 0 {
+				if err := br.Seek(parseVOffset(resume)); err != nil {
+					lg.Fatalf("cannot resume from %d: %v", resume, err)
 				}
 			}
-			if r == nil {
-				r = samql.NewReader(br)
+			if resume != 0 || ckpt > 0 {
+				if len(regions) > 0 {
+					lg.Warnf("%s: the index is not used when resuming or checkpointing; reading the whole file", in)
+				}
+				readers[i] = samql.NewReader(&checkpointReader{
+					Reader: br, name: in, every: ckpt, w: os.Stderr})
+				continue
 			}
+			idxbr, err := bamx.New(br, bufio.NewReader(idxf))
+			if err != nil {
+				lg.Fatalf("opening file failed: %v", err)
+			}
+			// Regions on unknown references cannot contain records and
+			// are skipped with a warning.
+			regions := regions
+			if renamer != nil {
+				regions = renameRegions(regions, renamer(br.Header()))
+			}
+			n := 0
+			for _, reg := range regions {
+				if err := idxbr.AddQuery(reg.Rname, reg.Start, reg.End); err != nil {
+					lg.Warnf("%s: %v", in, unknownRefHint(err))
+					continue
+				}
+				indexed[i] = idxbr
+				n++
+			}
+			if len(regions) > 0 {
+				lg.Debugf("%s: reading %d of %d regions from the index", in, n, len(regions))
+			}
+			r = samql.NewReader(idxbr)
+		default:
+			lg.Fatalf("cannot read %s: unsupported %s format", in, format)
 		}
 		readers[i] = r
 	}
-	return readers
+	if permissive {
+		for i, r := range readers {
+			in := inputs[i]
+			r.OnCorrupt = func(err *samql.CorruptError) {
+				lg.Warnf("%s: %v; the records after the error are skipped", in, err)
+			}
+		}
+	}
+	return readers, indexed
 }
 
-// writer defines a common interface for a bam and sam writer.
-type writer interface {
-	Write(*sam.Record) error
+// maxSkipWarnings is the number of invalid SAM records of an input that are
+// reported with --lenient.
+const maxSkipWarnings = 10
+
+// skipWarner returns a function that warns about the invalid records of
+// the SAM input in that are skipped with --lenient, up to maxSkipWarnings.
+func skipWarner(in string) func(line int64, err error) {
+	n := 0
+	return func(line int64, err error) {
+		n++
+		switch {
+		case n <= maxSkipWarnings:
+			lg.Warnf("%s: line %d: %v; record skipped", in, line, err)
+		case n == maxSkipWarnings+1:
+			lg.Warnf("%s: more invalid records are skipped without warning", in)
+		}
+	}
+}
+
+// checkEOF checks that the BAM input in, opened as fh, ends with the BGZF EOF
+// marker. A missing marker is an error, unless permissive is true, since the
+// file is probably truncated. Inputs whose size is not known, e.g. STDIN, are
+// not checked.
+func checkEOF(in string, fh io.Reader, permissive bool) {
+	ra, ok := fh.(io.ReaderAt)
+	if !ok || in == "-" {
+		return
+	}
+	ok, err := bgzf.HasEOF(ra)
+	switch {
+	case err != nil:
+		lg.Debugf("%s: cannot check the BGZF EOF marker: %v", in, err)
+	case ok:
+	case permissive:
+		lg.Warnf("%s: missing BGZF EOF marker; the file may be truncated", in)
+	default:
+		lg.Fatalf("%s: missing BGZF EOF marker; the file is probably truncated or still being written (use --permissive to read it anyway)", in)
+	}
+}
+
+// openMateReader returns an indexed BAM reader for in that is used to fetch
+// mates independently of the reader of in. It returns nil if in is not an
+// indexed BAM file.
+func openMateReader(in string) *bamx.Reader {
+	if in == "-" {
+		return nil
+	}
+	idxf, err := samql.OpenIndex(in)
+	if err != nil {
+		return nil
+	}
+	defer idxf.Close()
+	f, err := getFileDescriptor(in)
+	if err != nil {
+		return nil
+	}
+	br, err := bam.NewReader(f, 1)
+	if err != nil {
+		f.Close()
+		return nil
+	}
+	mr, err := bamx.New(br, bufio.NewReader(idxf))
+	if err != nil {
+		br.Close()
+		f.Close()
+		return nil
+	}
+	return mr
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql"
+)
+
+// mergeOrder returns the sort order of the headers of readers, which must be
+// the same coordinate or queryname order for all readers.
+func mergeOrder(readers []*samql.Reader) (sam.SortOrder, error) {
+	order := readers[0].Header().SortOrder
+	for _, r := range readers {
+		if so := r.Header().SortOrder; so != order {
+			return 0, fmt.Errorf("inputs are sorted by %s and %s", order, so)
+		}
+	}
+	if order != sam.Coordinate && order != sam.QueryName {
+		return 0, fmt.Errorf("inputs must be sorted by coordinate or queryname, not %s", order)
+	}
+	return order, nil
+}
+
+// mergeReaders returns a source that merges the sorted readers by order with
+// header h. Records are tagged by tag, if not nil, before they are merged.
+func mergeReaders(readers []*samql.Reader, h *sam.Header, order sam.SortOrder,
+	tag func(*sam.Record, int) error) (samql.Source, error) {
+
+	tagged := make([]*samql.Reader, len(readers))
+	for i, r := range readers {
+		tagged[i] = r
+		if tag != nil {
+			tagged[i] = samql.NewReader(&taggedReader{Reader: r, i: i, tag: tag})
+		}
+	}
+	return samql.MergeSorted(h, order, tagged...)
+}
+
+// taggedReader tags the records of the i-th input as they are read.
+type taggedReader struct {
+	*samql.Reader
+	i   int
+	tag func(*sam.Record, int) error
+}
+
+// Read returns the next record of the reader after tagging it.
+func (r *taggedReader) Read() (*sam.Record, error) {
+	rec, err := r.Reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.tag(rec, r.i); err != nil {
+		return nil, fmt.Errorf("cannot tag record %s: %v", rec.Name, err)
+	}
+	return rec, nil
+}
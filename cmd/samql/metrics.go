@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maragkakislab/samql"
+)
+
+// runMetrics collects the progress of reading each input for the summary of
+// a run.
+type runMetrics struct {
+	start    time.Time
+	inputs   []string
+	progress []samql.Progress
+	filters  [][]string // names of the filters of each input, in order.
+}
+
+// newRunMetrics returns a runMetrics for inputs that starts timing the run.
+func newRunMetrics(inputs []string) *runMetrics {
+	return &runMetrics{
+		start:    time.Now(),
+		inputs:   inputs,
+		progress: make([]samql.Progress, len(inputs)),
+		filters:  make([][]string, len(inputs)),
+	}
+}
+
+// addFilter names the next filter appended to the reader of input i.
+func (m *runMetrics) addFilter(i int, name string) {
+	m.filters[i] = append(m.filters[i], name)
+}
+
+// update records the progress p of input i. It can be used in the
+// OnProgress function of a samql.Reader.
+func (m *runMetrics) update(i int, p samql.Progress) {
+	m.progress[i] = p
+}
+
+// inputSummary is the summary of reading and filtering the records of an
+// input, or of all inputs.
+type inputSummary struct {
+	Input     string           `json:"input,omitempty"`
+	Read      int64            `json:"read"`
+	Matched   int64            `json:"matched"`
+	MatchRate float64          `json:"match_rate"`
+	Rejected  map[string]int64 `json:"rejected"`
+}
+
+// runSummary is the summary of a run, as written by --metrics.
+type runSummary struct {
+	inputSummary
+	Inputs     []inputSummary `json:"inputs"`
+	WallTime   float64        `json:"wall_time_seconds"`
+	Throughput float64        `json:"records_per_second"`
+}
+
+// summary returns the summary of the run so far. The records rejected by
+// each filter are keyed by the name of the filter.
+func (m *runMetrics) summary() runSummary {
+	s := runSummary{inputSummary: inputSummary{Rejected: make(map[string]int64)}}
+	for i, p := range m.progress {
+		in := inputSummary{Input: m.inputs[i], Read: p.Read, Matched: p.Matched,
+			Rejected: make(map[string]int64)}
+		for j, n := range p.Rejected {
+			name := fmt.Sprintf("filter%d", j+1)
+			if j < len(m.filters[i]) {
+				name = m.filters[i][j]
+			}
+			in.Rejected[name] += n
+			s.Rejected[name] += n
+		}
+		in.MatchRate = matchRate(in.Matched, in.Read)
+		s.Inputs = append(s.Inputs, in)
+		s.Read += in.Read
+		s.Matched += in.Matched
+	}
+	s.MatchRate = matchRate(s.Matched, s.Read)
+	elapsed := time.Since(m.start)
+	s.WallTime = elapsed.Seconds()
+	if s.WallTime > 0 {
+		s.Throughput = float64(s.Read) / s.WallTime
+	}
+	return s
+}
+
+// matchRate returns the fraction of the read records that matched, or zero if
+// no records were read.
+func matchRate(matched, read int64) float64 {
+	if read == 0 {
+		return 0
+	}
+	return float64(matched) / float64(read)
+}
+
+// print prints the summary s to w, a line for each input followed by the
+// total of all inputs.
+func (s runSummary) print(w io.Writer) {
+	for _, in := range append(s.Inputs, s.inputSummary) {
+		name := in.Input
+		if name == "" {
+			name = "total"
+		}
+		fmt.Fprintf(w, "summary\t%s\t%d read\t%d matched\t%.1f%%", name, in.Read,
+			in.Matched, 100*in.MatchRate)
+		if len(in.Rejected) > 0 {
+			fmt.Fprintf(w, "\trejected %s", formatRejected(in.Rejected))
+		}
+		if in.Input == "" {
+			fmt.Fprintf(w, "\t%s\t%.0f records/s",
+				time.Duration(s.WallTime*float64(time.Second)).Round(time.Millisecond), s.Throughput)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// formatRejected returns the counts of the rejected records of each filter,
+// sorted by filter name, e.g. "sample=10 where=200".
+func formatRejected(rejected map[string]int64) string {
+	names := make([]string, 0, len(rejected))
+	for name := range rejected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s=%d", name, rejected[name])
+	}
+	return strings.Join(names, " ")
+}
+
+// report prints the summary of the run to STDERR, if print is true, and
+// writes it as JSON to the file path, or to STDERR if path is -, if path is
+// not empty.
+func (m *runMetrics) report(print bool, path string) error {
+	s := m.summary()
+	if print {
+		s.print(os.Stderr)
+	}
+	if path == "" {
+		return nil
+	}
+	if path == "-" {
+		return writeSummary(os.Stderr, s)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeSummary(f, s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeSummary writes the summary s to w as indented JSON.
+func writeSummary(w io.Writer, s runSummary) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
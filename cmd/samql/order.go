@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql"
+)
+
+// inputOrder returns the sort order of the headers of readers if it is the
+// same coordinate or queryname order for all readers and sam.UnknownOrder
+// otherwise.
+func inputOrder(readers []*samql.Reader) sam.SortOrder {
+	order := readers[0].Header().SortOrder
+	for _, r := range readers {
+		if r.Header().SortOrder != order {
+			return sam.UnknownOrder
+		}
+	}
+	if order != sam.Coordinate && order != sam.QueryName {
+		return sam.UnknownOrder
+	}
+	return order
+}
+
+// orderBreaks returns why records read from sorted inputs with order are
+// not written in that order, or an empty string if they are. Indexed
+// regions, including ORs of regions, are read in file order and keep it.
+func orderBreaks(order sam.SortOrder, inputs int, opts Opts) string {
+	switch {
+	case inputs > 1:
+		return fmt.Sprintf("%d inputs are concatenated", inputs)
+	case order == sam.Coordinate && (opts.Pairs || opts.BothMates || opts.FetchPairs):
+		return "mates are written together"
+	}
+	return ""
+}
+
+// outputOrder returns the sort order of the output header for records read
+// from readers without sorting or merging them. Inputs that are sorted but
+// whose records are not written in order are written as unsorted, with a
+// warning unless opts.IgnoreOrder is set, or keep their order if
+// opts.AssumeSorted is set, e.g. for inputs of consecutive references.
+func outputOrder(readers []*samql.Reader, h *sam.Header, opts Opts) sam.SortOrder {
+	order := inputOrder(readers)
+	if order == sam.UnknownOrder {
+		return h.SortOrder
+	}
+	why := orderBreaks(order, len(readers), opts)
+	if why == "" || opts.AssumeSorted {
+		return order
+	}
+	if !opts.IgnoreOrder {
+		fix := "ORDER BY"
+		if len(readers) > 1 {
+			fix = "merge"
+		}
+		lg.Warnf("inputs are sorted by %s but %s; the output is marked as unsorted; "+
+			"use %s to sort it or --assume-sorted to keep the order", order, why, fix)
+	}
+	return sam.Unsorted
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/maragkakislab/samql/bamx"
+)
+
+// outputFile is a temporary file that replaces the file at path when it is
+// committed. The temporary file is created in the same directory as path so
+// that it can be renamed atomically and an existing file is never left
+// partially written.
+type outputFile struct {
+	*os.File
+	path string
+}
+
+// createOutput creates a temporary file that replaces path on commit.
+func createOutput(path string) (*outputFile, error) {
+	dir, base := filepath.Split(path)
+	tmp := filepath.Join(dir, fmt.Sprintf(".%s.%d.tmp", base, os.Getpid()))
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	out := &outputFile{File: f, path: path}
+	pending.Lock()
+	pending.files[out] = true
+	pending.Unlock()
+	return out, nil
+}
+
+// pending are the temporary files that are neither committed nor aborted,
+// which are removed if the program is interrupted.
+var pending = struct {
+	sync.Mutex
+	files map[*outputFile]bool
+}{files: make(map[*outputFile]bool)}
+
+// done removes f from the pending temporary files.
+func (f *outputFile) done() {
+	pending.Lock()
+	delete(pending.files, f)
+	pending.Unlock()
+}
+
+// abortOutputs aborts all pending temporary files and returns their number.
+func abortOutputs() int {
+	pending.Lock()
+	defer pending.Unlock()
+	n := len(pending.files)
+	for f := range pending.files {
+		f.File.Close()
+		os.Remove(f.Name())
+		delete(pending.files, f)
+	}
+	return n
+}
+
+// Commit closes the temporary file and renames it to the output path.
+func (f *outputFile) Commit() error {
+	f.done()
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), f.path)
+}
+
+// Abort closes and removes the temporary file.
+func (f *outputFile) Abort() {
+	f.done()
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+// writeIndex writes the BAI or CSI index of the BAM file at path next to it
+// and returns the name of the index.
+func writeIndex(path string, useCSI bool) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	name := path + ".bai"
+	if useCSI {
+		name = path + ".csi"
+	}
+	out, err := createOutput(name)
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(out)
+	if err := bamx.WriteIndex(w, bufio.NewReader(f), useCSI); err != nil {
+		out.Abort()
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		out.Abort()
+		return "", err
+	}
+	return name, out.Commit()
+}
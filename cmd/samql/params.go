@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseParams returns the query parameters of the --param options, given as
+// name=value. Values are integers, floats or booleans if they can be parsed
+// as such and strings otherwise. Values in single quotes are always strings.
+func parseParams(opts []string) (map[string]interface{}, error) {
+	params := make(map[string]interface{})
+	for _, opt := range opts {
+		i := strings.Index(opt, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid parameter %q; expected name=value", opt)
+		}
+		name, val := opt[:i], opt[i+1:]
+		if _, ok := params[name]; ok {
+			return nil, fmt.Errorf("duplicate parameter %s", name)
+		}
+		params[name] = paramValue(val)
+	}
+	return params, nil
+}
+
+// paramValue returns the typed value of the parameter value s.
+func paramValue(s string) interface{} {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return s[1 : len(s)-1]
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return v
+	}
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v
+	}
+	if v, err := strconv.ParseBool(s); err == nil && (s == "true" || s == "false") {
+		return v
+	}
+	return s
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql"
+)
+
+// progressEvery is the number of records read between progress checks.
+const progressEvery = 100000
+
+// progressInterval is the minimum time between progress reports.
+const progressInterval = 5 * time.Second
+
+// progressReporter prints the progress of reading an input to w at most once
+// every progressInterval and when the input is exhausted.
+type progressReporter struct {
+	w     io.Writer
+	name  string
+	size  int64 // size of the input in bytes or -1 if unknown.
+	start time.Time
+	last  time.Time
+}
+
+// newProgressReporter returns a progressReporter for the input in that
+// prints to w.
+func newProgressReporter(w io.Writer, in string) *progressReporter {
+	now := time.Now()
+	return &progressReporter{
+		w: w, name: in, size: inputSize(in), start: now, last: now}
+}
+
+// Report prints p if progressInterval has passed since the last report or if
+// the input is exhausted. It can be used as the OnProgress function of a
+// samql.Reader.
+func (r *progressReporter) Report(p samql.Progress) {
+	now := time.Now()
+	if !p.Done && now.Sub(r.last) < progressInterval {
+		return
+	}
+	r.last = now
+
+	elapsed := now.Sub(r.start)
+	rate := float64(p.Read) / elapsed.Seconds()
+	fmt.Fprintf(r.w, "progress\t%s\t%d read\t%d matched\t%.0f records/s",
+		r.name, p.Read, p.Matched, rate)
+	switch {
+	case p.Done:
+		fmt.Fprintf(r.w, "\tdone in %s", elapsed.Round(time.Second))
+	case r.size > 0 && p.Offset > 0:
+		frac := float64(p.Offset) / float64(r.size)
+		eta := time.Duration(float64(elapsed) * (1 - frac) / frac)
+		fmt.Fprintf(r.w, "\t%.1f%%\tETA %s", 100*frac, eta.Round(time.Second))
+	}
+	fmt.Fprintln(r.w)
+}
+
+// inputSize returns the size in bytes of the input in or -1 if it is unknown,
+// e.g. for STDIN.
+func inputSize(in string) int64 {
+	if in == "-" {
+		return -1
+	}
+	if samql.IsRemote(in) {
+		f, err := samql.OpenRemote(in)
+		if err != nil {
+			return -1
+		}
+		return f.Size()
+	}
+	fi, err := os.Stat(in)
+	if err != nil || !fi.Mode().IsRegular() {
+		return -1
+	}
+	return fi.Size()
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read reads from the underlying reader and counts the bytes read.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// offsetSAMReader is a SAM reader that reports the number of bytes of its
+// input consumed. The SAM reader reads ahead, so the offset is approximate.
+type offsetSAMReader struct {
+	*sam.Reader
+	cr *countingReader
+}
+
+// newOffsetSAMReader returns a new SAM reader that reads from r and reports
+// its offset.
+func newOffsetSAMReader(r io.Reader) (*offsetSAMReader, error) {
+	cr := &countingReader{r: r}
+	sr, err := sam.NewReader(cr)
+	if err != nil {
+		return nil, err
+	}
+	return &offsetSAMReader{Reader: sr, cr: cr}, nil
+}
+
+// Offset returns the number of bytes read from the input.
+func (r *offsetSAMReader) Offset() int64 {
+	return r.cr.n
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql"
+	"github.com/maragkakislab/samql/bamx"
+)
+
+// chrAliases returns a function that renames the reference names that are not
+// in h to the same name with or without the chr prefix, if that is in h, as
+// --chr-alias. Other names are returned unchanged.
+func chrAliases(h *sam.Header) func(string) string {
+	refs := make(map[string]bool)
+	for _, r := range h.Refs() {
+		refs[r.Name()] = true
+	}
+	return func(name string) string {
+		if alias := bamx.ChrAlias(name); !refs[name] && refs[alias] {
+			return alias
+		}
+		return name
+	}
+}
+
+// newRenamer returns a function that returns the function that renames the
+// reference names of queries for header h, with the chr prefix added or
+// removed if chrAlias is true and to their aliases in aliases, if not nil. It
+// returns nil if references are not renamed.
+func newRenamer(chrAlias bool, aliases samql.RefAliases) func(h *sam.Header) func(string) string {
+	if !chrAlias && aliases == nil {
+		return nil
+	}
+	return func(h *sam.Header) func(string) string {
+		var chr, alias func(string) string
+		if chrAlias {
+			chr = chrAliases(h)
+		}
+		if aliases != nil {
+			alias = aliases.Renamer(h)
+		}
+		return func(name string) string {
+			if chr != nil {
+				if renamed := chr(name); renamed != name {
+					return renamed
+				}
+			}
+			if alias != nil {
+				return alias(name)
+			}
+			return name
+		}
+	}
+}
+
+// renameRefs renames the references of h by rename, e.g. to the names of the
+// references of another header. The records that refer to them are renamed
+// as well. References are not renamed to a name that is already in h.
+func renameRefs(h *sam.Header, rename func(string) string) {
+	for _, r := range h.Refs() {
+		if name := rename(r.Name()); name != r.Name() {
+			if err := r.SetName(name); err != nil {
+				lg.Warnf("cannot rename reference %s to %s: %v", r.Name(), name, err)
+			}
+		}
+	}
+}
+
+// renameRegions returns a copy of regions with the reference names renamed by
+// rename.
+func renameRegions(regions []samql.Region, rename func(string) string) []samql.Region {
+	renamed := make([]samql.Region, len(regions))
+	for i, r := range regions {
+		r.Rname = rename(r.Rname)
+		renamed[i] = r
+	}
+	return renamed
+}
+
+// unknownRefHint returns err with a hint to use --chr-alias if err is an
+// unknown reference whose name differs from a reference of the header only in
+// the chr prefix.
+func unknownRefHint(err error) error {
+	var e *bamx.UnknownRefError
+	if !errors.As(err, &e) {
+		return err
+	}
+	for _, name := range e.Similar {
+		if name == bamx.ChrAlias(e.Name) {
+			return fmt.Errorf("%v (use --chr-alias to match %s)", err, name)
+		}
+	}
+	return err
+}
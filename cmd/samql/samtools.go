@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/maragkakislab/samql"
+)
+
+// samtoolsWhere returns the WHERE clause of the samtools view options -f,
+// -F, --rf and -q, or an empty string if none is set. Flags are compared as
+// bits of FLAG, so that they are evaluated with bit masks.
+func samtoolsWhere(opts Opts) (string, error) {
+	var conds []string
+	for _, f := range []struct {
+		name, flags, cond string
+	}{
+		{"-f", opts.RequireFlags, "FLAG & %[1]d = %[1]d"},
+		{"-F", opts.ExcludeFlags, "FLAG & %[1]d = 0"},
+		{"--rf", opts.AnyFlags, "FLAG & %[1]d != 0"},
+	} {
+		bits, err := parseFlags(f.flags)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", f.name, err)
+		}
+		if bits == 0 {
+			continue
+		}
+		conds = append(conds, fmt.Sprintf(f.cond, bits))
+	}
+	if opts.MinMapQ < 0 {
+		return "", fmt.Errorf("-q: invalid mapping quality %d", opts.MinMapQ)
+	}
+	if opts.MinMapQ > 0 {
+		conds = append(conds, fmt.Sprintf("MAPQ >= %d", opts.MinMapQ))
+	}
+	return strings.Join(conds, " AND "), nil
+}
+
+// regionArgs splits the positional arguments args into the inputs and the
+// regions in the syntax of samtools, e.g. chr1:10000-20000. The first
+// argument is always an input. Later arguments are regions if they are not
+// existing files or URLs and can be parsed as regions.
+func regionArgs(args []string) (inputs []string, regions []samql.Region) {
+	for i, a := range args {
+		if i == 0 || a == "-" || strings.Contains(a, "://") {
+			inputs = append(inputs, a)
+			continue
+		}
+		if _, err := os.Stat(a); err == nil {
+			inputs = append(inputs, a)
+			continue
+		}
+		r, err := samql.ParseRegion(a)
+		if err != nil {
+			inputs = append(inputs, a)
+			continue
+		}
+		regions = append(regions, r)
+	}
+	return inputs, regions
+}
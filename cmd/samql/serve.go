@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	arg "github.com/alexflint/go-arg"
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql"
+	"github.com/maragkakislab/samql/bamx"
+	"github.com/maragkakislab/samql/encode"
+)
+
+// ServeOpts is the struct with the options of the serve command.
+type ServeOpts struct {
+	Listen string `arg:"--listen" help:"address to listen on" default:"localhost:8080"`
+	Root   string `arg:"--root" help:"directory with the served SAM/BAM files" default:"."`
+	Parr   int    `arg:"-p" help:"number of cores for BAM compression and decompression of each request" default:"1"`
+
+	WriteTimeout time.Duration `arg:"--write-timeout" help:"maximum time to write each response, which streams the whole filtered file" default:"1h"`
+
+	Verbose bool `arg:"--verbose" help:"print each request to STDERR"`
+	Quiet   bool `arg:"--quiet" help:"do not print warnings and information"`
+}
+
+// Version returns the program name and version.
+func (ServeOpts) Version() string { return "samql " + VERSION }
+
+// Description returns an extended description of the serve command.
+func (ServeOpts) Description() string {
+	return "Serves filtered SAM/BAM files over HTTP at /reads/<file>"
+}
+
+// serve runs the samql HTTP server with the options in os.Args.
+func serve() {
+	var opts ServeOpts
+	arg.MustParse(&opts)
+	lg.setLevel(opts.Verbose, opts.Quiet)
+	if opts.Parr < 1 {
+		lg.Fatalf("invalid number of cores %d", opts.Parr)
+	}
+	if fi, err := os.Stat(opts.Root); err != nil || !fi.IsDir() {
+		lg.Fatalf("invalid root directory: %s", opts.Root)
+	}
+	if opts.WriteTimeout <= 0 {
+		lg.Fatalf("invalid write timeout %v", opts.WriteTimeout)
+	}
+
+	s := &server{root: opts.Root, parr: opts.Parr, indexes: newIndexCache()}
+	mux := http.NewServeMux()
+	mux.Handle("/reads/", s)
+	srv := &http.Server{
+		Addr:         opts.Listen,
+		Handler:      mux,
+		ReadTimeout:  serveReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		IdleTimeout:  serveIdleTimeout,
+		ErrorLog:     lg.l, // Errors of accepting connections and of handlers.
+	}
+	lg.Infof("serving %s on %s", opts.Root, opts.Listen)
+	lg.Fatalf("server failed: %v", srv.ListenAndServe())
+}
+
+// Timeouts of the server. Requests have no body, so they are read quickly,
+// and idle connections are closed so that clients cannot keep them open
+// indefinitely.
+const (
+	serveReadTimeout = time.Minute
+	serveIdleTimeout = 2 * time.Minute
+)
+
+// server is an http.Handler that serves the filtered records of the SAM/BAM
+// files in a directory, similar to the reads endpoint of htsget. The records
+// are streamed in the response body instead of returning a ticket. Requests
+// are handled concurrently and share the BAM indexes.
+type server struct {
+	root    string
+	parr    int
+	indexes *indexCache
+}
+
+// request holds the parameters of a request to the server.
+type request struct {
+	file    string
+	format  string
+	where   string
+	regions []samql.Region
+}
+
+// ServeHTTP handles GET requests to /reads/<file> with the parameters:
+// format (BAM, SAM or JSON; default BAM), referenceName, start and end
+// (0-based, end exclusive) and where, a WHERE clause. Errors before any
+// records are written are reported with the HTTP status code; later errors
+// abort the response.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lg.Debugf("%s %s", r.Method, r.URL)
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := s.parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(req.file)
+	if err != nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	reader, err := s.openReader(f, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer reader.Close()
+
+	var rw recordWriter
+	switch req.format {
+	case "JSON":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		rw = samql.NewWriter(encode.NewJSONWriter(w))
+	case "SAM":
+		w.Header().Set("Content-Type", "text/plain")
+		rw, err = samql.NewSAMWriter(w, reader.Header())
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		rw, err = samql.NewBAMWriter(w, reader.Header(), s.parr)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			abortf("filtering %s failed: %v", r.URL.Path, err)
+		}
+		if err := rw.Write(rec); err != nil {
+			abortf("write failed: %v for %s", err, rec.Name)
+		}
+	}
+	if err := rw.Close(); err != nil {
+		abortf("cannot close SAM/BAM writer: %v", err)
+	}
+}
+
+// abortf logs the error and aborts the response to the client, so that it
+// does not receive a truncated output with a success status.
+func abortf(format string, v ...interface{}) {
+	lg.Errorf(format, v...)
+	panic(http.ErrAbortHandler)
+}
+
+// parseRequest returns the parameters of r. The requested file is resolved
+// within the root directory of s.
+func (s *server) parseRequest(r *http.Request) (*request, error) {
+	id := strings.TrimPrefix(r.URL.Path, "/reads/")
+	if id == "" {
+		return nil, fmt.Errorf("missing file")
+	}
+	q := r.URL.Query()
+	req := &request{
+		file:   filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+id))),
+		format: strings.ToUpper(q.Get("format")),
+		where:  q.Get("where"),
+	}
+	switch req.format {
+	case "":
+		req.format = "BAM"
+	case "BAM", "SAM", "JSON":
+	default:
+		return nil, fmt.Errorf("unsupported format %q", q.Get("format"))
+	}
+
+	rname := q.Get("referenceName")
+	if rname == "" {
+		if q.Get("start") != "" || q.Get("end") != "" {
+			return nil, fmt.Errorf("start and end require referenceName")
+		}
+		return req, nil
+	}
+	reg := samql.Region{Rname: rname}
+	var err error
+	if v := q.Get("start"); v != "" {
+		if reg.Start, err = strconv.Atoi(v); err != nil || reg.Start < 0 {
+			return nil, fmt.Errorf("invalid start %q", v)
+		}
+	}
+	if v := q.Get("end"); v != "" {
+		if reg.End, err = strconv.Atoi(v); err != nil || reg.End <= reg.Start {
+			return nil, fmt.Errorf("invalid end %q", v)
+		}
+	}
+	req.regions = []samql.Region{reg}
+	return req, nil
+}
+
+// openReader returns a samql reader for the file f that returns the records
+// of req. Indexed BAM files read only the requested regions or the regions
+// of the WHERE clause.
+func (s *server) openReader(f *os.File, req *request) (*samql.Reader, error) {
+	format, rd, err := samql.DetectFormat(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot detect format: %v", err)
+	}
+
+	regions := req.regions
+	if regions == nil && req.where != "" {
+		regions, _ = samql.QueryRegions(req.where)
+	}
+
+	var r *samql.Reader
+	indexed := false
+	switch format {
+	case samql.SAM:
+		sr, err := sam.NewReader(rd)
+		if err != nil {
+			return nil, err
+		}
+		r = samql.NewReader(sr)
+	case samql.BAM:
+		br, err := bam.NewReader(rd, s.parr)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := s.indexes.Get(req.file)
+		if err != nil {
+			br.Close()
+			return nil, err
+		}
+		if idx == nil {
+			r = samql.NewReader(br)
+			break
+		}
+		bx := bamx.NewWithIndex(br, idx)
+		// Regions on unknown references cannot contain records and are
+		// skipped.
+		for _, reg := range regions {
+			if bx.AddQuery(reg.Rname, reg.Start, reg.End) == nil {
+				indexed = true
+			}
+		}
+		r = samql.NewReader(bx)
+	default:
+		return nil, fmt.Errorf("unsupported %s format", format)
+	}
+
+	if req.regions != nil {
+		r.AppendFilter(samql.OverlapFilter(req.regions))
+	}
+	if req.where != "" {
+		plan, err := samql.PlanHeader(req.where, inputName(req.file), r.Header())
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("filter creation from where clause failed: %v", err)
+		}
+		filter := plan.Filter
+		if indexed && req.regions == nil {
+			filter = plan.Residual
+		}
+		r.AppendFilter(filter)
+	}
+	return r, nil
+}
+
+// indexCache holds the parsed BAM indexes of the served files so that they
+// are shared by concurrent requests. Indexes are read again when their file
+// is modified.
+type indexCache struct {
+	mu      sync.Mutex
+	indexes map[string]cachedIndex
+}
+
+// cachedIndex is an index and the modification time of its file.
+type cachedIndex struct {
+	idx     *bamx.Index
+	modTime time.Time
+}
+
+// newIndexCache returns a new empty indexCache.
+func newIndexCache() *indexCache {
+	return &indexCache{indexes: make(map[string]cachedIndex)}
+}
+
+// Get returns the index of the BAM file in or nil if in is not indexed.
+func (c *indexCache) Get(in string) (*bamx.Index, error) {
+	idxf, err := samql.OpenIndex(in)
+	if err != nil {
+		return nil, nil
+	}
+	defer idxf.Close()
+	f, ok := idxf.(*os.File)
+	if !ok {
+		return nil, nil
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ci, ok := c.indexes[in]; ok && ci.modTime.Equal(fi.ModTime()) {
+		return ci.idx, nil
+	}
+	idx, err := bamx.ReadIndex(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read index: %v", err)
+	}
+	c.indexes[in] = cachedIndex{idx: idx, modTime: fi.ModTime()}
+	return idx, nil
+}
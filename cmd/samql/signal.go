@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// interrupted is set to 1 when the program receives SIGINT or SIGTERM.
+var interrupted int32
+
+// stopping is set to 1 while the records are written by a loop that checks
+// interrupted, so that the loop, instead of the signal handler, stops the
+// program after closing the writer of the records.
+var stopping int32
+
+// interruptSignal is the signal that interrupted the program.
+var interruptSignal = make(chan os.Signal, 1)
+
+// handleSignals handles SIGINT and SIGTERM. If the records are written by a
+// loop that checks isInterrupted, the loop stops at the next record and
+// calls exitInterrupted after closing its writer, so that BAM output ends
+// with the BGZF EOF block. Otherwise, or if a second signal is received,
+// the temporary output files are removed and the program exits at once.
+func handleSignals() {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		interruptSignal <- sig
+		atomic.StoreInt32(&interrupted, 1)
+		if atomic.LoadInt32(&stopping) == 1 {
+			sig = <-c
+		}
+		exitSignal(sig)
+	}()
+}
+
+// stopOnInterrupt makes a signal stop the loop that writes the records,
+// which must check isInterrupted after each record, instead of the program.
+func stopOnInterrupt() {
+	atomic.StoreInt32(&stopping, 1)
+}
+
+// isInterrupted returns true if the program received SIGINT or SIGTERM. The
+// caller must stop writing records and call exitInterrupted.
+func isInterrupted() bool {
+	return atomic.LoadInt32(&interrupted) == 1
+}
+
+// exitInterrupted removes the temporary output files and exits with the
+// status of the signal that interrupted the program.
+func exitInterrupted() {
+	exitSignal(<-interruptSignal)
+}
+
+// exitSignal removes the temporary output files and exits with the status of
+// a program killed by sig, 128 plus the signal number.
+func exitSignal(sig os.Signal) {
+	if n := abortOutputs(); n > 0 {
+		lg.Errorf("interrupted by %v; removed %d partial output file(s)", sig, n)
+	} else {
+		lg.Errorf("interrupted by %v", sig)
+	}
+	code := 1
+	if s, ok := sig.(syscall.Signal); ok {
+		code = 128 + int(s)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql"
+	"github.com/maragkakislab/samql/encode"
+)
+
+// bindQueries returns q bound to the name of each input in inputs and the
+// header of the corresponding reader, so that the keywords of specific inputs,
+// e.g. SOURCE, can be selected.
+func bindQueries(q *samql.Query, readers []*samql.Reader, inputs []string) ([]*samql.Query, error) {
+	qs := make([]*samql.Query, len(readers))
+	for i, r := range readers {
+		var err error
+		if qs[i], err = q.Bind(inputName(inputs[i]), r.Header()); err != nil {
+			return nil, err
+		}
+	}
+	return qs, nil
+}
+
+// writeTable writes the columns selected by the queries qs for all records
+// in readers as tab separated values to w. qs holds the same query for each
+// reader, bound to its input. The first line contains the column names. If
+// the query is an aggregate query, only the aggregated rows are written after
+// all records have been read. Records are not retained, so they are read into
+// a single record.
+func writeTable(w io.Writer, readers []*samql.Reader, qs []*samql.Query) error {
+	q := qs[0]
+	if err := writeRow(w, q.ColumnNames()); err != nil {
+		return err
+	}
+
+	var agg *samql.Aggregation
+	if q.IsAggregate() {
+		agg = q.NewAggregation()
+	}
+
+	var rec sam.Record
+	for i, r := range readers {
+		for {
+			err := r.ReadInto(&rec)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+
+			if agg != nil {
+				agg.AddWith(qs[i], &rec)
+				continue
+			}
+			if err := writeValues(w, qs[i].Values(&rec)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if agg != nil {
+		for _, vals := range agg.Rows() {
+			if err := writeValues(w, vals); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parquetTypes maps the types of selected columns to Parquet column types.
+// Columns of unknown type are written as strings.
+var parquetTypes = map[samql.ColumnType]encode.ParquetType{
+	samql.BoolColumn:   encode.ParquetBool,
+	samql.IntColumn:    encode.ParquetInt64,
+	samql.FloatColumn:  encode.ParquetFloat,
+	samql.DoubleColumn: encode.ParquetDouble,
+	samql.StringColumn: encode.ParquetString,
+}
+
+// writeParquet writes the columns selected by the queries qs for all records
+// in readers, or the aggregated rows if the query is an aggregate query, as a
+// Parquet file to w. qs is as in writeTable.
+func writeParquet(w io.Writer, readers []*samql.Reader, qs []*samql.Query) error {
+	q := qs[0]
+	names := q.ColumnNames()
+	types := make([]encode.ParquetType, len(names))
+	for i, t := range q.ColumnTypes() {
+		pt, ok := parquetTypes[t]
+		if !ok {
+			pt = encode.ParquetString
+		}
+		types[i] = pt
+	}
+	// Constants have no column name but Parquet columns need one.
+	for i, name := range names {
+		if name == "" {
+			names[i] = fmt.Sprintf("column%d", i+1)
+		}
+	}
+	pw, err := encode.NewParquetWriter(w, names, types)
+	if err != nil {
+		return err
+	}
+
+	var agg *samql.Aggregation
+	if q.IsAggregate() {
+		agg = q.NewAggregation()
+	}
+	var rec sam.Record
+	for i, r := range readers {
+		for {
+			err := r.ReadInto(&rec)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+
+			if agg != nil {
+				agg.AddWith(qs[i], &rec)
+				continue
+			}
+			if err := pw.Write(qs[i].Values(&rec)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if agg != nil {
+		for _, vals := range agg.Rows() {
+			if err := pw.Write(vals); err != nil {
+				return err
+			}
+		}
+	}
+	return pw.Close()
+}
+
+// writeBedGraph writes the rows of the windowed aggregate queries qs for all
+// records in readers with header h as bedGraph to w. qs is as in writeTable.
+func writeBedGraph(w io.Writer, readers []*samql.Reader, qs []*samql.Query, h *sam.Header) error {
+	bw, err := samql.NewBedGraphWriter(w, qs[0], h)
+	if err != nil {
+		return err
+	}
+	agg := qs[0].NewAggregation()
+	var rec sam.Record
+	for i, r := range readers {
+		for {
+			err := r.ReadInto(&rec)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			agg.AddWith(qs[i], &rec)
+		}
+	}
+	return bw.WriteRows(agg.Rows())
+}
+
+// writeValues writes vals to w as a tab separated line. Missing values, e.g.
+// the minimum or mean of no records, are written as empty fields.
+func writeValues(w io.Writer, vals []interface{}) error {
+	row := make([]string, len(vals))
+	for i, v := range vals {
+		if v != nil {
+			row[i] = fmt.Sprint(v)
+		}
+	}
+	return writeRow(w, row)
+}
+
+// writeRow writes row to w as a tab separated line.
+func writeRow(w io.Writer, row []string) error {
+	_, err := fmt.Fprintln(w, strings.Join(row, "\t"))
+	return err
+}
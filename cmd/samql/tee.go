@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql"
+	"github.com/maragkakislab/samql/encode"
+)
+
+// teeArgs returns args with each "--out FILE QUERY" rewritten as
+// "--out=FILE --out=QUERY", so that the pairs are parsed as a single repeated
+// option and queries that start with a dash are not taken for options.
+func teeArgs(args []string) []string {
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--out" && i+2 < len(args) {
+			out = append(out, "--out="+args[i+1], "--out="+args[i+2])
+			i += 2
+			continue
+		}
+		out = append(out, args[i])
+	}
+	return out
+}
+
+// newTeeWriter returns a writer that writes the records with header h that
+// match each query of the file and query pairs outs to the file. The format
+// of each file is given by newFileWriter. Files are created as temporary output files
+// that must be committed or aborted. The filters are created with filterOpts.
+func newTeeWriter(outs []string, h *sam.Header, opts Opts, wc int, filterOpts []samql.FilterOption) (*samql.TeeWriter, []*outputFile, error) {
+	var files []*outputFile
+	abort := func() {
+		for _, f := range files {
+			f.Abort()
+		}
+	}
+
+	tw := samql.NewTeeWriter()
+	for i := 0; i+1 < len(outs); i += 2 {
+		path, query := outs[i], outs[i+1]
+		filter, err := samql.WhereHeader(query, "", h, filterOpts...)
+		if err != nil {
+			abort()
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		f, err := createOutput(path)
+		if err != nil {
+			abort()
+			return nil, nil, err
+		}
+		files = append(files, f)
+
+		w, err := newFileWriter(f, path, h, opts, wc)
+		if err != nil {
+			abort()
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		w.AppendFilter(filter)
+		tw.Add(w)
+	}
+	return tw, files, nil
+}
+
+// newFileWriter returns a writer of records with header h to the output file
+// f at path using wc concurrent BAM compressors and the --compression-level
+// option. The format is given by the extension of path, .sam, .sam.gz, .bam
+// or .json, or else by the --obam, --osam-gz, --obgzf and --json options.
+// .sam.gz files are BGZF compressed, which gzip can also read.
+func newFileWriter(f *outputFile, path string, h *sam.Header, opts Opts, wc int) (*samql.Writer, error) {
+	switch {
+	case strings.HasSuffix(path, ".bam"):
+		return samql.NewBAMWriterLevel(f, h, opts.Level, wc)
+	case strings.HasSuffix(path, ".sam"):
+		return samql.NewSAMWriter(f, h)
+	case strings.HasSuffix(path, ".sam.gz"):
+		return samql.NewSAMBGZFWriter(f, h, wc)
+	case strings.HasSuffix(path, ".json") || opts.JSON:
+		return samql.NewWriter(encode.NewJSONWriter(f)), nil
+	case opts.OBam:
+		return samql.NewBAMWriterLevel(f, h, opts.Level, wc)
+	case opts.OSamGz:
+		return samql.NewSAMGzipWriter(f, h)
+	case opts.OBgzf:
+		return samql.NewSAMBGZFWriter(f, h, wc)
+	}
+	return samql.NewSAMWriter(f, h)
+}
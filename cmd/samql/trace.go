@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql"
+)
+
+// debugTracer prints the trace of the WHERE clause for the first records
+// that are filtered.
+type debugTracer struct {
+	w    io.Writer
+	left int // records left to trace.
+}
+
+// filter returns a filter that returns the value of filter and, for the
+// records left to trace, prints the trace of t and the value.
+func (d *debugTracer) filter(filter samql.FilterFunc, t *samql.Tracer) samql.FilterFunc {
+	return func(rec *sam.Record) bool {
+		match := filter(rec)
+		if d.left > 0 {
+			d.left--
+			d.print(rec, t.Trace(rec), match)
+		}
+		return match
+	}
+}
+
+// print prints the steps of the trace of rec, indented by their depth, and
+// whether rec matches.
+func (d *debugTracer) print(rec *sam.Record, steps []samql.TraceStep, match bool) {
+	fmt.Fprintf(d.w, "trace %s\n", rec.Name)
+	for _, s := range steps {
+		fmt.Fprintf(d.w, "  %s%s => %s\n", strings.Repeat("  ", s.Depth), s.Expr,
+			traceValue(s.Value))
+	}
+	verdict := "no match"
+	if match {
+		verdict = "match"
+	}
+	fmt.Fprintf(d.w, "  %s\n", verdict)
+}
+
+// traceValue returns the printed value v of a trace step. Strings are
+// quoted.
+func traceValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("'%s'", s)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql"
+)
+
+// unmatchedWriter writes the records that do not match the WHERE clause to
+// a file. Records are written while they are filtered, so the writer must be
+// opened before any records are read.
+type unmatchedWriter struct {
+	w     *samql.Writer
+	file  *outputFile
+	links [][]*sam.Reference // References of the merged header by input.
+	err   error
+}
+
+// filter returns a filter that returns the result of f and writes the
+// records of input i for which f is false. Write errors are returned by
+// Close.
+func (u *unmatchedWriter) filter(f samql.FilterFunc, i int) samql.FilterFunc {
+	return func(rec *sam.Record) bool {
+		if f(rec) {
+			return true
+		}
+		if u.err == nil {
+			// The record is dropped, so it can be relinked in place.
+			if u.links != nil {
+				samql.Relink(rec, u.links[i])
+			}
+			u.err = u.w.Write(rec)
+		}
+		return false
+	}
+}
+
+// open creates the output file at path and a writer of records with header
+// h, in the format given by newFileWriter. links are the references of h for
+// the references of each input, as returned by samql.MergeHeaders.
+func (u *unmatchedWriter) open(path string, h *sam.Header, links [][]*sam.Reference,
+	opts Opts, wc int) error {
+
+	f, err := createOutput(path)
+	if err != nil {
+		return err
+	}
+	w, err := newFileWriter(f, path, h, opts, wc)
+	if err != nil {
+		f.Abort()
+		return err
+	}
+	u.w, u.file, u.links = w, f, links
+	return nil
+}
+
+// Close closes the writer and replaces the file at path with the output
+// file.
+func (u *unmatchedWriter) Close() error {
+	if err := u.w.Close(); err != nil && u.err == nil {
+		u.err = err
+	}
+	if u.err != nil {
+		u.file.Abort()
+		return u.err
+	}
+	return u.file.Commit()
+}
+
+// Abort removes the output file.
+func (u *unmatchedWriter) Abort() {
+	u.file.Abort()
+}
@@ -0,0 +1,72 @@
+package samql
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/biogo/hts/bgzf"
+)
+
+// CorruptError is the error of a Reader whose input is truncated, e.g. a BAM
+// file that was not completely copied, or corrupt, e.g. a BGZF block that
+// fails its CRC check. The records read before the error are valid.
+type CorruptError struct {
+	// Records is the number of records read before the error.
+	Records int64
+	// Err is the error of the underlying reader.
+	Err error
+}
+
+// Error returns a description of e, e.g. "truncated input after 100
+// records: unexpected EOF".
+func (e *CorruptError) Error() string {
+	kind := "corrupt"
+	if errors.Is(e.Err, io.ErrUnexpectedEOF) {
+		kind = "truncated"
+	}
+	return fmt.Sprintf("%s input after %d records: %v", kind, e.Records, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// corruptErrors are the errors of reading a truncated input, an invalid
+// BGZF or gzip block or a BAM record that does not fit its block.
+var corruptErrors = []error{
+	io.ErrUnexpectedEOF, gzip.ErrChecksum, gzip.ErrHeader, bgzf.ErrCorrupt,
+	bgzf.ErrNoBlockSize, bgzf.ErrBlockSizeMismatch,
+	errShortBlock, errShortBlockSize, errBlockSize, errTruncatedRecord,
+}
+
+// isCorrupt returns true if err is, or wraps, one of corruptErrors or a
+// flate.CorruptInputError.
+func isCorrupt(err error) bool {
+	for _, e := range corruptErrors {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	var ferr flate.CorruptInputError
+	return errors.As(err, &ferr)
+}
+
+// corrupt returns err as a *CorruptError if it is an error of a truncated or
+// corrupt input. It returns io.EOF instead if r.OnCorrupt is not nil, after
+// calling it, so that the records before the error are salvaged.
+func (r *Reader) corrupt(err error) error {
+	if !isCorrupt(err) {
+		return err
+	}
+	cerr := &CorruptError{Records: r.read, Err: err}
+	if r.OnCorrupt == nil {
+		return cerr
+	}
+	r.salvaged = true
+	r.OnCorrupt(cerr)
+	return io.EOF
+}
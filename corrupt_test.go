@@ -0,0 +1,157 @@
+package samql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/sam"
+)
+
+// corruptCRC returns a copy of the BGZF data with the CRC of block n
+// inverted.
+func corruptCRC(t *testing.T, data []byte, n int) []byte {
+	data = append([]byte(nil), data...)
+	off := 0
+	for i := 0; off+18 <= len(data); i++ {
+		size := int(binary.LittleEndian.Uint16(data[off+16:])) + 1
+		if i == n {
+			for j := off + size - 8; j < off+size-4; j++ {
+				data[j] ^= 0xff
+			}
+			return data
+		}
+		off += size
+	}
+	t.Fatalf("BGZF data has fewer than %d blocks", n+1)
+	return nil
+}
+
+// corruptRecord returns a copy of the BAM data with the 32-bit value at
+// offset off of record n, from the start of its block size, set to v.
+func corruptRecord(t *testing.T, data []byte, n, off int, v uint32) []byte {
+	bg, err := bgzf.NewReader(bytes.NewReader(data), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u32 := func(i int) int { return int(binary.LittleEndian.Uint32(raw[i:])) }
+	i := 8 + u32(4) // Magic, l_text and text.
+	nRef := u32(i)
+	for i += 4; nRef > 0; nRef-- {
+		i += 4 + u32(i) + 4 // l_name, name and l_ref.
+	}
+	for ; n > 0; n-- {
+		i += 4 + u32(i)
+	}
+	binary.LittleEndian.PutUint32(raw[i+off:], v)
+
+	var buf bytes.Buffer
+	bw := bgzf.NewWriter(&buf, 1)
+	if _, err := bw.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReaderCorrupt(t *testing.T) {
+	data := newBAM(t, auxSamData, 2000)
+	const total = 3 * 2000
+
+	tests := []struct {
+		Test      string
+		Data      []byte
+		Truncated bool
+		Records   int64 // Records before the error, if known.
+	}{
+		{Test: "Truncated", Data: data[:len(data)/2], Truncated: true},
+		{Test: "Checksum", Data: corruptCRC(t, data, 3)},
+		{Test: "BlockSize", Data: corruptRecord(t, data, 100, 0, 0xffffffff), Records: 100},
+		{Test: "Record", Data: corruptRecord(t, data, 100, 20, 1<<20), Records: 100}, // l_seq
+	}
+	for _, tt := range tests {
+		t.Run(tt.Test, func(t *testing.T) {
+			// Without OnCorrupt reading fails with a *CorruptError.
+			br, err := NewBAMReader(bytes.NewReader(tt.Data), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := NewReader(br)
+			n := int64(0)
+			for {
+				_, err = r.Read()
+				if err != nil {
+					break
+				}
+				n++
+			}
+			cerr, ok := err.(*CorruptError)
+			if !ok {
+				t.Fatalf("expected *CorruptError, got %T: %v", err, err)
+			}
+			if cerr.Records != n || n == 0 || n >= total || tt.Records > 0 && n != tt.Records {
+				t.Errorf("expected error after %d of %d records, got %d", n, total, cerr.Records)
+			}
+			if got := cerr.Err == io.ErrUnexpectedEOF; got != tt.Truncated {
+				t.Errorf("expected truncated %v, got error %v", tt.Truncated, cerr)
+			}
+
+			// With OnCorrupt the records before the error are read.
+			br, err = NewBAMReader(bytes.NewReader(tt.Data), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = NewReader(br)
+			var salvaged *CorruptError
+			r.OnCorrupt = func(err *CorruptError) { salvaged = err }
+			var rec sam.Record
+			m := int64(0)
+			for {
+				if err := r.ReadInto(&rec); err != nil {
+					if err != io.EOF {
+						t.Fatalf("expected io.EOF, got %v", err)
+					}
+					break
+				}
+				m++
+			}
+			if salvaged == nil || salvaged.Records != n || m != n {
+				t.Errorf("expected %d salvaged records, got %d (%v)", n, m, salvaged)
+			}
+			if err := r.Close(); err != nil {
+				t.Errorf("unexpected error on close: %v", err)
+			}
+
+			// A Pipeline salvages the records with the OnCorrupt of its
+			// reader.
+			br, err = NewBAMReader(bytes.NewReader(tt.Data), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = NewReader(br)
+			salvaged = nil
+			r.OnCorrupt = func(err *CorruptError) { salvaged = err }
+			p := NewPipeline(r, 4)
+			p.BatchSize = 7
+			recs, err := NewReader(p).ReadAll()
+			if err != nil {
+				t.Fatalf("unexpected pipeline error %v", err)
+			}
+			if salvaged == nil || salvaged.Records != n || int64(len(recs)) != n {
+				t.Errorf("expected %d salvaged pipeline records, got %d (%v)", n, len(recs), salvaged)
+			}
+			if err := p.Close(); err != nil {
+				t.Errorf("unexpected error on pipeline close: %v", err)
+			}
+		})
+	}
+}
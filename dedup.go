@@ -0,0 +1,159 @@
+package samql
+
+import (
+	"errors"
+	"io"
+
+	"github.com/biogo/hts/sam"
+)
+
+// errUnsorted is returned by a Deduper for input that is not sorted by
+// coordinate.
+var errUnsorted = errors.New("samql: dedup requires coordinate sorted input")
+
+// Deduper is a SAM reader that marks or removes duplicate records, e.g. PCR
+// or optical duplicates, in coordinate sorted input. Records are duplicates
+// if they have the same reference, position, strand and CIGAR and, if a UMI
+// tag is provided, the same UMI. For paired records the mate reference and
+// position are also compared. Of each set of duplicates the record with the
+// highest sum of base qualities is kept, or the first one on ties. Unmapped,
+// secondary and supplementary records are never duplicates. Records with the
+// same position are buffered, so that memory use grows with the depth of
+// the input.
+type Deduper struct {
+	// Remove removes the duplicates instead of setting their duplicate
+	// flag.
+	Remove bool
+
+	r   readerSAM
+	umi placeholderStr
+	buf []*sam.Record // Records with the position of buf[0].
+	out []*sam.Record
+	err error
+}
+
+// dupKey is the key that identifies duplicates at a position.
+type dupKey struct {
+	reverse bool
+	cigar   string
+	umi     string
+	mateRef int
+	matePos int
+}
+
+// NewDeduper returns a new Deduper that reads from r. If umi is not empty it
+// is the name of the tag with the UMI of each record, e.g. RX.
+func NewDeduper(r readerSAM, umi string) (*Deduper, error) {
+	d := &Deduper{r: r}
+	if umi != "" {
+		if len(umi) != 2 {
+			return nil, errors.New("samql: invalid UMI tag " + umi)
+		}
+		d.umi = getPlaceholderTag(umi + ":Z").(placeholderStr)
+	}
+	return d, nil
+}
+
+// Header returns the Header of the underlying reader.
+func (d *Deduper) Header() *sam.Header {
+	return d.r.Header()
+}
+
+// Read returns the next record that is not removed as a duplicate. Returns nil
+// and io.EOF when the underlying reader is exhausted.
+func (d *Deduper) Read() (*sam.Record, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return nil, d.err
+		}
+		rec, err := d.r.Read()
+		if err != nil {
+			d.err = err
+			d.flush()
+			continue
+		}
+		if len(d.buf) > 0 {
+			last := d.buf[0]
+			if rec.Ref == last.Ref && rec.Pos < last.Pos {
+				d.err = errUnsorted
+				continue
+			}
+			if rec.Ref != last.Ref || rec.Pos != last.Pos {
+				d.flush()
+			}
+		}
+		d.buf = append(d.buf, rec)
+	}
+	rec := d.out[0]
+	d.out = d.out[1:]
+	return rec, nil
+}
+
+// flush finds the duplicates among the buffered records and queues the
+// records for output in the order they were read.
+func (d *Deduper) flush() {
+	best := make(map[dupKey]int)
+	dup := make([]bool, len(d.buf))
+	for i, rec := range d.buf {
+		if rec.Flags&(sam.Unmapped|sam.Secondary|sam.Supplementary) != 0 {
+			continue
+		}
+		k := d.key(rec)
+		j, ok := best[k]
+		switch {
+		case !ok:
+			best[k] = i
+		case qualSum(rec) > qualSum(d.buf[j]):
+			best[k], dup[j] = i, true
+		default:
+			dup[i] = true
+		}
+	}
+	for i, rec := range d.buf {
+		if dup[i] {
+			if d.Remove {
+				continue
+			}
+			rec.Flags |= sam.Duplicate
+		}
+		d.out = append(d.out, rec)
+	}
+	d.buf = d.buf[:0]
+}
+
+// key returns the key of rec that identifies its duplicates at its position.
+func (d *Deduper) key(rec *sam.Record) dupKey {
+	k := dupKey{
+		reverse: rec.Flags&sam.Reverse != 0,
+		cigar:   rec.Cigar.String(),
+		mateRef: -1,
+		matePos: -1,
+	}
+	if d.umi != nil {
+		k.umi = d.umi(rec)
+	}
+	if rec.Flags&sam.Paired != 0 {
+		k.mateRef, k.matePos = rec.MateRef.ID(), rec.MatePos
+	}
+	return k
+}
+
+// qualSum returns the sum of the base qualities of rec. Missing qualities are
+// zero.
+func qualSum(rec *sam.Record) int {
+	n := 0
+	for _, q := range rec.Qual {
+		if q != 0xff {
+			n += int(q)
+		}
+	}
+	return n
+}
+
+// Close closes the underlying reader if it implements io.Closer.
+func (d *Deduper) Close() error {
+	if c, ok := d.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package samql
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+const dedupData = "@HD\tVN:1.5\tSO:coordinate\n" +
+	"@SQ\tSN:chr1\tLN:100\n" +
+	"d1\t0\tchr1\t10\t30\t8M\t*\t0\t0\tTTAGATAA\t########\tRX:Z:AAA\n" +
+	"d2\t0\tchr1\t10\t30\t8M\t*\t0\t0\tTTAGATAA\tIIIIIIII\tRX:Z:AAA\n" +
+	"d3\t0\tchr1\t10\t30\t8M\t*\t0\t0\tTTAGATAA\tIIIIIIII\tRX:Z:CCC\n" +
+	"d4\t16\tchr1\t10\t30\t8M\t*\t0\t0\tTTAGATAA\tIIIIIIII\tRX:Z:AAA\n" +
+	"d5\t0\tchr1\t10\t30\t4M1I3M\t*\t0\t0\tTTAGATAA\tIIIIIIII\tRX:Z:AAA\n" +
+	"d6\t256\tchr1\t10\t30\t8M\t*\t0\t0\tTTAGATAA\tIIIIIIII\tRX:Z:AAA\n" +
+	"d7\t0\tchr1\t20\t30\t8M\t*\t0\t0\tTTAGATAA\tIIIIIIII\tRX:Z:AAA\n" +
+	"d8\t0\tchr1\t20\t30\t8M\t*\t0\t0\tTTAGATAA\tIIIIIIII\tRX:Z:AAA\n" +
+	"d9\t4\t*\t0\t0\t*\t*\t0\t0\tTTAGATAA\tIIIIIIII\n" +
+	"d10\t4\t*\t0\t0\t*\t*\t0\t0\tTTAGATAA\tIIIIIIII\n"
+
+func TestDeduper(t *testing.T) {
+	for _, tt := range []struct {
+		Test   string
+		UMI    string
+		Remove bool
+		Names  string
+	}{
+		{Test: "Mark", Names: "d1*,d2,d3*,d4,d5,d6,d7,d8*,d9,d10"},
+		{Test: "MarkUMI", UMI: "RX", Names: "d1*,d2,d3,d4,d5,d6,d7,d8*,d9,d10"},
+		{Test: "Remove", Remove: true, Names: "d2,d4,d5,d6,d7,d9,d10"},
+		{Test: "RemoveUMI", UMI: "RX", Remove: true, Names: "d2,d3,d4,d5,d6,d7,d9,d10"},
+	} {
+		sr, err := sam.NewReader(strings.NewReader(dedupData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDeduper(sr, tt.UMI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Remove = tt.Remove
+
+		var names []string
+		for {
+			rec, err := d.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: unexpected error %q", tt.Test, err.Error())
+			}
+			if rec.Flags&sam.Duplicate != 0 {
+				rec.Name += "*"
+			}
+			names = append(names, rec.Name)
+		}
+		if got := strings.Join(names, ","); got != tt.Names {
+			t.Errorf("%s: records=%s want %s", tt.Test, got, tt.Names)
+		}
+	}
+}
+
+func TestDeduperUnsorted(t *testing.T) {
+	const data = "@SQ\tSN:chr1\tLN:100\n" +
+		"u1\t0\tchr1\t20\t30\t8M\t*\t0\t0\tTTAGATAA\t*\n" +
+		"u2\t0\tchr1\t10\t30\t8M\t*\t0\t0\tTTAGATAA\t*\n"
+	sr, err := sam.NewReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewDeduper(sr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err = r.Read()
+		if err != nil {
+			break
+		}
+	}
+	if err != errUnsorted {
+		t.Errorf("error=%v want %v", err, errUnsorted)
+	}
+}
+
+func TestDeduperInvalidUMI(t *testing.T) {
+	if _, err := NewDeduper(newTestReader(t, ""), "RXZ"); err == nil {
+		t.Errorf("expected error")
+	}
+}
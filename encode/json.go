@@ -0,0 +1,124 @@
+// Package encode provides encoders that serialize SAM records in formats
+// other than SAM and BAM.
+package encode
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/biogo/hts/sam"
+)
+
+// Record is the JSON representation of a SAM record. Fields hold the values
+// of the SAM text format, i.e. positions are 1-based, qualities are Phred+33
+// encoded and missing strings are "*". Unlike the SAM text format, RNEXT is
+// the mate reference name even if it is the same as RNAME.
+type Record struct {
+	Qname string                 `json:"qname"`
+	Flag  int                    `json:"flag"`
+	Rname string                 `json:"rname"`
+	Pos   int                    `json:"pos"`
+	Mapq  int                    `json:"mapq"`
+	Cigar string                 `json:"cigar"`
+	Rnext string                 `json:"rnext"`
+	Pnext int                    `json:"pnext"`
+	Tlen  int                    `json:"tlen"`
+	Seq   string                 `json:"seq"`
+	Qual  string                 `json:"qual"`
+	Tags  map[string]interface{} `json:"tags,omitempty"`
+}
+
+// NewRecord returns the JSON representation of rec.
+func NewRecord(rec *sam.Record) *Record {
+	r := &Record{
+		Qname: rec.Name,
+		Flag:  int(rec.Flags),
+		Rname: rec.Ref.Name(),
+		Pos:   rec.Pos + 1,
+		Mapq:  int(rec.MapQ),
+		Cigar: rec.Cigar.String(),
+		Rnext: rec.MateRef.Name(),
+		Pnext: rec.MatePos + 1,
+		Tlen:  rec.TempLen,
+		Seq:   string(rec.Seq.Expand()),
+		Qual:  qual(rec.Qual),
+	}
+	if r.Seq == "" {
+		r.Seq = "*"
+	}
+	if len(rec.AuxFields) > 0 {
+		r.Tags = make(map[string]interface{}, len(rec.AuxFields))
+		for _, a := range rec.AuxFields {
+			r.Tags[a.Tag().String()] = auxValue(a)
+		}
+	}
+	return r
+}
+
+// qual returns the Phred+33 encoded string of the qualities q. Missing
+// qualities, that are stored as 0xff, are returned as "*".
+func qual(q []byte) string {
+	if len(q) == 0 || q[0] == 0xff {
+		return "*"
+	}
+	b := make([]byte, len(q))
+	for i, v := range q {
+		b[i] = v + 33
+	}
+	return string(b)
+}
+
+// auxValue returns the value of a in a type that is encoded naturally in
+// JSON. Characters and hex strings are returned as strings and byte arrays as
+// arrays of numbers instead of base64 strings.
+func auxValue(a sam.Aux) interface{} {
+	switch v := a.Value().(type) {
+	case []uint8:
+		if a.Type() == 'H' {
+			return fmt.Sprintf("%X", v)
+		}
+		ints := make([]int, len(v))
+		for i, b := range v {
+			ints[i] = int(b)
+		}
+		return ints
+	case uint8:
+		if a.Type() == 'A' {
+			return string(v)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// JSONWriter writes SAM records as JSON objects, one per line.
+type JSONWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewJSONWriter returns a new JSONWriter that writes to w. Output is buffered
+// and is written to w on Flush or Close.
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	buf := bufio.NewWriter(w)
+	return &JSONWriter{buf: buf, enc: json.NewEncoder(buf)}
+}
+
+// Write writes rec as a JSON object followed by a newline.
+func (w *JSONWriter) Write(rec *sam.Record) error {
+	return w.enc.Encode(NewRecord(rec))
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (w *JSONWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+// Close flushes any buffered data. It does not close the underlying
+// io.Writer.
+func (w *JSONWriter) Close() error {
+	return w.Flush()
+}
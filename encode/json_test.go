@@ -0,0 +1,44 @@
+package encode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+const samData = `@HD	VN:1.5	SO:coordinate
+@SQ	SN:chr1	LN:45
+r001	99	chr1	7	30	8M2I4M1D3M	=	37	39	TTAGATAAAGGATACTG	*	NM:i:1	XA:A:x	XB:B:C,1,2	XH:H:1AE3
+r006	77	*	0	0	*	*	0	0	CAGC	III#
+`
+
+func TestJSONWriter(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := NewJSONWriter(&buf)
+	for {
+		rec, err := sr.Read()
+		if err != nil {
+			break
+		}
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"qname":"r001","flag":99,"rname":"chr1","pos":7,"mapq":30,"cigar":"8M2I4M1D3M","rnext":"chr1","pnext":37,"tlen":39,"seq":"TTAGATAAAGGATACTG","qual":"*","tags":{"NM":1,"XA":"x","XB":[1,2],"XH":"1AE3"}}
+{"qname":"r006","flag":77,"rname":"*","pos":0,"mapq":0,"cigar":"*","rnext":"*","pnext":0,"tlen":0,"seq":"CAGC","qual":"III#"}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("json=%s want %s", got, want)
+	}
+}
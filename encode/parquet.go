@@ -0,0 +1,480 @@
+package encode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ParquetType is the type of a column of a Parquet file.
+type ParquetType int
+
+// Parquet column types. Values of ParquetInt64 columns can be any Go integer
+// type and values of ParquetDouble columns float32 or float64. Values of
+// ParquetString columns that are not strings are formatted with fmt.Sprint.
+const (
+	ParquetBool ParquetType = iota
+	ParquetInt64
+	ParquetFloat
+	ParquetDouble
+	ParquetString
+)
+
+// DefaultRowGroupSize is the default number of rows of a Parquet row group.
+const DefaultRowGroupSize = 1 << 20
+
+// maxGroupBytes is the size of the encoded values of a row group after
+// which it is written regardless of its number of rows.
+const maxGroupBytes = 1 << 28
+
+// parquetMagic starts and ends Parquet files.
+const parquetMagic = "PAR1"
+
+// Physical types, encodings and other enum values of the Parquet format.
+const (
+	physBoolean   = 0
+	physInt64     = 2
+	physFloat     = 4
+	physDouble    = 5
+	physByteArray = 6
+
+	encPlain = 0
+	encRLE   = 3
+
+	convertedUTF8 = 0
+	repOptional   = 1
+	pageData      = 0
+	codecNone     = 0
+)
+
+// physicalTypes maps column types to Parquet physical types.
+var physicalTypes = map[ParquetType]int64{
+	ParquetBool:   physBoolean,
+	ParquetInt64:  physInt64,
+	ParquetFloat:  physFloat,
+	ParquetDouble: physDouble,
+	ParquetString: physByteArray,
+}
+
+// ParquetWriter writes rows of values as a Parquet file. All columns are
+// optional, i.e. nil values are written as nulls, and are stored
+// uncompressed with plain encoding in a single page per row group.
+type ParquetWriter struct {
+	// RowGroupSize is the number of rows of each row group.
+	RowGroupSize int
+
+	w      *bufio.Writer
+	off    int64
+	names  []string
+	types  []ParquetType
+	cols   []parquetColumn
+	rows   int
+	total  int64
+	groups []parquetRowGroup
+	err    error
+}
+
+// parquetColumn holds the values of a column of the current row group.
+type parquetColumn struct {
+	defined []bool
+	vals    bytes.Buffer
+	bits    int // Number of booleans packed in vals.
+}
+
+// parquetRowGroup is the metadata of a written row group.
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int64
+	size   int64
+}
+
+// parquetChunk is the metadata of a written column chunk.
+type parquetChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+// NewParquetWriter returns a new ParquetWriter that writes columns with names
+// and types to w. The file is complete only after Close.
+func NewParquetWriter(w io.Writer, names []string, types []ParquetType) (*ParquetWriter, error) {
+	if len(names) == 0 || len(names) != len(types) {
+		return nil, fmt.Errorf("encode: %d column names for %d types", len(names), len(types))
+	}
+	for i, t := range types {
+		if _, ok := physicalTypes[t]; !ok {
+			return nil, fmt.Errorf("encode: invalid type %d for column %s", t, names[i])
+		}
+	}
+	pw := &ParquetWriter{
+		RowGroupSize: DefaultRowGroupSize,
+		w:            bufio.NewWriter(w),
+		names:        names,
+		types:        types,
+		cols:         make([]parquetColumn, len(names)),
+	}
+	pw.write([]byte(parquetMagic))
+	return pw, pw.err
+}
+
+// write writes b to the underlying writer and keeps the offset of the next
+// byte. Errors are kept and returned by Write and Close.
+func (w *ParquetWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(b)
+	w.off += int64(n)
+	w.err = err
+}
+
+// Write writes a row with the values vals, one for each column.
+func (w *ParquetWriter) Write(vals []interface{}) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(vals) != len(w.cols) {
+		return fmt.Errorf("encode: %d values for %d columns", len(vals), len(w.cols))
+	}
+	for i, v := range vals {
+		if err := w.cols[i].add(w.types[i], v); err != nil {
+			// Previous columns of the row have been added already.
+			w.err = fmt.Errorf("encode: column %s: %v", w.names[i], err)
+			return w.err
+		}
+	}
+	w.rows++
+	if w.rows >= w.RowGroupSize || w.bufferedBytes() >= maxGroupBytes {
+		w.flushGroup()
+	}
+	return w.err
+}
+
+// bufferedBytes returns the size of the encoded values of the current row
+// group.
+func (w *ParquetWriter) bufferedBytes() int {
+	n := 0
+	for i := range w.cols {
+		n += w.cols[i].vals.Len()
+	}
+	return n
+}
+
+// add appends v, or a null if v is nil, to c.
+func (c *parquetColumn) add(t ParquetType, v interface{}) error {
+	if v == nil {
+		c.defined = append(c.defined, false)
+		return nil
+	}
+	var b [8]byte
+	switch t {
+	case ParquetBool:
+		x, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("%T is not a boolean", v)
+		}
+		if c.bits%8 == 0 {
+			c.vals.WriteByte(0)
+		}
+		if x {
+			c.vals.Bytes()[c.vals.Len()-1] |= 1 << uint(c.bits%8)
+		}
+		c.bits++
+	case ParquetInt64:
+		x, ok := toInt64(v)
+		if !ok {
+			return fmt.Errorf("%T is not an integer", v)
+		}
+		binary.LittleEndian.PutUint64(b[:], uint64(x))
+		c.vals.Write(b[:8])
+	case ParquetFloat:
+		x, ok := v.(float32)
+		if !ok {
+			return fmt.Errorf("%T is not a float", v)
+		}
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(x))
+		c.vals.Write(b[:4])
+	case ParquetDouble:
+		var x float64
+		switch f := v.(type) {
+		case float64:
+			x = f
+		case float32:
+			x = float64(f)
+		default:
+			return fmt.Errorf("%T is not a float", v)
+		}
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(x))
+		c.vals.Write(b[:8])
+	case ParquetString:
+		s, ok := v.(string)
+		if !ok {
+			s = fmt.Sprint(v)
+		}
+		binary.LittleEndian.PutUint32(b[:], uint32(len(s)))
+		c.vals.Write(b[:4])
+		c.vals.WriteString(s)
+	}
+	c.defined = append(c.defined, true)
+	return nil
+}
+
+// toInt64 returns v as an int64 if it is an integer.
+func toInt64(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int8:
+		return int64(x), true
+	case int16:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	case uint8:
+		return int64(x), true
+	case uint16:
+		return int64(x), true
+	case uint32:
+		return int64(x), true
+	}
+	return 0, false
+}
+
+// flushGroup writes the buffered rows as a row group with a single data page
+// for each column.
+func (w *ParquetWriter) flushGroup() {
+	if w.rows == 0 || w.err != nil {
+		return
+	}
+	g := parquetRowGroup{rows: int64(w.rows)}
+	for i := range w.cols {
+		c := &w.cols[i]
+		levels := rleLevels(c.defined)
+		data := make([]byte, 4, 4+len(levels)+c.vals.Len())
+		binary.LittleEndian.PutUint32(data, uint32(len(levels)))
+		data = append(data, levels...)
+		data = append(data, c.vals.Bytes()...)
+
+		var t thriftWriter
+		t.i32(1, pageData)
+		t.i32(2, int64(len(data)))
+		t.i32(3, int64(len(data)))
+		t.beginStruct(5)
+		t.i32(1, int64(len(c.defined)))
+		t.i32(2, encPlain)
+		t.i32(3, encRLE)
+		t.i32(4, encRLE)
+		t.endStruct()
+		t.stop()
+
+		chunk := parquetChunk{
+			offset: w.off,
+			size:   int64(t.buf.Len() + len(data)),
+			values: int64(len(c.defined)),
+		}
+		w.write(t.buf.Bytes())
+		w.write(data)
+		g.chunks = append(g.chunks, chunk)
+		g.size += chunk.size
+
+		c.defined = c.defined[:0]
+		c.vals.Reset()
+		c.bits = 0
+	}
+	w.groups = append(w.groups, g)
+	w.total += g.rows
+	w.rows = 0
+}
+
+// rleLevels returns the definition levels of a column with bit width 1 in
+// the RLE encoding of the Parquet format, i.e. 1 for defined values and 0
+// for nulls.
+func rleLevels(defined []bool) []byte {
+	var b []byte
+	var tmp [binary.MaxVarintLen64]byte
+	for i := 0; i < len(defined); {
+		j := i
+		for j < len(defined) && defined[j] == defined[i] {
+			j++
+		}
+		n := binary.PutUvarint(tmp[:], uint64(j-i)<<1)
+		b = append(b, tmp[:n]...)
+		if defined[i] {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		i = j
+	}
+	return b
+}
+
+// Close writes the remaining rows and the file metadata and flushes any
+// buffered data. It does not close the underlying io.Writer.
+func (w *ParquetWriter) Close() error {
+	w.flushGroup()
+	if w.err != nil {
+		return w.err
+	}
+
+	var t thriftWriter
+	t.i32(1, 1) // version
+	t.list(2, thriftStruct, len(w.cols)+1)
+	t.beginElem()
+	t.binary(4, "schema")
+	t.i32(5, int64(len(w.cols)))
+	t.endStruct()
+	for i, name := range w.names {
+		t.beginElem()
+		t.i32(1, physicalTypes[w.types[i]])
+		t.i32(3, repOptional)
+		t.binary(4, name)
+		if w.types[i] == ParquetString {
+			t.i32(6, convertedUTF8)
+		}
+		t.endStruct()
+	}
+	t.i64(3, w.total)
+	t.list(4, thriftStruct, len(w.groups))
+	for _, g := range w.groups {
+		t.beginElem()
+		t.list(1, thriftStruct, len(g.chunks))
+		for i, c := range g.chunks {
+			t.beginElem()
+			t.i64(2, c.offset)
+			t.beginStruct(3)
+			t.i32(1, physicalTypes[w.types[i]])
+			t.list(2, thriftI32, 2)
+			t.varint(encPlain)
+			t.varint(encRLE)
+			t.list(3, thriftBinary, 1)
+			t.str(w.names[i])
+			t.i32(4, codecNone)
+			t.i64(5, c.values)
+			t.i64(6, c.size)
+			t.i64(7, c.size)
+			t.i64(9, c.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, g.size)
+		t.i64(3, g.rows)
+		t.endStruct()
+	}
+	t.binary(6, "samql")
+	t.stop()
+
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(t.buf.Len()))
+	w.write(t.buf.Bytes())
+	w.write(n[:])
+	w.write([]byte(parquetMagic))
+	if w.err != nil {
+		return w.err
+	}
+	w.err = errors.New("encode: write to closed ParquetWriter")
+	return w.w.Flush()
+}
+
+// Type codes of the Thrift compact protocol.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol that Parquet
+// uses for its metadata.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int // Last field id of each open struct.
+}
+
+// field writes the header of field id of type typ.
+func (t *thriftWriter) field(id int, typ byte) {
+	if len(t.last) == 0 {
+		t.last = append(t.last, 0)
+	}
+	last := &t.last[len(t.last)-1]
+	if d := id - *last; d > 0 && d <= 15 {
+		t.buf.WriteByte(byte(d<<4) | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	*last = id
+}
+
+// varint writes v as a zigzag encoded varint.
+func (t *thriftWriter) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], uint64((v<<1)^(v>>63)))
+	t.buf.Write(b[:n])
+}
+
+// str writes s as a binary value.
+func (t *thriftWriter) str(s string) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], uint64(len(s)))
+	t.buf.Write(b[:n])
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) i32(id int, v int64) {
+	t.field(id, thriftI32)
+	t.varint(v)
+}
+
+func (t *thriftWriter) i64(id int, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int, s string) {
+	t.field(id, thriftBinary)
+	t.str(s)
+}
+
+// list writes the header of list field id with n elements of type typ. The
+// elements are written next, e.g. with beginElem for structs.
+func (t *thriftWriter) list(id int, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n<<4) | typ)
+		return
+	}
+	t.buf.WriteByte(0xf0 | typ)
+	var b [binary.MaxVarintLen64]byte
+	k := binary.PutUvarint(b[:], uint64(n))
+	t.buf.Write(b[:k])
+}
+
+// beginStruct starts struct field id.
+func (t *thriftWriter) beginStruct(id int) {
+	t.field(id, thriftStruct)
+	t.beginElem()
+}
+
+// beginElem starts a struct that is an element of a list.
+func (t *thriftWriter) beginElem() {
+	t.last = append(t.last, 0)
+}
+
+// endStruct ends the innermost open struct.
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.last = t.last[:len(t.last)-1]
+}
+
+// stop writes the stop byte that ends a struct.
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
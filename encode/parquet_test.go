@@ -0,0 +1,222 @@
+package encode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// thriftReader decodes structs of the Thrift compact protocol into maps of
+// field ids to values for testing.
+type thriftReader struct {
+	b []byte
+}
+
+func (r *thriftReader) byte() byte {
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		v := r.uvarint()
+		return int64(v>>1) ^ -int64(v&1)
+	case thriftBinary:
+		n := r.uvarint()
+		s := string(r.b[:n])
+		r.b = r.b[n:]
+		return s
+	case thriftList:
+		h := r.byte()
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		l := make([]interface{}, n)
+		for i := range l {
+			l[i] = r.value(h & 0x0f)
+		}
+		return l
+	case thriftStruct:
+		return r.structure()
+	}
+	panic("unexpected thrift type")
+}
+
+func (r *thriftReader) structure() map[int]interface{} {
+	s := make(map[int]interface{})
+	id := 0
+	for {
+		h := r.byte()
+		if h == 0 {
+			return s
+		}
+		if d := int(h >> 4); d != 0 {
+			id += d
+		} else {
+			id = int(r.value(thriftI32).(int64))
+		}
+		s[id] = r.value(h & 0x0f)
+	}
+}
+
+// readParquet returns the file metadata of the Parquet file b and the values
+// of each column, with nil for nulls.
+func readParquet(t *testing.T, b []byte) (map[int]interface{}, [][]interface{}) {
+	if string(b[:4]) != parquetMagic || string(b[len(b)-4:]) != parquetMagic {
+		t.Fatalf("missing magic")
+	}
+	n := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	meta := (&thriftReader{b[len(b)-8-n : len(b)-8]}).structure()
+
+	schema := meta[2].([]interface{})
+	cols := make([][]interface{}, len(schema)-1)
+	for _, g := range meta[4].([]interface{}) {
+		for i, c := range g.(map[int]interface{})[1].([]interface{}) {
+			md := c.(map[int]interface{})[3].(map[int]interface{})
+			r := &thriftReader{b[md[9].(int64):]}
+			page := r.structure()
+			data := r.b[:page[3].(int64)]
+			nvals := int(page[5].(map[int]interface{})[1].(int64))
+
+			// Definition levels.
+			ln := binary.LittleEndian.Uint32(data)
+			lr := &thriftReader{data[4 : 4+ln]}
+			var defined []bool
+			for len(lr.b) > 0 {
+				run := int(lr.uvarint() >> 1)
+				v := lr.byte() == 1
+				for k := 0; k < run; k++ {
+					defined = append(defined, v)
+				}
+			}
+			if len(defined) != nvals {
+				t.Fatalf("levels=%d want %d", len(defined), nvals)
+			}
+
+			vals := data[4+ln:]
+			bit := 0
+			for _, d := range defined {
+				if !d {
+					cols[i] = append(cols[i], nil)
+					continue
+				}
+				var v interface{}
+				switch md[1].(int64) {
+				case physBoolean:
+					v = vals[bit/8]&(1<<uint(bit%8)) != 0
+					bit++
+				case physInt64:
+					v = int64(binary.LittleEndian.Uint64(vals))
+					vals = vals[8:]
+				case physFloat:
+					v = math.Float32frombits(binary.LittleEndian.Uint32(vals))
+					vals = vals[4:]
+				case physDouble:
+					v = math.Float64frombits(binary.LittleEndian.Uint64(vals))
+					vals = vals[8:]
+				case physByteArray:
+					l := binary.LittleEndian.Uint32(vals)
+					v = string(vals[4 : 4+l])
+					vals = vals[4+l:]
+				}
+				cols[i] = append(cols[i], v)
+			}
+		}
+	}
+	return meta, cols
+}
+
+func TestParquetWriter(t *testing.T) {
+	names := []string{"qname", "pos", "identity", "mean", "paired"}
+	types := []ParquetType{ParquetString, ParquetInt64, ParquetFloat, ParquetDouble, ParquetBool}
+	rows := [][]interface{}{
+		{"r001", 7, float32(0.5), 1.5, true},
+		{"r002", int64(9), nil, float32(2), false},
+		{nil, nil, float32(1), nil, nil},
+		{"r004", 0, float32(0), 0.0, true},
+	}
+
+	for _, size := range []int{DefaultRowGroupSize, 3, 1} {
+		var buf bytes.Buffer
+		w, err := NewParquetWriter(&buf, names, types)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.RowGroupSize = size
+		for _, row := range rows {
+			if err := w.Write(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		meta, cols := readParquet(t, buf.Bytes())
+		if n := meta[3].(int64); n != int64(len(rows)) {
+			t.Errorf("size %d: num_rows=%d want %d", size, n, len(rows))
+		}
+		if n, want := len(meta[4].([]interface{})), (len(rows)+size-1)/size; n != want {
+			t.Errorf("size %d: row groups=%d want %d", size, n, want)
+		}
+		var got []string
+		for _, e := range meta[2].([]interface{})[1:] {
+			got = append(got, e.(map[int]interface{})[4].(string))
+		}
+		if !reflect.DeepEqual(got, names) {
+			t.Errorf("size %d: names=%v want %v", size, got, names)
+		}
+
+		want := [][]interface{}{
+			{"r001", "r002", nil, "r004"},
+			{int64(7), int64(9), nil, int64(0)},
+			{float32(0.5), nil, float32(1), float32(0)},
+			{1.5, 2.0, nil, 0.0},
+			{true, false, nil, true},
+		}
+		if !reflect.DeepEqual(cols, want) {
+			t.Errorf("size %d: columns=%v want %v", size, cols, want)
+		}
+	}
+}
+
+func TestParquetWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewParquetWriter(&buf, []string{"count"}, []ParquetType{ParquetInt64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := readParquet(t, buf.Bytes())
+	if n := meta[3].(int64); n != 0 {
+		t.Errorf("num_rows=%d want 0", n)
+	}
+}
+
+func TestParquetWriterInvalid(t *testing.T) {
+	if _, err := NewParquetWriter(&bytes.Buffer{}, []string{"a"}, nil); err == nil {
+		t.Errorf("expected error for missing types")
+	}
+	w, err := NewParquetWriter(&bytes.Buffer{}, []string{"a"}, []ParquetType{ParquetInt64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range [][]interface{}{{"x"}, {1.5}, {1, 2}} {
+		if err := w.Write(row); err == nil {
+			t.Errorf("%v: expected error", row)
+		}
+	}
+}
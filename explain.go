@@ -0,0 +1,177 @@
+package samql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// Explanation describes how a WHERE clause is evaluated, without reading any
+// records.
+type Explanation struct {
+	// Query is the normalized WHERE clause.
+	Query string
+	// UseIndex is true if only the records overlapping Regions need to be
+	// read from indexed files.
+	UseIndex bool
+	// Regions contains all records that can match the query.
+	Regions []Region
+	// IndexPredicates are the top level predicates that restrict the
+	// records to Regions.
+	IndexPredicates []string
+	// Residual is the predicate that is evaluated for the records read from
+	// the Regions of indexed files.
+	Residual string
+	// Fields are the record fields and keywords accessed, e.g. MAPQ.
+	Fields []string
+	// Tags are the aux tags accessed, e.g. NH:i.
+	Tags []string
+	// Functions are the functions called, e.g. length.
+	Functions []string
+}
+
+// Explain validates the SQL WHERE statement query and returns how it is
+// evaluated by the filters created with opts.
+func Explain(query string, opts ...FilterOption) (*Explanation, error) {
+	stmt, err := parseWhere(query)
+	if err != nil {
+		return nil, err
+	}
+	p, err := plan(query, withOptions(unboundVars(), opts))
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Explanation{
+		Query:    stmt.Condition.String(),
+		UseIndex: p.UseIndex,
+		Regions:  p.Regions,
+		Residual: stmt.Condition.String(),
+	}
+	if p.UseIndex {
+		e.Residual = "FALSE"
+		if len(p.Regions) > 0 {
+			e.Residual = "TRUE"
+			if p.residual != nil {
+				e.Residual = p.residual.String()
+			}
+		}
+		for _, c := range conjuncts(stmt.Condition) {
+			if _, ok := exprRegions(c); ok {
+				e.IndexPredicates = append(e.IndexPredicates, c.String())
+			}
+		}
+	}
+
+	fields, tags, funcs := references(stmt.Condition)
+	e.Fields, e.Tags, e.Functions = sortedKeys(fields), sortedKeys(tags), sortedKeys(funcs)
+	return e, nil
+}
+
+// references returns the record fields and keywords, the aux tags and the
+// functions that are referenced in the condition expression cond.
+func references(cond ql.Expr) (fields, tags, funcs map[string]bool) {
+	fields, tags, funcs = make(map[string]bool), make(map[string]bool), make(map[string]bool)
+	if cond == nil {
+		return fields, tags, funcs
+	}
+	ql.WalkFunc(cond, func(n ql.Node) bool {
+		switch n := n.(type) {
+		case *ql.VarRef:
+			if _, ok := getPlaceholder[n.Val]; ok {
+				fields[n.Val] = true
+			} else if validTag.MatchString(n.Val) {
+				tags[n.Val[:4]] = true
+			}
+		case *ql.Call:
+			funcs[n.Cmd] = true
+			if n.Cmd == "has" && len(n.Args) == 1 {
+				if ref, ok := n.Args[0].(*ql.VarRef); ok && len(ref.Val) == 2 {
+					tags[ref.Val] = true
+					return false
+				}
+			}
+		}
+		return true
+	})
+	return fields, tags, funcs
+}
+
+// sortedKeys returns the sorted keys of m.
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Fraction returns the estimated fraction of the records of an indexed file
+// with header h that are read, as the fraction of the reference bases that
+// are covered by the regions. It returns 1 if the whole file is read.
+func (e *Explanation) Fraction(h *sam.Header) float64 {
+	if !e.UseIndex {
+		return 1
+	}
+	refs := make(map[string]int)
+	total := 0
+	for _, ref := range h.Refs() {
+		refs[ref.Name()] = ref.Len()
+		total += ref.Len()
+	}
+	if total == 0 {
+		return 0
+	}
+	covered := 0
+	for _, r := range e.Regions {
+		l, ok := refs[r.Rname]
+		if !ok {
+			continue
+		}
+		end := r.End
+		if end <= 0 || end > l {
+			end = l
+		}
+		if end > r.Start {
+			covered += end - r.Start
+		}
+	}
+	if covered > total {
+		return 1
+	}
+	return float64(covered) / float64(total)
+}
+
+// String returns a multi-line description of e.
+func (e *Explanation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "query\t%s\n", e.Query)
+	if e.UseIndex {
+		regions := make([]string, len(e.Regions))
+		for i, r := range e.Regions {
+			regions[i] = r.String()
+		}
+		if len(regions) == 0 {
+			regions = []string{"none"}
+		}
+		fmt.Fprintf(&b, "scan\tindex regions %s\n", strings.Join(regions, " "))
+		fmt.Fprintf(&b, "index\t%s\n", strings.Join(e.IndexPredicates, " AND "))
+		fmt.Fprintf(&b, "residual\t%s\n", e.Residual)
+	} else {
+		fmt.Fprintf(&b, "scan\tall records\n")
+		fmt.Fprintf(&b, "filter\t%s\n", e.Residual)
+	}
+	for _, l := range []struct {
+		name string
+		vals []string
+	}{{"fields", e.Fields}, {"tags", e.Tags}, {"functions", e.Functions}} {
+		if len(l.vals) > 0 {
+			fmt.Fprintf(&b, "%s\t%s\n", l.name, strings.Join(l.vals, " "))
+		}
+	}
+	return b.String()
+}
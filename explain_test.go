@@ -0,0 +1,117 @@
+package samql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestExplain(t *testing.T) {
+	for _, tt := range []struct {
+		Query string
+		Want  Explanation
+	}{
+		{
+			Query: "RNAME = chr1 AND POS > 100 AND MAPQ > 10 AND NH:i = 1",
+			Want: Explanation{
+				Query:           "RNAME = chr1 AND POS > 100 AND MAPQ > 10 AND NH:i = 1",
+				UseIndex:        true,
+				Regions:         []Region{{Rname: "chr1", Start: 100}},
+				IndexPredicates: []string{"RNAME = chr1", "POS > 100"},
+				Residual:        "POS > 100 AND MAPQ > 10 AND NH:i = 1",
+				Fields:          []string{"MAPQ", "POS", "RNAME"},
+				Tags:            []string{"NH:i"},
+			},
+		},
+		{
+			Query: "(RNAME = chr1 OR RNAME = chr2) AND has(XS) AND length(SEQ) > 10",
+			Want: Explanation{
+				Query:           "(RNAME = chr1 OR RNAME = chr2) AND has(XS) AND length(SEQ) > 10",
+				UseIndex:        true,
+				Regions:         []Region{{Rname: "chr1"}, {Rname: "chr2"}},
+				IndexPredicates: []string{"RNAME = chr1 OR RNAME = chr2"},
+				Residual:        "(RNAME = chr1 OR RNAME = chr2) AND has(XS) AND length(SEQ) > 10",
+				Fields:          []string{"RNAME", "SEQ"},
+				Tags:            []string{"XS"},
+				Functions:       []string{"has", "length"},
+			},
+		},
+		{
+			Query: "MAPQ > 10",
+			Want: Explanation{
+				Query:    "MAPQ > 10",
+				Residual: "MAPQ > 10",
+				Fields:   []string{"MAPQ"},
+			},
+		},
+		{
+			Query: "RNAME = chr1 AND RNAME = chr2",
+			Want: Explanation{
+				Query:           "RNAME = chr1 AND RNAME = chr2",
+				UseIndex:        true,
+				IndexPredicates: []string{"RNAME = chr1", "RNAME = chr2"},
+				Residual:        "FALSE",
+				Fields:          []string{"RNAME"},
+			},
+		},
+	} {
+		e, err := Explain(tt.Query)
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Query, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(*e, tt.Want) {
+			t.Errorf("%s: explanation=%+v want %+v", tt.Query, *e, tt.Want)
+		}
+	}
+
+	for _, q := range []string{"MAPQ >", "FOO", "MAPQ = 'a'"} {
+		if _, err := Explain(q); err == nil {
+			t.Errorf("%s: expected error", q)
+		}
+	}
+}
+
+func TestExplainString(t *testing.T) {
+	e, err := Explain("RNAME = chr1 AND NH:i = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "query\tRNAME = chr1 AND NH:i = 1\n" +
+		"scan\tindex regions [chr1:0-end)\n" +
+		"index\tRNAME = chr1\n" +
+		"residual\tNH:i = 1\n" +
+		"fields\tRNAME\n" +
+		"tags\tNH:i\n"
+	if s := e.String(); s != want {
+		t.Errorf("string=%q want %q", s, want)
+	}
+}
+
+func TestExplainFraction(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader("@SQ\tSN:chr1\tLN:300\n@SQ\tSN:chr2\tLN:100\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sr.Header()
+
+	for _, tt := range []struct {
+		Query    string
+		Fraction float64
+	}{
+		{Query: "MAPQ > 10", Fraction: 1},
+		{Query: "RNAME = chr2", Fraction: 0.25},
+		{Query: "RNAME = chr1 AND POS BETWEEN 101 AND 200", Fraction: 0.25},
+		{Query: "RNAME = chr3", Fraction: 0},
+	} {
+		e, err := Explain(tt.Query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f := e.Fraction(h); f != tt.Fraction {
+			t.Errorf("%s: fraction=%g want %g", tt.Query, f, tt.Fraction)
+		}
+	}
+}
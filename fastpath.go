@@ -0,0 +1,188 @@
+package samql
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// appendFuncs append the text of a record field to a byte slice. The text is
+// the same as the value of the field keyword, e.g. SEQ, but is written
+// without allocating if the slice has enough capacity.
+var appendFuncs = map[string]func([]byte, *sam.Record) []byte{
+	"SEQ":   appendSeq,
+	"CIGAR": appendCigar,
+}
+
+// seqBases are the bases of the 4-bit codes of packed sequences.
+const seqBases = "=ACMGRSVTWYHKDBN"
+
+// appendSeq appends the bases of rec to dst, as sam.Seq.Expand.
+func appendSeq(dst []byte, rec *sam.Record) []byte {
+	for i := 0; i < rec.Seq.Length; i++ {
+		d := rec.Seq.Seq[i>>1]
+		if i&1 == 0 {
+			dst = append(dst, seqBases[d>>4])
+		} else {
+			dst = append(dst, seqBases[d&0xf])
+		}
+	}
+	return dst
+}
+
+// appendCigar appends the CIGAR of rec to dst, as sam.Cigar.String.
+func appendCigar(dst []byte, rec *sam.Record) []byte {
+	if len(rec.Cigar) == 0 {
+		return append(dst, '*')
+	}
+	for _, co := range rec.Cigar {
+		dst = strconv.AppendInt(dst, int64(co.Len()), 10)
+		dst = append(dst, co.Type().String()...)
+	}
+	return dst
+}
+
+// textBufs holds the buffers that fields are decoded into by byte
+// comparisons. A pool keeps the filters safe for concurrent use.
+var textBufs = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
+}
+
+// byteComparison returns a FilterFunc for n if it compares a field with an
+// append function, e.g. SEQ, to a string or a regex literal with =, !=, =~ or
+// !~. The field is decoded into a pooled buffer and compared as bytes, so the
+// filter does not allocate a string for each record, as the placeholder of
+// the field does. It returns false if n is not such a comparison or the
+// field is bound in vars.
+func byteComparison(n *ql.BinaryExpr, vars map[string]interface{}) (FilterFunc, bool) {
+	ref, ok := n.LHS.(*ql.VarRef)
+	if !ok {
+		return nil, false
+	}
+	appendText, ok := appendFuncs[ref.Val]
+	if !ok {
+		return nil, false
+	}
+	if _, ok := vars[ref.Val]; ok {
+		return nil, false
+	}
+
+	var match func([]byte) bool
+	switch rhs := n.RHS.(type) {
+	case *ql.StringLiteral:
+		s := rhs.Val
+		switch n.Op {
+		case ql.EQ:
+			match = func(b []byte) bool { return string(b) == s }
+		case ql.NEQ:
+			match = func(b []byte) bool { return string(b) != s }
+		}
+	case *ql.RegexLiteral:
+		re := rhs.Val
+		switch n.Op {
+		case ql.EQREGEX:
+			match = re.Match
+		case ql.NEQREGEX:
+			match = func(b []byte) bool { return !re.Match(b) }
+		}
+	}
+	if match == nil {
+		return nil, false
+	}
+
+	return func(rec *sam.Record) bool {
+		buf := textBufs.Get().(*[]byte)
+		*buf = appendText((*buf)[:0], rec)
+		ok := match(*buf)
+		textBufs.Put(buf)
+		return ok
+	}, true
+}
+
+// flagComparison returns a FilterFunc for n if it combines only flag tests
+// with AND, e.g. PAIRED AND NOT DUPLICATE AND FLAG & 2304 = 0. The tests
+// are compiled into the bits that must be set and the bits that must be
+// unset, so the filter takes two integer operations for each record, as
+// samtools view -f and -F. It returns false if n is not such a combination or
+// a flag keyword is bound in vars.
+func flagComparison(n *ql.BinaryExpr, vars map[string]interface{}) (FilterFunc, bool) {
+	if n.Op != ql.AND {
+		return nil, false
+	}
+	var include, exclude sam.Flags
+	for _, e := range operands(n, ql.AND) {
+		inc, exc, ok := flagTest(e, vars)
+		if !ok {
+			return nil, false
+		}
+		include |= inc
+		exclude |= exc
+	}
+	return func(rec *sam.Record) bool {
+		return rec.Flags&include == include && rec.Flags&exclude == 0
+	}, true
+}
+
+// flagTest returns the bits that expr requires to be set and unset if it is
+// a flag keyword, e.g. PAIRED, a negated flag keyword, e.g. NOT DUPLICATE, or
+// a comparison of FLAG bits, e.g. FLAG & 3 = 3 or FLAG & 1024 = 0.
+func flagTest(expr ql.Expr, vars map[string]interface{}) (include, exclude sam.Flags, ok bool) {
+	switch e := unparen(expr).(type) {
+	case *ql.VarRef:
+		if _, ok := vars[e.Val]; ok {
+			return 0, 0, false
+		}
+		bit, ok := FlagNames[e.Val]
+		return bit, 0, ok
+	case *ql.NotExpr:
+		if ref, ok := unparen(e.Expr).(*ql.VarRef); ok {
+			if include, _, ok := flagTest(ref, vars); ok {
+				return 0, include, true
+			}
+		}
+	case *ql.BinaryExpr:
+		if e.Op != ql.EQ {
+			break
+		}
+		and, ok := unparen(e.LHS).(*ql.BinaryExpr)
+		if !ok || and.Op != ql.BITWISEAND {
+			break
+		}
+		ref, ok := and.LHS.(*ql.VarRef)
+		if !ok || ref.Val != "FLAG" {
+			break
+		}
+		if _, ok := vars[ref.Val]; ok {
+			break
+		}
+		mask, ok := and.RHS.(*ql.IntegerLiteral)
+		if !ok || mask.Val < 0 || mask.Val > 0xffff {
+			break
+		}
+		val, ok := e.RHS.(*ql.IntegerLiteral)
+		switch {
+		case !ok:
+		case val.Val == mask.Val:
+			return sam.Flags(mask.Val), 0, true
+		case val.Val == 0:
+			return 0, sam.Flags(mask.Val), true
+		}
+	}
+	return 0, 0, false
+}
+
+// unparen returns expr without enclosing parentheses.
+func unparen(expr ql.Expr) ql.Expr {
+	for {
+		p, ok := expr.(*ql.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = p.Expr
+	}
+}
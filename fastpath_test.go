@@ -0,0 +1,192 @@
+package samql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+func TestAppendFuncs(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := NewReader(sr).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		if got, want := string(appendSeq(nil, rec)), string(rec.Seq.Expand()); got != want {
+			t.Errorf("%s: seq=%s want %s", rec.Name, got, want)
+		}
+		if got, want := string(appendCigar([]byte("x"), rec)), "x"+rec.Cigar.String(); got != want {
+			t.Errorf("%s: cigar=%s want %s", rec.Name, got, want)
+		}
+	}
+}
+
+func TestByteComparison(t *testing.T) {
+	for _, tt := range []struct {
+		Query string
+		Names []string
+	}{
+		{Query: "SEQ = 'CAGCGGCAT'", Names: []string{"r001"}},
+		{Query: "SEQ != 'CAGCGGCAT' AND SEQ =~ /^CAGC/", Names: []string{"r006"}},
+		{Query: "SEQ !~ /GATA/", Names: []string{"r003", "r001", "r004", "r005", "r006"}},
+		{Query: "CIGAR = '6M14N5M'", Names: []string{"r003", "r004", "r005"}},
+		{Query: "CIGAR =~ /^[0-9]+S/ OR CIGAR = '*'", Names: []string{"r002", "r006", "r006"}},
+		{Query: "CIGAR != '9M' AND CIGAR !~ /N/", Names: []string{"r001", "r002", "r006", "r006"}},
+	} {
+		sr, err := sam.NewReader(strings.NewReader(samData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewReader(sr)
+		r.AppendFilter(Must(Where(tt.Query)))
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, rec := range records {
+			names = append(names, rec.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.Names, ",") {
+			t.Errorf("%s: names=%v want %v", tt.Query, names, tt.Names)
+		}
+	}
+}
+
+func TestByteComparisonAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool allocates under the race detector")
+	}
+	rec := &sam.Record{
+		Seq:   sam.NewSeq([]byte(strings.Repeat("ACGTTGCA", 32))),
+		Cigar: sam.Cigar{sam.NewCigarOp(sam.CigarSoftClipped, 10), sam.NewCigarOp(sam.CigarMatch, 246)},
+	}
+	for _, query := range []string{
+		"SEQ =~ /TTGCAA/",
+		"SEQ = 'ACGT'",
+		"CIGAR =~ /^[0-9]+S/",
+		"CIGAR != '256M'",
+	} {
+		f := Must(Where(query))
+		f(rec) // Fill the buffer pool.
+		if n := testing.AllocsPerRun(100, func() { f(rec) }); n > 0 {
+			t.Errorf("%s: allocs=%v want 0", query, n)
+		}
+	}
+}
+
+func BenchmarkSeqRegex(b *testing.B) {
+	rec := &sam.Record{Seq: sam.NewSeq([]byte(strings.Repeat("ACGTTGCA", 32)))}
+	f := Must(Where("SEQ =~ /GATTACA/"))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f(rec)
+	}
+}
+
+func TestFlagComparison(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := NewReader(sr).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Query   string
+		Include sam.Flags
+		Exclude sam.Flags
+		Masks   bool
+	}{
+		{Query: "PAIRED AND NOT DUPLICATE", Include: sam.Paired, Exclude: sam.Duplicate, Masks: true},
+		{Query: "PAIRED AND (READ1 AND NOT (UNMAPPED))", Include: sam.Paired | sam.Read1, Exclude: sam.Unmapped, Masks: true},
+		{Query: "FLAG & 3 = 3 AND FLAG & 2304 = 0", Include: 3, Exclude: 2304, Masks: true},
+		{Query: "(FLAG & 1) = 1 AND NOT SECONDARY", Include: sam.Paired, Exclude: sam.Secondary, Masks: true},
+		{Query: "PAIRED AND NOT PAIRED", Include: sam.Paired, Exclude: sam.Paired, Masks: true},
+		{Query: "PAIRED AND MAPQ > 10"},
+		{Query: "PAIRED OR DUPLICATE"},
+		{Query: "FLAG & 3 = 1 AND PAIRED"},
+		{Query: "FLAG & 3 > 0 AND PAIRED"},
+	} {
+		stmt, err := parseWhere(tt.Query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, ok := flagComparison(stmt.Condition.(*ql.BinaryExpr), nil)
+		if ok != tt.Masks {
+			t.Errorf("%s: masks=%v want %v", tt.Query, ok, tt.Masks)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		want, err := Where(tt.Query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range records {
+			wantMatch := rec.Flags&tt.Include == tt.Include && rec.Flags&tt.Exclude == 0
+			if filter(rec) != wantMatch || want(rec) != wantMatch {
+				t.Errorf("%s: %s: match=%v want %v", tt.Query, rec.Name, filter(rec), wantMatch)
+			}
+		}
+	}
+
+	stmt, err := parseWhere("PAIRED AND NOT DUPLICATE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]interface{}{"DUPLICATE": true}
+	if _, ok := flagComparison(stmt.Condition.(*ql.BinaryExpr), vars); ok {
+		t.Errorf("bound keyword: expected no masks")
+	}
+}
+
+func BenchmarkFlagComparison(b *testing.B) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		b.Fatal(err)
+	}
+	records, err := NewReader(sr).ReadAll()
+	if err != nil {
+		b.Fatal(err)
+	}
+	stmt, err := parseWhere("PAIRED AND NOT DUPLICATE AND NOT SECONDARY AND NOT QCFAIL")
+	if err != nil {
+		b.Fatal(err)
+	}
+	keywords := evalVisitor{}
+	for _, e := range operands(stmt.Condition, ql.AND) {
+		ql.Walk(&keywords, e)
+	}
+	b.Run("Keywords", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, rec := range records {
+				for _, n := range keywords.nodes {
+					switch f := n.(type) {
+					case placeholderBool:
+						f(rec)
+					case FilterFunc:
+						f(rec)
+					}
+				}
+			}
+		}
+	})
+	masks, _ := flagComparison(stmt.Condition.(*ql.BinaryExpr), nil)
+	b.Run("Masks", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, rec := range records {
+				masks(rec)
+			}
+		}
+	})
+}
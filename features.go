@@ -0,0 +1,226 @@
+package samql
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+)
+
+// Feature is an annotation of a GTF or GFF file, e.g. a gene or an exon.
+// The region is 0-based and half-open, as BED regions.
+type Feature struct {
+	Region
+	Type string
+	Name string
+}
+
+// FeatureSet is a set of annotations that records are compared to by the
+// overlaps_feature, feature_name and feature_type functions.
+type FeatureSet struct {
+	features []Feature
+	tree     intervalTree
+}
+
+// NewFeatureSet returns a new FeatureSet with features.
+func NewFeatureSet(features []Feature) *FeatureSet {
+	regions := make([]Region, len(features))
+	for i, f := range features {
+		regions[i] = f.Region
+	}
+	return &FeatureSet{features: features, tree: newIntervalTree(regions)}
+}
+
+// WithFeatures sets the annotations that the overlaps_feature, feature_name
+// and feature_type functions compare records to.
+func WithFeatures(fs *FeatureSet) FilterOption {
+	return func(o *filterOptions) { o.features = fs }
+}
+
+// nameAttrs are the attributes that feature names are read from, in order of
+// preference.
+var nameAttrs = []string{"gene_name", "gene_id", "Name", "ID", "transcript_id"}
+
+// ReadGTF reads the features of a GTF or GFF3 file from r. The name of each
+// feature is read from the gene_name, gene_id, Name, ID or transcript_id
+// attribute, in order of preference. Empty lines and comments are skipped.
+func ReadGTF(r io.Reader) ([]Feature, error) {
+	var features []Feature
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("samql: GTF line %d: expected at least 8 columns", n)
+		}
+		start, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("samql: GTF line %d: invalid start: %v", n, err)
+		}
+		end, err := strconv.Atoi(fields[4])
+		if err != nil {
+			return nil, fmt.Errorf("samql: GTF line %d: invalid end: %v", n, err)
+		}
+		if start < 1 || end < start {
+			return nil, fmt.Errorf("samql: GTF line %d: invalid feature %d-%d", n, start, end)
+		}
+		attrs := ""
+		if len(fields) > 8 {
+			attrs = fields[8]
+		}
+		features = append(features, Feature{
+			Region: Region{Rname: fields[0], Start: start - 1, End: end},
+			Type:   fields[2],
+			Name:   featureName(attrs),
+		})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return features, nil
+}
+
+// featureName returns the name of a feature from its GTF attributes, e.g.
+// gene_id "g1"; gene_name "A1BG";, or GFF3 attributes, e.g. ID=g1;Name=A1BG.
+func featureName(attrs string) string {
+	vals := make(map[string]string)
+	for _, attr := range strings.Split(attrs, ";") {
+		attr = strings.TrimSpace(attr)
+		var k, v string
+		if i := strings.IndexAny(attr, " ="); i >= 0 {
+			k, v = attr[:i], strings.TrimSpace(attr[i+1:])
+		}
+		if k != "" {
+			vals[k] = strings.Trim(v, `"`)
+		}
+	}
+	for _, k := range nameAttrs {
+		if v, ok := vals[k]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// ReadGTFFile reads the features of the GTF or GFF3 file at path, which can
+// be gzip compressed.
+func ReadGTFFile(path string) ([]Feature, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		return nil, err
+	}
+	return ReadGTF(r)
+}
+
+// decompress returns a reader of the content of r, which is decompressed if
+// it is gzip, or bgzip, compressed.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// overlapping returns the shortest feature of type typ, or of any type if typ
+// is empty, that overlaps rec. Ties are broken by the order of the features.
+// It returns nil if no feature overlaps rec.
+func (f *FeatureSet) overlapping(rec *sam.Record, typ string) *Feature {
+	if rec.Ref == nil || rec.Pos < 0 {
+		return nil
+	}
+	end := rec.End()
+	if end <= rec.Pos { // Records without a CIGAR occupy one position.
+		end = rec.Pos + 1
+	}
+	best := -1
+	f.tree.each(rec.Ref.Name(), rec.Pos, end, func(id int) bool {
+		ft := &f.features[id]
+		if typ != "" && ft.Type != typ {
+			return true
+		}
+		if best < 0 {
+			best = id
+			return true
+		}
+		b := &f.features[best]
+		if l, bl := ft.End-ft.Start, b.End-b.Start; l < bl || (l == bl && id < best) {
+			best = id
+		}
+		return true
+	})
+	if best < 0 {
+		return nil
+	}
+	return &f.features[best]
+}
+
+// featuresArg returns the features of o for function name or an error if they
+// are not set.
+func featuresArg(name string, o *filterOptions) (*FeatureSet, error) {
+	if o.features == nil {
+		return nil, fmt.Errorf("%s requires features, e.g. --features genes.gtf", name)
+	}
+	return o.features, nil
+}
+
+// overlapsFeature returns a placeholderBool that is true if a record overlaps
+// a feature, e.g. overlaps_feature(), or a feature of a type, e.g.
+// overlaps_feature('exon').
+func overlapsFeature(o *filterOptions, args []interface{}) (interface{}, error) {
+	features, err := featuresArg("overlaps_feature", o)
+	if err != nil {
+		return nil, err
+	}
+	typ := ""
+	switch len(args) {
+	case 0:
+	case 1:
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("overlaps_feature expects a string feature type")
+		}
+		typ = s
+	default:
+		return nil, fmt.Errorf("overlaps_feature expects at most 1 argument, got %d", len(args))
+	}
+	return placeholderBool(func(rec *sam.Record) bool {
+		return features.overlapping(rec, typ) != nil
+	}), nil
+}
+
+// featureAttr returns a function that returns a placeholderStr with an
+// attribute of the shortest feature that overlaps a record, e.g.
+// feature_name(), or an empty string if none does.
+func featureAttr(name string, attr func(*Feature) string) optionFunction {
+	return func(o *filterOptions, args []interface{}) (interface{}, error) {
+		features, err := featuresArg(name, o)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != 0 {
+			return nil, fmt.Errorf("%s expects 0 arguments, got %d", name, len(args))
+		}
+		return placeholderStr(func(rec *sam.Record) string {
+			if f := features.overlapping(rec, ""); f != nil {
+				return attr(f)
+			}
+			return ""
+		}), nil
+	}
+}
@@ -0,0 +1,102 @@
+package samql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+const gtfData = `#!genome-build test
+chr1	test	gene	1	40	.	+	.	gene_id "g1"; gene_name "A1BG";
+chr1	test	exon	5	10	.	+	.	gene_id "g1"; gene_name "A1BG"; exon_number "1";
+chr2	test	gene	30	60	.	-	.	ID=g2;Name=B2M
+`
+
+func TestReadGTF(t *testing.T) {
+	features, err := ReadGTF(strings.NewReader(gtfData))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	want := []Feature{
+		{Region: Region{Rname: "chr1", Start: 0, End: 40}, Type: "gene", Name: "A1BG"},
+		{Region: Region{Rname: "chr1", Start: 4, End: 10}, Type: "exon", Name: "A1BG"},
+		{Region: Region{Rname: "chr2", Start: 29, End: 60}, Type: "gene", Name: "B2M"},
+	}
+	if !reflect.DeepEqual(features, want) {
+		t.Errorf("features=%v want %v", features, want)
+	}
+
+	for _, data := range []string{
+		"chr1\ttest\tgene\t1\t40\n",
+		"chr1\ttest\tgene\ta\t40\t.\t+\t.\n",
+		"chr1\ttest\tgene\t0\t40\t.\t+\t.\n",
+		"chr1\ttest\tgene\t40\t1\t.\t+\t.\n",
+	} {
+		if _, err := ReadGTF(strings.NewReader(data)); err == nil {
+			t.Errorf("%q: expected error", data)
+		}
+	}
+}
+
+func TestFeatureFunctions(t *testing.T) {
+	features, err := ReadGTF(strings.NewReader(gtfData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Where("overlaps_feature()"); err == nil {
+		t.Errorf("expected error without features")
+	}
+	withFeatures := WithFeatures(NewFeatureSet(features))
+	if diags := Validate("feature_name() = 'B2M'", nil, withFeatures); diags != nil {
+		t.Errorf("unexpected diagnostics %v", diags)
+	}
+
+	for _, tt := range []struct {
+		Query string
+		Names []string
+	}{
+		{Query: "overlaps_feature()", Names: []string{"r001", "r002", "r003", "r001", "r004"}},
+		{Query: "overlaps_feature('exon')", Names: []string{"r001", "r002"}},
+		{Query: "overlaps_feature() AND feature_type() = 'exon'", Names: []string{"r001", "r002"}},
+		{Query: "feature_name() = 'B2M'", Names: []string{"r004"}},
+		{Query: "NOT overlaps_feature()", Names: []string{"r005", "r006", "r006"}},
+	} {
+		sr, err := sam.NewReader(strings.NewReader(samData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewReader(sr)
+		r.AppendFilter(Must(Where(tt.Query, withFeatures)))
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, rec := range records {
+			names = append(names, rec.Name)
+		}
+		if !reflect.DeepEqual(names, tt.Names) {
+			t.Errorf("%s: names=%v want %v", tt.Query, names, tt.Names)
+		}
+	}
+
+	r, err := OpenSAM(strings.NewReader(samData), WithWhere("overlaps_feature('exon')"), WithFilterOptions(withFeatures))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := readNames(t, r); !reflect.DeepEqual(names, []string{"r001:chr1", "r002:chr1"}) {
+		t.Errorf("OpenSAM: names=%v", names)
+	}
+
+	q, err := NewQuery("SELECT QNAME, feature_name() FROM aln WHERE RNAME = 'chr2'", withFeatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &sam.Record{Name: "r", Pos: 40}
+	rec.Ref, _ = sam.NewReference("chr2", "", "", 100, nil, nil)
+	if got := q.Values(rec); !reflect.DeepEqual(got, []interface{}{"r", "B2M"}) {
+		t.Errorf("values=%v", got)
+	}
+}
@@ -0,0 +1,147 @@
+package samql
+
+import (
+	"strconv"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// Filter is a compiled SQL WHERE statement. Unlike a bare FilterFunc, it
+// describes the record fields it reads and the regions of indexed files that
+// contain all records it matches. A Filter is immutable and safe for
+// concurrent use, e.g. by the shards of a ReaderPool.
+type Filter struct {
+	match   FilterFunc
+	cond    ql.Expr
+	fields  []string
+	regions []Region
+	ranged  bool
+	limit   int
+}
+
+// CompileError is the error returned by Compile, and Where, for an invalid
+// query. Err is the underlying error, e.g. a *ql.ParseError for syntax errors.
+// Diagnostics are the problems found by Validate, with their positions and
+// suggestions, e.g. MAPQ for MPAQ, if any.
+type CompileError struct {
+	Query       string
+	Err         error
+	Diagnostics []*Diagnostic
+}
+
+// Error returns the message of the underlying error.
+func (e *CompileError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// Compile compiles the SQL WHERE statement query, e.g. "MAPQ > 30 AND
+// NH:i = 1", to a Filter. The WHERE keyword is not part of query. Errors are
+// of type *CompileError. Options configure the functions of query, as for
+// Where.
+func Compile(query string, opts ...FilterOption) (*Filter, error) {
+	return compile(query, withOptions(nil, opts))
+}
+
+// compile is similar to Compile but additionally resolves the variable
+// references in query that match a key in vars to the corresponding value.
+func compile(query string, vars map[string]interface{}) (*Filter, error) {
+	fail := func(err error) (*Filter, error) {
+		// Diagnostics do not report the keywords of inputs as unknown.
+		diagVars := inputVars("")
+		for name, val := range vars {
+			diagVars[name] = val
+		}
+		return nil, &CompileError{Query: query, Err: err, Diagnostics: validate(query, diagVars, nil)}
+	}
+
+	stmt, err := parseWhere(query)
+	if err != nil {
+		return fail(err)
+	}
+	match, err := newFilter(stmt.Condition, vars)
+	if err != nil {
+		return fail(err)
+	}
+
+	f := &Filter{match: withSample(match, stmt.Sample, optionsOf(vars).seed), cond: stmt.Condition}
+	fields, tags, _ := references(stmt.Condition)
+	f.fields = append(sortedKeys(fields), sortedKeys(tags)...)
+	f.regions, f.ranged = condRegions(stmt.Condition)
+	f.limit = stmt.Limit
+	return f, nil
+}
+
+// Match returns true if rec matches f. Match can be used as a FilterFunc,
+// e.g. r.AppendFilter(f.Match).
+func (f *Filter) Match(rec *sam.Record) bool {
+	return f.match(rec)
+}
+
+// Fields returns the record fields and keywords, e.g. MAPQ, followed by the
+// aux tags, e.g. NH:i, that f reads. Both are sorted.
+func (f *Filter) Fields() []string {
+	return f.fields
+}
+
+// RangeHints returns the regions that contain all records that f can match,
+// as QueryRegions. It returns false if f cannot be restricted to regions.
+func (f *Filter) RangeHints() ([]Region, bool) {
+	return f.regions, f.ranged
+}
+
+// Limit returns the maximum number of records to read, as set by the LIMIT
+// clause, e.g. 10 for "MAPQ > 30 LIMIT 10", or 0 for all records. Match does
+// not apply the limit; readers should stop after Limit matching records.
+func (f *Filter) Limit() int {
+	return f.limit
+}
+
+// QueryLimit returns the LIMIT of the WHERE clause query, or 0 if query has
+// no LIMIT or is invalid.
+func QueryLimit(query string) int {
+	stmt, err := parseWhere(query)
+	if err != nil {
+		return 0
+	}
+	return stmt.Limit
+}
+
+// AndWhere returns the WHERE clause query combined with the condition cond
+// using AND. The SAMPLE and LIMIT clauses of query, if any, are kept at the
+// end. An empty query returns cond.
+func AndWhere(query, cond string) (string, error) {
+	c, err := parseWhere(cond)
+	if err != nil {
+		return "", err
+	}
+	if query == "" {
+		return c.Condition.String(), nil
+	}
+	stmt, err := parseWhere(query)
+	if err != nil {
+		return "", err
+	}
+
+	s := parenOR(stmt.Condition).String() + " AND " + parenOR(c.Condition).String()
+	if stmt.Sample > 0 {
+		s += " SAMPLE " + strconv.FormatFloat(stmt.Sample, 'f', -1, 64)
+	}
+	if stmt.Limit > 0 {
+		s += " LIMIT " + strconv.Itoa(stmt.Limit)
+	}
+	return s, nil
+}
+
+// String returns the normalized WHERE condition of f.
+func (f *Filter) String() string {
+	if f.cond == nil {
+		return "TRUE"
+	}
+	return f.cond.String()
+}
@@ -0,0 +1,146 @@
+package samql
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+func TestCompile(t *testing.T) {
+	for _, tt := range []struct {
+		Query   string
+		Fields  []string
+		Regions []Region
+		Ranged  bool
+		Limit   int
+		Names   []string
+	}{
+		{
+			Query:   "RNAME = 'chr1' AND POS > 10 AND NM:i = 1",
+			Fields:  []string{"POS", "RNAME", "NM:i"},
+			Regions: []Region{{Rname: "chr1", Start: 10}},
+			Ranged:  true,
+			Names:   []string{"r001"},
+		},
+		{
+			Query:  "MAPQ < 30 OR has(NM)",
+			Fields: []string{"MAPQ", "NM"},
+			Names:  []string{"r001", "r005", "r006", "r006"},
+		},
+		{
+			Query:   "RNAME = 'chr1' LIMIT 2",
+			Fields:  []string{"RNAME"},
+			Regions: []Region{{Rname: "chr1"}},
+			Ranged:  true,
+			Limit:   2,
+			Names:   []string{"r001", "r002", "r003", "r001"},
+		},
+		{
+			Query:  "length(SEQ) > 20",
+			Fields: []string{"SEQ"},
+			Names:  []string{"r006", "r006"},
+		},
+	} {
+		f, err := Compile(tt.Query)
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Query, err.Error())
+			continue
+		}
+		if fields := f.Fields(); !reflect.DeepEqual(fields, tt.Fields) {
+			t.Errorf("%s: fields=%v want %v", tt.Query, fields, tt.Fields)
+		}
+		if f.Limit() != tt.Limit || QueryLimit(tt.Query) != tt.Limit {
+			t.Errorf("%s: limit=%d want %d", tt.Query, f.Limit(), tt.Limit)
+		}
+		regions, ranged := f.RangeHints()
+		if ranged != tt.Ranged || !reflect.DeepEqual(regions, tt.Regions) {
+			t.Errorf("%s: range hints=%v %t want %v %t", tt.Query, regions, ranged, tt.Regions, tt.Ranged)
+		}
+
+		sr, err := sam.NewReader(strings.NewReader(samData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewReader(sr)
+		r.AppendFilter(f.Match)
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, rec := range records {
+			names = append(names, rec.Name)
+		}
+		if !reflect.DeepEqual(names, tt.Names) {
+			t.Errorf("%s: names=%v want %v", tt.Query, names, tt.Names)
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, tt := range []struct {
+		Query string
+		Parse bool
+		Diag  string
+	}{
+		{Query: "MAPQ >", Parse: true, Diag: "found EOF"},
+		{Query: "MPAQ > 30", Diag: "did you mean MAPQ"},
+		{Query: "QNAME", Diag: "not boolean"},
+	} {
+		_, err := Compile(tt.Query)
+		var cerr *CompileError
+		if !errors.As(err, &cerr) {
+			t.Errorf("%s: error=%v want *CompileError", tt.Query, err)
+			continue
+		}
+		var perr *ql.ParseError
+		if errors.As(err, &perr) != tt.Parse {
+			t.Errorf("%s: parse error=%t want %t", tt.Query, !tt.Parse, tt.Parse)
+		}
+		if len(cerr.Diagnostics) == 0 || !strings.Contains(cerr.Diagnostics[0].Message, tt.Diag) {
+			t.Errorf("%s: diagnostics=%v want %q", tt.Query, cerr.Diagnostics, tt.Diag)
+		}
+
+		// Where returns the same errors.
+		if _, err := Where(tt.Query); !errors.As(err, &cerr) {
+			t.Errorf("%s: Where error=%v want *CompileError", tt.Query, err)
+		}
+	}
+}
+
+func TestAndWhere(t *testing.T) {
+	for _, tt := range []struct {
+		Query string
+		Cond  string
+		Want  string
+	}{
+		{Query: "", Cond: "FLAG & 4 = 0", Want: "FLAG & 4 = 0"},
+		{Query: "MAPQ > 10", Cond: "FLAG & 4 = 0", Want: "MAPQ > 10 AND FLAG & 4 = 0"},
+		{
+			Query: "RNAME = 'chr1' OR RNAME = 'chr2'",
+			Cond:  "PAIRED OR MAPQ >= 30",
+			Want:  "(RNAME = 'chr1' OR RNAME = 'chr2') AND (PAIRED OR MAPQ >= 30)",
+		},
+		{Query: "POS > 10 SAMPLE 0.5 LIMIT 3", Cond: "MAPQ >= 30", Want: "POS > 10 AND MAPQ >= 30 SAMPLE 0.5 LIMIT 3"},
+	} {
+		got, err := AndWhere(tt.Query, tt.Cond)
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Query, err)
+			continue
+		}
+		if got != tt.Want {
+			t.Errorf("%s: where=%s want %s", tt.Query, got, tt.Want)
+		}
+		if _, err := Where(got); err != nil {
+			t.Errorf("%s: invalid where %s: %v", tt.Query, got, err)
+		}
+	}
+
+	if _, err := AndWhere("MAPQ >", "PAIRED"); err == nil {
+		t.Errorf("expected error for invalid query")
+	}
+}
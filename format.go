@@ -0,0 +1,152 @@
+package samql
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Format is the format of a file with SAM records.
+type Format int
+
+// Supported and recognized formats.
+const (
+	UnknownFormat Format = iota
+	SAM
+	BAM
+	CRAM
+)
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case SAM:
+		return "SAM"
+	case BAM:
+		return "BAM"
+	case CRAM:
+		return "CRAM"
+	}
+	return "unknown"
+}
+
+var (
+	// bgzfMagic is the gzip magic with the FEXTRA flag that starts every
+	// BGZF block.
+	bgzfMagic = []byte{0x1f, 0x8b, 0x08, 0x04}
+	gzipMagic = []byte{0x1f, 0x8b}
+	bamMagic  = []byte("BAM\x01")
+	cramMagic = []byte("CRAM")
+)
+
+// DetectFormat detects the format of the data in r by inspecting its magic
+// bytes. It returns the format and a reader that reads the data from the
+// same position r was at. If r is an io.Seeker, r itself is rewound and
+// returned so that it can still be seeked, e.g. for indexed BAM access.
+// Otherwise, a buffered reader that wraps r is returned. SAM data that is
+// compressed with gzip or BGZF, e.g. .sam.gz files, is detected as SAM and
+// the returned reader is a *gzip.Reader that decompresses it.
+func DetectFormat(r io.Reader) (Format, io.Reader, error) {
+	var start int64 = -1
+	if s, ok := r.(io.Seeker); ok {
+		if off, err := s.Seek(0, io.SeekCurrent); err == nil {
+			start = off
+		}
+	}
+
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(bgzfMagic))
+	if err != nil && err != io.EOF {
+		return UnknownFormat, nil, err
+	}
+	f := detect(magic)
+	compressed := false
+	if bytes.HasPrefix(magic, gzipMagic) {
+		f, compressed = detectCompressed(br)
+	}
+
+	var rd io.Reader = br
+	if start >= 0 {
+		if _, err := r.(io.Seeker).Seek(start, io.SeekStart); err != nil {
+			return UnknownFormat, nil, err
+		}
+		rd = r
+	}
+	if compressed {
+		gz, err := gzip.NewReader(rd)
+		if err != nil {
+			return UnknownFormat, nil, err
+		}
+		return f, gz, nil
+	}
+	return f, rd, nil
+}
+
+// detectCompressed returns the format of the gzip compressed data in br and
+// true if the data are compressed SAM. BAM is BGZF compressed and starts with
+// the BAM magic. The data are not consumed.
+func detectCompressed(br *bufio.Reader) (Format, bool) {
+	// The first bytes of the data are decompressed from the buffered
+	// compressed data, which are enough for a header line or a record.
+	data, _ := br.Peek(br.Size())
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return UnknownFormat, false
+	}
+	magic := make([]byte, 64)
+	n, _ := io.ReadFull(gz, magic)
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(data, bgzfMagic) && bytes.HasPrefix(magic, bamMagic):
+		return BAM, false
+	case bytes.HasPrefix(magic, bamMagic):
+		return UnknownFormat, false
+	case detect(magic) == SAM:
+		return SAM, true
+	}
+	return UnknownFormat, false
+}
+
+// detect returns the format that corresponds to the magic bytes.
+func detect(magic []byte) Format {
+	switch {
+	case bytes.HasPrefix(magic, bgzfMagic):
+		return BAM
+	case bytes.HasPrefix(magic, cramMagic):
+		return CRAM
+	case len(magic) == 0: // An empty file is a SAM file without records.
+		return SAM
+	}
+	// SAM files start either with a header line or with the query name of
+	// the first record, both of which are printable text.
+	for _, b := range magic {
+		if b < 0x20 || b > 0x7e {
+			if b != '\t' && b != '\n' && b != '\r' {
+				return UnknownFormat
+			}
+		}
+	}
+	return SAM
+}
+
+// Open opens the SAM or BAM file at path and returns a Reader that reads from
+// it. The file format is detected from the file contents. The path "-"
+// corresponds to STDIN. Names with a registered URL scheme are opened with
+// OpenSource.
+func Open(path string) (*Reader, error) {
+	src, err := OpenSource(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(src), nil
+}
+
+// errFormat returns an error for files with format f that cannot be read.
+func errFormat(name string, f Format) error {
+	if f == CRAM {
+		return fmt.Errorf("samql: %s: CRAM input is not supported", name)
+	}
+	return fmt.Errorf("samql: %s: unknown file format", name)
+}
@@ -0,0 +1,196 @@
+package samql
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/sam"
+)
+
+// bamData returns samData encoded as BAM.
+func bamData(t *testing.T) []byte {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	bw, err := bam.NewWriter(&buf, sr.Header(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		rec, err := sr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bw.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectFormat(t *testing.T) {
+	for _, tt := range []struct {
+		Test   string
+		Data   []byte
+		Format Format
+	}{
+		{Test: "SAM", Data: []byte(samData), Format: SAM},
+		{Test: "Headerless", Data: []byte("r001\t0\tchr1\t7\t30\t8M\t*\t0\t0\t*\t*\n"), Format: SAM},
+		{Test: "Empty", Data: nil, Format: SAM},
+		{Test: "BAM", Data: bamData(t), Format: BAM},
+		{Test: "CRAM", Data: []byte("CRAM\x03\x00"), Format: CRAM},
+		{Test: "Binary", Data: []byte{0x00, 0x01, 0x02, 0x03}, Format: UnknownFormat},
+	} {
+		// A seeker is rewound and returned.
+		rs := bytes.NewReader(tt.Data)
+		f, r, err := DetectFormat(rs)
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			continue
+		}
+		if f != tt.Format {
+			t.Errorf("%s: format=%s want %s", tt.Test, f, tt.Format)
+		}
+		if r != io.Reader(rs) {
+			t.Errorf("%s: expected seeker to be returned", tt.Test)
+		}
+
+		// Other readers are buffered without losing data.
+		f, r, err = DetectFormat(ioutil.NopCloser(bytes.NewReader(tt.Data)))
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			continue
+		}
+		if f != tt.Format {
+			t.Errorf("%s: format=%s want %s", tt.Test, f, tt.Format)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+		if !bytes.Equal(data, tt.Data) {
+			t.Errorf("%s: data changed after detection", tt.Test)
+		}
+	}
+}
+
+func TestDetectCompressed(t *testing.T) {
+	var gz, bg, gzBAM bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(samData))
+	gw.Close()
+	bw := bgzf.NewWriter(&bg, 1)
+	bw.Write([]byte(samData))
+	bw.Close()
+	gw = gzip.NewWriter(&gzBAM)
+	gw.Write([]byte("BAM\x01"))
+	gw.Close()
+
+	for _, tt := range []struct {
+		Test   string
+		Data   []byte
+		Format Format
+	}{
+		{Test: "Gzip", Data: gz.Bytes(), Format: SAM},
+		{Test: "BGZF", Data: bg.Bytes(), Format: SAM},
+		{Test: "GzipBAM", Data: gzBAM.Bytes(), Format: UnknownFormat},
+		{Test: "BAM", Data: bamData(t), Format: BAM},
+	} {
+		for _, r := range []io.Reader{
+			bytes.NewReader(tt.Data),
+			ioutil.NopCloser(bytes.NewReader(tt.Data)),
+		} {
+			f, r, err := DetectFormat(r)
+			if err != nil {
+				t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+				continue
+			}
+			if f != tt.Format {
+				t.Errorf("%s: format=%s want %s", tt.Test, f, tt.Format)
+			}
+			if f != SAM {
+				continue
+			}
+			// Compressed SAM is decompressed.
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			}
+			if string(data) != samData {
+				t.Errorf("%s: data not decompressed", tt.Test)
+			}
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "samql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// File names intentionally do not match the formats.
+	samPath := filepath.Join(dir, "test.bam")
+	if err := ioutil.WriteFile(samPath, []byte(samData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bamPath := filepath.Join(dir, "test.sam")
+	if err := ioutil.WriteFile(bamPath, bamData(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cramPath := filepath.Join(dir, "test.cram")
+	if err := ioutil.WriteFile(cramPath, []byte("CRAM\x03\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Test   string
+		Path   string
+		RecCnt int
+		Err    bool
+	}{
+		{Test: "SAM", Path: samPath, RecCnt: 8},
+		{Test: "BAM", Path: bamPath, RecCnt: 8},
+		{Test: "CRAM", Path: cramPath, Err: true},
+	} {
+		r, err := Open(tt.Path)
+		if tt.Err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.Test)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			continue
+		}
+
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+		if l := len(records); l != tt.RecCnt {
+			t.Errorf("%s: record count=%d want %d", tt.Test, l, tt.RecCnt)
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("%s: unexpected close error %q", tt.Test, err.Error())
+		}
+	}
+}
@@ -0,0 +1,840 @@
+package samql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+)
+
+// function is a record level function that can be called in queries. It
+// receives the evaluated arguments of the call and returns a placeholder.
+type function func(args []interface{}) (interface{}, error)
+
+// placeholderCigar is a function that returns a CIGAR given a sam.Record. It
+// is passed to functions for the CIGAR keyword to avoid formatting and
+// parsing the CIGAR string.
+type placeholderCigar func(*sam.Record) sam.Cigar
+
+// functions associates function names with their implementation.
+var functions = map[string]function{
+	"gc_content": gcContent,
+	"mean_qual":  meanQual,
+	"length":     length,
+	"lower":      lower,
+	"upper":      upper,
+	"pair_name":  pairNameFunc,
+
+	// String predicates.
+	"startswith": strPredicate("startswith", strings.HasPrefix),
+	"endswith":   strPredicate("endswith", strings.HasSuffix),
+	"contains":   strPredicate("contains", strings.Contains),
+	"match_seq":  matchSeq,
+
+	// CIGAR functions.
+	"soft_clipped":     cigarOpLen("soft_clipped", sam.CigarSoftClipped),
+	"hard_clipped":     cigarOpLen("hard_clipped", sam.CigarHardClipped),
+	"clipped_bases":    cigarOpLen("clipped_bases", sam.CigarSoftClipped, sam.CigarHardClipped),
+	"insertions":       cigarOpLen("insertions", sam.CigarInsertion),
+	"deletions":        cigarOpLen("deletions", sam.CigarDeletion),
+	"matches":          cigarOpLen("matches", sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch),
+	"aligned_fraction": alignedFraction,
+
+	// MD tag functions.
+	"mismatch_positions": mismatchPositions,
+	"ref_bases":          refBases,
+	"mismatch_count_md":  mismatchCountMD,
+
+	// SA tag functions.
+	"sa_count":   saCount,
+	"sa_has_ref": saHasRef,
+
+	// Base modification functions of the MM and ML tags.
+	"mod_count":     modCount,
+	"mean_mod_prob": meanModProb,
+	"has_mod":       hasMod,
+
+	// Sequence complexity functions.
+	"entropy":             entropy,
+	"longest_homopolymer": longestHomopolymer,
+	"dust_score":          dustScore,
+
+	// Base quality functions.
+	"min_qual":     qualReduce("min_qual", false),
+	"max_qual":     qualReduce("max_qual", true),
+	"frac_qual_ge": fracQualGE,
+
+	"replace": replace,
+	"window":  window,
+}
+
+// optionFunction is a record level function that depends on the options of
+// the filter, e.g. the features of WithFeatures.
+type optionFunction func(o *filterOptions, args []interface{}) (interface{}, error)
+
+// optionFunctions associates the names of functions that depend on the
+// options of the filter with their implementation.
+var optionFunctions = map[string]optionFunction{
+	// Annotation functions.
+	"overlaps_feature": overlapsFeature,
+	"feature_name":     featureAttr("feature_name", func(f *Feature) string { return f.Name }),
+	"feature_type":     featureAttr("feature_type", func(f *Feature) string { return f.Type }),
+
+	// Variant site functions.
+	"overlaps_site":  overlapsSite,
+	"allele_at_site": alleleAtSite,
+
+	"rand": random,
+}
+
+// arrayFunctions associates the names of functions of array tags, e.g.
+// sum(ZC:B), with their implementation. Their names are shared with the
+// aggregate functions.
+var arrayFunctions = map[string]func(placeholderArray) interface{}{
+	"len": func(arr placeholderArray) interface{} {
+		return placeholderInt(func(rec *sam.Record) int { return len(arr(rec)) })
+	},
+	"sum":  arrayReduce(sumValues),
+	"mean": arrayReduce(func(vals []float64) float64 { return sumValues(vals) / float64(len(vals)) }),
+	"min":  arrayReduce(func(vals []float64) float64 { return extremeValue(vals, true) }),
+	"max":  arrayReduce(func(vals []float64) float64 { return extremeValue(vals, false) }),
+}
+
+// arrayReduce returns an array function that returns a placeholderFloat with
+// the value of fn for the values of an array. The value is zero for missing
+// or empty arrays.
+func arrayReduce(fn func([]float64) float64) func(placeholderArray) interface{} {
+	return func(arr placeholderArray) interface{} {
+		return placeholderFloat(func(rec *sam.Record) float32 {
+			vals := arr(rec)
+			if len(vals) == 0 {
+				return 0
+			}
+			return float32(fn(vals))
+		})
+	}
+}
+
+// sumValues returns the sum of vals.
+func sumValues(vals []float64) float64 {
+	sum := 0.0
+	for _, v := range vals {
+		sum += v
+	}
+	return sum
+}
+
+// extremeValue returns the minimum, if less is true, or maximum of the
+// non-empty vals.
+func extremeValue(vals []float64, less bool) float64 {
+	ext := vals[0]
+	for _, v := range vals[1:] {
+		if (less && v < ext) || (!less && v > ext) {
+			ext = v
+		}
+	}
+	return ext
+}
+
+// isFunction returns true if name is a function that can be called in
+// queries, other than has and the aggregates.
+func isFunction(name string) bool {
+	_, ok := functions[name]
+	_, ook := optionFunctions[name]
+	_, aok := arrayFunctions[name]
+	return ok || ook || aok
+}
+
+// evalCall evaluates the function name with the evaluated arguments args and
+// the filter options o.
+func evalCall(name string, args []interface{}, o *filterOptions) (interface{}, error) {
+	if len(args) == 1 {
+		if arr, ok := args[0].(placeholderArray); ok {
+			fn, ok := arrayFunctions[name]
+			if !ok {
+				return nil, fmt.Errorf("%s does not accept an array argument", name)
+			}
+			return fn(arr), nil
+		}
+	}
+	if _, ok := aggregates[name]; ok {
+		return nil, fmt.Errorf("aggregate function %s is not allowed here", name)
+	}
+	if fn, ok := optionFunctions[name]; ok {
+		return fn(o, args)
+	}
+	fn, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	return fn(args)
+}
+
+// gcContent returns a placeholderFloat with the fraction of G and C bases in
+// a sequence, e.g. gc_content(SEQ). It is zero for empty sequences.
+func gcContent(args []interface{}) (interface{}, error) {
+	seq, err := strArg("gc_content", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderFloat(func(rec *sam.Record) float32 {
+		s := seq(rec)
+		if len(s) == 0 {
+			return 0
+		}
+		gc := 0
+		for i := 0; i < len(s); i++ {
+			switch s[i] {
+			case 'G', 'C', 'g', 'c', 'S', 's':
+				gc++
+			}
+		}
+		return float32(gc) / float32(len(s))
+	}), nil
+}
+
+// entropy returns a placeholderFloat with the Shannon entropy, in bits, of the
+// base composition of a sequence, e.g. entropy(SEQ) < 1.5. It is 2 for equal
+// amounts of the four bases and zero for homopolymers or empty sequences.
+func entropy(args []interface{}) (interface{}, error) {
+	seq, err := strArg("entropy", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderFloat(func(rec *sam.Record) float32 {
+		s := seq(rec)
+		if len(s) == 0 {
+			return 0
+		}
+		var counts [256]int
+		for i := 0; i < len(s); i++ {
+			counts[upperBase(s[i])]++
+		}
+		e := 0.0
+		for _, c := range counts {
+			if c > 0 {
+				p := float64(c) / float64(len(s))
+				e -= p * math.Log2(p)
+			}
+		}
+		return float32(e)
+	}), nil
+}
+
+// longestHomopolymer returns a placeholderInt with the length of the longest
+// run of a single base in a sequence, e.g. longest_homopolymer(SEQ) < 10.
+func longestHomopolymer(args []interface{}) (interface{}, error) {
+	seq, err := strArg("longest_homopolymer", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderInt(func(rec *sam.Record) int {
+		s := seq(rec)
+		longest, run := 0, 0
+		for i := 0; i < len(s); i++ {
+			if i > 0 && upperBase(s[i]) == upperBase(s[i-1]) {
+				run++
+			} else {
+				run = 1
+			}
+			if run > longest {
+				longest = run
+			}
+		}
+		return longest
+	}), nil
+}
+
+// dustScore returns a placeholderFloat with the DUST score of a sequence,
+// e.g. dust_score(SEQ) > 7 for low complexity reads. The score is the sum of
+// c*(c-1)/2 over the counts c of the triplets of the sequence divided by the
+// number of triplets minus one. It is 31 for a homopolymer of 64 bases and
+// zero for sequences shorter than 4 bases.
+func dustScore(args []interface{}) (interface{}, error) {
+	seq, err := strArg("dust_score", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderFloat(func(rec *sam.Record) float32 {
+		s := seq(rec)
+		if len(s) < 4 {
+			return 0
+		}
+		counts := make(map[[3]byte]int)
+		for i := 0; i+3 <= len(s); i++ {
+			counts[[3]byte{upperBase(s[i]), upperBase(s[i+1]), upperBase(s[i+2])}]++
+		}
+		sum := 0
+		for _, c := range counts {
+			sum += c * (c - 1) / 2
+		}
+		return float32(sum) / float32(len(s)-3)
+	}), nil
+}
+
+// upperBase returns the base b in upper case.
+func upperBase(b byte) byte {
+	if b >= 'a' && b <= 'z' {
+		return b - 'a' + 'A'
+	}
+	return b
+}
+
+// meanQual returns a placeholderFloat with the mean Phred quality of a quality
+// string as stored in QUAL, e.g. mean_qual(QUAL). It is zero for missing or
+// empty qualities.
+func meanQual(args []interface{}) (interface{}, error) {
+	qual, err := strArg("mean_qual", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderFloat(func(rec *sam.Record) float32 {
+		q := qual(rec)
+		// Missing qualities are stored as 0xff.
+		if len(q) == 0 || q[0] == 0xff {
+			return 0
+		}
+		sum := 0
+		for i := 0; i < len(q); i++ {
+			sum += int(q[i])
+		}
+		return float32(sum) / float32(len(q))
+	}), nil
+}
+
+// qualArg returns the quality string used by function name. Without
+// arguments the record QUAL is used.
+func qualArg(name string, args []interface{}) (placeholderStr, error) {
+	if len(args) == 0 {
+		return getPlaceholder["QUAL"].(placeholderStr), nil
+	}
+	return strArg(name, args)
+}
+
+// qualReduce returns a function that returns a placeholderInt with the
+// minimum, or if max is true the maximum, Phred quality of a quality string,
+// e.g. min_qual() or max_qual(QUAL). It is zero for missing or empty
+// qualities.
+func qualReduce(name string, max bool) function {
+	return func(args []interface{}) (interface{}, error) {
+		qual, err := qualArg(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return placeholderInt(func(rec *sam.Record) int {
+			q := qual(rec)
+			if len(q) == 0 || q[0] == 0xff {
+				return 0
+			}
+			ext := q[0]
+			for i := 1; i < len(q); i++ {
+				if (max && q[i] > ext) || (!max && q[i] < ext) {
+					ext = q[i]
+				}
+			}
+			return int(ext)
+		}), nil
+	}
+}
+
+// fracQualGE returns a placeholderFloat with the fraction of the bases of a
+// quality string with Phred quality at least a threshold, e.g.
+// frac_qual_ge(20) >= 0.9 or frac_qual_ge(QUAL, 20). It is zero for missing
+// or empty qualities.
+func fracQualGE(args []interface{}) (interface{}, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, fmt.Errorf("frac_qual_ge expects 1 or 2 arguments, got %d", len(args))
+	}
+	minQual, ok := args[len(args)-1].(int64)
+	if !ok {
+		return nil, fmt.Errorf("frac_qual_ge expects an integer quality, got %v", args[len(args)-1])
+	}
+	qual, err := qualArg("frac_qual_ge", args[:len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+	return placeholderFloat(func(rec *sam.Record) float32 {
+		q := qual(rec)
+		if len(q) == 0 || q[0] == 0xff {
+			return 0
+		}
+		n := 0
+		for i := 0; i < len(q); i++ {
+			if int64(q[i]) >= minQual {
+				n++
+			}
+		}
+		return float32(n) / float32(len(q))
+	}), nil
+}
+
+// length returns a placeholderInt with the length of a string, e.g.
+// length(SEQ).
+func length(args []interface{}) (interface{}, error) {
+	s, err := strArg("length", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderInt(func(rec *sam.Record) int {
+		return len(s(rec))
+	}), nil
+}
+
+// lower returns a placeholderStr with a string in lower case, e.g.
+// lower(QNAME).
+func lower(args []interface{}) (interface{}, error) {
+	s, err := strArg("lower", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderStr(func(rec *sam.Record) string {
+		return strings.ToLower(s(rec))
+	}), nil
+}
+
+// pairNameFunc returns a placeholderStr with a read name without a mate
+// suffix, e.g. pair_name(QNAME).
+func pairNameFunc(args []interface{}) (interface{}, error) {
+	s, err := strArg("pair_name", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderStr(func(rec *sam.Record) string {
+		return pairName(s(rec))
+	}), nil
+}
+
+// pairName returns name without a trailing /1 or /2 that marks the mate of a
+// read pair, e.g. in FASTQ headers, so that both mates have the same name.
+func pairName(name string) string {
+	if n := len(name); n > 2 && name[n-2] == '/' && (name[n-1] == '1' || name[n-1] == '2') {
+		return name[:n-2]
+	}
+	return name
+}
+
+// upper returns a placeholderStr with a string in upper case, e.g.
+// upper(RNAME).
+func upper(args []interface{}) (interface{}, error) {
+	s, err := strArg("upper", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderStr(func(rec *sam.Record) string {
+		return strings.ToUpper(s(rec))
+	}), nil
+}
+
+// strPredicate returns a function that returns a placeholderBool with the
+// value of fn for two strings, e.g. startswith(QNAME, 'r00') or
+// contains(SEQ, 'GGGG'). It is faster than the equivalent regex.
+func strPredicate(name string, fn func(s, substr string) bool) function {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s expects 2 arguments, got %d", name, len(args))
+		}
+		s, err := strArg(name, args[:1])
+		if err != nil {
+			return nil, err
+		}
+		if sub, ok := args[1].(string); ok {
+			return placeholderBool(func(rec *sam.Record) bool {
+				return fn(s(rec), sub)
+			}), nil
+		}
+		sub, err := strArg(name, args[1:])
+		if err != nil {
+			return nil, err
+		}
+		return placeholderBool(func(rec *sam.Record) bool {
+			return fn(s(rec), sub(rec))
+		}), nil
+	}
+}
+
+// matchSeq returns a placeholderBool that is true if a sequence contains a
+// pattern with at most a number of mismatches, e.g. match_seq('AGATCGGAAG', 1)
+// for SEQ or match_seq(SEQ, 'AGATCGGAAG', 1). N in the pattern matches any
+// base. The search uses the bitap algorithm, so patterns are limited to 64
+// bases.
+func matchSeq(args []interface{}) (interface{}, error) {
+	if len(args) == 2 {
+		args = append([]interface{}{getPlaceholder["SEQ"]}, args...)
+	}
+	if len(args) != 3 {
+		return nil, fmt.Errorf("match_seq expects 2 or 3 arguments, got %d", len(args))
+	}
+	seq, err := strArg("match_seq", args[:1])
+	if err != nil {
+		return nil, err
+	}
+	pattern, ok := args[1].(string)
+	if !ok || len(pattern) == 0 || len(pattern) > 64 {
+		return nil, fmt.Errorf("match_seq expects a pattern of 1 to 64 bases, got %v", args[1])
+	}
+	k, ok := args[2].(int64)
+	if !ok || k < 0 {
+		return nil, fmt.Errorf("match_seq expects a non-negative number of mismatches, got %v", args[2])
+	}
+
+	// The mask of each base has the bits of the pattern positions it
+	// matches.
+	var masks [256]uint64
+	for i := 0; i < len(pattern); i++ {
+		b := upperBase(pattern[i])
+		if b == 'N' {
+			for c := range masks {
+				masks[c] |= 1 << uint(i)
+			}
+			continue
+		}
+		masks[b] |= 1 << uint(i)
+		if b >= 'A' && b <= 'Z' {
+			masks[b-'A'+'a'] |= 1 << uint(i)
+		}
+	}
+	found := uint64(1) << uint(len(pattern)-1)
+	return placeholderBool(func(rec *sam.Record) bool {
+		// Bit i of r[d] is set if the pattern prefix of length i+1 ends at
+		// the current base with at most d mismatches.
+		s := seq(rec)
+		r := make([]uint64, k+1)
+		for i := 0; i < len(s); i++ {
+			mask := masks[s[i]]
+			prev := r[0]
+			r[0] = (r[0]<<1 | 1) & mask
+			for d := 1; d < len(r); d++ {
+				cur := r[d]
+				r[d] = (r[d]<<1|1)&mask | (prev<<1 | 1)
+				prev = cur
+			}
+			if r[k]&found != 0 {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// random returns a placeholderFloat with a pseudo-random number in [0, 1),
+// e.g. rand() < 0.01. The number is a hash of the record and the seed of o,
+// set by WithSeed, so that results are reproducible and do not depend on the
+// order in which records are evaluated. All calls return the same number for
+// a record.
+func random(o *filterOptions, args []interface{}) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("rand expects 0 arguments, got %d", len(args))
+	}
+	var seed [8]byte
+	binary.LittleEndian.PutUint64(seed[:], uint64(o.seed))
+	return placeholderFloat(func(rec *sam.Record) float32 {
+		var b [12]byte
+		binary.LittleEndian.PutUint32(b[0:], uint32(rec.Flags))
+		binary.LittleEndian.PutUint32(b[4:], uint32(rec.Ref.ID()))
+		binary.LittleEndian.PutUint32(b[8:], uint32(rec.Pos))
+		h := fnv.New64a()
+		_, _ = h.Write(seed[:])
+		_, _ = h.Write([]byte(rec.Name))
+		_, _ = h.Write(b[:])
+		// The top 24 bits fit exactly in the float32 mantissa.
+		return float32(mix64(h.Sum64())>>40) / (1 << 24)
+	}), nil
+}
+
+// replace returns a placeholderStr with the matches of a regular expression
+// in a string replaced, e.g. replace(QNAME, '/[12]$', ”). The pattern and the
+// replacement must be strings; the replacement can refer to submatches, e.g.
+// $1, as in regexp.Regexp.ReplaceAllString.
+func replace(args []interface{}) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("replace expects 3 arguments, got %d", len(args))
+	}
+	s, err := strArg("replace", args[:1])
+	if err != nil {
+		return nil, err
+	}
+	pattern, ok := args[1].(string)
+	repl, ok2 := args[2].(string)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("replace expects a string pattern and replacement")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("replace: %v", err)
+	}
+	return placeholderStr(func(rec *sam.Record) string {
+		return re.ReplaceAllString(s(rec), repl)
+	}), nil
+}
+
+// window returns a placeholderInt with the start of the window of a fixed
+// size that contains a position, e.g. window(POS, 10000) is 20000 for
+// positions 20000 to 29999. Windows start at 0, so that grouping by RNAME and
+// window gives binned counts, e.g. for coverage tracks.
+func window(args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("window expects 2 arguments, got %d", len(args))
+	}
+	pos, ok := args[0].(placeholderInt)
+	if !ok {
+		return nil, fmt.Errorf("window expects an integer position, got %T", args[0])
+	}
+	size, ok := args[1].(int64)
+	if !ok || size <= 0 {
+		return nil, fmt.Errorf("window expects a positive integer size, got %v", args[1])
+	}
+	n := int(size)
+	return placeholderInt(func(rec *sam.Record) int {
+		p := pos(rec)
+		if p < 0 {
+			// Unmapped records are in the window before 0.
+			return (p - n + 1) / n * n
+		}
+		return p / n * n
+	}), nil
+}
+
+// strArg returns the single string argument of function name as a
+// placeholderStr.
+func strArg(name string, args []interface{}) (placeholderStr, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s expects 1 argument, got %d", name, len(args))
+	}
+	switch a := args[0].(type) {
+	case placeholderStr:
+		return a, nil
+	case placeholderCigar:
+		return func(rec *sam.Record) string { return a(rec).String() }, nil
+	case string:
+		return func(*sam.Record) string { return a }, nil
+	}
+	return nil, fmt.Errorf("%s expects a string argument", name)
+}
+
+// cigarOpLen returns a function that returns a placeholderInt with the total
+// length of the CIGAR operations of the provided types, e.g. deletions(CIGAR).
+func cigarOpLen(name string, types ...sam.CigarOpType) function {
+	return func(args []interface{}) (interface{}, error) {
+		cigar, err := cigarArg(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return placeholderInt(func(rec *sam.Record) int {
+			n := 0
+			for _, co := range cigar(rec) {
+				for _, t := range types {
+					if co.Type() == t {
+						n += co.Len()
+					}
+				}
+			}
+			return n
+		}), nil
+	}
+}
+
+// alignedFraction returns a placeholderFloat with the fraction of the query
+// bases that are aligned, i.e. M, = and X, e.g. aligned_fraction(). It is
+// zero for records without a CIGAR.
+func alignedFraction(args []interface{}) (interface{}, error) {
+	cigar, err := cigarArg("aligned_fraction", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderFloat(func(rec *sam.Record) float32 {
+		aligned, query := 0, 0
+		for _, co := range cigar(rec) {
+			switch co.Type() {
+			case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch:
+				aligned += co.Len()
+			}
+			query += co.Len() * co.Type().Consumes().Query
+		}
+		if query == 0 {
+			return 0
+		}
+		return float32(aligned) / float32(query)
+	}), nil
+}
+
+// cigarArg returns a function that returns the CIGAR used by function name.
+// Without arguments or with the CIGAR keyword the record CIGAR is used. Any
+// other string argument, e.g. a tag with the original CIGAR, is parsed as a
+// CIGAR and invalid strings are treated as empty CIGARs.
+func cigarArg(name string, args []interface{}) (func(*sam.Record) sam.Cigar, error) {
+	if len(args) == 0 {
+		return func(rec *sam.Record) sam.Cigar { return rec.Cigar }, nil
+	}
+	if c, ok := args[0].(placeholderCigar); ok && len(args) == 1 {
+		return c, nil
+	}
+	s, err := strArg(name, args)
+	if err != nil {
+		return nil, err
+	}
+	return func(rec *sam.Record) sam.Cigar {
+		c, _ := sam.ParseCigar([]byte(s(rec)))
+		return c
+	}, nil
+}
+
+// saTag returns the value of the SA tag of a record or an empty string if it
+// is missing.
+var saTag = getPlaceholderTag("SA:Z").(placeholderStr)
+
+// saRefs returns the reference names of the alignments listed in the SA tag
+// sa, e.g. "chr2,100,+,10M5S,60,0;".
+func saRefs(sa string) []string {
+	var refs []string
+	for _, aln := range strings.Split(sa, ";") {
+		if aln == "" {
+			continue
+		}
+		if i := strings.IndexByte(aln, ','); i >= 0 {
+			aln = aln[:i]
+		}
+		refs = append(refs, aln)
+	}
+	return refs
+}
+
+// saCount returns a placeholderInt with the number of alignments listed in
+// the SA tag of the record, e.g. sa_count() > 0 for chimeric reads, or in a
+// string argument.
+func saCount(args []interface{}) (interface{}, error) {
+	sa := saTag
+	if len(args) > 0 {
+		var err error
+		if sa, err = strArg("sa_count", args); err != nil {
+			return nil, err
+		}
+	}
+	return placeholderInt(func(rec *sam.Record) int {
+		return len(saRefs(sa(rec)))
+	}), nil
+}
+
+// saHasRef returns a placeholderBool that is true if an alignment listed in
+// the SA tag of the record is on a reference, e.g. sa_has_ref('chr2') or
+// sa_has_ref(RNAME) for reads with supplementary alignments on the same
+// reference.
+func saHasRef(args []interface{}) (interface{}, error) {
+	ref, err := strArg("sa_has_ref", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderBool(func(rec *sam.Record) bool {
+		name := ref(rec)
+		for _, r := range saRefs(saTag(rec)) {
+			if r == name {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// mdTag returns the value of the MD tag of a record or an empty string if it
+// is missing.
+var mdTag = getPlaceholderTag("MD:Z").(placeholderStr)
+
+// mdArg returns the MD tag used by function name. Without arguments the MD
+// tag of the record is used. Any other string argument, e.g. a tag with the
+// original MD, is parsed as an MD tag.
+func mdArg(name string, args []interface{}) (placeholderStr, error) {
+	if len(args) == 0 {
+		return mdTag, nil
+	}
+	return strArg(name, args)
+}
+
+// mdMismatch is a mismatch in an MD tag.
+type mdMismatch struct {
+	pos  int  // Offset on the reference from the alignment start.
+	base byte // Reference base.
+}
+
+// parseMD returns the mismatches of the MD tag md. Deleted reference bases,
+// e.g. ^AC, are skipped. Parsing stops at the first invalid character.
+func parseMD(md string) []mdMismatch {
+	var mms []mdMismatch
+	pos := 0
+	for i := 0; i < len(md); {
+		switch c := md[i]; {
+		case c >= '0' && c <= '9':
+			n := 0
+			for ; i < len(md) && md[i] >= '0' && md[i] <= '9'; i++ {
+				n = n*10 + int(md[i]-'0')
+			}
+			pos += n
+		case c == '^':
+			for i++; i < len(md) && isMDBase(md[i]); i++ {
+				pos++
+			}
+		case isMDBase(c):
+			mms = append(mms, mdMismatch{pos: pos, base: c})
+			pos++
+			i++
+		default:
+			return mms
+		}
+	}
+	return mms
+}
+
+// isMDBase returns true if c is a reference base in an MD tag.
+func isMDBase(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+// mismatchPositions returns a placeholderArray with the offsets of the
+// mismatches in an MD tag from the alignment start on the reference, e.g.
+// min(mismatch_positions()) >= 10. The array is empty if there are no
+// mismatches.
+func mismatchPositions(args []interface{}) (interface{}, error) {
+	md, err := mdArg("mismatch_positions", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderArray(func(rec *sam.Record) []float64 {
+		mms := parseMD(md(rec))
+		pos := make([]float64, len(mms))
+		for i, mm := range mms {
+			pos[i] = float64(mm.pos)
+		}
+		return pos
+	}), nil
+}
+
+// refBases returns a placeholderStr with the reference bases of the
+// mismatches in an MD tag, in alignment order, e.g. ref_bases() = 'G'.
+func refBases(args []interface{}) (interface{}, error) {
+	md, err := mdArg("ref_bases", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderStr(func(rec *sam.Record) string {
+		mms := parseMD(md(rec))
+		b := make([]byte, len(mms))
+		for i, mm := range mms {
+			b[i] = mm.base
+		}
+		return string(b)
+	}), nil
+}
+
+// mismatchCountMD returns a placeholderInt with the number of mismatches in
+// an MD tag. Unlike MISMATCHES, it does not depend on the NM tag.
+func mismatchCountMD(args []interface{}) (interface{}, error) {
+	md, err := mdArg("mismatch_count_md", args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderInt(func(rec *sam.Record) int {
+		return len(parseMD(md(rec)))
+	}), nil
+}
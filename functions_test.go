@@ -0,0 +1,242 @@
+package samql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+func TestFunctions(t *testing.T) {
+	const data = "@SQ\tSN:chr1\tLN:45\n" +
+		"r001\t0\tchr1\t1\t30\t2S3M1I2D1M2H\t*\t0\t0\tACGTGGA\tII#I+5I\tOC:Z:3M2S\tMD:Z:1A1^GT0C\t" +
+		"SA:Z:chr2,100,+,10M5S,60,0;chrX,5,-,5S10M,20,1;\n"
+	sr, err := sam.NewReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := sr.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Expr string
+		Want interface{}
+	}{
+		{Expr: "gc_content(SEQ)", Want: float32(4) / 7},
+		{Expr: "gc_content('')", Want: float32(0)},
+		{Expr: "mean_qual(QUAL)", Want: float32(40+40+2+40+10+20+40) / 7},
+		{Expr: "length(SEQ)", Want: 7},
+		{Expr: "length(QNAME) + 1", Want: 5},
+		{Expr: "length(CIGAR)", Want: 12},
+		{Expr: "soft_clipped()", Want: 2},
+		{Expr: "soft_clipped(CIGAR)", Want: 2},
+		{Expr: "hard_clipped(CIGAR)", Want: 2},
+		{Expr: "clipped_bases(CIGAR)", Want: 4},
+		{Expr: "insertions(CIGAR)", Want: 1},
+		{Expr: "deletions(CIGAR)", Want: 2},
+		{Expr: "matches(CIGAR)", Want: 4},
+		{Expr: "aligned_fraction()", Want: float32(4) / 7},
+		{Expr: "matches(OC:Z)", Want: 3},
+		{Expr: "soft_clipped(OC:Z)", Want: 2},
+		{Expr: "replace(QNAME, '^r0*', 'read')", Want: "read1"},
+		{Expr: "replace(CIGAR, '([0-9]+)S', '${1}s')", Want: "2s3M1I2D1M2H"},
+		{Expr: "startswith(QNAME, 'r00')", Want: true},
+		{Expr: "startswith(QNAME, 'R00')", Want: false},
+		{Expr: "endswith(SEQ, 'GGA')", Want: true},
+		{Expr: "contains(SEQ, 'GTGG')", Want: true},
+		{Expr: "contains(SEQ, 'TTT')", Want: false},
+		{Expr: "contains(OC:Z, 'M')", Want: true},
+		{Expr: "contains(CIGAR, OC:Z)", Want: false},
+		{Expr: "endswith(CIGAR, '2H')", Want: true},
+		{Expr: "window(POS, 10)", Want: 0},
+		{Expr: "window(END, 5)", Want: 5},
+		{Expr: "window(POS + 10, 4)", Want: 8},
+		{Expr: "entropy('AAAA')", Want: float32(0)},
+		{Expr: "entropy('ACGTacgt')", Want: float32(2)},
+		{Expr: "entropy('AACG')", Want: float32(1.5)},
+		{Expr: "longest_homopolymer(SEQ)", Want: 2},
+		{Expr: "longest_homopolymer('ACaaAT')", Want: 3},
+		{Expr: "longest_homopolymer('')", Want: 0},
+		{Expr: "dust_score('AAAAAAAA')", Want: float32(3)},
+		{Expr: "dust_score('ACGTACGA')", Want: float32(0.2)},
+		{Expr: "dust_score('ACG')", Want: float32(0)},
+		{Expr: "match_seq('GTGG', 0)", Want: true},
+		{Expr: "match_seq('GTCG', 0)", Want: false},
+		{Expr: "match_seq('GTCG', 1)", Want: true},
+		{Expr: "match_seq('GTCC', 1)", Want: false},
+		{Expr: "match_seq('acgnggA', 0)", Want: true},
+		{Expr: "match_seq('ACCTGGT', 2)", Want: true},
+		{Expr: "match_seq('TTACG', 2)", Want: false},
+		{Expr: "match_seq(QNAME, 'r0x1', 1)", Want: true},
+		{Expr: "sa_count()", Want: 2},
+		{Expr: "sa_count('chr3,1,+,5M,60,0;')", Want: 1},
+		{Expr: "sa_count('')", Want: 0},
+		{Expr: "sa_has_ref('chr2')", Want: true},
+		{Expr: "sa_has_ref('chr1')", Want: false},
+		{Expr: "sa_has_ref(RNAME)", Want: false},
+		{Expr: "min_qual()", Want: 2},
+		{Expr: "max_qual(QUAL)", Want: 40},
+		{Expr: "frac_qual_ge(20)", Want: float32(5) / 7},
+		{Expr: "frac_qual_ge(QUAL, 40)", Want: float32(4) / 7},
+		{Expr: "mismatch_count_md()", Want: 2},
+		{Expr: "mismatch_count_md('10A5T0')", Want: 2},
+		{Expr: "mismatch_count_md('12')", Want: 0},
+		{Expr: "len(mismatch_positions())", Want: 2},
+		{Expr: "min(mismatch_positions())", Want: float32(1)},
+		{Expr: "max(mismatch_positions())", Want: float32(5)},
+		{Expr: "ref_bases()", Want: "AC"},
+		{Expr: "ref_bases('3^T2g')", Want: "g"},
+	} {
+		expr, err := ql.NewParserFromStr(tt.Expr).ParseExpr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn, err := newValueFunc(expr, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Expr, err.Error())
+			continue
+		}
+		if got := fn(rec); got != tt.Want {
+			t.Errorf("%s: got %v want %v", tt.Expr, got, tt.Want)
+		}
+	}
+}
+
+func TestRand(t *testing.T) {
+	f := Must(Where("rand() < 0.25"))
+	cnt := 0
+	for i := 0; i < 10000; i++ {
+		rec := &sam.Record{Name: fmt.Sprintf("read%d", i), Pos: i}
+		if f(rec) {
+			cnt++
+		}
+		// The same record always gives the same result.
+		if f(rec) != f(&sam.Record{Name: rec.Name, Pos: rec.Pos}) {
+			t.Errorf("rand differs for %s", rec.Name)
+		}
+	}
+	if cnt < 2250 || cnt > 2750 {
+		t.Errorf("count=%d want about 2500", cnt)
+	}
+
+	// Filters with different seeds, created in any order, select different
+	// records and the same seed selects the same records.
+	a, b := Must(Where("rand() < 0.5", WithSeed(1))), Must(Where("rand() < 0.5", WithSeed(2)))
+	c := Must(Where("rand() < 0.5", WithSeed(1)))
+	same := 0
+	for i := 0; i < 100; i++ {
+		rec := &sam.Record{Name: fmt.Sprintf("read%d", i), Pos: i}
+		if a(rec) == b(rec) {
+			same++
+		}
+		if a(rec) != c(rec) {
+			t.Errorf("seed 1 differs for %s", rec.Name)
+		}
+	}
+	if same == 100 {
+		t.Errorf("seeds 1 and 2 selected the same records")
+	}
+
+	if _, err := Where("rand(1) < 0.5"); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestStrPredicateInvalid(t *testing.T) {
+	for _, query := range []string{
+		"startswith(QNAME)",
+		"contains(SEQ, 'A', 'C')",
+		"endswith(MAPQ, '0')",
+		"contains(SEQ, 1.5)",
+	} {
+		if _, err := Where(query); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
+func TestMatchSeqInvalid(t *testing.T) {
+	for _, query := range []string{
+		"match_seq('ACGT')",
+		"match_seq('', 1)",
+		"match_seq('ACGT', -1)",
+		"match_seq('ACGT', 1.5)",
+		"match_seq(SEQ, QNAME, 1)",
+		"match_seq('" + strings.Repeat("A", 65) + "', 1)",
+	} {
+		if _, err := Where(query); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
+func TestWindowInvalid(t *testing.T) {
+	for _, query := range []string{
+		"window(POS) > 0",
+		"window(POS, 0) > 0",
+		"window(QNAME, 10) > 0",
+		"window(POS, MAPQ) > 0",
+	} {
+		if _, err := Where(query); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
+func TestArrayTags(t *testing.T) {
+	const data = "@SQ\tSN:chr1\tLN:45\n" +
+		"r001\t0\tchr1\t1\t30\t3M\t*\t0\t0\tACG\t*\tZC:B:c,3,-1,7\tZF:B:f,0.5,1.5\n"
+	sr, err := sam.NewReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := sr.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Expr string
+		Want interface{}
+	}{
+		{Expr: "len(ZC:B)", Want: 3},
+		{Expr: "sum(ZC:B)", Want: float32(9)},
+		{Expr: "mean(ZC:B)", Want: float32(3)},
+		{Expr: "min(ZC:B)", Want: float32(-1)},
+		{Expr: "max(ZC:B)", Want: float32(7)},
+		{Expr: "ZC:B[0]", Want: float32(3)},
+		{Expr: "ZC:B[2] > 5", Want: true},
+		{Expr: "ZC:B[3]", Want: float32(0)},
+		{Expr: "sum(ZF:B)", Want: float32(2)},
+		{Expr: "len(XX:B)", Want: 0},
+		{Expr: "max(XX:B)", Want: float32(0)},
+	} {
+		expr, err := ql.NewParserFromStr(tt.Expr).ParseExpr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn, err := newValueFunc(expr, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Expr, err.Error())
+			continue
+		}
+		if got := fn(rec); got != tt.Want {
+			t.Errorf("%s: got %v want %v", tt.Expr, got, tt.Want)
+		}
+	}
+
+	for _, query := range []string{
+		"ZC:B > 1",
+		"ZC:B + 1 > 1",
+		"length(ZC:B) > 1",
+		"NM:i[0] > 1",
+	} {
+		if _, err := Where(query); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
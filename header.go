@@ -0,0 +1,192 @@
+package samql
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/biogo/hts/sam"
+)
+
+// RefsPolicy defines how MergeHeaders merges the references of headers.
+type RefsPolicy int
+
+const (
+	// UnionRefs merges the references of all headers in the order they are
+	// first found. References with the same name must have the same length.
+	UnionRefs RefsPolicy = iota
+	// StrictRefs requires all headers to have the same references, with the
+	// same lengths, in the same order.
+	StrictRefs
+)
+
+// GroupsPolicy defines how MergeHeaders merges the read groups and programs
+// of headers.
+type GroupsPolicy int
+
+const (
+	// FirstGroups keeps the read groups and programs of the first header
+	// only, as sam.MergeHeaders.
+	FirstGroups GroupsPolicy = iota
+	// UnionGroups keeps the read groups and programs of all headers, once
+	// for identical lines. Read groups with the same ID but different fields
+	// are an error, as records refer to them by ID. Such programs get a
+	// numeric suffix, e.g. bwa.1, also in the PP fields of the header.
+	UnionGroups
+)
+
+// MergePolicy defines how MergeHeaders merges the headers of multiple inputs.
+// The zero value merges the references of all headers and keeps the read
+// groups and programs of the first.
+type MergePolicy struct {
+	Refs   RefsPolicy
+	Groups GroupsPolicy
+}
+
+// MergeHeaders returns a new header merged from headers by policy p and, for
+// each header, the references of the merged header that correspond to its
+// references, by ID, as sam.MergeHeaders. The records of each header must be
+// relinked with Relink to be written with the merged header, as BAM records
+// refer to references by ID. The merged header is not sorted. A single header
+// is returned as is, with nil links.
+func MergeHeaders(headers []*sam.Header, p MergePolicy) (*sam.Header, [][]*sam.Reference, error) {
+	switch len(headers) {
+	case 0:
+		return nil, nil, nil
+	case 1:
+		return headers[0], nil, nil
+	}
+	h := headers[0].Clone()
+	h.SortOrder = sam.UnknownOrder
+	h.GroupOrder = sam.GroupUnspecified
+
+	links := make([][]*sam.Reference, len(headers))
+	links[0] = append([]*sam.Reference(nil), h.Refs()...)
+	for i, add := range headers[1:] {
+		var err error
+		if p.Refs == StrictRefs {
+			links[i+1], err = sameRefs(h, add, i+2)
+		} else {
+			links[i+1], err = unionRefs(h, add, i+2)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.Groups == UnionGroups {
+			if err := unionGroups(h, add, i+2); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return h, links, nil
+}
+
+// sameRefs returns the references of h if add, the header of input n, has
+// the same references as h.
+func sameRefs(h, add *sam.Header, n int) ([]*sam.Reference, error) {
+	refs, addRefs := h.Refs(), add.Refs()
+	if len(refs) != len(addRefs) {
+		return nil, fmt.Errorf("samql: input %d has %d references instead of %d",
+			n, len(addRefs), len(refs))
+	}
+	for i, r := range addRefs {
+		if r.Name() != refs[i].Name() || r.Len() != refs[i].Len() {
+			return nil, fmt.Errorf("samql: reference %d of input %d is %s:%d instead of %s:%d",
+				i+1, n, r.Name(), r.Len(), refs[i].Name(), refs[i].Len())
+		}
+	}
+	return append([]*sam.Reference(nil), refs...), nil
+}
+
+// unionRefs adds the references of add, the header of input n, that are not
+// in h to h and returns the references of h that correspond to those of add.
+func unionRefs(h, add *sam.Header, n int) ([]*sam.Reference, error) {
+	byName := make(map[string]*sam.Reference)
+	for _, r := range h.Refs() {
+		byName[r.Name()] = r
+	}
+	links := make([]*sam.Reference, len(add.Refs()))
+	for i, r := range add.Refs() {
+		if hr, ok := byName[r.Name()]; ok {
+			if hr.Len() != r.Len() {
+				return nil, fmt.Errorf("samql: reference %s has length %d in input %d and %d before",
+					r.Name(), r.Len(), n, hr.Len())
+			}
+			links[i] = hr
+			continue
+		}
+		r = r.Clone()
+		if err := h.AddReference(r); err != nil {
+			return nil, fmt.Errorf("samql: cannot add reference %s of input %d: %v", r.Name(), n, err)
+		}
+		byName[r.Name()] = r
+		links[i] = r
+	}
+	return links, nil
+}
+
+// unionGroups adds the read groups and programs of add, the header of input
+// n, that are not in h to h.
+func unionGroups(h, add *sam.Header, n int) error {
+	rgs := make(map[string]*sam.ReadGroup)
+	for _, rg := range h.RGs() {
+		rgs[rg.Name()] = rg
+	}
+	for _, rg := range add.RGs() {
+		if hrg, ok := rgs[rg.Name()]; ok {
+			if hrg.String() != rg.String() {
+				return fmt.Errorf("samql: read group %s of input %d differs from a previous one", rg.Name(), n)
+			}
+			continue
+		}
+		if err := h.AddReadGroup(rg.Clone()); err != nil {
+			return fmt.Errorf("samql: cannot add read group %s of input %d: %v", rg.Name(), n, err)
+		}
+	}
+
+	progs := make(map[string]*sam.Program)
+	for _, p := range h.Progs() {
+		progs[p.UID()] = p
+	}
+	renamed := make(map[string]string)
+	for _, p := range add.Progs() {
+		p = p.Clone()
+		if uid, ok := renamed[p.Previous()]; ok {
+			p.Set(ppTag, uid)
+		}
+		hp, ok := progs[p.UID()]
+		if ok && hp.String() == p.String() {
+			continue
+		}
+		if ok {
+			uid := p.UID()
+			for i := 1; progs[uid] != nil; i++ {
+				uid = p.UID() + "." + strconv.Itoa(i)
+			}
+			renamed[p.UID()] = uid
+			p.SetUID(uid)
+		}
+		if err := h.AddProgram(p); err != nil {
+			return fmt.Errorf("samql: cannot add program %s of input %d: %v", p.UID(), n, err)
+		}
+		progs[p.UID()] = p
+	}
+	return nil
+}
+
+// ppTag is the tag of the previous program of @PG lines.
+var ppTag = sam.NewTag("PP")
+
+// Relink replaces the references of rec with the corresponding references of
+// a merged header, given by the links that MergeHeaders returns for the
+// header of rec. Records are not changed if links is nil.
+func Relink(rec *sam.Record, links []*sam.Reference) {
+	if links == nil {
+		return
+	}
+	if rec.Ref != nil && rec.Ref.ID() >= 0 && rec.Ref.ID() < len(links) {
+		rec.Ref = links[rec.Ref.ID()]
+	}
+	if rec.MateRef != nil && rec.MateRef.ID() >= 0 && rec.MateRef.ID() < len(links) {
+		rec.MateRef = links[rec.MateRef.ID()]
+	}
+}
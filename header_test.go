@@ -0,0 +1,130 @@
+package samql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestMergeHeaders(t *testing.T) {
+	const (
+		h1 = "@SQ\tSN:chr1\tLN:1000\n@SQ\tSN:chr2\tLN:1000\n" +
+			"@RG\tID:a\tSM:x\n@PG\tID:bwa\tPN:bwa\tCL:bwa mem a\n" +
+			"r1\t0\tchr2\t10\t30\t4M\t*\t0\t0\tACGT\t*\n"
+		h2 = "@SQ\tSN:chr2\tLN:1000\n@SQ\tSN:chr3\tLN:500\n" +
+			"@RG\tID:a\tSM:x\n@RG\tID:b\tSM:y\n" +
+			"@PG\tID:bwa\tPN:bwa\tCL:bwa mem b\n@PG\tID:st\tPN:samtools\tPP:bwa\n" +
+			"r2\t0\tchr2\t10\t30\t4M\t=\t20\t0\tACGT\t*\n"
+		h3 = "@SQ\tSN:chr1\tLN:1000\n@SQ\tSN:chr2\tLN:1000\n@RG\tID:a\tSM:z\n"
+		h4 = "@SQ\tSN:chr2\tLN:2000\n"
+	)
+	tests := []struct {
+		Test   string
+		Data   []string
+		Policy MergePolicy
+		Refs   string
+		Groups string
+		Err    string
+	}{
+		{
+			Test: "Union",
+			Data: []string{h1, h2},
+			Refs: "chr1 chr2 chr3",
+			Groups: "@RG\tID:a\tSM:x\n" +
+				"@PG\tID:bwa\tPN:bwa\tCL:bwa mem a\n",
+		},
+		{
+			Test:   "UnionGroups",
+			Data:   []string{h1, h2},
+			Policy: MergePolicy{Groups: UnionGroups},
+			Refs:   "chr1 chr2 chr3",
+			Groups: "@RG\tID:a\tSM:x\n@RG\tID:b\tSM:y\n" +
+				"@PG\tID:bwa\tPN:bwa\tCL:bwa mem a\n" +
+				"@PG\tID:bwa.1\tPN:bwa\tCL:bwa mem b\n" +
+				"@PG\tID:st\tPN:samtools\tPP:bwa.1\n",
+		},
+		{
+			Test:   "Strict",
+			Data:   []string{h1, h1},
+			Policy: MergePolicy{Refs: StrictRefs, Groups: UnionGroups},
+			Refs:   "chr1 chr2",
+			Groups: "@RG\tID:a\tSM:x\n" +
+				"@PG\tID:bwa\tPN:bwa\tCL:bwa mem a\n",
+		},
+		{
+			Test:   "StrictDifferent",
+			Data:   []string{h1, h2},
+			Policy: MergePolicy{Refs: StrictRefs},
+			Err:    "samql: reference 1 of input 2 is chr2:1000 instead of chr1:1000",
+		},
+		{
+			Test: "Lengths",
+			Data: []string{h1, h4},
+			Err:  "samql: reference chr2 has length 2000 in input 2 and 1000 before",
+		},
+		{
+			Test:   "ReadGroups",
+			Data:   []string{h1, h3},
+			Policy: MergePolicy{Groups: UnionGroups},
+			Err:    "samql: read group a of input 2 differs from a previous one",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Test, func(t *testing.T) {
+			var headers []*sam.Header
+			var recs []*sam.Record
+			for _, d := range tt.Data {
+				sr, err := sam.NewReader(strings.NewReader(d))
+				if err != nil {
+					t.Fatal(err)
+				}
+				headers = append(headers, sr.Header())
+				rec, _ := sr.Read()
+				recs = append(recs, rec)
+			}
+			h, links, err := MergeHeaders(headers, tt.Policy)
+			if tt.Err != "" {
+				if err == nil || err.Error() != tt.Err {
+					t.Fatalf("expected error %q, got %v", tt.Err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, r := range h.Refs() {
+				names = append(names, r.Name())
+			}
+			if got := strings.Join(names, " "); got != tt.Refs {
+				t.Errorf("expected references %s, got %s", tt.Refs, got)
+			}
+			text, _ := h.MarshalText()
+			var groups strings.Builder
+			for _, line := range strings.SplitAfter(string(text), "\n") {
+				if strings.HasPrefix(line, "@RG") || strings.HasPrefix(line, "@PG") {
+					groups.WriteString(line)
+				}
+			}
+			if groups.String() != tt.Groups {
+				t.Errorf("expected groups:\n%s\ngot:\n%s", tt.Groups, groups.String())
+			}
+
+			// The records of all inputs refer to chr2 of the merged header.
+			for i, rec := range recs {
+				if rec == nil {
+					continue
+				}
+				Relink(rec, links[i])
+				if rec.Ref.ID() != 1 || rec.Ref.Name() != "chr2" {
+					t.Errorf("input %d: expected reference chr2 with ID 1, got %s with ID %d",
+						i+1, rec.Ref.Name(), rec.Ref.ID())
+				}
+				if rec.MateRef != nil && rec.MateRef != rec.Ref {
+					t.Errorf("input %d: expected mate reference chr2, got %s", i+1, rec.MateRef.Name())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package samql
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+)
+
+func init() {
+	RegisterScheme("htsget", openHtsget)
+}
+
+// htsgetTicket is the response of an htsget server to a reads request.
+type htsgetTicket struct {
+	Htsget struct {
+		Format  string      `json:"format"`
+		URLs    []htsgetURL `json:"urls"`
+		Error   string      `json:"error"`
+		Message string      `json:"message"`
+	} `json:"htsget"`
+}
+
+// htsgetURL is a block of the data of an htsget ticket. URL is either an
+// HTTP(S) URL that is requested with Headers or a data URI.
+type htsgetURL struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// openHtsget opens the reads of an htsget server. The htsget scheme of name
+// is replaced with https, e.g. htsget://host/reads/NA12878?referenceName=chr1
+// requests the ticket https://host/reads/NA12878?referenceName=chr1. The
+// blocks of the ticket are fetched in order and concatenated. The blocks can
+// hold records outside the requested region, so only the records that
+// overlap it are returned.
+func openHtsget(name string) (Source, error) {
+	return openHtsgetURL(name, "https://"+strings.TrimPrefix(name, "htsget://"))
+}
+
+// openHtsgetURL opens the reads of the htsget ticket at ticketURL. name is
+// used in errors.
+func openHtsgetURL(name, ticketURL string) (Source, error) {
+	filter, err := htsgetFilter(ticketURL)
+	if err != nil {
+		return nil, fmt.Errorf("samql: %s: %v", name, err)
+	}
+	resp, err := http.Get(ticketURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var t htsgetTicket
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("samql: htsget request for %s failed: %s", name, resp.Status)
+		}
+		return nil, fmt.Errorf("samql: invalid htsget ticket for %s: %v", name, err)
+	}
+	if resp.StatusCode != http.StatusOK || t.Htsget.Error != "" {
+		return nil, fmt.Errorf("samql: htsget request for %s failed: %s: %s",
+			name, t.Htsget.Error, t.Htsget.Message)
+	}
+	if f := t.Htsget.Format; f != "" && f != "BAM" {
+		return nil, fmt.Errorf("samql: %s: unsupported htsget format %s", name, f)
+	}
+	src, err := newSource(name, &htsgetReader{urls: t.Htsget.URLs})
+	if err != nil || filter == nil {
+		return src, err
+	}
+	return &regionSource{Source: src, filter: filter}, nil
+}
+
+// htsgetFilter returns a filter of the records in the region of the
+// referenceName, start and end parameters of the ticket URL u, with 0-based
+// start and exclusive end as in the htsget protocol. The reference name *
+// selects the unplaced unmapped records. It returns nil if no reference is
+// requested.
+func htsgetFilter(u string) (FilterFunc, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	q := parsed.Query()
+	reg := Region{Rname: q.Get("referenceName")}
+	if reg.Rname == "" {
+		return nil, nil
+	}
+	if reg.Rname == "*" {
+		return func(rec *sam.Record) bool { return rec.Ref == nil }, nil
+	}
+	if v := q.Get("start"); v != "" {
+		if reg.Start, err = strconv.Atoi(v); err != nil || reg.Start < 0 {
+			return nil, fmt.Errorf("invalid htsget start %q", v)
+		}
+	}
+	if v := q.Get("end"); v != "" {
+		if reg.End, err = strconv.Atoi(v); err != nil || reg.End <= reg.Start {
+			return nil, fmt.Errorf("invalid htsget end %q", v)
+		}
+	}
+	return OverlapFilter([]Region{reg}), nil
+}
+
+// regionSource is a Source that returns only the records of s that pass
+// filter.
+type regionSource struct {
+	Source
+	filter FilterFunc
+}
+
+// Read returns the next record that passes the filter.
+func (s *regionSource) Read() (*sam.Record, error) {
+	for {
+		rec, err := s.Source.Read()
+		if err != nil {
+			return nil, err
+		}
+		if s.filter(rec) {
+			return rec, nil
+		}
+	}
+}
+
+// htsgetReader reads the concatenated blocks of an htsget ticket. Blocks are
+// fetched when they are reached.
+type htsgetReader struct {
+	urls []htsgetURL
+	cur  io.ReadCloser
+}
+
+// Read reads up to len(p) bytes from the current block, fetching the next
+// block when the current one is exhausted.
+func (r *htsgetReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.urls) == 0 {
+				return 0, io.EOF
+			}
+			var err error
+			if r.cur, err = fetchBlock(r.urls[0]); err != nil {
+				return 0, err
+			}
+			r.urls = r.urls[1:]
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Close closes the block that is read.
+func (r *htsgetReader) Close() error {
+	r.urls = nil
+	if r.cur == nil {
+		return nil
+	}
+	err := r.cur.Close()
+	r.cur = nil
+	return err
+}
+
+// fetchBlock returns a reader for the data of the block u.
+func fetchBlock(u htsgetURL) (io.ReadCloser, error) {
+	if strings.HasPrefix(u.URL, "data:") {
+		data, err := decodeDataURI(u.URL)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(strings.NewReader(data)), nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range u.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("samql: htsget block request to %s failed: %s", u.URL, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// decodeDataURI returns the data of the data URI s, e.g.
+// data:application/vnd.ga4gh.bam;base64,H4sI.
+func decodeDataURI(s string) (string, error) {
+	i := strings.IndexByte(s, ',')
+	if i < 0 {
+		return "", fmt.Errorf("samql: invalid data URI")
+	}
+	if strings.HasSuffix(s[:i], ";base64") {
+		data, err := base64.StdEncoding.DecodeString(s[i+1:])
+		return string(data), err
+	}
+	return url.PathUnescape(s[i+1:])
+}
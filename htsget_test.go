@@ -0,0 +1,120 @@
+package samql
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOpenHtsget(t *testing.T) {
+	data := bamData(t)
+	split := len(data) / 2
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/reads/test":
+			// The first block is a data URI and the second a range of the
+			// file that is requested with the ticket headers.
+			var ticket htsgetTicket
+			ticket.Htsget.Format = "BAM"
+			ticket.Htsget.URLs = []htsgetURL{
+				{URL: "data:application/vnd.ga4gh.bam;base64," +
+					base64.StdEncoding.EncodeToString(data[:split])},
+				{URL: srv.URL + "/data/test.bam",
+					Headers: map[string]string{"Range": fmt.Sprintf("bytes=%d-", split)}},
+			}
+			json.NewEncoder(w).Encode(ticket)
+		case "/data/test.bam":
+			http.ServeContent(w, r, "test.bam", time.Time{}, bytes.NewReader(data))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"htsget": {"error": "NotFound", "message": "No such accession"}}`)
+		}
+	}))
+	defer srv.Close()
+
+	src, err := openHtsgetURL("htsget://test", srv.URL+"/reads/test")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	r := NewReader(src)
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	if l := len(records); l != 8 {
+		t.Errorf("record count=%d want 8", l)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("unexpected close error %q", err.Error())
+	}
+
+	if _, err := openHtsgetURL("htsget://missing", srv.URL+"/reads/missing"); err == nil {
+		t.Errorf("expected error for missing reads")
+	}
+
+	// The blocks hold all records, so the records outside the requested
+	// region are dropped by the client.
+	for _, tt := range []struct {
+		Query string
+		Names string
+		Err   bool
+	}{
+		{Query: "referenceName=chr1&start=10&end=20", Names: "r001,r002,r003"},
+		{Query: "referenceName=chr1&start=30", Names: "r003,r001"},
+		{Query: "referenceName=chr2", Names: "r004"},
+		{Query: "referenceName=*", Names: "r006,r006"},
+		{Query: "referenceName=chr1&start=20&end=10", Err: true},
+	} {
+		src, err := openHtsgetURL("htsget://test", srv.URL+"/reads/test?"+tt.Query)
+		if tt.Err {
+			if err == nil {
+				src.Close()
+				t.Errorf("%s: expected error", tt.Query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Query, err.Error())
+			continue
+		}
+		if got := readQnames(t, src); got != tt.Names {
+			t.Errorf("%s: records=%s want %s", tt.Query, got, tt.Names)
+		}
+		src.Close()
+	}
+}
+
+func TestDecodeDataURI(t *testing.T) {
+	for _, tt := range []struct {
+		URI  string
+		Data string
+		Err  bool
+	}{
+		{URI: "data:;base64,YWJj", Data: "abc"},
+		{URI: "data:text/plain,a%20b", Data: "a b"},
+		{URI: "data:;base64,#", Err: true},
+		{URI: "data:abc", Err: true},
+	} {
+		data, err := decodeDataURI(tt.URI)
+		if tt.Err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.URI)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.URI, err.Error())
+			continue
+		}
+		if data != tt.Data {
+			t.Errorf("%s: data=%q want %q", tt.URI, data, tt.Data)
+		}
+	}
+}
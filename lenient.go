@@ -0,0 +1,163 @@
+package samql
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/biogo/hts/sam"
+)
+
+// LenientSAMReader reads the records of SAM data, like sam.Reader, but skips
+// the lines that are not valid records, e.g. with a MAPQ above 255, an RNAME
+// without an @SQ header line or malformed optional fields, instead of
+// failing, so that a single bad line does not abort reading. The header must
+// be valid. As with sam.Reader, the references of data without a header are
+// added to the header as they are found.
+type LenientSAMReader struct {
+	r *bufio.Reader
+	h *sam.Header
+
+	// OnSkip, if not nil, is called with the line number, starting from 1,
+	// and the parsing error of each skipped line.
+	OnSkip func(line int64, err error)
+
+	refs    map[string]*sam.Reference // references of data without a header
+	line    int64                     // number of lines read
+	offset  int64                     // number of bytes read
+	skipped int64
+}
+
+// The LenientSAMReader satisfies readerSAM.
+var _ readerSAM = (*LenientSAMReader)(nil)
+
+// NewLenientSAMReader returns a new LenientSAMReader that reads from r,
+// after reading the header.
+func NewLenientSAMReader(r io.Reader) (*LenientSAMReader, error) {
+	h, _ := sam.NewHeader(nil, nil)
+	lr := &LenientSAMReader{r: bufio.NewReader(r), h: h}
+
+	var text []byte
+	for {
+		p, err := lr.r.Peek(1)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p[0] != '@' {
+			break
+		}
+		line, err := lr.readLine()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		text = append(append(text, line...), '\n')
+	}
+	if len(text) == 0 {
+		lr.refs = make(map[string]*sam.Reference)
+		return lr, nil
+	}
+	if err := h.UnmarshalText(text); err != nil {
+		return nil, err
+	}
+	return lr, nil
+}
+
+// Header returns the SAM header of r.
+func (r *LenientSAMReader) Header() *sam.Header {
+	return r.h
+}
+
+// readLine returns the next line of r without the line ending. The last
+// line may not end with a newline. It returns io.EOF if r is exhausted.
+func (r *LenientSAMReader) readLine() ([]byte, error) {
+	b, err := r.r.ReadBytes('\n')
+	r.offset += int64(len(b))
+	if err == io.EOF && len(b) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.line++
+	b = bytes.TrimSuffix(b, []byte{'\n'})
+	return bytes.TrimSuffix(b, []byte{'\r'}), nil
+}
+
+// Read returns the next valid record of r, skipping invalid lines. It returns
+// nil and io.EOF when r is exhausted.
+func (r *LenientSAMReader) Read() (*sam.Record, error) {
+	for {
+		b, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(b) == 0 {
+			continue
+		}
+		rec, err := r.parse(b)
+		if err != nil {
+			r.skipped++
+			if r.OnSkip != nil {
+				r.OnSkip(r.line, err)
+			}
+			continue
+		}
+		return rec, nil
+	}
+}
+
+// parse returns the record of line b.
+func (r *LenientSAMReader) parse(b []byte) (*sam.Record, error) {
+	var rec sam.Record
+	if r.refs == nil {
+		if err := rec.UnmarshalSAM(r.h, b); err != nil {
+			return nil, err
+		}
+		return &rec, nil
+	}
+
+	// The references of records without a header are added to the header
+	// by name.
+	if err := rec.UnmarshalSAM(nil, b); err != nil {
+		return nil, err
+	}
+	var err error
+	if rec.Ref, err = r.addRef(rec.Ref); err != nil {
+		return nil, err
+	}
+	if rec.MateRef, err = r.addRef(rec.MateRef); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// addRef returns the reference of the header of r with the name of ref,
+// adding ref to the header if there is none. It returns nil if ref is nil.
+func (r *LenientSAMReader) addRef(ref *sam.Reference) (*sam.Reference, error) {
+	if ref == nil {
+		return nil, nil
+	}
+	if hr, ok := r.refs[ref.Name()]; ok {
+		return hr, nil
+	}
+	if err := r.h.AddReference(ref); err != nil {
+		return nil, fmt.Errorf("cannot add reference %s: %v", ref.Name(), err)
+	}
+	r.refs[ref.Name()] = ref
+	return ref, nil
+}
+
+// Skipped returns the number of lines skipped so far.
+func (r *LenientSAMReader) Skipped() int64 {
+	return r.skipped
+}
+
+// Offset returns the number of bytes read from the input, including those of
+// the skipped lines.
+func (r *LenientSAMReader) Offset() int64 {
+	return r.offset
+}
@@ -0,0 +1,80 @@
+package samql
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLenientSAMReader(t *testing.T) {
+	tests := []struct {
+		Test    string
+		Data    string
+		Names   []string
+		Skipped []int64
+		Refs    int
+	}{
+		{
+			Test: "Invalid",
+			Data: "@SQ\tSN:chr1\tLN:100\n" +
+				"r1\t0\tchr1\t1\t30\t4M\t*\t0\t0\tACGT\t*\n" +
+				"r2\t0\tchr2\t1\t30\t4M\t*\t0\t0\tACGT\t*\n" +
+				"r3\t0\tchr1\t1\t300\t4M\t*\t0\t0\tACGT\t*\n" +
+				"r4\t0\tchr1\t1\t30\t4M\t*\t0\t0\tACGT\t*\tNM:i:x\n" +
+				"r5\t0\tchr1\t1\t30\t4M\t*\t0\t0\tACG\t*\n" +
+				"\n" +
+				"r6\t0\tchr1\t5\t30\t4M\t*\t0\t0\tACGT\t*\tNM:i:1",
+			Names:   []string{"r1", "r6"},
+			Skipped: []int64{3, 4, 5, 6},
+			Refs:    1,
+		},
+		{
+			Test: "Headerless",
+			Data: "r1\t0\tchr1\t1\t30\t4M\tchr2\t10\t0\tACGT\t*\n" +
+				"r2\t0\tchr2\t1\t300\t4M\t*\t0\t0\tACGT\t*\r\n" +
+				"r3\t0\tchr2\t1\t30\t4M\t=\t10\t0\tACGT\t*\r\n",
+			Names:   []string{"r1", "r3"},
+			Skipped: []int64{2},
+			Refs:    2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Test, func(t *testing.T) {
+			r, err := NewLenientSAMReader(strings.NewReader(tt.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var skipped []int64
+			r.OnSkip = func(line int64, err error) {
+				skipped = append(skipped, line)
+			}
+			var names []string
+			for {
+				rec, err := r.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, rec.Name)
+			}
+			if !reflect.DeepEqual(names, tt.Names) {
+				t.Errorf("expected records %v, got %v", tt.Names, names)
+			}
+			if !reflect.DeepEqual(skipped, tt.Skipped) {
+				t.Errorf("expected skipped lines %v, got %v", tt.Skipped, skipped)
+			}
+			if r.Skipped() != int64(len(tt.Skipped)) {
+				t.Errorf("expected %d skipped, got %d", len(tt.Skipped), r.Skipped())
+			}
+			if n := len(r.Header().Refs()); n != tt.Refs {
+				t.Errorf("expected %d references, got %d", tt.Refs, n)
+			}
+			if r.Offset() != int64(len(tt.Data)) {
+				t.Errorf("expected offset %d, got %d", len(tt.Data), r.Offset())
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package samql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/maragkakislab/samql/ql"
+)
+
+// QueryLibrary contains named WHERE clauses, so that commonly used queries
+// can be shared. A query can refer to other queries of the library by name
+// where a condition is expected, e.g. "PROPER_PAIR AND unique".
+type QueryLibrary map[string]string
+
+// validQueryName matches the names of library queries.
+var validQueryName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ReadQueryLibrary reads a query library from r. The library is written in a
+// subset of YAML: each line is a "name: query" pair and long queries can
+// continue on the following indented lines after "name: >" or "name: |".
+// Values can be quoted. Empty lines and comments starting with # are skipped.
+func ReadQueryLibrary(r io.Reader) (QueryLibrary, error) {
+	l := make(QueryLibrary)
+	block := ""
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Indented lines continue a block query.
+		if line[0] == ' ' || line[0] == '\t' {
+			if block == "" {
+				return nil, fmt.Errorf("samql: query library line %d: unexpected indentation", n)
+			}
+			l[block] = strings.TrimSpace(l[block] + " " + trimmed)
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("samql: query library line %d: expected name: query", n)
+		}
+		name, val := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if !validQueryName.MatchString(name) {
+			return nil, fmt.Errorf("samql: query library line %d: invalid name %q", n, name)
+		}
+		if _, ok := getPlaceholder[name]; ok {
+			return nil, fmt.Errorf("samql: query library line %d: name %s is a keyword", n, name)
+		}
+		if _, ok := l[name]; ok {
+			return nil, fmt.Errorf("samql: query library line %d: duplicate name %s", n, name)
+		}
+
+		block = ""
+		switch val {
+		case ">", "|", ">-", "|-":
+			block = name
+			val = ""
+		default:
+			val = unquote(val)
+		}
+		l[name] = val
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	for name, q := range l {
+		if q == "" {
+			return nil, fmt.Errorf("samql: query library: empty query %s", name)
+		}
+	}
+	return l, nil
+}
+
+// unquote removes the YAML quotes around s, if any.
+func unquote(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	inner := s[1 : len(s)-1]
+	switch {
+	case s[0] == '"' && s[len(s)-1] == '"':
+		return strings.Replace(inner, `\"`, `"`, -1)
+	case s[0] == '\'' && s[len(s)-1] == '\'':
+		// A query such as 'a' = 'b' is not quoted as a whole.
+		if !strings.Contains(strings.Replace(inner, "''", "", -1), "'") {
+			return strings.Replace(inner, "''", "'", -1)
+		}
+	}
+	return s
+}
+
+// ReadQueryLibraryFile reads the query library file at path.
+func ReadQueryLibraryFile(path string) (QueryLibrary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadQueryLibrary(f)
+}
+
+// DefaultQueryLibraryPath returns the path of the query library in the home
+// directory of the user, ~/.samql/queries.yaml.
+func DefaultQueryLibraryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".samql", "queries.yaml")
+}
+
+// Get returns the query name of l with all references to other queries
+// expanded.
+func (l QueryLibrary) Get(name string) (string, error) {
+	q, ok := l[name]
+	if !ok {
+		return "", fmt.Errorf("samql: unknown query %s", name)
+	}
+	return l.expand(q, []string{name})
+}
+
+// Expand returns query with all references to queries of l expanded. A
+// reference is a query name used as a condition, e.g. unique in "MAPQ > 10
+// AND unique". Queries without references are returned unchanged.
+func (l QueryLibrary) Expand(query string) (string, error) {
+	return l.expand(query, nil)
+}
+
+// expand expands the references of query. stack contains the names of the
+// queries being expanded, to detect circular references.
+func (l QueryLibrary) expand(query string, stack []string) (string, error) {
+	stmt, err := parseWhere(query)
+	if err != nil {
+		if len(stack) > 0 {
+			return "", fmt.Errorf("samql: query %s: %v", stack[len(stack)-1], err)
+		}
+		return "", err
+	}
+
+	changed := false
+	var expandExpr func(expr ql.Expr) (ql.Expr, error)
+	expandExpr = func(expr ql.Expr) (ql.Expr, error) {
+		switch e := expr.(type) {
+		case *ql.VarRef:
+			q, ok := l[e.Val]
+			if !ok {
+				return e, nil
+			}
+			for _, name := range stack {
+				if name == e.Val {
+					return nil, fmt.Errorf("samql: query %s refers to itself", e.Val)
+				}
+			}
+			q, err := l.expand(q, append(stack, e.Val))
+			if err != nil {
+				return nil, err
+			}
+			sub, err := parseWhere(q)
+			if err != nil {
+				return nil, err
+			}
+			changed = true
+			return &ql.ParenExpr{Expr: sub.Condition}, nil
+		case *ql.BinaryExpr:
+			if e.Op != ql.AND && e.Op != ql.OR {
+				return e, nil
+			}
+			lhs, err := expandExpr(e.LHS)
+			if err != nil {
+				return nil, err
+			}
+			rhs, err := expandExpr(e.RHS)
+			if err != nil {
+				return nil, err
+			}
+			return &ql.BinaryExpr{Op: e.Op, LHS: lhs, RHS: rhs}, nil
+		case *ql.NotExpr:
+			sub, err := expandExpr(e.Expr)
+			if err != nil {
+				return nil, err
+			}
+			return &ql.NotExpr{Expr: sub}, nil
+		case *ql.ParenExpr:
+			sub, err := expandExpr(e.Expr)
+			if err != nil {
+				return nil, err
+			}
+			return &ql.ParenExpr{Expr: sub}, nil
+		}
+		return expr, nil
+	}
+
+	cond, err := expandExpr(stmt.Condition)
+	if err != nil {
+		return "", err
+	}
+	if !changed {
+		return query, nil
+	}
+	return cond.String(), nil
+}
@@ -0,0 +1,90 @@
+package samql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadQueryLibrary(t *testing.T) {
+	in := `# Shared filters.
+unique: NH:i = 1
+proper: "PAIRED AND PROPERPAIR"
+chr1: 'RNAME = ''chr1'''
+named: 'chr1' = 'chr1'
+
+proper_unique_pairs: >
+  proper AND unique AND
+  NOT (SECONDARY OR SUPPLEMENTARY)
+`
+	l, err := ReadQueryLibrary(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := QueryLibrary{
+		"unique":              "NH:i = 1",
+		"proper":              "PAIRED AND PROPERPAIR",
+		"chr1":                "RNAME = 'chr1'",
+		"named":               "'chr1' = 'chr1'",
+		"proper_unique_pairs": "proper AND unique AND NOT (SECONDARY OR SUPPLEMENTARY)",
+	}
+	if !reflect.DeepEqual(l, want) {
+		t.Errorf("library=%v want %v", l, want)
+	}
+
+	for _, in := range []string{
+		"unique NH:i = 1",
+		"MAPQ: MAPQ > 10",
+		"1st: MAPQ > 10",
+		"a: MAPQ > 10\na: MAPQ > 20",
+		"  MAPQ > 10",
+		"a: >\nb: MAPQ > 10",
+	} {
+		if _, err := ReadQueryLibrary(strings.NewReader(in)); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestQueryLibraryExpand(t *testing.T) {
+	l := QueryLibrary{
+		"unique":      "NH:i = 1",
+		"proper":      "PAIRED AND PROPERPAIR",
+		"proper_uniq": "proper AND unique",
+		"loop":        "MAPQ > 10 AND loop2",
+		"loop2":       "NOT loop",
+	}
+
+	for _, tt := range []struct {
+		Query string
+		Want  string
+	}{
+		{Query: "MAPQ > 10", Want: "MAPQ > 10"},
+		{Query: "RNAME = unique", Want: "RNAME = unique"},
+		{Query: "MAPQ > 10 AND unique", Want: "MAPQ > 10 AND (NH:i = 1)"},
+		{Query: "NOT proper_uniq", Want: "NOT ((PAIRED AND PROPERPAIR) AND (NH:i = 1))"},
+		{Query: "(unique OR proper)", Want: "((NH:i = 1) OR (PAIRED AND PROPERPAIR))"},
+	} {
+		q, err := l.Expand(tt.Query)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.Query, err)
+			continue
+		}
+		if q != tt.Want {
+			t.Errorf("%s: expanded=%q want %q", tt.Query, q, tt.Want)
+		}
+		if _, err := Where(q); err != nil {
+			t.Errorf("%s: invalid expanded query %q: %v", tt.Query, q, err)
+		}
+	}
+
+	if q, err := l.Get("proper_uniq"); err != nil || q != "(PAIRED AND PROPERPAIR) AND (NH:i = 1)" {
+		t.Errorf("query=%q, err=%v", q, err)
+	}
+	if _, err := l.Get("loop"); err == nil {
+		t.Error("expected error for circular reference")
+	}
+	if _, err := l.Get("missing"); err == nil {
+		t.Error("expected error for unknown query")
+	}
+}
@@ -0,0 +1,78 @@
+package samql
+
+import (
+	"fmt"
+
+	"github.com/biogo/hts/sam"
+)
+
+// MergeSorted returns a Source that merges readers, each sorted by order,
+// into a single sequence sorted by order with header h, typically the merged
+// header of the readers. order must be sam.Coordinate, which sorts records by
+// the order of their references in h and position with unmapped records
+// last, or sam.QueryName, which sorts records by QNAME as ORDER BY QNAME.
+// Records that compare equal are returned in the order of readers. Reading
+// fails if a reader is not sorted by order. Closing the returned Source does
+// not close the readers.
+func MergeSorted(h *sam.Header, order sam.SortOrder, readers ...*Reader) (Source, error) {
+	var less func(a, b *sam.Record) bool
+	switch order {
+	case sam.Coordinate:
+		refs := make(map[string]int)
+		for i, ref := range h.Refs() {
+			refs[ref.Name()] = i
+		}
+		refID := func(rec *sam.Record) int {
+			if rec.Ref == nil {
+				return -1
+			}
+			id, ok := refs[rec.Ref.Name()]
+			if !ok {
+				return -1
+			}
+			return id
+		}
+		less = func(a, b *sam.Record) bool {
+			if c := compareRefIDs(refID(a), refID(b)); c != 0 {
+				return c < 0
+			}
+			return a.Pos < b.Pos
+		}
+	case sam.QueryName:
+		less = func(a, b *sam.Record) bool { return a.Name < b.Name }
+	default:
+		return nil, fmt.Errorf("samql: cannot merge records sorted by %s", order)
+	}
+
+	m := &mergeSource{h: h, less: less}
+	for i, r := range readers {
+		sr := &sortedReader{r: r, less: less, name: fmt.Sprintf("input %d", i+1), order: order}
+		if err := m.push(&run{r: sr, idx: i}); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// sortedReader returns an error if the records of r are not sorted by less.
+type sortedReader struct {
+	r     *Reader
+	less  func(a, b *sam.Record) bool
+	prev  *sam.Record
+	name  string
+	order sam.SortOrder
+}
+
+// Read returns the next record of the reader.
+func (r *sortedReader) Read() (*sam.Record, error) {
+	rec, err := r.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if r.prev != nil && r.less(rec, r.prev) {
+		return nil, fmt.Errorf("samql: %s is not sorted by %s: %s after %s",
+			r.name, r.order, rec.Name, r.prev.Name)
+	}
+	r.prev = rec
+	return rec, nil
+}
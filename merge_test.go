@@ -0,0 +1,107 @@
+package samql
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestMergeSorted(t *testing.T) {
+	const (
+		data1 = "@HD\tVN:1.5\tSO:coordinate\n@SQ\tSN:chr1\tLN:100\n@SQ\tSN:chr2\tLN:100\n" +
+			"a1\t0\tchr1\t5\t30\t4M\t*\t0\t0\tACGT\t*\n" +
+			"a2\t0\tchr2\t1\t30\t4M\t*\t0\t0\tACGT\t*\n" +
+			"a3\t4\t*\t0\t0\t*\t*\t0\t0\tACGT\t*\n"
+		// The references of the second input are in a different order.
+		data2 = "@HD\tVN:1.5\tSO:coordinate\n@SQ\tSN:chr2\tLN:100\n@SQ\tSN:chr1\tLN:100\n" +
+			"b1\t0\tchr2\t3\t5\t4M\t*\t0\t0\tACGT\t*\n" +
+			"b2\t0\tchr1\t1\t30\t4M\t*\t0\t0\tACGT\t*\n" +
+			"b3\t0\tchr1\t5\t30\t4M\t*\t0\t0\tACGT\t*\n"
+	)
+
+	for _, tt := range []struct {
+		Test  string
+		Order sam.SortOrder
+		Data  []string
+		Query string
+		Names []string
+		Err   bool
+	}{
+		{
+			Test:  "Coordinate",
+			Order: sam.Coordinate,
+			Data:  []string{data1, data1},
+			Names: []string{"a1", "a1", "a2", "a2", "a3", "a3"},
+		},
+		{
+			Test:  "CoordinateFilter",
+			Order: sam.Coordinate,
+			Data:  []string{data1, data1},
+			Query: "MAPQ > 10",
+			Names: []string{"a1", "a1", "a2", "a2"},
+		},
+		{
+			Test:  "QueryName",
+			Order: sam.QueryName,
+			Data:  []string{"b1\t4\t*\t0\t0\t*\t*\t0\t0\tA\t*\nb3\t4\t*\t0\t0\t*\t*\t0\t0\tA\t*\n", "a2\t4\t*\t0\t0\t*\t*\t0\t0\tA\t*\nb2\t4\t*\t0\t0\t*\t*\t0\t0\tA\t*\n"},
+			Names: []string{"a2", "b1", "b2", "b3"},
+		},
+		{
+			Test:  "Unsorted",
+			Order: sam.Coordinate,
+			Data:  []string{data1, data2},
+			Err:   true,
+		},
+	} {
+		var readers []*Reader
+		var headers []*sam.Header
+		for _, d := range tt.Data {
+			sr, err := sam.NewReader(strings.NewReader(d))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := NewReader(sr)
+			if tt.Query != "" {
+				r.AppendFilter(Must(Where(tt.Query)))
+			}
+			readers = append(readers, r)
+			headers = append(headers, sr.Header())
+		}
+		h, _, err := sam.MergeHeaders(headers)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		src, err := MergeSorted(h, tt.Order, readers...)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.Test, err)
+			continue
+		}
+		var names []string
+		for {
+			rec, err := src.Read()
+			if err != nil {
+				if err != io.EOF {
+					names = nil
+				}
+				if (err != io.EOF) != tt.Err {
+					t.Errorf("%s: unexpected error %v", tt.Test, err)
+				}
+				break
+			}
+			names = append(names, rec.Name)
+		}
+		if !tt.Err && strings.Join(names, " ") != strings.Join(tt.Names, " ") {
+			t.Errorf("%s: names=%v want %v", tt.Test, names, tt.Names)
+		}
+		if err := src.Close(); err != nil {
+			t.Errorf("%s: unexpected close error %v", tt.Test, err)
+		}
+	}
+
+	if _, err := MergeSorted(&sam.Header{}, sam.Unsorted); err == nil {
+		t.Error("expected error for unsorted order")
+	}
+}
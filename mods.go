@@ -0,0 +1,192 @@
+package samql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+)
+
+// defaultModProb is the probability above which a modification is called by
+// mod_count and has_mod if a threshold is not provided.
+const defaultModProb = 0.5
+
+// modCode is a base modification of the MM tag, e.g. C+m for 5mC on the
+// forward strand. code is a single letter code or a ChEBI number.
+type modCode struct {
+	base   byte
+	strand byte
+	code   string
+}
+
+// parseModCode parses a base modification, e.g. C+m, A+a or C+76792.
+func parseModCode(s string) (modCode, error) {
+	if len(s) < 3 || !strings.ContainsRune("ACGTUN", rune(s[0])) ||
+		(s[1] != '+' && s[1] != '-') {
+		return modCode{}, fmt.Errorf("invalid base modification %q, e.g. 'C+m'", s)
+	}
+	m := modCode{base: s[0], strand: s[1], code: s[2:]}
+	if !isDigits(m.code) && len(m.code) != 1 {
+		return modCode{}, fmt.Errorf("invalid base modification %q; expected a single code", s)
+	}
+	return m, nil
+}
+
+// isDigits returns true if s is not empty and has only digits.
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// modTags returns the MM and ML tags of r, or their earlier Mm and Ml names.
+// ml is nil if the ML tag is missing.
+func modTags(r *sam.Record) (mm string, ml []uint8) {
+	for _, names := range [][2]string{{"MM", "ML"}, {"Mm", "Ml"}} {
+		aux, ok := r.Tag([]byte(names[0]))
+		if !ok {
+			continue
+		}
+		mm, _ = aux.Value().(string)
+		if aux, ok := r.Tag([]byte(names[1])); ok {
+			ml, _ = aux.Value().([]uint8)
+		}
+		return mm, ml
+	}
+	return "", nil
+}
+
+// modProbs returns the probabilities of modification m at the bases listed
+// in the MM tag mm with the ML tag ml. Each ML value v is the probability
+// (v+0.5)/256. Bases without an ML value are certainly modified.
+func modProbs(mm string, ml []uint8, m modCode) []float64 {
+	var probs []float64
+	off := 0
+	for _, entry := range strings.Split(mm, ";") {
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ",")
+		head := strings.TrimRight(fields[0], ".?")
+		n := len(fields) - 1
+		if len(head) < 3 {
+			continue
+		}
+		// The codes of an entry, e.g. mh in C+mh, share the positions and
+		// their ML values are interleaved.
+		codes := []string{head[2:]}
+		if !isDigits(head[2:]) {
+			codes = strings.Split(head[2:], "")
+		}
+		if head[0] == m.base && head[1] == m.strand {
+			for k, c := range codes {
+				if c != m.code {
+					continue
+				}
+				for i := 0; i < n; i++ {
+					j := off + i*len(codes) + k
+					if j >= len(ml) {
+						probs = append(probs, 1)
+						continue
+					}
+					probs = append(probs, (float64(ml[j])+0.5)/256)
+				}
+			}
+		}
+		off += n * len(codes)
+	}
+	return probs
+}
+
+// modArgs returns the modification and the optional probability threshold
+// of function name, e.g. mod_count('C+m', 0.8).
+func modArgs(name string, args []interface{}, threshold bool) (modCode, float64, error) {
+	switch {
+	case threshold && len(args) != 1 && len(args) != 2:
+		return modCode{}, 0, fmt.Errorf("%s expects 1 or 2 arguments, got %d", name, len(args))
+	case !threshold && len(args) != 1:
+		return modCode{}, 0, fmt.Errorf("%s expects 1 argument, got %d", name, len(args))
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return modCode{}, 0, fmt.Errorf("%s expects a base modification, e.g. 'C+m'", name)
+	}
+	m, err := parseModCode(s)
+	if err != nil {
+		return modCode{}, 0, err
+	}
+	p := defaultModProb
+	if len(args) == 2 {
+		switch v := args[1].(type) {
+		case float64:
+			p = v
+		case int64:
+			p = float64(v)
+		default:
+			return modCode{}, 0, fmt.Errorf("%s expects a probability threshold, got %v", name, args[1])
+		}
+		if p < 0 || p > 1 {
+			return modCode{}, 0, fmt.Errorf("%s expects a probability threshold in [0, 1], got %v", name, p)
+		}
+	}
+	return m, p, nil
+}
+
+// modCount returns a placeholderInt with the number of bases with
+// modification m at a probability of at least p, 0.5 if omitted, e.g.
+// mod_count('C+m') or mod_count('C+m', 0.8).
+func modCount(args []interface{}) (interface{}, error) {
+	m, p, err := modArgs("mod_count", args, true)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderInt(func(rec *sam.Record) int {
+		return countMods(rec, m, p)
+	}), nil
+}
+
+// hasMod returns a placeholderBool that is true if a base has modification m
+// at a probability of at least p, 0.5 if omitted, e.g. has_mod('A+a').
+func hasMod(args []interface{}) (interface{}, error) {
+	m, p, err := modArgs("has_mod", args, true)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderBool(func(rec *sam.Record) bool {
+		return countMods(rec, m, p) > 0
+	}), nil
+}
+
+// countMods returns the number of bases of rec with modification m at a
+// probability of at least p.
+func countMods(rec *sam.Record, m modCode, p float64) int {
+	mm, ml := modTags(rec)
+	n := 0
+	for _, prob := range modProbs(mm, ml, m) {
+		if prob >= p {
+			n++
+		}
+	}
+	return n
+}
+
+// meanModProb returns a placeholderFloat with the mean probability of
+// modification m at the bases listed for it in the MM tag, e.g.
+// mean_mod_prob('C+m'). It is zero if none are listed.
+func meanModProb(args []interface{}) (interface{}, error) {
+	m, _, err := modArgs("mean_mod_prob", args, false)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderFloat(func(rec *sam.Record) float32 {
+		mm, ml := modTags(rec)
+		probs := modProbs(mm, ml, m)
+		if len(probs) == 0 {
+			return 0
+		}
+		return float32(sumValues(probs) / float64(len(probs)))
+	}), nil
+}
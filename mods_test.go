@@ -0,0 +1,72 @@
+package samql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+func TestMods(t *testing.T) {
+	const data = "@SQ\tSN:chr1\tLN:45\n" +
+		"r001\t0\tchr1\t1\t30\t9M\t*\t0\t0\tACGCGACGA\t*\t" +
+		"MM:Z:C+mh?,0,1;A+a.,1;C-m,0;\tML:B:C,250,10,100,240,200,20\n" +
+		"r002\t0\tchr1\t1\t30\t3M\t*\t0\t0\tACG\t*\tMm:Z:C+m,0;\n" +
+		"r003\t0\tchr1\t1\t30\t3M\t*\t0\t0\tACG\t*\n"
+	sr, err := sam.NewReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := NewReader(sr).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Expr string
+		Want []interface{}
+	}{
+		// C+m is at 250 and 100, C+h at 10 and 240, A+a at 200 and C-m at
+		// 20.
+		{Expr: "mod_count('C+m')", Want: []interface{}{1, 1, 0}},
+		{Expr: "mod_count('C+m', 0.3)", Want: []interface{}{2, 1, 0}},
+		{Expr: "mod_count('C+h')", Want: []interface{}{1, 0, 0}},
+		{Expr: "mod_count('C-m', 0)", Want: []interface{}{1, 0, 0}},
+		{Expr: "mean_mod_prob('C+m')", Want: []interface{}{float32(351) / 512, float32(1), float32(0)}},
+		{Expr: "mean_mod_prob('A+a')", Want: []interface{}{float32(200.5) / 256, float32(0), float32(0)}},
+		{Expr: "has_mod('A+a')", Want: []interface{}{true, false, false}},
+		{Expr: "has_mod('A+a', 0.9)", Want: []interface{}{false, false, false}},
+		{Expr: "has_mod('T+g', 0)", Want: []interface{}{false, false, false}},
+	} {
+		expr, err := ql.NewParserFromStr(tt.Expr).ParseExpr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn, err := newValueFunc(expr, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Expr, err.Error())
+			continue
+		}
+		for i, rec := range records {
+			if got := fn(rec); got != tt.Want[i] {
+				t.Errorf("%s: %s: got %v want %v", tt.Expr, rec.Name, got, tt.Want[i])
+			}
+		}
+	}
+
+	for _, query := range []string{
+		"has_mod()",
+		"has_mod(QNAME)",
+		"has_mod('Cm')",
+		"has_mod('X+m')",
+		"has_mod('C+mh')",
+		"mod_count('C+m', 2) > 0",
+		"mod_count('C+m', 'a') > 0",
+		"mean_mod_prob('C+m', 0.5) > 0",
+	} {
+		if _, err := Where(query); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
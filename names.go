@@ -0,0 +1,125 @@
+package samql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// ReadNames reads a list of names, e.g. read names, from r. Each line has a
+// name in the first column. Empty lines and comments are skipped and a
+// leading @, as in FASTQ headers, is removed.
+func ReadNames(r io.Reader) ([]string, error) {
+	var names []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, strings.TrimPrefix(strings.Fields(line)[0], "@"))
+	}
+	return names, s.Err()
+}
+
+// ReadNamesFile reads the names of the file at path, which can be gzip
+// compressed.
+func ReadNamesFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		return nil, err
+	}
+	return ReadNames(r)
+}
+
+// NamesWhere returns a WHERE clause that matches the records with a QNAME in
+// the names file at path, e.g. "QNAME IN FILE('names.txt')". If mates is
+// true, the names are compared without their /1 or /2 mate suffixes, so that
+// both mates of a read pair match the name of either, e.g.
+// "pair_name(QNAME) IN FILE('names.txt')".
+func NamesWhere(path string, mates bool) string {
+	field := "QNAME"
+	if mates {
+		field = "pair_name(QNAME)"
+	}
+	return field + " IN " + (&ql.FileLiteral{Path: path}).String()
+}
+
+// nameSets caches the sets of the names files of IN FILE(...), so that each
+// file is read once, although filters are created for each input. A file is
+// read again if it is modified.
+var nameSets = struct {
+	sync.Mutex
+	sets map[namesKey]*namesFile
+}{sets: make(map[namesKey]*namesFile)}
+
+// namesKey identifies the set of a names file and whether its names are pair
+// names.
+type namesKey struct {
+	path  string
+	pairs bool
+}
+
+// namesFile is the set of the names of a file and its modification time.
+type namesFile struct {
+	set     map[string]struct{}
+	modTime time.Time
+}
+
+// nameSet returns the set of the names in the file at path. If pairs is
+// true, the set has the pair names of the names.
+func nameSet(path string, pairs bool) (map[string]struct{}, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	nameSets.Lock()
+	defer nameSets.Unlock()
+	key := namesKey{path: path, pairs: pairs}
+	if f, ok := nameSets.sets[key]; ok && f.modTime.Equal(fi.ModTime()) {
+		return f.set, nil
+	}
+	names, err := ReadNamesFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		if pairs {
+			n = pairName(n)
+		}
+		set[n] = struct{}{}
+	}
+	nameSets.sets[key] = &namesFile{set: set, modTime: fi.ModTime()}
+	return set, nil
+}
+
+// evalInFile returns a FilterFunc that checks whether the string value of a
+// is one of the names in the file at path. If pairs is true, a is a pair
+// name, e.g. pair_name(QNAME), and is compared to the pair names of the file.
+func evalInFile(a interface{}, path string, pairs bool) (FilterFunc, error) {
+	str, ok := a.(placeholderStr)
+	if !ok {
+		return nil, fmt.Errorf("IN FILE requires a string field, found %s", describe(a))
+	}
+	set, err := nameSet(path, pairs)
+	if err != nil {
+		return nil, err
+	}
+	return func(rec *sam.Record) bool {
+		_, ok := set[str(rec)]
+		return ok
+	}, nil
+}
@@ -0,0 +1,134 @@
+package samql
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/biogo/hts/sam"
+)
+
+const namesData = `# reads
+r001
+@r003	extra
+
+r006
+`
+
+func TestReadNames(t *testing.T) {
+	names, err := ReadNames(strings.NewReader(namesData))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	want := []string{"r001", "r003", "r006"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("names=%v want %v", names, want)
+	}
+}
+
+func TestInFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "samql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "names.txt")
+	if err := ioutil.WriteFile(path, []byte(namesData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gzPath := filepath.Join(dir, "names.txt.gz")
+	f, err := os.Create(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("r002\n"))
+	gz.Close()
+	f.Close()
+	matesPath := filepath.Join(dir, "mates.txt")
+	if err := ioutil.WriteFile(matesPath, []byte("r001/1\nr003/2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Query string
+		Names []string
+	}{
+		{Query: NamesWhere(path, false), Names: []string{"r001", "r003", "r001", "r006", "r006"}},
+		{Query: NamesWhere(path, false) + " AND MAPQ > 0", Names: []string{"r001", "r003", "r001"}},
+		{Query: "NOT " + NamesWhere(path, false), Names: []string{"r002", "r004", "r005"}},
+		{Query: NamesWhere(gzPath, false), Names: []string{"r002"}},
+		{Query: NamesWhere(matesPath, false), Names: nil},
+		{Query: NamesWhere(matesPath, true), Names: []string{"r001", "r003", "r001"}},
+		{Query: "NOT " + NamesWhere(matesPath, true), Names: []string{"r002", "r004", "r005", "r006", "r006"}},
+	} {
+		sr, err := sam.NewReader(strings.NewReader(samData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := Where(tt.Query)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", tt.Query, err)
+		}
+		r := NewReader(sr)
+		r.AppendFilter(filter)
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, rec := range records {
+			names = append(names, rec.Name)
+		}
+		if !reflect.DeepEqual(names, tt.Names) {
+			t.Errorf("%s: names=%v want %v", tt.Query, names, tt.Names)
+		}
+	}
+
+	// A modified file is read again.
+	if err := ioutil.WriteFile(path, []byte("r004\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	set, err := nameSet(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := set["r004"]; !ok || len(set) != 1 {
+		t.Errorf("modified file: names=%v want [r004]", set)
+	}
+
+	for _, q := range []string{
+		"MAPQ IN FILE('" + path + "')",
+		NamesWhere(filepath.Join(dir, "missing.txt"), false),
+	} {
+		if _, err := Where(q); err == nil {
+			t.Errorf("%s: expected error", q)
+		}
+	}
+}
+
+func TestPairName(t *testing.T) {
+	for _, tt := range []struct {
+		Name string
+		Want string
+	}{
+		{Name: "r001/1", Want: "r001"},
+		{Name: "r001/2", Want: "r001"},
+		{Name: "r001/3", Want: "r001/3"},
+		{Name: "r001", Want: "r001"},
+		{Name: "/1", Want: "/1"},
+	} {
+		if got := pairName(tt.Name); got != tt.Want {
+			t.Errorf("%s: pair name=%s want %s", tt.Name, got, tt.Want)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !race
+// +build !race
+
+package samql
+
+// raceEnabled is true if the tests run with the race detector.
+const raceEnabled = false
@@ -0,0 +1,241 @@
+package samql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/bamx"
+)
+
+// OpenOption is an option of OpenSAM, OpenBAM and OpenPath.
+type OpenOption func(*openOptions)
+
+// openOptions holds the options of the Open functions.
+type openOptions struct {
+	threads    int
+	index      io.Reader
+	where      string
+	filterOpts []FilterOption
+}
+
+// WithThreads sets the number of goroutines that decompress BAM data. The
+// default, 0, uses GOMAXPROCS, as bam.NewReader.
+func WithThreads(n int) OpenOption {
+	return func(o *openOptions) { o.threads = n }
+}
+
+// WithIndex sets the BAI or CSI index of the BAM data, e.g. for OpenBAM, or
+// for OpenPath to use an index other than the one next to the file.
+func WithIndex(r io.Reader) OpenOption {
+	return func(o *openOptions) { o.index = r }
+}
+
+// WithWhere filters the records by the SQL WHERE statement query, as
+// PlanHeader. Indexed BAM data is read only in the regions of query.
+func WithWhere(query string) OpenOption {
+	return func(o *openOptions) { o.where = query }
+}
+
+// WithFilterOptions sets the options of the filter of the WithWhere query,
+// e.g. WithFeatures.
+func WithFilterOptions(opts ...FilterOption) OpenOption {
+	return func(o *openOptions) { o.filterOpts = append(o.filterOpts, opts...) }
+}
+
+// OpenSAM returns a Reader of the SAM data of r, which can be compressed with
+// gzip or BGZF. Closing the Reader does not close r.
+func OpenSAM(r io.Reader, opts ...OpenOption) (*Reader, error) {
+	o := newOpenOptions(opts)
+	format, rd, err := DetectFormat(r)
+	if err != nil {
+		return nil, err
+	}
+	if format != SAM {
+		return nil, fmt.Errorf("samql: expected SAM data, found %s", format)
+	}
+	sr, err := sam.NewReader(rd)
+	if err != nil {
+		return nil, err
+	}
+	return o.filter(NewReader(sr), nil, "")
+}
+
+// OpenBAM returns a Reader of the BAM data of r. If an index is set with
+// WithIndex, only the regions of the WithWhere query are read. Closing the
+// Reader does not close r.
+func OpenBAM(r io.ReadSeeker, opts ...OpenOption) (*Reader, error) {
+	o := newOpenOptions(opts)
+	if o.index == nil {
+		br, err := NewBAMReader(r, o.threads)
+		if err != nil {
+			return nil, err
+		}
+		return o.filter(NewReader(br), nil, "")
+	}
+	bx, err := o.openIndexed(r)
+	if err != nil {
+		return nil, err
+	}
+	return o.filter(NewReader(bx), bx, "")
+}
+
+// OpenPath returns a Reader of the SAM or BAM file at path, or at an http,
+// https, s3 or gs URL as OpenRemote. The format is detected from the content
+// and the index of BAM files is found as OpenIndex, unless one is set with
+// WithIndex. The SOURCE and FILE keywords of the WithWhere query are bound to
+// the base name of path. Closing the Reader closes the file. As Open, the
+// path "-" corresponds to STDIN and names with other registered URL schemes
+// are opened with OpenSource, without an index.
+func OpenPath(path string, opts ...OpenOption) (*Reader, error) {
+	o := newOpenOptions(opts)
+	path = strings.TrimPrefix(path, "file://")
+	name := filepath.Base(path)
+	if path == "-" || (schemeOf(path) != "" && !IsRemote(path)) {
+		src, err := OpenSource(path)
+		if err != nil {
+			return nil, err
+		}
+		return o.filter(NewReader(src), nil, name)
+	}
+
+	var f interface {
+		io.ReadSeeker
+		io.Closer
+	}
+	var err error
+	if IsRemote(path) {
+		f, err = OpenRemote(path)
+	} else {
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	format, rd, err := DetectFormat(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	switch format {
+	case SAM:
+		sr, err := sam.NewReader(rd)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return o.filter(NewReader(&closerSource{readerSAM: sr, c: f}), nil, name)
+	case BAM:
+		if o.index == nil {
+			if idx, err := OpenIndex(path); err == nil {
+				defer idx.Close()
+				o.index = idx
+			}
+		}
+		if o.index == nil {
+			br, err := NewBAMReader(rd, o.threads)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			return o.filter(NewReader(&bamSource{BAMReader: br, f: f}), nil, name)
+		}
+		bx, err := o.openIndexed(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return o.filter(NewReader(&indexedSource{Reader: bx, f: f}), bx, name)
+	}
+	f.Close()
+	return nil, errFormat(path, format)
+}
+
+// newOpenOptions returns the options with opts applied.
+func newOpenOptions(opts []OpenOption) *openOptions {
+	o := &openOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// openIndexed returns an indexed BAM reader of r with the index of o.
+func (o *openOptions) openIndexed(r io.ReadSeeker) (*bamx.Reader, error) {
+	br, err := bam.NewReader(r, o.threads)
+	if err != nil {
+		return nil, err
+	}
+	bx, err := bamx.New(br, bufio.NewReader(o.index))
+	if err != nil {
+		br.Close()
+		return nil, err
+	}
+	return bx, nil
+}
+
+// filter appends the filter of the WHERE query of o, if any, to r, with the
+// keywords bound to input. If bx is the indexed reader of r, only the regions
+// of the query are read from it. r is closed if the query is invalid.
+func (o *openOptions) filter(r *Reader, bx *bamx.Reader, input string) (*Reader, error) {
+	if o.where == "" {
+		return r, nil
+	}
+	p, err := PlanHeader(o.where, input, r.Header(), o.filterOpts...)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	// Regions on unknown references cannot contain records and are
+	// skipped. The whole query is evaluated if no region is read.
+	filter := p.Filter
+	if bx != nil && p.UseIndex {
+		for _, reg := range p.Regions {
+			if bx.AddQuery(reg.Rname, reg.Start, reg.End) == nil {
+				filter = p.Residual
+			}
+		}
+	}
+	r.AppendFilter(filter)
+	return r, nil
+}
+
+// indexedSource is an indexed BAM reader that closes the file it reads from
+// on Close.
+type indexedSource struct {
+	*bamx.Reader
+	f io.Closer
+}
+
+// Close closes the indexed BAM reader and the underlying file.
+func (s *indexedSource) Close() error {
+	return multiCloser{s.Reader, s.f}.Close()
+}
+
+// OpenIndex opens the BAI or CSI index of the BAM file at path, or at a URL
+// as OpenRemote. The index is searched as path.bai, with the .bam extension
+// replaced by .bai, and then likewise with .csi. Local indexes are returned
+// as *os.File.
+func OpenIndex(path string) (io.ReadCloser, error) {
+	base := strings.TrimSuffix(path, ".bam")
+	var err error
+	for _, name := range []string{path + ".bai", base + ".bai", path + ".csi", base + ".csi"} {
+		if IsRemote(path) {
+			var f *RemoteFile
+			if f, err = OpenRemote(name); err == nil {
+				return f, nil
+			}
+			continue
+		}
+		var f *os.File
+		if f, err = os.Open(name); err == nil {
+			return f, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,132 @@
+package samql
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maragkakislab/samql/bamx"
+)
+
+func TestOpenPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "samql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := newBAM(t, samData, 1)
+	var idx bytes.Buffer
+	if err := bamx.WriteIndex(&idx, bytes.NewReader(data), false); err != nil {
+		t.Fatal(err)
+	}
+	samPath := filepath.Join(dir, "test.sam")
+	bamPath := filepath.Join(dir, "test.bam")
+	for path, b := range map[string][]byte{
+		samPath:          []byte(samData),
+		bamPath:          data,
+		bamPath + ".bai": idx.Bytes(),
+	} {
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const query = "RNAME = chr1 AND POS > 10 AND MAPQ = 30"
+	tests := []struct {
+		Test  string
+		Open  func() (*Reader, error)
+		Names []string
+		Err   bool
+	}{
+		{
+			Test: "SAM",
+			Open: func() (*Reader, error) {
+				return OpenSAM(strings.NewReader(samData), WithWhere(query))
+			},
+			Names: []string{"r003", "r001"},
+		},
+		{
+			Test: "BAM",
+			Open: func() (*Reader, error) {
+				return OpenBAM(bytes.NewReader(data), WithThreads(1))
+			},
+			Names: []string{"r001", "r002", "r003", "r001", "r004", "r005", "r006", "r006"},
+		},
+		{
+			Test: "IndexedBAM",
+			Open: func() (*Reader, error) {
+				return OpenBAM(bytes.NewReader(data), WithIndex(bytes.NewReader(idx.Bytes())),
+					WithWhere(query))
+			},
+			Names: []string{"r003", "r001"},
+		},
+		{
+			Test: "PathSAM",
+			Open: func() (*Reader, error) {
+				return OpenPath(samPath, WithWhere("SOURCE = 'test.sam' AND RNAME = chr2"))
+			},
+			Names: []string{"r004"},
+		},
+		{
+			Test: "PathBAM",
+			Open: func() (*Reader, error) {
+				return OpenPath(bamPath, WithWhere(query))
+			},
+			Names: []string{"r003", "r001"},
+		},
+		{
+			Test: "PathUnknownRef",
+			Open: func() (*Reader, error) {
+				return OpenPath(bamPath, WithWhere("RNAME = chr9"))
+			},
+		},
+		{
+			Test: "Missing",
+			Open: func() (*Reader, error) { return OpenPath(filepath.Join(dir, "missing.sam")) },
+			Err:  true,
+		},
+		{
+			Test: "NotSAM",
+			Open: func() (*Reader, error) { return OpenSAM(bytes.NewReader(data)) },
+			Err:  true,
+		},
+		{
+			Test: "InvalidWhere",
+			Open: func() (*Reader, error) { return OpenPath(bamPath, WithWhere("MAPQ >")) },
+			Err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Test, func(t *testing.T) {
+			r, err := tt.Open()
+			if tt.Err {
+				if err == nil {
+					r.Close()
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			recs, err := r.ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, rec := range recs {
+				names = append(names, rec.Name)
+			}
+			if strings.Join(names, " ") != strings.Join(tt.Names, " ") {
+				t.Errorf("expected records %v, got %v", tt.Names, names)
+			}
+			if err := r.Close(); err != nil {
+				t.Errorf("unexpected close error %v", err)
+			}
+		})
+	}
+}
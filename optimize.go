@@ -0,0 +1,261 @@
+package samql
+
+import (
+	"sort"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// optimize returns an expression that is equivalent to cond but is cheaper to
+// evaluate. Subexpressions of literals, e.g. 10 * 1000, are evaluated once and
+// replaced by their value, and the operands of AND and OR are ordered by their
+// estimated cost, so that cheap predicates, e.g. flag checks, decide the
+// result before expensive ones, e.g. regexes on SEQ, are evaluated. The
+// written order of the predicates does not matter, since evaluating them has
+// no side effects. cond is not modified.
+func optimize(cond ql.Expr) ql.Expr {
+	if cond == nil {
+		return nil
+	}
+	if lit, ok := fold(cond); ok {
+		return lit
+	}
+
+	switch e := cond.(type) {
+	case *ql.BinaryExpr:
+		if e.Op == ql.AND || e.Op == ql.OR {
+			return reorder(e.Op, operands(e, e.Op))
+		}
+		return &ql.BinaryExpr{Op: e.Op, LHS: optimize(e.LHS), RHS: optimize(e.RHS)}
+	case *ql.ParenExpr:
+		return &ql.ParenExpr{Expr: optimize(e.Expr)}
+	case *ql.NotExpr:
+		return &ql.NotExpr{Expr: optimize(e.Expr)}
+	case *ql.RangeExpr:
+		return &ql.RangeExpr{Lower: optimize(e.Lower), Upper: optimize(e.Upper)}
+	case *ql.Call:
+		c := *e
+		c.Args = make([]ql.Expr, len(e.Args))
+		for i, arg := range e.Args {
+			c.Args[i] = optimize(arg)
+		}
+		return &c
+	}
+	return cond
+}
+
+// operands returns the operands of the chain of op, AND or OR, in expr,
+// including those of nested chains in parentheses.
+func operands(expr ql.Expr, op ql.Token) []ql.Expr {
+	switch e := expr.(type) {
+	case *ql.ParenExpr:
+		if b, ok := e.Expr.(*ql.BinaryExpr); ok && b.Op == op {
+			return operands(b, op)
+		}
+	case *ql.BinaryExpr:
+		if e.Op == op {
+			return append(operands(e.LHS, op), operands(e.RHS, op)...)
+		}
+	}
+	return []ql.Expr{expr}
+}
+
+// reorder optimizes the operands of op, AND or OR, and combines them with op
+// in the order of their estimated cost. Operands of equal cost keep their
+// order.
+func reorder(op ql.Token, exprs []ql.Expr) ql.Expr {
+	costs := make([]int, len(exprs))
+	for i, e := range exprs {
+		exprs[i] = optimize(e)
+		costs[i] = cost(exprs[i])
+	}
+	sort.Stable(byCost{exprs, costs})
+
+	expr := exprs[0]
+	for _, e := range exprs[1:] {
+		expr = &ql.BinaryExpr{Op: op, LHS: expr, RHS: e}
+	}
+	return expr
+}
+
+// byCost sorts expressions by their costs.
+type byCost struct {
+	exprs []ql.Expr
+	costs []int
+}
+
+func (s byCost) Len() int           { return len(s.exprs) }
+func (s byCost) Less(i, j int) bool { return s.costs[i] < s.costs[j] }
+func (s byCost) Swap(i, j int) {
+	s.exprs[i], s.exprs[j] = s.exprs[j], s.exprs[i]
+	s.costs[i], s.costs[j] = s.costs[j], s.costs[i]
+}
+
+// fold returns the literal value of expr if it is an operation on literals,
+// e.g. 10 * 1000 or NOT TRUE. The operation is evaluated as in a filter, so
+// the value has the same type, e.g. a division is a float.
+func fold(expr ql.Expr) (ql.Expr, bool) {
+	switch expr.(type) {
+	case *ql.BinaryExpr, *ql.NotExpr, *ql.ParenExpr:
+	default:
+		return nil, false
+	}
+	constant := true
+	ql.WalkFunc(expr, func(n ql.Node) bool {
+		switch n.(type) {
+		case *ql.VarRef, *ql.Call, *ql.IndexExpr, *ql.ListLiteral, *ql.FileLiteral, *ql.NilLiteral:
+			constant = false
+		}
+		return constant
+	})
+	if !constant {
+		return nil, false
+	}
+
+	// Operations that are invalid are not folded, so that they are
+	// reported when the filter is built.
+	v := evalVisitor{}
+	ql.Walk(&v, expr)
+	if v.err != nil || len(v.nodes) != 1 {
+		return nil, false
+	}
+	// The placeholders of literals do not read the record.
+	switch val := v.nodes[0].(type) {
+	case bool:
+		return &ql.BooleanLiteral{Val: val}, true
+	case FilterFunc:
+		return &ql.BooleanLiteral{Val: val(nil)}, true
+	case placeholderBool:
+		return &ql.BooleanLiteral{Val: val(nil)}, true
+	case placeholderInt:
+		return &ql.IntegerLiteral{Val: int64(val(nil))}, true
+	case placeholderFloat:
+		return &ql.NumberLiteral{Val: float64(val(nil))}, true
+	}
+	return nil, false
+}
+
+// The estimated costs of evaluating the parts of an expression for a record.
+const (
+	// costField is the cost of a field that is stored in the record, e.g.
+	// FLAG or MAPQ, and of an operator.
+	costField = 1
+	// costString is the cost of a string field or of a keyword that is
+	// computed from the fields, e.g. RNAME or END.
+	costString = 2
+	// costTag is the cost of looking up an aux tag.
+	costTag = 8
+	// costCall is the cost of a function call, besides its arguments.
+	costCall = 8
+	// costSeq is the cost of a field that is decoded into a new string,
+	// e.g. SEQ.
+	costSeq = 16
+	// costRegex is the cost of matching a regex.
+	costRegex = 32
+)
+
+// fieldCosts are the costs of the keywords that do not cost costString.
+var fieldCosts = map[string]int{
+	"FLAG":    costField,
+	"POS":     costField,
+	"MAPQ":    costField,
+	"PNEXT":   costField,
+	"TLEN":    costField,
+	"ABSTLEN": costField,
+
+	"RG":         costTag,
+	"UMI":        costTag,
+	"MISMATCHES": costTag,
+	"IDENTITY":   costTag,
+	"HAPLOTYPE":  costTag,
+	"PHASESET":   costTag,
+
+	"SEQ":  costSeq,
+	"QUAL": costSeq,
+}
+
+// cost returns the estimated cost of evaluating expr for a record.
+func cost(expr ql.Expr) int {
+	// Flag tests cost as much as a flag keyword, so that they are kept
+	// together and combined into bit masks.
+	if _, _, ok := flagTest(expr, nil); ok {
+		return costField
+	}
+	c := 0
+	ql.WalkFunc(expr, func(n ql.Node) bool {
+		switch n := n.(type) {
+		case *ql.VarRef:
+			c += fieldCost(n.Val)
+		case *ql.BinaryExpr:
+			c += costField
+		case *ql.Call:
+			c += costCall
+		case *ql.RegexLiteral:
+			c += costRegex
+		}
+		return true
+	})
+	return c
+}
+
+// fieldCost returns the cost of the keyword or tag name. Other names are
+// values, e.g. chr1, and cost nothing.
+func fieldCost(name string) int {
+	if c, ok := fieldCosts[name]; ok {
+		return c
+	}
+	if p, ok := getPlaceholder[name]; ok {
+		// The flag keywords cost as much as FLAG.
+		if _, ok := p.(placeholderBool); ok {
+			return costField
+		}
+		return costString
+	}
+	if validTag.MatchString(name) {
+		return costTag
+	}
+	return 0
+}
+
+// logical returns the combination of the booleans a and b with op, AND or
+// OR, and true if both are booleans. The FilterFunc evaluates b only if a
+// does not decide the result. An operand that is constant is not evaluated
+// for each record: it either decides the result or the result is the other
+// operand.
+func logical(a, b interface{}, op ql.Token) (interface{}, bool) {
+	af, aok := boolOperand(a)
+	bf, bok := boolOperand(b)
+	if !aok || !bok {
+		return nil, false
+	}
+
+	// The result when an operand is c, i.e. false for AND and true for OR.
+	c := op == ql.OR
+	switch {
+	case af == nil && a.(bool) == c, bf == nil && b.(bool) == c:
+		return c, true
+	case af == nil:
+		return b, true
+	case bf == nil:
+		return a, true
+	}
+	if op == ql.AND {
+		return FilterFunc(func(rec *sam.Record) bool { return af(rec) && bf(rec) }), true
+	}
+	return FilterFunc(func(rec *sam.Record) bool { return af(rec) || bf(rec) }), true
+}
+
+// boolOperand returns the function of a boolean placeholder or FilterFunc
+// v. It returns nil for a constant boolean and false if v is not boolean.
+func boolOperand(v interface{}) (func(*sam.Record) bool, bool) {
+	switch v := v.(type) {
+	case FilterFunc:
+		return v, true
+	case placeholderBool:
+		return v, true
+	case bool:
+		return nil, true
+	}
+	return nil, false
+}
@@ -0,0 +1,182 @@
+package samql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+func TestOptimize(t *testing.T) {
+	for _, tt := range []struct {
+		Query string
+		Want  string
+	}{
+		{
+			Query: "SEQ =~ /GATA/ AND NM:i = 1 AND MAPQ > 10 AND PAIRED",
+			Want:  "PAIRED AND MAPQ > 10 AND NM:i = 1 AND SEQ =~ /GATA/",
+		},
+		{
+			Query: "(QNAME = r001 OR FLAG = 0) AND NOT (1 + 1 > 3)",
+			Want:  "true AND (FLAG = 0 OR QNAME = r001)",
+		},
+		{
+			Query: "length(SEQ) > 10 AND (RNAME = chr1 AND (MAPQ > 10 OR has(NM)))",
+			Want:  "RNAME = chr1 AND (MAPQ > 10 OR has(NM)) AND length(SEQ) > 10",
+		},
+		{
+			Query: "POS > 10 * 1000 AND POS BETWEEN 2 * 5 AND 10 + 5",
+			Want:  "POS > 10000 AND POS BETWEEN 10 AND 15",
+		},
+		{
+			Query: "MAPQ > 10 AND FLAG & 4 = 0 AND PAIRED",
+			Want:  "FLAG & 4 = 0 AND PAIRED AND MAPQ > 10",
+		},
+		{Query: "MAPQ > 10 / 4", Want: "MAPQ > 2.500"},
+		{Query: "MAPQ > 10 AND MAPQ < 20", Want: "MAPQ > 10 AND MAPQ < 20"},
+		{Query: "1 = 1 OR QNAME = r001", Want: "1 = 1 OR QNAME = r001"},
+	} {
+		stmt, err := parseWhere(tt.Query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := optimize(stmt.Condition).String(); got != tt.Want {
+			t.Errorf("%s: optimized=%s want %s", tt.Query, got, tt.Want)
+		}
+		if got := stmt.Condition.String(); got != strings.Replace(tt.Query, "'", "", -1) {
+			t.Errorf("%s: condition modified to %s", tt.Query, got)
+		}
+	}
+}
+
+func TestOptimizeEquivalent(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := NewReader(sr).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{
+		"SEQ =~ /GATA/ AND MAPQ > 10 AND NOT PAIRED",
+		"(NM:i = 1 OR FLAG & 64 = 64) AND POS < 2 * 20",
+		"CIGAR =~ /N/ OR REVERSE OR NM:i > 100 * 100",
+		"POS BETWEEN 4 + 4 AND 50 / 2 AND QNAME != r002",
+		"TRUE AND (FALSE OR UNMAPPED)",
+		"NOT (TRUE AND FALSE) AND has(MD) AND length(SEQ) > 5",
+		"rand() < 0.5 AND MAPQ >= 30",
+	} {
+		stmt, err := parseWhere(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The filter of the condition as written.
+		v := evalVisitor{}
+		ql.Walk(&v, stmt.Condition)
+		if v.err != nil {
+			t.Fatalf("%s: unexpected error %q", q, v.err)
+		}
+		var want FilterFunc
+		switch val := v.nodes[0].(type) {
+		case FilterFunc:
+			want = val
+		case placeholderBool:
+			want = FilterFunc(val)
+		default:
+			t.Fatalf("%s: not a filter", q)
+		}
+		got, err := Where(q)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", q, err)
+		}
+		for _, rec := range records {
+			if got(rec) != want(rec) {
+				t.Errorf("%s: %s: match=%v want %v", q, rec.Name, got(rec), want(rec))
+			}
+		}
+	}
+}
+
+func TestLogical(t *testing.T) {
+	var calls int
+	yes := FilterFunc(func(*sam.Record) bool { calls++; return true })
+	no := placeholderBool(func(*sam.Record) bool { calls++; return false })
+
+	for _, tt := range []struct {
+		A, B  interface{}
+		Op    ql.Token
+		Want  bool
+		Calls int
+	}{
+		{A: no, B: yes, Op: ql.AND, Want: false, Calls: 1},
+		{A: yes, B: no, Op: ql.AND, Want: false, Calls: 2},
+		{A: yes, B: no, Op: ql.OR, Want: true, Calls: 1},
+		{A: no, B: yes, Op: ql.OR, Want: true, Calls: 2},
+		{A: yes, B: false, Op: ql.AND, Want: false, Calls: 0},
+		{A: true, B: no, Op: ql.AND, Want: false, Calls: 1},
+		{A: no, B: true, Op: ql.OR, Want: true, Calls: 0},
+		{A: false, B: yes, Op: ql.OR, Want: true, Calls: 1},
+	} {
+		calls = 0
+		val, ok := logical(tt.A, tt.B, tt.Op)
+		if !ok {
+			t.Fatalf("%s: expected booleans", tt.Op)
+		}
+		var got bool
+		switch val := val.(type) {
+		case bool:
+			got = val
+		case FilterFunc:
+			got = val(nil)
+		case placeholderBool:
+			got = val(nil)
+		default:
+			t.Fatalf("%s: unexpected value %T", tt.Op, val)
+		}
+		if got != tt.Want || calls != tt.Calls {
+			t.Errorf("%s %s %s: value=%v calls=%d want %v and %d", describe(tt.A), tt.Op,
+				describe(tt.B), got, calls, tt.Want, tt.Calls)
+		}
+	}
+
+	if _, ok := logical(placeholderInt(nil), true, ql.AND); ok {
+		t.Errorf("integer: expected not boolean")
+	}
+}
+
+func BenchmarkOptimize(b *testing.B) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		b.Fatal(err)
+	}
+	records, err := NewReader(sr).ReadAll()
+	if err != nil {
+		b.Fatal(err)
+	}
+	stmt, err := parseWhere("QUAL =~ /I+/ AND NM:i = 1 AND UNMAPPED")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bb := range []struct {
+		Name string
+		Cond ql.Expr
+	}{
+		{Name: "Written", Cond: stmt.Condition},
+		{Name: "Optimized", Cond: optimize(stmt.Condition)},
+	} {
+		v := evalVisitor{}
+		ql.Walk(&v, bb.Cond)
+		filter := v.nodes[0].(FilterFunc)
+		b.Run(bb.Name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, rec := range records {
+					filter(rec)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package samql
+
+// FilterOption is an option of the filters that are compiled from queries,
+// e.g. by Where, Compile, PlanHeader or NewQuery. Options are resolved when
+// a filter is created, so filters with different options can be used
+// concurrently.
+type FilterOption func(*filterOptions)
+
+// filterOptions holds the options of the filters of a query.
+type filterOptions struct {
+	features *FeatureSet
+	sites    *SiteSet
+	seed     int64
+	umiTags  []string
+}
+
+// optionsVar is the key of the variables of a query that holds its
+// filterOptions. It is not a valid keyword, so queries cannot refer to it.
+const optionsVar = ""
+
+// withOptions returns a copy of vars that holds the filterOptions of opts or
+// vars itself if opts is empty. The UMI keyword is bound to the tags of
+// WithUMITags.
+func withOptions(vars map[string]interface{}, opts []FilterOption) map[string]interface{} {
+	if len(opts) == 0 {
+		return vars
+	}
+	o := &filterOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	res := make(map[string]interface{}, len(vars)+1)
+	for name, val := range vars {
+		res[name] = val
+	}
+	res[optionsVar] = o
+	if o.umiTags != nil {
+		res["UMI"] = newUMI(o.umiTags)
+	}
+	return res
+}
+
+// optionsOf returns the filterOptions held by vars or the default options.
+func optionsOf(vars map[string]interface{}) *filterOptions {
+	if o, ok := vars[optionsVar].(*filterOptions); ok {
+		return o
+	}
+	return &filterOptions{}
+}
@@ -0,0 +1,182 @@
+package samql
+
+import (
+	"io"
+	"sort"
+
+	"github.com/biogo/hts/sam"
+)
+
+// PairPolicy defines how the filter results of the two mates of a pair are
+// combined.
+type PairPolicy int
+
+const (
+	// EitherMate keeps both mates if either mate passes the filter.
+	EitherMate PairPolicy = iota
+	// BothMates keeps both mates only if both mates pass the filter.
+	BothMates
+)
+
+// PairReader is a SAM reader that applies a filter to read pairs instead of
+// single records. Primary alignments of paired records are buffered by QNAME
+// until their mate is read and both mates are returned together, in the
+// order they were read, if the pair passes the filter according to the
+// policy. All other records, i.e. unpaired, secondary or supplementary ones,
+// are filtered individually. Records whose mate is never read, e.g. because
+// it is outside the regions read from an index, are returned at the end if
+// they pass the filter. Memory use grows with the number of pairs whose mates
+// are far apart in the input. If Fetch is set, the mates that are never read
+// are fetched with Fetch instead.
+type PairReader struct {
+	// Fetch returns the mate of a record, e.g. with an index lookup. It is
+	// optional.
+	Fetch FetchFunc
+	// FetchFilter is applied to the fetched mates instead of the filter of
+	// the pairs, if set. Fetched mates are not read through the regions of
+	// an index, so they need the full query rather than its residual. It is
+	// optional.
+	FetchFilter FilterFunc
+
+	r       readerSAM
+	filter  FilterFunc
+	policy  PairPolicy
+	pending map[string]*pendingMate
+	n       int // Number of records read, used to order pending records.
+	out     []*sam.Record
+	err     error
+}
+
+// FetchFunc returns the mate of rec. It returns nil if the mate is not found.
+type FetchFunc func(rec *sam.Record) (*sam.Record, error)
+
+// pendingMate is a primary alignment that waits for its mate.
+type pendingMate struct {
+	rec    *sam.Record
+	passed bool
+	n      int
+}
+
+// PairAware returns a new PairReader that reads from r and applies filter to
+// read pairs according to policy.
+func PairAware(r readerSAM, filter FilterFunc, policy PairPolicy) *PairReader {
+	return &PairReader{
+		r:       r,
+		filter:  filter,
+		policy:  policy,
+		pending: make(map[string]*pendingMate),
+	}
+}
+
+// Header returns the Header of the underlying reader.
+func (p *PairReader) Header() *sam.Header {
+	return p.r.Header()
+}
+
+// Read returns the next *sam.Record that passes the filter, or whose mate
+// passes the filter. Returns nil and io.EOF when the underlying reader is
+// exhausted.
+func (p *PairReader) Read() (*sam.Record, error) {
+	for len(p.out) == 0 {
+		if p.err != nil {
+			return nil, p.err
+		}
+		rec, err := p.r.Read()
+		if err != nil {
+			p.err = err
+			if err == io.EOF {
+				if ferr := p.flush(); ferr != nil {
+					p.err = ferr
+				}
+			}
+			continue
+		}
+		p.add(rec)
+	}
+	rec := p.out[0]
+	p.out = p.out[1:]
+	return rec, nil
+}
+
+// add applies the filter to rec and queues rec and its mate, if any, for
+// output.
+func (p *PairReader) add(rec *sam.Record) {
+	p.n++
+	passed := p.filter(rec)
+	if !isPrimaryPaired(rec) {
+		if passed {
+			p.out = append(p.out, rec)
+		}
+		return
+	}
+
+	mate, ok := p.pending[rec.Name]
+	if !ok {
+		pm := &pendingMate{rec: rec, passed: passed, n: p.n}
+		if !passed && p.policy == BothMates {
+			pm.rec = nil // The pair cannot pass; only the name is kept.
+		}
+		p.pending[rec.Name] = pm
+		return
+	}
+	delete(p.pending, rec.Name)
+
+	keep := mate.passed || passed
+	if p.policy == BothMates {
+		keep = mate.passed && passed
+	}
+	if keep {
+		p.out = append(p.out, mate.rec, rec)
+	}
+}
+
+// flush queues the pending records that pass the filter in the order they
+// were read. If Fetch is set, the mates of the pending records are fetched
+// and the pairs are queued according to the policy.
+func (p *PairReader) flush() error {
+	pms := make([]*pendingMate, 0, len(p.pending))
+	for _, pm := range p.pending {
+		if pm.passed {
+			pms = append(pms, pm)
+		}
+	}
+	p.pending = make(map[string]*pendingMate)
+	sort.Slice(pms, func(i, j int) bool { return pms[i].n < pms[j].n })
+
+	filter := p.filter
+	if p.FetchFilter != nil {
+		filter = p.FetchFilter
+	}
+	for _, pm := range pms {
+		if p.Fetch == nil {
+			p.out = append(p.out, pm.rec)
+			continue
+		}
+		mate, err := p.Fetch(pm.rec)
+		if err != nil {
+			return err
+		}
+		switch {
+		case mate == nil:
+			p.out = append(p.out, pm.rec)
+		case p.policy == EitherMate || filter(mate):
+			p.out = append(p.out, pm.rec, mate)
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying reader if it implements io.Closer.
+func (p *PairReader) Close() error {
+	if c, ok := p.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// isPrimaryPaired returns true if rec is the primary alignment of a paired
+// record.
+func isPrimaryPaired(rec *sam.Record) bool {
+	return rec.Flags&sam.Paired != 0 &&
+		rec.Flags&(sam.Secondary|sam.Supplementary) == 0
+}
@@ -0,0 +1,111 @@
+package samql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestPairAware(t *testing.T) {
+	for _, tt := range []struct {
+		Test   string
+		Input  string // Filter applied before pairing, e.g. to drop mates.
+		Query  string
+		Policy PairPolicy
+		Names  string
+	}{
+		{Test: "EitherFirst", Query: "POS = 6", Policy: EitherMate, Names: "r001,r001"},
+		{Test: "BothFirst", Query: "POS = 6", Policy: BothMates, Names: ""},
+		{Test: "EitherSecond", Query: "QNAME = r006 AND READ2", Policy: EitherMate, Names: "r006,r006"},
+		{Test: "BothSecond", Query: "QNAME = r006 AND READ2", Policy: BothMates, Names: ""},
+		{Test: "BothPass", Query: "UNMAPPED", Policy: BothMates, Names: "r006,r006"},
+		{Test: "Order", Query: "RNAME = chr1", Policy: EitherMate, Names: "r002,r003,r001,r001"},
+		{Test: "Unpaired", Query: "SECONDARY OR QNAME = r005", Policy: BothMates, Names: "r004,r005"},
+		{Test: "MissingMate", Input: "POS < 30", Query: "RNAME = chr1", Policy: BothMates, Names: "r002,r003,r001"},
+	} {
+		p := PairAware(newTestReader(t, tt.Input), Must(Where(tt.Query)), tt.Policy)
+		if got := readQnames(t, p); got != tt.Names {
+			t.Errorf("%s: records=%s want %s", tt.Test, got, tt.Names)
+		}
+		if err := p.Close(); err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+	}
+}
+
+func TestPairAwareFetch(t *testing.T) {
+	recs, err := newTestReader(t, "").ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Mates are fetched from all records, as with an index.
+	fetch := func(rec *sam.Record) (*sam.Record, error) {
+		for _, m := range recs {
+			if m.Name == rec.Name && m.Pos == rec.MatePos && m != rec {
+				return m, nil
+			}
+		}
+		return nil, nil
+	}
+
+	for _, tt := range []struct {
+		Test   string
+		Query  string
+		Policy PairPolicy
+		Names  string
+	}{
+		{Test: "Either", Query: "RNAME = chr1", Policy: EitherMate, Names: "r002,r003,r001,r001"},
+		{Test: "BothPass", Query: "RNAME = chr1", Policy: BothMates, Names: "r002,r003,r001,r001"},
+		{Test: "BothFail", Query: "POS < 30", Policy: BothMates, Names: "r002,r003,r006,r006"},
+	} {
+		// Records after position 30 are not read, as with a region query.
+		p := PairAware(newTestReader(t, "POS < 30"), Must(Where(tt.Query)), tt.Policy)
+		p.Fetch = fetch
+		if got := readQnames(t, p); got != tt.Names {
+			t.Errorf("%s: records=%s want %s", tt.Test, got, tt.Names)
+		}
+	}
+}
+
+func TestPairAwareFetchFilter(t *testing.T) {
+	r := newTestReader(t, "POS < 30")
+	chr2 := r.Header().Refs()[1]
+	// The mate of r001 is fetched from another reference, outside the
+	// regions of the index.
+	fetch := func(rec *sam.Record) (*sam.Record, error) {
+		if rec.Name != "r001" {
+			return nil, nil
+		}
+		m := *rec
+		m.Ref, m.Pos, m.Flags = chr2, rec.MatePos, sam.Paired|sam.Read2
+		return &m, nil
+	}
+
+	for _, tt := range []struct {
+		Test  string
+		Fetch FilterFunc
+		Names string
+	}{
+		{Test: "Residual", Names: "r002,r003,r001,r001"},
+		{Test: "Full", Fetch: Must(Where("RNAME = chr1 AND MAPQ = 30")), Names: "r002,r003"},
+	} {
+		// The residual filter of "RNAME = chr1 AND MAPQ = 30" on the
+		// records of chr1.
+		p := PairAware(newTestReader(t, "POS < 30"), Must(Where("MAPQ = 30")), BothMates)
+		p.Fetch = fetch
+		p.FetchFilter = tt.Fetch
+		if got := readQnames(t, p); got != tt.Names {
+			t.Errorf("%s: records=%s want %s", tt.Test, got, tt.Names)
+		}
+	}
+}
+
+// readQnames returns the comma separated names of all records read from r.
+func readQnames(t *testing.T, r readerSAM) string {
+	names := readNames(t, r)
+	for i := range names {
+		names[i] = names[i][:strings.Index(names[i], ":")]
+	}
+	return strings.Join(names, ",")
+}
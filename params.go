@@ -0,0 +1,130 @@
+package samql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/maragkakislab/samql/ql"
+)
+
+// WhereParams is similar to Where but additionally replaces the bound
+// parameters of query, e.g. $minq in "MAPQ > $minq", with the values in
+// params. Values can be integers, floats, strings or booleans and are never
+// parsed as part of the query, so they need not be escaped. As for Where,
+// opts configure the functions of query.
+func WhereParams(query string, params map[string]interface{}, opts ...FilterOption) (FilterFunc, error) {
+	params, err := normalizeParams(params)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := parseWhereParams(query, params)
+	if err != nil {
+		return nil, err
+	}
+	vars := withOptions(nil, opts)
+	filter, err := newFilter(stmt.Condition, vars)
+	if err != nil {
+		return nil, err
+	}
+	return withSample(filter, stmt.Sample, optionsOf(vars).seed), nil
+}
+
+// BindParams returns query with its bound parameters, e.g. $minq, replaced
+// by the literals of the values in params. Strings are quoted and escaped.
+// Unlike WhereParams, the returned query can be used wherever a query string
+// is expected, e.g. a SELECT statement for NewQuery.
+func BindParams(query string, params map[string]interface{}) (string, error) {
+	params, err := normalizeParams(params)
+	if err != nil {
+		return "", err
+	}
+
+	// The start of each token is used to find the end of the previous one.
+	type token struct {
+		tok ql.Token
+		pos ql.Pos
+		lit string
+	}
+	var tokens []token
+	s := ql.NewScanner(strings.NewReader(query))
+	for {
+		tok, pos, lit := s.Scan()
+		if tok == ql.EOF {
+			break
+		}
+		tokens = append(tokens, token{tok, pos, lit})
+	}
+
+	lines := strings.Split(query, "\n")
+	runes := make([][]rune, len(lines))
+	for i, l := range lines {
+		runes[i] = []rune(l)
+	}
+	offset := func(pos ql.Pos) int {
+		n := 0
+		for _, l := range runes[:pos.Line] {
+			n += len(l) + 1
+		}
+		return n + pos.Char
+	}
+
+	src := []rune(query)
+	var b strings.Builder
+	last := 0
+	for i, t := range tokens {
+		if t.tok != ql.BOUNDPARAM {
+			continue
+		}
+		k := strings.TrimPrefix(t.lit, "$")
+		v, ok := params[k]
+		if !ok {
+			return "", fmt.Errorf("samql: missing parameter: %s", k)
+		}
+		start, end := offset(t.pos), len(src)
+		if i+1 < len(tokens) {
+			end = offset(tokens[i+1].pos)
+		}
+		b.WriteString(string(src[last:start]))
+		b.WriteString(paramLiteral(v))
+		last = end
+	}
+	b.WriteString(string(src[last:]))
+	return b.String(), nil
+}
+
+// normalizeParams returns a copy of params with all integer and float values
+// converted to the int64 and float64 values of bound parameters.
+func normalizeParams(params map[string]interface{}) (map[string]interface{}, error) {
+	norm := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		switch v := v.(type) {
+		case int:
+			norm[k] = int64(v)
+		case int32:
+			norm[k] = int64(v)
+		case int64, float64, string, bool:
+			norm[k] = v
+		case float32:
+			norm[k] = float64(v)
+		default:
+			return nil, fmt.Errorf("samql: parameter %s has unsupported type %T", k, v)
+		}
+	}
+	return norm, nil
+}
+
+// paramLiteral returns the query literal of the normalized parameter v.
+func paramLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return (&ql.StringLiteral{Val: v}).String()
+	case bool:
+		return (&ql.BooleanLiteral{Val: v}).String()
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package samql
+
+import (
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestWhereParams(t *testing.T) {
+	ref, err := sam.NewReference("chr1", "", "", 1000, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &sam.Record{Name: "it's", Ref: ref, Pos: 10, MapQ: 30}
+
+	for _, tt := range []struct {
+		Query  string
+		Params map[string]interface{}
+		Want   bool
+	}{
+		{Query: "MAPQ > $minq", Params: map[string]interface{}{"minq": 20}, Want: true},
+		{Query: "MAPQ > $minq", Params: map[string]interface{}{"minq": int64(30)}, Want: false},
+		{Query: "MAPQ > $minq", Params: map[string]interface{}{"minq": 29.5}, Want: true},
+		{Query: "QNAME = $name", Params: map[string]interface{}{"name": "it's"}, Want: true},
+		{Query: "QNAME = $name", Params: map[string]interface{}{"name": "x' OR MAPQ > 0 OR QNAME = 'x"}, Want: false},
+		{Query: "RNAME = $ref AND POS >= $pos", Params: map[string]interface{}{"ref": "chr1", "pos": 10}, Want: true},
+	} {
+		filter, err := WhereParams(tt.Query, tt.Params)
+		if err != nil {
+			t.Errorf("%s %v: unexpected error %v", tt.Query, tt.Params, err)
+			continue
+		}
+		if got := filter(rec); got != tt.Want {
+			t.Errorf("%s %v: filter=%t want %t", tt.Query, tt.Params, got, tt.Want)
+		}
+
+		// The bound query must match the same records.
+		q, err := BindParams(tt.Query, tt.Params)
+		if err != nil {
+			t.Errorf("%s %v: unexpected error %v", tt.Query, tt.Params, err)
+			continue
+		}
+		filter, err = Where(q)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", q, err)
+			continue
+		}
+		if got := filter(rec); got != tt.Want {
+			t.Errorf("%s: filter=%t want %t", q, got, tt.Want)
+		}
+	}
+
+	if _, err := WhereParams("MAPQ > $minq", nil); err == nil {
+		t.Error("expected error for missing parameter")
+	}
+	if _, err := WhereParams("MAPQ > $minq", map[string]interface{}{"minq": []int{1}}); err == nil {
+		t.Error("expected error for unsupported parameter type")
+	}
+}
+
+func TestBindParams(t *testing.T) {
+	params := map[string]interface{}{"minq": 30, "ref": "chr'1", "f": 0.5, "b": true}
+	for _, tt := range []struct {
+		Query string
+		Want  string
+	}{
+		{Query: "MAPQ > 10", Want: "MAPQ > 10"},
+		{Query: "MAPQ > $minq", Want: "MAPQ > 30"},
+		{Query: "MAPQ>$minq AND\n  RNAME = $ref", Want: `MAPQ>30 AND` + "\n" + `  RNAME = 'chr\'1'`},
+		{Query: "SELECT QNAME FROM x WHERE rand() < $f AND PAIRED = $b", Want: "SELECT QNAME FROM x WHERE rand() < 0.5 AND PAIRED = true"},
+	} {
+		q, err := BindParams(tt.Query, params)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.Query, err)
+			continue
+		}
+		if q != tt.Want {
+			t.Errorf("%s: query=%q want %q", tt.Query, q, tt.Want)
+		}
+	}
+	if _, err := BindParams("MAPQ > $q", params); err == nil {
+		t.Error("expected error for missing parameter")
+	}
+}
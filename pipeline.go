@@ -0,0 +1,159 @@
+package samql
+
+import (
+	"io"
+	"sync"
+
+	"github.com/biogo/hts/sam"
+)
+
+// DefaultBatchSize is the default number of records that are filtered
+// together by a Pipeline worker.
+const DefaultBatchSize = 1024
+
+// Pipeline is a filtering-enabled SAM reader that evaluates the filters of a
+// Reader on a pool of worker goroutines. Records are read in batches from the
+// underlying reader of the Reader, the batches are filtered concurrently and
+// the records that pass the filters are returned in input order. A Pipeline is
+// useful for CPU heavy filters, e.g. regular expressions on SEQ. Filters must
+// be safe for concurrent use; all filters provided by samql are.
+type Pipeline struct {
+	r         *Reader
+	BatchSize int
+
+	once  sync.Once
+	wg    sync.WaitGroup
+	order chan *batch
+	done  chan struct{}
+	cur   *batch
+}
+
+// batch is a group of records that is filtered by a single worker. out
+// receives the batch once it is filtered.
+type batch struct {
+	recs []*sam.Record
+	err  error
+	out  chan *batch
+}
+
+// NewPipeline returns a new Pipeline that reads from r and evaluates the
+// filters of r on workers goroutines. If workers is less than 1 a single
+// worker is used. Filters should be appended to r before reading. The
+// OnProgress function of r is not called, as records are read from the
+// underlying reader of r, but corrupt input errors are handled by r, e.g.
+// with its OnCorrupt function.
+func NewPipeline(r *Reader, workers int) *Pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pipeline{
+		r:         r,
+		BatchSize: DefaultBatchSize,
+		order:     make(chan *batch, workers),
+		done:      make(chan struct{}),
+	}
+}
+
+// Header returns the Header of the underlying reader.
+func (p *Pipeline) Header() *sam.Header {
+	return p.r.Header()
+}
+
+// Read returns the next *sam.Record that passes all filters. Returns nil and
+// io.EOF when the underlying reader is exhausted.
+func (p *Pipeline) Read() (*sam.Record, error) {
+	p.once.Do(p.start)
+	for {
+		if p.cur == nil {
+			b, ok := <-p.order
+			if !ok {
+				return nil, io.EOF
+			}
+			p.cur = <-b.out
+		}
+		if len(p.cur.recs) > 0 {
+			rec := p.cur.recs[0]
+			p.cur.recs = p.cur.recs[1:]
+			return rec, nil
+		}
+		if err := p.cur.err; err != nil {
+			return nil, err
+		}
+		p.cur = nil
+	}
+}
+
+// start starts the goroutine that reads batches and the workers that filter
+// them. Batches are queued in order before they are filtered, so that Read
+// can return them in input order.
+func (p *Pipeline) start() {
+	size := p.BatchSize
+	if size < 1 {
+		size = DefaultBatchSize
+	}
+
+	jobs := make(chan *batch, cap(p.order))
+	for i := 0; i < cap(p.order); i++ {
+		go func() {
+			for b := range jobs {
+				n := 0
+				for _, rec := range b.recs {
+					if allTrue(rec, p.r.Filters) {
+						b.recs[n] = rec
+						n++
+					}
+				}
+				b.recs = b.recs[:n]
+				b.out <- b
+			}
+		}()
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(p.order)
+		defer close(jobs)
+		for {
+			b := &batch{recs: make([]*sam.Record, 0, size), out: make(chan *batch, 1)}
+			for len(b.recs) < size {
+				rec, err := p.r.r.Read()
+				if err != nil {
+					if err = p.r.corrupt(err); err != io.EOF {
+						b.err = err
+					}
+					break
+				}
+				p.r.read++
+				b.recs = append(b.recs, rec)
+			}
+			if len(b.recs) == 0 && b.err == nil {
+				return
+			}
+			// A short batch is the last one. It is checked before the
+			// batch is passed to the workers that modify it.
+			last := len(b.recs) < size
+			select {
+			case p.order <- b:
+			case <-p.done:
+				return
+			}
+			jobs <- b
+			if last {
+				return
+			}
+		}
+	}()
+}
+
+// Close stops reading and closes the underlying reader.
+func (p *Pipeline) Close() error {
+	p.once.Do(func() {}) // Nothing to stop if reading has not started.
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	p.wg.Wait()
+	return p.r.Close()
+}
@@ -0,0 +1,79 @@
+package samql
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestPipeline(t *testing.T) {
+	for _, tt := range []struct {
+		Test      string
+		Query     string
+		Workers   int
+		BatchSize int
+	}{
+		{Test: "NoFilter", Workers: 2, BatchSize: 3},
+		{Test: "SingleWorker", Query: "RNAME = chr1", Workers: 1, BatchSize: 1},
+		{Test: "ManyWorkers", Query: "POS > 20 OR FLAG & 4 = 4", Workers: 8, BatchSize: 1},
+		{Test: "LargeBatch", Query: "SEQ =~ /^CAGC/", Workers: 4},
+		{Test: "NoMatch", Query: "RNAME = chrX", Workers: 3, BatchSize: 2},
+	} {
+		want := readNames(t, newTestReader(t, tt.Query))
+
+		p := NewPipeline(newTestReader(t, tt.Query), tt.Workers)
+		if tt.BatchSize > 0 {
+			p.BatchSize = tt.BatchSize
+		}
+		got := readNames(t, p)
+		if err := p.Close(); err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: records=%v want %v", tt.Test, got, want)
+		}
+	}
+}
+
+func TestPipelineClose(t *testing.T) {
+	p := NewPipeline(newTestReader(t, ""), 2)
+	p.BatchSize = 1
+	if _, err := p.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("unexpected error %q", err.Error())
+	}
+}
+
+// newTestReader returns a Reader that reads from samData and filters records
+// with query, if not empty.
+func newTestReader(t *testing.T, query string) *Reader {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(sr)
+	if query != "" {
+		r.AppendFilter(Must(Where(query)))
+	}
+	return r
+}
+
+// readNames returns the names and references of all records read from r.
+func readNames(t *testing.T, r readerSAM) []string {
+	var names []string
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, rec.Name+":"+rec.Ref.Name())
+	}
+}
@@ -0,0 +1,119 @@
+package samql
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/biogo/hts/sam"
+)
+
+// ReaderPool reads sharded inputs, e.g. the files of a run or the regions of
+// an indexed BAM file, on a fixed number of goroutines. The same filters are
+// applied to the records of all shards. As the filters provided by samql are
+// safe for concurrent use, a single compiled filter can be shared by all
+// shards.
+type ReaderPool struct {
+	// Workers is the number of shards that are read concurrently. If less
+	// than 1, runtime.NumCPU() is used.
+	Workers int
+	// Filters are appended to the Reader of each shard.
+	Filters []FilterFunc
+}
+
+// Map opens each of n shards with open and calls fn with the index of the
+// shard and each of its records that pass the filters of p. fn is called
+// concurrently for different shards and sequentially, in input order, for the
+// records of a shard. Map stops at the first error returned by open, by a
+// shard or by fn, or when ctx is done, and returns it. All opened sources are
+// closed before Map returns.
+func (p *ReaderPool) Map(ctx context.Context, n int, open func(shard int) (Source, error),
+	fn func(shard int, rec *sam.Record) error) error {
+	workers := p.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	if workers > n {
+		workers = n
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	shards := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shard := range shards {
+				if err := p.mapShard(ctx, shard, open, fn); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+send:
+	for i := 0; i < n; i++ {
+		select {
+		case shards <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(shards)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// mapShard reads the records of shard and calls fn with the records that
+// pass the filters of p.
+func (p *ReaderPool) mapShard(ctx context.Context, shard int, open func(int) (Source, error),
+	fn func(int, *sam.Record) error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	src, err := open(shard)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	r := NewReader(src)
+	r.Filters = append(r.Filters, p.Filters...)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		rec, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := fn(shard, rec); err != nil {
+			return err
+		}
+	}
+}
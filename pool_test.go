@@ -0,0 +1,81 @@
+package samql
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+// openShard opens the records of samData as a Source.
+func openShard(int) (Source, error) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		return nil, err
+	}
+	return &closerSource{readerSAM: sr, c: nopCloser{}}, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func TestReaderPool(t *testing.T) {
+	f, err := Compile("RNAME =~ /chr[12]/ AND QNAME !~ /^r00[24]/ AND rand() < 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const shards = 16
+	var mu sync.Mutex
+	counts := make(map[int]int)
+	p := &ReaderPool{Workers: 4, Filters: []FilterFunc{f.Match}}
+	err = p.Map(context.Background(), shards, openShard, func(shard int, rec *sam.Record) error {
+		mu.Lock()
+		counts[shard]++
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != shards {
+		t.Errorf("shards=%d want %d", len(counts), shards)
+	}
+	for shard, n := range counts {
+		if n != 3 {
+			t.Errorf("shard %d: count=%d want 3", shard, n)
+		}
+	}
+}
+
+func TestReaderPoolError(t *testing.T) {
+	errStop := errors.New("stop")
+	p := &ReaderPool{Workers: 2}
+	err := p.Map(context.Background(), 8, openShard, func(shard int, rec *sam.Record) error {
+		if shard == 3 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("err=%v want %v", err, errStop)
+	}
+
+	errOpen := errors.New("open")
+	err = p.Map(context.Background(), 8, func(int) (Source, error) { return nil, errOpen },
+		func(int, *sam.Record) error { return nil })
+	if err != errOpen {
+		t.Errorf("err=%v want %v", err, errOpen)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = p.Map(ctx, 8, openShard, func(int, *sam.Record) error { return nil })
+	if err != context.Canceled {
+		t.Errorf("err=%v want %v", err, context.Canceled)
+	}
+}
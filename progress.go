@@ -0,0 +1,72 @@
+package samql
+
+import (
+	"github.com/biogo/hts/bgzf"
+)
+
+// Progress is the progress of a Reader.
+type Progress struct {
+	// Read is the number of records read from the underlying reader.
+	Read int64
+	// Matched is the number of records that passed all filters.
+	Matched int64
+	// Rejected is the number of records rejected by each filter of the
+	// Reader, in the order of Filters. A record is counted for the first
+	// filter that rejects it, as the next filters are not evaluated. It is
+	// nil if the Reader has no filters.
+	Rejected []int64
+	// Offset is the number of bytes of the input consumed or -1 if unknown.
+	// For BAM input it is the offset of the BGZF block of the last record
+	// read in the compressed file.
+	Offset int64
+	// Done is true when the underlying reader is exhausted.
+	Done bool
+}
+
+// offsetReader is implemented by readers that report the number of bytes of
+// their input consumed.
+type offsetReader interface {
+	Offset() int64
+}
+
+// chunkReader is implemented by the BAM readers.
+type chunkReader interface {
+	LastChunk() bgzf.Chunk
+}
+
+// countProgress counts a record read by r that was rejected by the filter at
+// index rejected, or that matched all filters if rejected is negative, and
+// reports the progress every r.ProgressEvery records.
+func (r *Reader) countProgress(rejected int) {
+	r.progress.Read++
+	if rejected < 0 {
+		r.progress.Matched++
+	} else {
+		if len(r.progress.Rejected) < len(r.Filters) {
+			r.progress.Rejected = append(r.progress.Rejected,
+				make([]int64, len(r.Filters)-len(r.progress.Rejected))...)
+		}
+		r.progress.Rejected[rejected]++
+	}
+	if r.ProgressEvery > 0 && r.progress.Read%int64(r.ProgressEvery) == 0 {
+		r.reportProgress()
+	}
+}
+
+// reportProgress calls OnProgress with the current progress of r.
+func (r *Reader) reportProgress() {
+	r.progress.Offset = -1
+	switch u := r.r.(type) {
+	case offsetReader:
+		r.progress.Offset = u.Offset()
+	case chunkReader:
+		r.progress.Offset = u.LastChunk().End.File
+	}
+	p := r.progress
+	if len(r.Filters) > 0 {
+		// The counts are copied, as they keep changing.
+		p.Rejected = make([]int64, len(r.Filters))
+		copy(p.Rejected, r.progress.Rejected)
+	}
+	r.OnProgress(p)
+}
@@ -0,0 +1,88 @@
+package samql
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+)
+
+func TestReaderProgress(t *testing.T) {
+	const query = "MAPQ > 20"
+	matched := int64(len(readNames(t, newTestReader(t, query))))
+
+	r := newTestReader(t, query)
+	r.ProgressEvery = 3
+	var got []Progress
+	r.OnProgress = func(p Progress) { got = append(got, p) }
+	if _, err := r.ReadAll(); err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("progress reports=%d want 3", len(got))
+	}
+	for i, read := range []int64{3, 6, 8} {
+		if got[i].Read != read || got[i].Offset != -1 {
+			t.Errorf("progress %d=%+v want Read=%d Offset=-1", i, got[i], read)
+		}
+	}
+	if last := got[len(got)-1]; last.Matched != matched || !last.Done {
+		t.Errorf("last progress=%+v want Matched=%d Done=true", last, matched)
+	}
+}
+
+func TestReaderProgressBAM(t *testing.T) {
+	data := bamData(t)
+	br, err := bam.NewReader(bytes.NewReader(data), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(br)
+	var last Progress
+	r.OnProgress = func(p Progress) { last = p }
+	if _, err := r.ReadAll(); err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+
+	// The records are in the first BGZF block after the header block.
+	if last.Read != 8 || last.Matched != 8 || !last.Done || last.Offset <= 0 ||
+		last.Offset >= int64(len(data)) {
+		t.Errorf("progress=%+v want Read=8 Matched=8 and an offset in the file", last)
+	}
+}
+
+// offsetTestReader reports a fixed number of bytes consumed.
+type offsetTestReader struct {
+	*Reader
+}
+
+func (offsetTestReader) Offset() int64 { return 42 }
+
+func TestReaderProgressOffset(t *testing.T) {
+	r := NewReader(offsetTestReader{newTestReader(t, "")})
+	var got []Progress
+	r.OnProgress = func(p Progress) { got = append(got, p) }
+	if _, err := r.ReadAll(); err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	want := []Progress{{Read: 8, Matched: 8, Offset: 42, Done: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("progress=%+v want %+v", got, want)
+	}
+}
+
+func TestReaderProgressRejected(t *testing.T) {
+	r := newTestReader(t, "RNAME = chr1")
+	r.AppendFilter(Must(Where("POS > 10")))
+	var got []Progress
+	r.OnProgress = func(p Progress) { got = append(got, p) }
+	if _, err := r.ReadAll(); err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	want := []Progress{{Read: 8, Matched: 2, Rejected: []int64{4, 2}, Offset: -1, Done: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("progress=%+v want %+v", got, want)
+	}
+}
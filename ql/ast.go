@@ -73,15 +73,24 @@ func (*SelectStatement) node() {}
 func (*BinaryExpr) node()      {}
 func (*BooleanLiteral) node()  {}
 func (*Call) node()            {}
+func (*Dimension) node()       {}
+func (Dimensions) node()       {}
+func (*IndexExpr) node()       {}
 func (*IntegerLiteral) node()  {}
 func (*UnsignedLiteral) node() {}
 func (*Field) node()           {}
 func (Fields) node()           {}
+func (*FileLiteral) node()     {}
+func (*ListLiteral) node()     {}
 func (*Table) node()           {}
 func (*NilLiteral) node()      {}
+func (*NotExpr) node()         {}
 func (*NumberLiteral) node()   {}
 func (*ParenExpr) node()       {}
+func (*RangeExpr) node()       {}
 func (*RegexLiteral) node()    {}
+func (*SortField) node()       {}
+func (SortFields) node()       {}
 func (*StringLiteral) node()   {}
 func (*VarRef) node()          {}
 func (*Wildcard) node()        {}
@@ -108,11 +117,16 @@ type Expr interface {
 func (*BinaryExpr) expr()      {}
 func (*BooleanLiteral) expr()  {}
 func (*Call) expr()            {}
+func (*FileLiteral) expr()     {}
+func (*IndexExpr) expr()       {}
 func (*IntegerLiteral) expr()  {}
 func (*UnsignedLiteral) expr() {}
+func (*ListLiteral) expr()     {}
 func (*NilLiteral) expr()      {}
+func (*NotExpr) expr()         {}
 func (*NumberLiteral) expr()   {}
 func (*ParenExpr) expr()       {}
+func (*RangeExpr) expr()       {}
 func (*RegexLiteral) expr()    {}
 func (*StringLiteral) expr()   {}
 func (*VarRef) expr()          {}
@@ -129,6 +143,8 @@ type Literal interface {
 func (*BooleanLiteral) literal()  {}
 func (*IntegerLiteral) literal()  {}
 func (*UnsignedLiteral) literal() {}
+func (*FileLiteral) literal()     {}
+func (*ListLiteral) literal()     {}
 func (*NilLiteral) literal()      {}
 func (*NumberLiteral) literal()   {}
 func (*RegexLiteral) literal()    {}
@@ -155,6 +171,18 @@ type SelectStatement struct {
 
 	// An expression evaluated on data point.
 	Condition Expr
+
+	// Fraction of the data points to sample. Zero samples all data points.
+	Sample float64
+
+	// Expressions used for grouping the selection.
+	Dimensions Dimensions
+
+	// Fields to sort results by.
+	SortFields SortFields
+
+	// Maximum number of results. Zero returns all results.
+	Limit int
 }
 
 // ColumnNames will walk all fields and functions and return the appropriate
@@ -220,6 +248,22 @@ func (s *SelectStatement) String() string {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
 	}
+	if s.Sample > 0 {
+		_, _ = buf.WriteString(" SAMPLE ")
+		_, _ = buf.WriteString(strconv.FormatFloat(s.Sample, 'f', -1, 64))
+	}
+	if len(s.Dimensions) > 0 {
+		_, _ = buf.WriteString(" GROUP BY ")
+		_, _ = buf.WriteString(s.Dimensions.String())
+	}
+	if len(s.SortFields) > 0 {
+		_, _ = buf.WriteString(" ORDER BY ")
+		_, _ = buf.WriteString(s.SortFields.String())
+	}
+	if s.Limit > 0 {
+		_, _ = buf.WriteString(" LIMIT ")
+		_, _ = buf.WriteString(strconv.Itoa(s.Limit))
+	}
 	return buf.String()
 }
 
@@ -276,6 +320,57 @@ func (f *Field) String() string {
 	return fmt.Sprintf("%s AS %s", str, quoteIdent(f.Alias))
 }
 
+// Dimensions represents a list of dimensions.
+type Dimensions []*Dimension
+
+// String returns a string representation of the dimensions.
+func (a Dimensions) String() string {
+	var str []string
+	for _, d := range a {
+		str = append(str, d.String())
+	}
+	return strings.Join(str, ", ")
+}
+
+// Dimension represents an expression that a select statement is grouped by.
+type Dimension struct {
+	Expr Expr
+}
+
+// String returns a string representation of the dimension.
+func (d *Dimension) String() string {
+	return d.Expr.String()
+}
+
+// SortFields represents an ordered list of ORDER BY fields.
+type SortFields []*SortField
+
+// String returns a string representation of the sort fields.
+func (a SortFields) String() string {
+	var str []string
+	for _, f := range a {
+		str = append(str, f.String())
+	}
+	return strings.Join(str, ", ")
+}
+
+// SortField represents an expression to sort by and its direction.
+type SortField struct {
+	// Expression to sort by.
+	Expr Expr
+
+	// Sort order.
+	Ascending bool
+}
+
+// String returns a string representation of the sort field.
+func (f *SortField) String() string {
+	if f.Ascending {
+		return f.Expr.String() + " ASC"
+	}
+	return f.Expr.String() + " DESC"
+}
+
 // Table represents a data source.
 type Table struct {
 	Name string
@@ -306,6 +401,10 @@ func (r *VarRef) Name() string {
 type Call struct {
 	Cmd  string
 	Args []Expr
+
+	// Distinct is true for calls with a DISTINCT argument, e.g.
+	// count(DISTINCT x).
+	Distinct bool
 }
 
 // String returns a string representation of the call.
@@ -317,6 +416,9 @@ func (c *Call) String() string {
 	}
 
 	// Write function name and args.
+	if c.Distinct {
+		return fmt.Sprintf("%s(DISTINCT %s)", c.Cmd, strings.Join(str, ", "))
+	}
 	return fmt.Sprintf("%s(%s)", c.Cmd, strings.Join(str, ", "))
 }
 
@@ -394,13 +496,43 @@ func (r *RegexLiteral) String() string {
 	return ""
 }
 
-// NilLiteral represents a nil literal. This is not available to the query
-// language itself. It's only used internally.
+// ListLiteral represents a list of literals, e.g. the RHS of an IN
+// operator.
+type ListLiteral struct {
+	Vals []Literal
+}
+
+// String returns a string representation of the literal.
+func (l *ListLiteral) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("(")
+	for i, v := range l.Vals {
+		if i != 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(v.String())
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
+
+// FileLiteral represents a file with a list of values, one per line, e.g.
+// the RHS of QNAME IN FILE('names.txt').
+type FileLiteral struct {
+	Path string
+}
+
+// String returns a string representation of the literal.
+func (l *FileLiteral) String() string {
+	return "FILE(" + quoteString(l.Path) + ")"
+}
+
+// NilLiteral represents a nil literal, i.e. NULL in IS NULL and IS NOT NULL.
 type NilLiteral struct{}
 
 // String returns a string representation of the literal.
 func (l *NilLiteral) String() string {
-	return `nil`
+	return `NULL`
 }
 
 // BinaryExpr represents an operation between two expressions.
@@ -433,6 +565,38 @@ func (e *BinaryExpr) Name() string {
 	return strings.Join(names, "_")
 }
 
+// NotExpr represents the logical negation of an expression.
+type NotExpr struct {
+	Expr Expr
+}
+
+// String returns a string representation of the negated expression.
+func (e *NotExpr) String() string {
+	return fmt.Sprintf("NOT %s", e.Expr.String())
+}
+
+// IndexExpr represents an element of an array, e.g. ZC:B[0].
+type IndexExpr struct {
+	Expr  Expr
+	Index int
+}
+
+// String returns a string representation of the indexed expression.
+func (e *IndexExpr) String() string {
+	return fmt.Sprintf("%s[%d]", e.Expr.String(), e.Index)
+}
+
+// RangeExpr represents the inclusive bounds of a BETWEEN operator.
+type RangeExpr struct {
+	Lower Expr
+	Upper Expr
+}
+
+// String returns a string representation of the range.
+func (e *RangeExpr) String() string {
+	return fmt.Sprintf("%s AND %s", e.Lower.String(), e.Upper.String())
+}
+
 // ParenExpr represents a parenthesized expression.
 type ParenExpr struct {
 	Expr Expr
@@ -490,21 +654,49 @@ func Walk(v Visitor, node Node) {
 			Walk(v, expr)
 		}
 
+	case *Dimension:
+		Walk(v, n.Expr)
+
+	case Dimensions:
+		for _, c := range n {
+			Walk(v, c)
+		}
+
 	case *Field:
 		Walk(v, n.Expr)
 
+	case *SortField:
+		Walk(v, n.Expr)
+
+	case SortFields:
+		for _, c := range n {
+			Walk(v, c)
+		}
+
 	case Fields:
 		for _, c := range n {
 			Walk(v, c)
 		}
 
+	case *IndexExpr:
+		Walk(v, n.Expr)
+
+	case *NotExpr:
+		Walk(v, n.Expr)
+
 	case *ParenExpr:
 		Walk(v, n.Expr)
 
+	case *RangeExpr:
+		Walk(v, n.Lower)
+		Walk(v, n.Upper)
+
 	case *SelectStatement:
 		Walk(v, n.Fields)
 		Walk(v, n.Source)
 		Walk(v, n.Condition)
+		Walk(v, n.Dimensions)
+		Walk(v, n.SortFields)
 
 	}
 }
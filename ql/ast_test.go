@@ -59,6 +59,12 @@ func TestParseString(t *testing.T) {
 		{stmt: `SELECT "cpu load" FROM "my\"series"`},
 		{stmt: `SELECT * FROM myseries`},
 		{stmt: `SELECT "cpu load" FROM "db_with_spaces"`},
+		{stmt: `SELECT * FROM myseries WHERE host IN ('a', 'b', 1)`},
+		{stmt: `SELECT * FROM myseries WHERE QNAME IN FILE('names.txt')`},
+		{stmt: `SELECT * FROM myseries WHERE pos BETWEEN 1 AND 10 AND NOT pos BETWEEN 3 AND 4`},
+		{stmt: `SELECT * FROM myseries WHERE NOT (host = 'a' OR NOT up) AND NOT x = 1`},
+		{stmt: `SELECT * FROM myseries WHERE x > 1 SAMPLE 0.1`},
+		{stmt: `SELECT * FROM myseries WHERE x > 1 ORDER BY x LIMIT 5`},
 	}
 
 	for _, tt := range tests {
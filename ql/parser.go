@@ -40,6 +40,12 @@ func (p *Parser) ParseStatement() (Statement, error) {
 
 // ParseExpr parses an expression.
 func (p *Parser) ParseExpr() (Expr, error) {
+	return p.parseExpr(0)
+}
+
+// parseExpr parses an expression that contains only binary operators with
+// precedence of at least minPrec.
+func (p *Parser) parseExpr(minPrec int) (Expr, error) {
 	var err error
 	// Dummy root node.
 	root := &BinaryExpr{}
@@ -56,7 +62,7 @@ func (p *Parser) ParseExpr() (Expr, error) {
 	for {
 		// If the next token is NOT an operator then return the expression.
 		op, _, _ := p.scanIgnoreWhiteSpace()
-		if !op.isOperator() {
+		if !op.isOperator() || op.Precedence() < minPrec {
 			p.unscan()
 			return root.RHS, nil
 		}
@@ -74,6 +80,27 @@ func (p *Parser) ParseExpr() (Expr, error) {
 				tok, pos, lit := p.scanIgnoreWhiteSpace()
 				return nil, newParseError(tokstr(tok, lit), []string{"regex"}, pos)
 			}
+		} else if op == BETWEEN {
+			// RHS of a BETWEEN operator must be a range.
+			if rhs, err = p.parseRange(); err != nil {
+				return nil, err
+			}
+		} else if op == IS {
+			// RHS of an IS operator must be NULL, optionally after NOT.
+			tok, pos, lit := p.scanIgnoreWhiteSpace()
+			if tok == NOT {
+				op = ISNOT
+				tok, pos, lit = p.scanIgnoreWhiteSpace()
+			}
+			if tok != NULL {
+				return nil, newParseError(tokstr(tok, lit), []string{"NULL"}, pos)
+			}
+			rhs = &NilLiteral{}
+		} else if op == IN {
+			// RHS of an IN operator must be a list of literals or a file.
+			if rhs, err = p.parseInValues(); err != nil {
+				return nil, err
+			}
 		} else {
 			if rhs, err = p.parseUnaryExpr(); err != nil {
 				return nil, err
@@ -141,6 +168,26 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 		return nil, err
 	}
 
+	// Parse sample: "SAMPLE FRACTION".
+	if stmt.Sample, err = p.parseSample(); err != nil {
+		return nil, err
+	}
+
+	// Parse dimensions: "GROUP BY DIMENSION+".
+	if stmt.Dimensions, err = p.parseDimensions(); err != nil {
+		return nil, err
+	}
+
+	// Parse sort: "ORDER BY FIELD+".
+	if stmt.SortFields, err = p.parseOrderBy(); err != nil {
+		return nil, err
+	}
+
+	// Parse limit: "LIMIT N".
+	if stmt.Limit, err = p.parseLimit(); err != nil {
+		return nil, err
+	}
+
 	return stmt, nil
 }
 
@@ -248,6 +295,116 @@ func (p *Parser) parseCondition() (Expr, error) {
 	return expr, nil
 }
 
+// parseSample parses the "SAMPLE" clause of the query, if it exists. The
+// sampled fraction must be a number in (0, 1].
+func (p *Parser) parseSample() (float64, error) {
+	// If the next token is not SAMPLE then exit.
+	if tok, _, _ := p.scanIgnoreWhiteSpace(); tok != SAMPLE {
+		p.unscan()
+		return 0, nil
+	}
+
+	tok, pos, lit := p.scanIgnoreWhiteSpace()
+	if tok != NUMBER && tok != INTEGER {
+		return 0, newParseError(tokstr(tok, lit), []string{"number"}, pos)
+	}
+	v, err := strconv.ParseFloat(lit, 64)
+	if err != nil || v <= 0 || v > 1 {
+		return 0, &ParseError{Message: "sample fraction must be in (0, 1]", Pos: pos}
+	}
+	return v, nil
+}
+
+// parseDimensions parses the "GROUP BY" clause of the query, if it exists.
+func (p *Parser) parseDimensions() (Dimensions, error) {
+	// If the next token is not GROUP then exit.
+	if tok, _, _ := p.scanIgnoreWhiteSpace(); tok != GROUP {
+		p.unscan()
+		return nil, nil
+	}
+
+	// Now the next token should be "BY".
+	if tok, pos, lit := p.scanIgnoreWhiteSpace(); tok != BY {
+		return nil, newParseError(tokstr(tok, lit), []string{"BY"}, pos)
+	}
+
+	var dimensions Dimensions
+	for {
+		// Parse the dimension expression.
+		expr, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		dimensions = append(dimensions, &Dimension{Expr: expr})
+
+		// If there's not a comma next then stop parsing dimensions.
+		if tok, _, _ := p.scanIgnoreWhiteSpace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+	return dimensions, nil
+}
+
+// parseOrderBy parses the "ORDER BY" clause of the query, if it exists.
+func (p *Parser) parseOrderBy() (SortFields, error) {
+	// If the next token is not ORDER then exit.
+	if tok, _, _ := p.scanIgnoreWhiteSpace(); tok != ORDER {
+		p.unscan()
+		return nil, nil
+	}
+
+	// Now the next token should be "BY".
+	if tok, pos, lit := p.scanIgnoreWhiteSpace(); tok != BY {
+		return nil, newParseError(tokstr(tok, lit), []string{"BY"}, pos)
+	}
+
+	var fields SortFields
+	for {
+		// Parse the sort expression.
+		expr, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		field := &SortField{Expr: expr, Ascending: true}
+
+		// Parse the optional sort direction.
+		if tok, _, _ := p.scanIgnoreWhiteSpace(); tok == DESC {
+			field.Ascending = false
+		} else if tok != ASC {
+			p.unscan()
+		}
+		fields = append(fields, field)
+
+		// If there's not a comma next then stop parsing sort fields.
+		if tok, _, _ := p.scanIgnoreWhiteSpace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+	return fields, nil
+}
+
+// parseLimit parses the "LIMIT" clause of the query, if it exists. The limit
+// must be a positive integer.
+func (p *Parser) parseLimit() (int, error) {
+	// If the next token is not LIMIT then exit.
+	if tok, _, _ := p.scanIgnoreWhiteSpace(); tok != LIMIT {
+		p.unscan()
+		return 0, nil
+	}
+
+	tok, pos, lit := p.scanIgnoreWhiteSpace()
+	if tok != INTEGER {
+		return 0, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
+	}
+	n, err := strconv.Atoi(lit)
+	if err != nil || n <= 0 {
+		return 0, &ParseError{Message: "limit must be a positive integer", Pos: pos}
+	}
+	return n, nil
+}
+
 // parseUnaryExpr parses an non-binary expression.
 func (p *Parser) parseUnaryExpr() (Expr, error) {
 	// If the first token is a LPAREN then parse it as its own grouped
@@ -270,6 +427,14 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 	// Read next token.
 	tok, pos, lit := p.scanIgnoreWhiteSpace()
 	switch tok {
+	case NOT:
+		// NOT binds tighter than AND and OR but looser than comparisons, i.e.
+		// NOT a = b is parsed as NOT (a = b).
+		expr, err := p.parseExpr(AND.Precedence() + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{Expr: expr}, nil
 	case IDENT:
 		// If the next immediate token is a left parentheses, parse as
 		// function call. Otherwise parse as a variable reference.
@@ -280,8 +445,20 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 		p.unscan() // Unscan the last token (wasn't an LPAREN)
 		p.unscan() // Unscan the IDENT token
 
-		// Parse it as a VarRef.
-		return p.parseVarRef()
+		// Parse it as a VarRef, that may be indexed, e.g. ZC:B[0].
+		ref, err := p.parseVarRef()
+		if err != nil {
+			return nil, err
+		}
+		if tok0, _, _ := p.scan(); tok0 != LBRACKET {
+			p.unscan()
+			return ref, nil
+		}
+		return p.parseIndex(ref)
+	case SAMPLE:
+		// SAMPLE is also a field, e.g. the sample of a read group. It starts
+		// the SAMPLE clause only after the condition.
+		return &VarRef{Val: "SAMPLE"}, nil
 	case STRING:
 		return &StringLiteral{Val: lit}, nil
 	case NUMBER:
@@ -422,6 +599,102 @@ func (p *Parser) parseRegex() (*RegexLiteral, error) {
 	return &RegexLiteral{Val: re}, nil
 }
 
+// parseRange parses the lower and upper bounds of a BETWEEN operator, i.e.
+// "lower AND upper".
+func (p *Parser) parseRange() (*RangeExpr, error) {
+	// The bounds cannot contain AND or OR operators to avoid ambiguity.
+	lower, err := p.parseExpr(AND.Precedence() + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhiteSpace(); tok != AND {
+		return nil, newParseError(tokstr(tok, lit), []string{"AND"}, pos)
+	}
+
+	upper, err := p.parseExpr(AND.Precedence() + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RangeExpr{Lower: lower, Upper: upper}, nil
+}
+
+// parseInValues parses the values of an IN operator, either a list of
+// literals or a file of values, e.g. FILE('names.txt').
+func (p *Parser) parseInValues() (Literal, error) {
+	tok, _, lit := p.scanIgnoreWhiteSpace()
+	if tok != IDENT || strings.ToUpper(lit) != "FILE" {
+		p.unscan()
+		return p.parseList()
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhiteSpace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	tok, pos, lit := p.scanIgnoreWhiteSpace()
+	if tok != STRING {
+		return nil, newParseError(tokstr(tok, lit), []string{"file name"}, pos)
+	}
+	file := &FileLiteral{Path: lit}
+	if tok, pos, lit := p.scanIgnoreWhiteSpace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return file, nil
+}
+
+// parseList parses a parenthesized, comma separated list of literals.
+func (p *Parser) parseList() (*ListLiteral, error) {
+	if tok, pos, lit := p.scanIgnoreWhiteSpace(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+
+	list := &ListLiteral{}
+	for {
+		tok, pos, lit := p.scanIgnoreWhiteSpace()
+		p.unscan()
+		expr, err := p.parseUnaryExpr()
+		if err != nil {
+			return nil, err
+		}
+		val, ok := expr.(Literal)
+		if !ok {
+			return nil, newParseError(tokstr(tok, lit), []string{"literal"}, pos)
+		}
+		list.Vals = append(list.Vals, val)
+
+		// If there's not a comma, stop parsing the list.
+		if tok, _, _ := p.scanIgnoreWhiteSpace(); tok != COMMA {
+			p.unscan()
+			break
+		}
+	}
+
+	// There should be a right parentheses at the end.
+	if tok, pos, lit := p.scanIgnoreWhiteSpace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+
+	return list, nil
+}
+
+// parseIndex parses the index of an array expression.
+// This function assumes the expression and LBRACKET have been consumed.
+func (p *Parser) parseIndex(expr Expr) (*IndexExpr, error) {
+	tok, pos, lit := p.scanIgnoreWhiteSpace()
+	if tok != INTEGER {
+		return nil, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
+	}
+	i, err := strconv.Atoi(lit)
+	if err != nil {
+		return nil, &ParseError{Message: "unable to parse index", Pos: pos}
+	}
+	if tok, pos, lit := p.scanIgnoreWhiteSpace(); tok != RBRACKET {
+		return nil, newParseError(tokstr(tok, lit), []string{"]"}, pos)
+	}
+	return &IndexExpr{Expr: expr, Index: i}, nil
+}
+
 // parseCall parses a function call.
 // This function assumes the function name and LPAREN have been consumed.
 func (p *Parser) parseCall(name string) (*Call, error) {
@@ -436,9 +709,21 @@ func (p *Parser) parseCall(name string) (*Call, error) {
 		args = append(args, re)
 	} else {
 		// If there's a right paren then just return immediately.
-		if tok, _, _ := p.scan(); tok == RPAREN {
+		tok, _, _ := p.scan()
+		if tok == RPAREN {
 			return &Call{Cmd: name}, nil
 		}
+		// A DISTINCT argument, as in count(DISTINCT x), is the only one.
+		if tok == DISTINCT {
+			arg, err := p.ParseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if tok, pos, lit := p.scanIgnoreWhiteSpace(); tok != RPAREN {
+				return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+			}
+			return &Call{Cmd: name, Args: []Expr{arg}, Distinct: true}, nil
+		}
 		p.unscan()
 
 		arg, err := p.ParseExpr()
@@ -488,6 +773,12 @@ type fieldValidator struct {
 }
 
 func (c *fieldValidator) Visit(n Node) Visitor {
+	if _, ok := n.(*NotExpr); ok {
+		c.foundInvalid = true
+		c.badToken = NOT
+		return nil
+	}
+
 	e, ok := n.(*BinaryExpr)
 	if !ok {
 		return c
@@ -495,8 +786,8 @@ func (c *fieldValidator) Visit(n Node) Visitor {
 
 	switch e.Op {
 	case EQ, NEQ, EQREGEX,
-		NEQREGEX, LT, LTE, GT, GTE,
-		AND, OR:
+		NEQREGEX, EQFOLD, NEQFOLD, LT, LTE, GT, GTE,
+		AND, OR, IN, BETWEEN, IS, ISNOT:
 		c.foundInvalid = true
 		c.badToken = e.Op
 		return nil
@@ -262,6 +262,112 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT statement with GROUP BY
+		{
+			s: `SELECT RNAME, count(*) FROM aln WHERE MAPQ > 30 GROUP BY RNAME, CB:Z`,
+			stmt: &SelectStatement{
+				Fields: []*Field{
+					{Expr: &VarRef{Val: "RNAME"}},
+					{Expr: &Call{Cmd: "count", Args: []Expr{&Wildcard{}}}},
+				},
+				Source: Source(&Table{Name: "aln"}),
+				Condition: &BinaryExpr{
+					Op:  GT,
+					LHS: &VarRef{Val: "MAPQ"},
+					RHS: &IntegerLiteral{Val: 30},
+				},
+				Dimensions: []*Dimension{
+					{Expr: &VarRef{Val: "RNAME"}},
+					{Expr: &VarRef{Val: "CB:Z"}},
+				},
+			},
+		},
+
+		// SELECT statement with DISTINCT aggregate
+		{
+			s: `SELECT RNAME, count(DISTINCT UMI) FROM aln GROUP BY RNAME`,
+			stmt: &SelectStatement{
+				Fields: []*Field{
+					{Expr: &VarRef{Val: "RNAME"}},
+					{Expr: &Call{Cmd: "count", Args: []Expr{&VarRef{Val: "UMI"}}, Distinct: true}},
+				},
+				Source: Source(&Table{Name: "aln"}),
+				Dimensions: []*Dimension{
+					{Expr: &VarRef{Val: "RNAME"}},
+				},
+			},
+		},
+
+		// SELECT statement with ORDER BY
+		{
+			s: `SELECT * FROM aln WHERE MAPQ > 20 ORDER BY RNAME, POS DESC, NM:i ASC`,
+			stmt: &SelectStatement{
+				Fields: []*Field{{Expr: &Wildcard{}}},
+				Source: Source(&Table{Name: "aln"}),
+				Condition: &BinaryExpr{
+					Op:  GT,
+					LHS: &VarRef{Val: "MAPQ"},
+					RHS: &IntegerLiteral{Val: 20},
+				},
+				SortFields: []*SortField{
+					{Expr: &VarRef{Val: "RNAME"}, Ascending: true},
+					{Expr: &VarRef{Val: "POS"}, Ascending: false},
+					{Expr: &VarRef{Val: "NM:i"}, Ascending: true},
+				},
+			},
+		},
+
+		// SELECT statement with SAMPLE
+		{
+			s: `SELECT * FROM aln WHERE MAPQ > 20 SAMPLE 0.05 ORDER BY POS`,
+			stmt: &SelectStatement{
+				Fields: []*Field{{Expr: &Wildcard{}}},
+				Source: Source(&Table{Name: "aln"}),
+				Condition: &BinaryExpr{
+					Op:  GT,
+					LHS: &VarRef{Val: "MAPQ"},
+					RHS: &IntegerLiteral{Val: 20},
+				},
+				Sample: 0.05,
+				SortFields: []*SortField{
+					{Expr: &VarRef{Val: "POS"}, Ascending: true},
+				},
+			},
+		},
+
+		// SELECT statement with the SAMPLE field and clause
+		{
+			s: `SELECT SAMPLE FROM aln WHERE SAMPLE = 'NA12878' SAMPLE 0.5`,
+			stmt: &SelectStatement{
+				Fields: []*Field{{Expr: &VarRef{Val: "SAMPLE"}}},
+				Source: Source(&Table{Name: "aln"}),
+				Condition: &BinaryExpr{
+					Op:  EQ,
+					LHS: &VarRef{Val: "SAMPLE"},
+					RHS: &StringLiteral{Val: "NA12878"},
+				},
+				Sample: 0.5,
+			},
+		},
+
+		// SELECT statement with LIMIT
+		{
+			s: `SELECT QNAME FROM aln WHERE MAPQ > 20 ORDER BY POS LIMIT 10`,
+			stmt: &SelectStatement{
+				Fields: []*Field{{Expr: &VarRef{Val: "QNAME"}}},
+				Source: Source(&Table{Name: "aln"}),
+				Condition: &BinaryExpr{
+					Op:  GT,
+					LHS: &VarRef{Val: "MAPQ"},
+					RHS: &IntegerLiteral{Val: 20},
+				},
+				SortFields: []*SortField{
+					{Expr: &VarRef{Val: "POS"}, Ascending: true},
+				},
+				Limit: 10,
+			},
+		},
+
 		// Errors
 		{s: `SELECT`, err: `found EOF, expected identifier, string, number, bool at line 1, char 8`},
 		{s: `UNKNOWN`, err: `found UNKNOWN, expected SELECT at line 1, char 1`},
@@ -274,6 +380,25 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `SELECT value > 2 FROM cpu`, err: `invalid operator > in SELECT clause at line 1, char 8; operator is intended for WHERE clause`},
 		{s: `SELECT value = 2 FROM cpu`, err: `invalid operator = in SELECT clause at line 1, char 8; operator is intended for WHERE clause`},
 		{s: `SELECT s =~ /foo/ FROM cpu`, err: `invalid operator =~ in SELECT clause at line 1, char 8; operator is intended for WHERE clause`},
+		{s: `SELECT count(*) FROM cpu GROUP host`, err: `found host, expected BY at line 1, char 32`},
+		{s: `SELECT count(*) FROM cpu GROUP BY`, err: `found EOF, expected identifier, string, number, bool at line 1, char 35`},
+		{s: `SELECT * FROM cpu ORDER host`, err: `found host, expected BY at line 1, char 25`},
+		{s: `SELECT NOT value FROM cpu`, err: `invalid operator NOT in SELECT clause at line 1, char 8; operator is intended for WHERE clause`},
+		{s: `SELECT * FROM cpu WHERE NOT`, err: `found EOF, expected identifier, string, number, bool at line 1, char 29`},
+		{s: `SELECT * FROM cpu WHERE pos BETWEEN 1 OR 2`, err: `found OR, expected AND at line 1, char 39`},
+		{s: `SELECT * FROM cpu WHERE host IN 'a'`, err: `found a, expected ( at line 1, char 32`},
+		{s: `SELECT * FROM cpu WHERE host IN ('a', b)`, err: `found b, expected literal at line 1, char 39`},
+		{s: `SELECT * FROM cpu WHERE host IN ('a' 'b')`, err: `found b, expected ) at line 1, char 37`},
+		{s: `SELECT * FROM cpu WHERE host IN FILE(a)`, err: `found a, expected file name at line 1, char 38`},
+		{s: `SELECT * FROM cpu WHERE host IN FILE 'a'`, err: `found a, expected ( at line 1, char 37`},
+		{s: `SELECT * FROM cpu WHERE host IN FILE('a'`, err: `found EOF, expected ) at line 1, char 41`},
+		{s: `SELECT * FROM cpu SAMPLE x`, err: `found x, expected number at line 1, char 26`},
+		{s: `SELECT count(DISTINCT a, b) FROM cpu`, err: `found ,, expected ) at line 1, char 24`},
+		{s: `SELECT * FROM cpu WHERE host IS 1`, err: `found 1, expected NULL at line 1, char 33`},
+		{s: `SELECT * FROM cpu SAMPLE 2`, err: `sample fraction must be in (0, 1] at line 1, char 26`},
+		{s: `SELECT * FROM cpu LIMIT x`, err: `found x, expected integer at line 1, char 25`},
+		{s: `SELECT * FROM cpu LIMIT 0`, err: `limit must be a positive integer at line 1, char 25`},
+		{s: `SELECT * FROM cpu LIMIT 1.5`, err: `found 1.5, expected integer at line 1, char 25`},
 	}
 
 	for i, tt := range tests {
@@ -554,6 +679,87 @@ func TestParser_ParseExpr(t *testing.T) {
 			},
 		},
 
+		// Binary expression with IN list.
+		{
+			s: `RNAME IN ('chr1', 'chr2') AND FLAG IN (0, 16)`,
+			expr: &BinaryExpr{
+				Op: AND,
+				LHS: &BinaryExpr{
+					Op:  IN,
+					LHS: &VarRef{Val: "RNAME"},
+					RHS: &ListLiteral{Vals: []Literal{
+						&StringLiteral{Val: "chr1"},
+						&StringLiteral{Val: "chr2"},
+					}},
+				},
+				RHS: &BinaryExpr{
+					Op:  IN,
+					LHS: &VarRef{Val: "FLAG"},
+					RHS: &ListLiteral{Vals: []Literal{
+						&IntegerLiteral{Val: 0},
+						&IntegerLiteral{Val: 16},
+					}},
+				},
+			},
+		},
+
+		// Binary expression with IN file.
+		{
+			s: `QNAME IN file('names.txt')`,
+			expr: &BinaryExpr{
+				Op:  IN,
+				LHS: &VarRef{Val: "QNAME"},
+				RHS: &FileLiteral{Path: "names.txt"},
+			},
+		},
+
+		// Binary expression with BETWEEN.
+		{
+			s: `pos BETWEEN 10 AND 20 + 1 AND host = 'a'`,
+			expr: &BinaryExpr{
+				Op: AND,
+				LHS: &BinaryExpr{
+					Op:  BETWEEN,
+					LHS: &VarRef{Val: "pos"},
+					RHS: &RangeExpr{
+						Lower: &IntegerLiteral{Val: 10},
+						Upper: &BinaryExpr{
+							Op:  ADD,
+							LHS: &IntegerLiteral{Val: 20},
+							RHS: &IntegerLiteral{Val: 1},
+						},
+					},
+				},
+				RHS: &BinaryExpr{
+					Op:  EQ,
+					LHS: &VarRef{Val: "host"},
+					RHS: &StringLiteral{Val: "a"},
+				},
+			},
+		},
+
+		// Binary expression with NOT.
+		{
+			s: `NOT host = 'a' AND NOT (region = 'b' OR up)`,
+			expr: &BinaryExpr{
+				Op: AND,
+				LHS: &NotExpr{Expr: &BinaryExpr{
+					Op:  EQ,
+					LHS: &VarRef{Val: "host"},
+					RHS: &StringLiteral{Val: "a"},
+				}},
+				RHS: &NotExpr{Expr: &ParenExpr{Expr: &BinaryExpr{
+					Op: OR,
+					LHS: &BinaryExpr{
+						Op:  EQ,
+						LHS: &VarRef{Val: "region"},
+						RHS: &StringLiteral{Val: "b"},
+					},
+					RHS: &VarRef{Val: "up"},
+				}}},
+			},
+		},
+
 		// Complex binary expression.
 		{
 			s: `value + 3 < 30 AND 1 + 2 OR true`,
@@ -580,6 +786,34 @@ func TestParser_ParseExpr(t *testing.T) {
 			},
 		},
 
+		// IS NULL and IS NOT NULL
+		{
+			s: `NM:i IS NULL OR XS:Z IS NOT NULL`,
+			expr: &BinaryExpr{
+				Op: OR,
+				LHS: &BinaryExpr{
+					Op:  IS,
+					LHS: &VarRef{Val: "NM:i"},
+					RHS: &NilLiteral{},
+				},
+				RHS: &BinaryExpr{
+					Op:  ISNOT,
+					LHS: &VarRef{Val: "XS:Z"},
+					RHS: &NilLiteral{},
+				},
+			},
+		},
+
+		// Array index
+		{
+			s: `ZC:B[1] > 5`,
+			expr: &BinaryExpr{
+				Op:  GT,
+				LHS: &IndexExpr{Expr: &VarRef{Val: "ZC:B"}, Index: 1},
+				RHS: &IntegerLiteral{Val: 5},
+			},
+		},
+
 		// Function call (empty)
 		{
 			s: `my_func()`,
@@ -94,6 +94,8 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 	case '=':
 		if ch1, _ := s.r.read(); ch1 == '~' {
 			return EQREGEX, pos, ""
+		} else if ch1 == '*' {
+			return EQFOLD, pos, ""
 		}
 		s.r.unread()
 		return EQ, pos, ""
@@ -102,6 +104,8 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 			return NEQ, pos, ""
 		} else if ch1 == '~' {
 			return NEQREGEX, pos, ""
+		} else if ch1 == '*' {
+			return NEQFOLD, pos, ""
 		}
 		s.r.unread()
 	case '>':
@@ -122,6 +126,10 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 		return LPAREN, pos, ""
 	case ')':
 		return RPAREN, pos, ""
+	case '[':
+		return LBRACKET, pos, ""
+	case ']':
+		return RBRACKET, pos, ""
 	case ',':
 		return COMMA, pos, ""
 	case ';':
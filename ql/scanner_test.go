@@ -48,6 +48,8 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `!=`, tok: NEQ},
 		{s: `=~`, tok: EQREGEX},
 		{s: `!~`, tok: NEQREGEX},
+		{s: `=*`, tok: EQFOLD},
+		{s: `!*`, tok: NEQFOLD},
 		{s: `<`, tok: LT},
 		{s: `<=`, tok: LTE},
 		{s: `>`, tok: GT},
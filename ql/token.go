@@ -46,16 +46,24 @@ const (
 	NEQ        // !=
 	EQREGEX    // =~
 	NEQREGEX   // !~
+	EQFOLD     // =*
+	NEQFOLD    // !*
 	LT         // <
 	LTE        // <=
 	GT         // >
 	GTE        // >=
+	IN         // IN
+	BETWEEN    // BETWEEN
+	IS         // IS
+	ISNOT      // IS NOT
 	operatorEnd
 
 	// Structure
-	LPAREN // (
-	RPAREN // )
-	COMMA  // ,
+	LPAREN   // (
+	RPAREN   // )
+	LBRACKET // [
+	RBRACKET // ]
+	COMMA    // ,
 	//	COLON     // :
 	SEMICOLON // ;
 	DOT       // .
@@ -63,7 +71,17 @@ const (
 	// Keywords
 	keywordBeg
 	AS
+	ASC
+	BY
+	DESC
+	DISTINCT
 	FROM
+	GROUP
+	LIMIT
+	NOT
+	NULL
+	ORDER
+	SAMPLE
 	SELECT
 	WHERE
 	keywordEnd
@@ -97,22 +115,40 @@ var tokens = [...]string{
 	NEQ:        "!=",
 	EQREGEX:    "=~",
 	NEQREGEX:   "!~",
+	EQFOLD:     "=*",
+	NEQFOLD:    "!*",
 	LT:         "<",
 	LTE:        "<=",
 	GT:         ">",
 	GTE:        ">=",
-
-	LPAREN: "(",
-	RPAREN: ")",
-	COMMA:  ",",
+	IN:         "IN",
+	BETWEEN:    "BETWEEN",
+	IS:         "IS",
+	ISNOT:      "IS NOT",
+
+	LPAREN:   "(",
+	RPAREN:   ")",
+	LBRACKET: "[",
+	RBRACKET: "]",
+	COMMA:    ",",
 	// COLON:     ":",
 	SEMICOLON: ";",
 	DOT:       ".",
 
-	AS:     "AS",
-	FROM:   "FROM",
-	SELECT: "SELECT",
-	WHERE:  "WHERE",
+	AS:       "AS",
+	ASC:      "ASC",
+	BY:       "BY",
+	DESC:     "DESC",
+	DISTINCT: "DISTINCT",
+	FROM:     "FROM",
+	GROUP:    "GROUP",
+	LIMIT:    "LIMIT",
+	NOT:      "NOT",
+	NULL:     "NULL",
+	ORDER:    "ORDER",
+	SAMPLE:   "SAMPLE",
+	SELECT:   "SELECT",
+	WHERE:    "WHERE",
 }
 
 var keywords map[string]Token
@@ -122,7 +158,7 @@ func init() {
 	for tok := keywordBeg + 1; tok < keywordEnd; tok++ {
 		keywords[strings.ToLower(tokens[tok])] = tok
 	}
-	for _, tok := range []Token{AND, OR} {
+	for _, tok := range []Token{AND, OR, IN, BETWEEN, IS} {
 		keywords[strings.ToLower(tokens[tok])] = tok
 	}
 	keywords["true"] = TRUE
@@ -144,7 +180,8 @@ func (tok Token) Precedence() int {
 		return 1
 	case AND:
 		return 2
-	case EQ, NEQ, EQREGEX, NEQREGEX, LT, LTE, GT, GTE:
+	case EQ, NEQ, EQREGEX, NEQREGEX, EQFOLD, NEQFOLD, LT, LTE, GT, GTE, IN,
+		BETWEEN, IS, ISNOT:
 		return 3
 	case ADD, SUB, BITWISEOR, BITWISEXOR:
 		return 4
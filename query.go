@@ -0,0 +1,349 @@
+package samql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// Query is a compiled samql SELECT statement. Unless all fields are selected
+// with a wildcard, it holds the columns that are projected from each record.
+// The records are filtered with the WHERE clause returned by Where, e.g. with
+// WhereHeader to bind the keywords of their input.
+type Query struct {
+	// Stmt is the parsed statement.
+	Stmt *ql.SelectStatement
+
+	columns []valueFunc
+	types   []ColumnType
+	aggs    []aggregatorFunc
+	args    []valueFunc // argument of each aggregate or nil, e.g. for count(*).
+	dims    []valueFunc
+	dimIdx  []int // index of the dimension selected by each field or -1.
+	sorts   []sortKey
+	input   bool // fields or dimensions use the keywords of specific inputs.
+	opts    []FilterOption
+}
+
+// valueFunc returns a value that is extracted from a sam.Record.
+type valueFunc func(*sam.Record) interface{}
+
+// ColumnType is the type of the values of a selected column. Values of
+// IntColumn are int or int64, of FloatColumn float32 and of DoubleColumn
+// float64. Values of all types can be nil, e.g. the minimum of no values.
+type ColumnType int
+
+// Column types.
+const (
+	UnknownColumn ColumnType = iota
+	BoolColumn
+	IntColumn
+	FloatColumn
+	DoubleColumn
+	StringColumn
+)
+
+// String returns the SQL name of t, e.g. INTEGER.
+func (t ColumnType) String() string {
+	switch t {
+	case BoolColumn:
+		return "BOOLEAN"
+	case IntColumn:
+		return "INTEGER"
+	case FloatColumn:
+		return "FLOAT"
+	case DoubleColumn:
+		return "DOUBLE"
+	case StringColumn:
+		return "VARCHAR"
+	}
+	return "UNKNOWN"
+}
+
+// NewQuery parses and compiles the SELECT statement query, e.g. "SELECT
+// QNAME, POS FROM aln WHERE MAPQ > 30". The source in the FROM clause is
+// required by the grammar but is otherwise ignored. The keywords of specific
+// inputs, e.g. SOURCE or SAMPLE, are empty in the selected fields and GROUP
+// BY dimensions unless the query is bound to an input with Bind. Options
+// configure the functions of the selected fields and the WHERE clause, which
+// must be filtered with the same options.
+func NewQuery(query string, opts ...FilterOption) (*Query, error) {
+	stmt, err := ql.NewParserFromStr(query).ParseStatement()
+	if err != nil {
+		return nil, err
+	}
+	sel := stmt.(*ql.SelectStatement)
+
+	// The WHERE clause is only checked, as its keywords are bound to the
+	// inputs of the records.
+	vars := withOptions(unboundVars(), opts)
+	if _, err := newFilter(sel.Condition, vars); err != nil {
+		return nil, err
+	}
+	q, err := newQuery(sel, vars)
+	if err != nil {
+		return nil, err
+	}
+	q.opts = opts
+	return q, nil
+}
+
+// Bind returns a copy of q whose selected fields and GROUP BY dimensions
+// bind the SOURCE and FILE keywords to input and resolve the read group
+// keywords with the read groups in h, as WhereHeader. The records of several
+// inputs are aggregated together by adding them to the same Aggregation with
+// AddWith and the query bound to their input.
+func (q *Query) Bind(input string, h *sam.Header) (*Query, error) {
+	b, err := newQuery(q.Stmt, withOptions(headerVars(input, h), q.opts))
+	if err != nil {
+		return nil, err
+	}
+	b.opts = q.opts
+	return b, nil
+}
+
+// NeedsInput returns true if the selected fields or GROUP BY dimensions of q
+// use the keywords of specific inputs, e.g. SOURCE, so that q must be bound
+// to the input of the records with Bind.
+func (q *Query) NeedsInput() bool {
+	return q.input
+}
+
+// newQuery compiles the SELECT statement sel. Variable references in the
+// selected fields and the GROUP BY dimensions that match a key in vars are
+// resolved to the corresponding value.
+func newQuery(sel *ql.SelectStatement, vars map[string]interface{}) (*Query, error) {
+	q := &Query{Stmt: sel}
+
+	// A single wildcard selects whole records and requires no projection.
+	wildcard := false
+	if len(sel.Fields) == 1 {
+		_, wildcard = sel.Fields[0].Expr.(*ql.Wildcard)
+	}
+	if !wildcard {
+		if err := q.compileFields(vars); err != nil {
+			return nil, err
+		}
+	} else if len(sel.Dimensions) > 0 {
+		return nil, errors.New("samql: wildcard cannot be used with GROUP BY")
+	}
+
+	for _, d := range sel.Dimensions {
+		fn, err := newValueFunc(d.Expr, vars)
+		if err != nil {
+			return nil, err
+		}
+		q.dims = append(q.dims, fn)
+		q.input = q.input || usesBoundVars(d.Expr)
+	}
+
+	for _, f := range sel.SortFields {
+		k, err := newSortKey(f)
+		if err != nil {
+			return nil, err
+		}
+		q.sorts = append(q.sorts, k)
+	}
+
+	if q.IsAggregate() {
+		if q.IsSorted() {
+			return nil, errors.New("samql: ORDER BY is not supported with aggregates")
+		}
+		if err := q.validateAggregate(); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// compileFields compiles the SELECT fields of q to value functions and
+// aggregates. Variable references that match a key in vars are resolved to
+// the corresponding value.
+func (q *Query) compileFields(vars map[string]interface{}) error {
+	for _, f := range q.Stmt.Fields {
+		q.input = q.input || usesBoundVars(f.Expr)
+		if isAggregate(f.Expr) {
+			fn, arg, typ, err := newAggregatorFunc(f.Expr.(*ql.Call), vars)
+			if err != nil {
+				return err
+			}
+			q.aggs = append(q.aggs, fn)
+			q.args = append(q.args, arg)
+			q.columns = append(q.columns, nil)
+			q.types = append(q.types, typ)
+			continue
+		}
+
+		fn, typ, err := newTypedValueFunc(f.Expr, vars)
+		if err != nil {
+			return err
+		}
+		q.aggs = append(q.aggs, nil)
+		q.args = append(q.args, nil)
+		q.columns = append(q.columns, fn)
+		q.types = append(q.types, typ)
+	}
+	return nil
+}
+
+// usesBoundVars returns true if expr references a keyword of boundVars.
+func usesBoundVars(expr ql.Expr) bool {
+	found := false
+	ql.WalkFunc(expr, func(n ql.Node) bool {
+		if ref, ok := n.(*ql.VarRef); ok && boundVars[ref.Val] {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// IsAggregate returns true if q computes aggregate functions over records or
+// groups records with GROUP BY. Records that pass the filter of an aggregate
+// query should be added to an Aggregation created with NewAggregation.
+func (q *Query) IsAggregate() bool {
+	if len(q.dims) > 0 {
+		return true
+	}
+	for _, fn := range q.aggs {
+		if fn != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAggregate returns an error if an aggregate query selects fields
+// that are neither aggregates, GROUP BY dimensions nor constants. It also
+// associates the fields with the dimensions they select.
+func (q *Query) validateAggregate() error {
+	q.dimIdx = make([]int, len(q.Stmt.Fields))
+	for i, f := range q.Stmt.Fields {
+		q.dimIdx[i] = -1
+		if q.aggs[i] != nil {
+			continue
+		}
+		for j, d := range q.Stmt.Dimensions {
+			if f.Expr.String() == d.Expr.String() {
+				q.dimIdx[i] = j
+				break
+			}
+		}
+		if q.dimIdx[i] >= 0 {
+			continue
+		}
+		if _, ok := f.Expr.(ql.Literal); !ok {
+			return fmt.Errorf("samql: field %s must be an aggregate or appear in GROUP BY", f)
+		}
+	}
+	return nil
+}
+
+// IsProjection returns true if q selects specific columns instead of whole
+// records.
+func (q *Query) IsProjection() bool {
+	return q.columns != nil
+}
+
+// Where returns the WHERE condition of q, followed by the SAMPLE clause if
+// any, as a string that can be passed to Where. It returns an empty string if
+// q has neither a WHERE nor a SAMPLE clause.
+func (q *Query) Where() string {
+	if q.Stmt.Sample > 0 {
+		cond := "true"
+		if q.Stmt.Condition != nil {
+			cond = q.Stmt.Condition.String()
+		}
+		return cond + " SAMPLE " + strconv.FormatFloat(q.Stmt.Sample, 'f', -1, 64)
+	}
+	if q.Stmt.Condition == nil {
+		return ""
+	}
+	return q.Stmt.Condition.String()
+}
+
+// Limit returns the maximum number of rows, or records for SELECT *, that q
+// returns, as set by the LIMIT clause, or 0 if q is not limited. Aggregate
+// queries return the first Limit groups.
+func (q *Query) Limit() int {
+	return q.Stmt.Limit
+}
+
+// ColumnNames returns the names of the columns selected by q.
+func (q *Query) ColumnNames() []string {
+	return q.Stmt.ColumnNames()
+}
+
+// ColumnTypes returns the types of the columns selected by q. It returns nil
+// if q is not a projection.
+func (q *Query) ColumnTypes() []ColumnType {
+	return q.types
+}
+
+// Values returns the values of the columns selected by q for rec. It returns
+// nil if q is not a projection or if it is an aggregate query.
+func (q *Query) Values(rec *sam.Record) []interface{} {
+	if q.columns == nil || q.IsAggregate() {
+		return nil
+	}
+	vals := make([]interface{}, len(q.columns))
+	for i, fn := range q.columns {
+		vals[i] = fn(rec)
+	}
+	return vals
+}
+
+// newValueFunc returns a valueFunc that evaluates expr for a record.
+// Variable references in expr that match a key in vars are resolved to the
+// corresponding value.
+func newValueFunc(expr ql.Expr, vars map[string]interface{}) (valueFunc, error) {
+	fn, _, err := newTypedValueFunc(expr, vars)
+	return fn, err
+}
+
+// newTypedValueFunc returns a valueFunc that evaluates expr for a record and
+// the type of its values, as newValueFunc.
+func newTypedValueFunc(expr ql.Expr, vars map[string]interface{}) (valueFunc, ColumnType, error) {
+	if _, ok := expr.(*ql.Wildcard); ok {
+		return nil, UnknownColumn, errors.New("samql: wildcard cannot be combined with other fields")
+	}
+
+	v := evalVisitor{vars: vars}
+	ql.Walk(&v, expr)
+	if v.Err() != nil {
+		return nil, UnknownColumn, v.Err()
+	}
+	if len(v.nodes) != 1 {
+		return nil, UnknownColumn, fmt.Errorf("samql: field %s cannot be selected", expr)
+	}
+
+	switch n := v.nodes[0].(type) {
+	case placeholderInt:
+		return func(rec *sam.Record) interface{} { return n(rec) }, IntColumn, nil
+	case placeholderFloat:
+		return func(rec *sam.Record) interface{} { return n(rec) }, FloatColumn, nil
+	case placeholderStr:
+		return func(rec *sam.Record) interface{} { return n(rec) }, StringColumn, nil
+	case placeholderBool:
+		return func(rec *sam.Record) interface{} { return n(rec) }, BoolColumn, nil
+	case FilterFunc:
+		return func(rec *sam.Record) interface{} { return n(rec) }, BoolColumn, nil
+	case string:
+		// Unknown variable references are resolved to their name.
+		if _, ok := expr.(*ql.VarRef); ok {
+			return nil, UnknownColumn, fmt.Errorf("samql: unknown field %s", n)
+		}
+		return func(*sam.Record) interface{} { return n }, StringColumn, nil
+	case int64:
+		return func(*sam.Record) interface{} { return n }, IntColumn, nil
+	case float64:
+		return func(*sam.Record) interface{} { return n }, DoubleColumn, nil
+	case bool:
+		return func(*sam.Record) interface{} { return n }, BoolColumn, nil
+	default:
+		return nil, UnknownColumn, fmt.Errorf("samql: field %s cannot be selected", expr)
+	}
+}
@@ -0,0 +1,521 @@
+package samql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+var queryTests = []struct {
+	Test    string
+	Query   string
+	Columns []string
+	Rows    [][]interface{}
+	Err     bool
+}{
+	{
+		Test:    "Wildcard",
+		Query:   "SELECT * FROM aln WHERE RNAME = 'chr2'",
+		Columns: []string{""},
+		Rows:    [][]interface{}{nil},
+	},
+	{
+		Test:    "Columns",
+		Query:   "SELECT QNAME, POS, MAPQ FROM aln WHERE RNAME = 'chr2'",
+		Columns: []string{"QNAME", "POS", "MAPQ"},
+		Rows:    [][]interface{}{{"r004", 39, 30}},
+	},
+	{
+		Test:    "Alias",
+		Query:   "SELECT QNAME AS name, NM:i, REVERSE FROM aln WHERE NM:i = 1",
+		Columns: []string{"name", "NM:i", "REVERSE"},
+		Rows:    [][]interface{}{{"r001", 1, true}},
+	},
+	{
+		Test:    "Arithmetic",
+		Query:   "SELECT QNAME, END - POS AS span FROM aln WHERE RNAME = 'chr2'",
+		Columns: []string{"QNAME", "span"},
+		Rows:    [][]interface{}{{"r004", 25}},
+	},
+	{
+		Test:    "Identity",
+		Query:   "SELECT ALIGNED_LENGTH, MISMATCHES, IDENTITY FROM aln WHERE NM:i = 1",
+		Columns: []string{"ALIGNED_LENGTH", "MISMATCHES", "IDENTITY"},
+		Rows:    [][]interface{}{{9, 1, float32(1) - float32(1)/float32(9)}},
+	},
+	{
+		Test:    "NoWhere",
+		Query:   "SELECT 'x', QNAME FROM aln",
+		Columns: []string{"", "QNAME"},
+		Rows: [][]interface{}{
+			{"x", "r001"}, {"x", "r002"}, {"x", "r003"}, {"x", "r001"},
+			{"x", "r004"}, {"x", "r005"}, {"x", "r006"}, {"x", "r006"},
+		},
+	},
+	{
+		Test:  "UnknownField",
+		Query: "SELECT MPAQ FROM aln",
+		Err:   true,
+	},
+	{
+		Test:  "WildcardAndField",
+		Query: "SELECT *, QNAME FROM aln",
+		Err:   true,
+	},
+}
+
+func TestQuery(t *testing.T) {
+	for _, tt := range queryTests {
+		q, err := NewQuery(tt.Query)
+		if tt.Err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.Test)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			continue
+		}
+
+		if cols := q.ColumnNames(); !reflect.DeepEqual(cols, tt.Columns) {
+			t.Errorf("%s: columns=%q want %q", tt.Test, cols, tt.Columns)
+		}
+
+		sr, err := sam.NewReader(strings.NewReader(samData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewReader(sr)
+		r.AppendFilter(queryFilter(t, q))
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			continue
+		}
+
+		rows := make([][]interface{}, len(records))
+		for i, rec := range records {
+			rows[i] = q.Values(rec)
+		}
+		if !reflect.DeepEqual(rows, tt.Rows) {
+			t.Errorf("%s: rows=%v want %v", tt.Test, rows, tt.Rows)
+		}
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	for _, tt := range []struct {
+		Query string
+		Types []ColumnType
+	}{
+		{
+			Query: "SELECT * FROM aln",
+			Types: nil,
+		},
+		{
+			Query: "SELECT QNAME, POS, IDENTITY, PAIRED, 1, 1.5, 'x', startswith(QNAME, 'r') FROM aln",
+			Types: []ColumnType{StringColumn, IntColumn, FloatColumn, BoolColumn,
+				IntColumn, DoubleColumn, StringColumn, BoolColumn},
+		},
+		{
+			Query: "SELECT RNAME, count(*), sum(POS), sum(IDENTITY), mean(POS), max(QNAME) FROM aln GROUP BY RNAME",
+			Types: []ColumnType{StringColumn, IntColumn, IntColumn, DoubleColumn,
+				DoubleColumn, StringColumn},
+		},
+	} {
+		q, err := NewQuery(tt.Query)
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Query, err.Error())
+			continue
+		}
+		if types := q.ColumnTypes(); !reflect.DeepEqual(types, tt.Types) {
+			t.Errorf("%s: types=%v want %v", tt.Query, types, tt.Types)
+		}
+	}
+}
+
+var aggregateTests = []struct {
+	Test    string
+	Query   string
+	Columns []string
+	Rows    [][]interface{}
+	Err     bool
+}{
+	{
+		Test:    "All",
+		Query:   "SELECT count(*), mean(MAPQ), max(LENGTH), min(QNAME), sum(NM:i) FROM aln WHERE RNAME = 'chr1'",
+		Columns: []string{"count", "mean", "max", "min", "sum"},
+		Rows:    [][]interface{}{{4, 30.0, 25, "r001", 1}},
+	},
+	{
+		Test:    "FloatSum",
+		Query:   "SELECT sum(de:f) AS s, 'all' AS label FROM aln",
+		Columns: []string{"s", "label"},
+		Rows:    [][]interface{}{{float64(float32(0.0903)), "all"}},
+	},
+	{
+		Test:    "Empty",
+		Query:   "SELECT count(QNAME), min(POS), sum(MAPQ), mean(MAPQ), max(QNAME) FROM aln WHERE MAPQ > 100",
+		Columns: []string{"count", "min", "sum", "mean", "max"},
+		Rows:    [][]interface{}{{0, nil, 0, nil, nil}},
+	},
+	{
+		Test:    "GroupByLimit",
+		Query:   "SELECT RNAME, count(*) FROM aln GROUP BY RNAME LIMIT 2",
+		Columns: []string{"RNAME", "count"},
+		Rows:    [][]interface{}{{"chr1", 4}, {"chr2", 1}},
+	},
+	{
+		Test:    "GroupBy",
+		Query:   "SELECT RNAME, count(*) FROM aln GROUP BY RNAME",
+		Columns: []string{"RNAME", "count"},
+		Rows: [][]interface{}{
+			{"chr1", 4}, {"chr2", 1}, {"1", 1}, {"*", 2},
+		},
+	},
+	{
+		Test:    "GroupByMulti",
+		Query:   "SELECT REVERSE, max(POS), RNAME FROM aln WHERE RNAME =~ /^chr/ GROUP BY RNAME, REVERSE",
+		Columns: []string{"REVERSE", "max", "RNAME"},
+		Rows: [][]interface{}{
+			{false, 15, "chr1"}, {true, 36, "chr1"}, {false, 39, "chr2"},
+		},
+	},
+	{
+		Test:    "GroupByWindow",
+		Query:   "SELECT RNAME, window(POS, 10), count(*) FROM aln WHERE RNAME = 'chr1' GROUP BY RNAME, window(POS, 10)",
+		Columns: []string{"RNAME", "window", "count"},
+		Rows: [][]interface{}{
+			{"chr1", 0, 2}, {"chr1", 10, 1}, {"chr1", 30, 1},
+		},
+	},
+	{
+		Test:    "GroupByStrand",
+		Query:   "SELECT STRAND, count(*) FROM aln GROUP BY STRAND",
+		Columns: []string{"STRAND", "count"},
+		Rows:    [][]interface{}{{"+", 7}, {"-", 1}},
+	},
+	{
+		Test:    "GroupByOnly",
+		Query:   "SELECT MAPQ FROM aln GROUP BY MAPQ",
+		Columns: []string{"MAPQ"},
+		Rows:    [][]interface{}{{30}, {29}, {0}},
+	},
+	{
+		Test:    "GroupByEmpty",
+		Query:   "SELECT RNAME, count(*) FROM aln WHERE MAPQ > 100 GROUP BY RNAME",
+		Columns: []string{"RNAME", "count"},
+		Rows:    [][]interface{}{},
+	},
+	{
+		Test:    "CountDistinct",
+		Query:   "SELECT count(DISTINCT QNAME) AS names, count(DISTINCT MAPQ) FROM aln",
+		Columns: []string{"names", "count"},
+		Rows:    [][]interface{}{{6, 3}},
+	},
+	{
+		Test:    "GroupByCountDistinct",
+		Query:   "SELECT RNAME, count(DISTINCT QNAME) FROM aln WHERE RNAME =~ /^chr/ GROUP BY RNAME",
+		Columns: []string{"RNAME", "count"},
+		Rows:    [][]interface{}{{"chr1", 3}, {"chr2", 1}},
+	},
+	{
+		Test:  "DistinctSum",
+		Query: "SELECT sum(DISTINCT POS) FROM aln",
+		Err:   true,
+	},
+	{
+		Test:  "DistinctWildcard",
+		Query: "SELECT count(DISTINCT *) FROM aln",
+		Err:   true,
+	},
+	{
+		Test:  "NonAggregateField",
+		Query: "SELECT QNAME, count(*) FROM aln",
+		Err:   true,
+	},
+	{
+		Test:  "NonGroupedField",
+		Query: "SELECT QNAME, count(*) FROM aln GROUP BY RNAME",
+		Err:   true,
+	},
+	{
+		Test:  "WildcardArg",
+		Query: "SELECT sum(*) FROM aln",
+		Err:   true,
+	},
+	{
+		Test:  "ArgCount",
+		Query: "SELECT max(POS, MAPQ) FROM aln",
+		Err:   true,
+	},
+	{
+		Test:  "SumString",
+		Query: "SELECT sum(QNAME) FROM aln",
+		Err:   true,
+	},
+	{
+		Test:  "MeanStringTag",
+		Query: "SELECT RNAME, mean(MD:Z) FROM aln GROUP BY RNAME",
+		Err:   true,
+	},
+}
+
+func TestAggregate(t *testing.T) {
+	for _, tt := range aggregateTests {
+		q, err := NewQuery(tt.Query)
+		if tt.Err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.Test)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			continue
+		}
+		if !q.IsAggregate() {
+			t.Errorf("%s: expected aggregate query", tt.Test)
+			continue
+		}
+
+		if cols := q.ColumnNames(); !reflect.DeepEqual(cols, tt.Columns) {
+			t.Errorf("%s: columns=%q want %q", tt.Test, cols, tt.Columns)
+		}
+
+		sr, err := sam.NewReader(strings.NewReader(samData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewReader(sr)
+		r.AppendFilter(queryFilter(t, q))
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			continue
+		}
+
+		agg := q.NewAggregation()
+		for _, rec := range records {
+			agg.Add(rec)
+		}
+		if rows := agg.Rows(); !reflect.DeepEqual(rows, tt.Rows) {
+			t.Errorf("%s: rows=%v want %v", tt.Test, rows, tt.Rows)
+		}
+	}
+}
+
+var sortTests = []struct {
+	Test   string
+	Query  string
+	Buffer int
+	Names  []string
+	Order  sam.SortOrder
+}{
+	{
+		Test:   "Coordinate",
+		Query:  "SELECT * FROM aln ORDER BY RNAME, POS",
+		Buffer: DefaultSortBuffer,
+		Names:  []string{"r001", "r002", "r003", "r001", "r004", "r005", "r006", "r006"},
+		Order:  sam.Coordinate,
+	},
+	{
+		Test:   "CoordinateSpill",
+		Query:  "SELECT * FROM aln ORDER BY RNAME, POS",
+		Buffer: 3,
+		Names:  []string{"r001", "r002", "r003", "r001", "r004", "r005", "r006", "r006"},
+		Order:  sam.Coordinate,
+	},
+	{
+		Test:   "DescSpill",
+		Query:  "SELECT * FROM aln WHERE RNAME = 'chr1' ORDER BY POS DESC",
+		Buffer: 2,
+		Names:  []string{"r001", "r003", "r002", "r001"},
+		Order:  sam.Unsorted,
+	},
+	{
+		Test:   "QueryName",
+		Query:  "SELECT QNAME FROM aln WHERE MAPQ > 0 ORDER BY QNAME, FLAG DESC",
+		Buffer: 4,
+		Names:  []string{"r001", "r001", "r002", "r003", "r004", "r005"},
+		Order:  sam.QueryName,
+	},
+}
+
+func TestSort(t *testing.T) {
+	for _, tt := range sortTests {
+		q, err := NewQuery(tt.Query)
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			continue
+		}
+		if o := q.SortOrder(); o != tt.Order {
+			t.Errorf("%s: sort order=%v want %v", tt.Test, o, tt.Order)
+		}
+
+		sr, err := sam.NewReader(strings.NewReader(samData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewReader(sr)
+		r.AppendFilter(queryFilter(t, q))
+
+		s := q.NewSorter(r.Header())
+		s.BufferSize = tt.Buffer
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range records {
+			if err := s.Add(rec); err != nil {
+				t.Fatalf("%s: unexpected error %q", tt.Test, err.Error())
+			}
+		}
+
+		src, err := s.Sort()
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+		sorted, err := NewReader(src).ReadAll()
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+		names := make([]string, len(sorted))
+		for i, rec := range sorted {
+			names[i] = rec.Name
+		}
+		if !reflect.DeepEqual(names, tt.Names) {
+			t.Errorf("%s: names=%v want %v", tt.Test, names, tt.Names)
+		}
+		if err := src.Close(); err != nil {
+			t.Errorf("%s: unexpected close error %q", tt.Test, err.Error())
+		}
+	}
+}
+
+// queryFilter returns the filter of the WHERE clause of q.
+func queryFilter(t *testing.T, q *Query) FilterFunc {
+	if q.Where() == "" {
+		return func(*sam.Record) bool { return true }
+	}
+	f, err := Where(q.Where())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// bindTests run over the records of samData, read from a.sam, followed by
+// those of samDataRG, read from rg.sam.
+var bindTests = []struct {
+	Test  string
+	Query string
+	Rows  [][]interface{}
+}{
+	{
+		Test:  "GroupBySource",
+		Query: "SELECT SOURCE, count(*) FROM aln GROUP BY SOURCE",
+		Rows:  [][]interface{}{{"a.sam", 8}, {"rg.sam", 5}},
+	},
+	{
+		Test:  "Source",
+		Query: "SELECT QNAME, SOURCE FROM aln WHERE QNAME = 'r002'",
+		Rows:  [][]interface{}{{"r002", "a.sam"}, {"r002", "rg.sam"}},
+	},
+	{
+		Test:  "File",
+		Query: "SELECT QNAME, FILE FROM aln WHERE FILE =~ /rg/ AND POS < 10",
+		Rows:  [][]interface{}{{"r001", "rg.sam"}, {"r002", "rg.sam"}},
+	},
+	{
+		Test:  "GroupByFile",
+		Query: "SELECT FILE, max(POS) FROM aln GROUP BY FILE",
+		Rows:  [][]interface{}{{"a.sam", 39}, {"rg.sam", 29}},
+	},
+	{
+		Test:  "ReadGroup",
+		Query: "SELECT QNAME, SAMPLE, LIBRARY, PLATFORM FROM aln WHERE SOURCE = 'rg.sam'",
+		Rows: [][]interface{}{
+			{"r001", "NA12878", "lib1", "ILLUMINA"},
+			{"r002", "NA12878", "lib2", "PACBIO"},
+			{"r003", "NA12891", "lib3", "ILLUMINA"},
+			{"r004", "", "", ""},
+			{"r005", "", "", ""},
+		},
+	},
+	{
+		Test:  "GroupBySample",
+		Query: "SELECT SAMPLE, count(*) FROM aln GROUP BY SAMPLE",
+		Rows:  [][]interface{}{{"", 10}, {"NA12878", 2}, {"NA12891", 1}},
+	},
+	{
+		Test:  "GroupByPlatform",
+		Query: "SELECT PLATFORM, count(DISTINCT LIBRARY) FROM aln WHERE SOURCE = 'rg.sam' GROUP BY PLATFORM",
+		Rows:  [][]interface{}{{"ILLUMINA", 2}, {"PACBIO", 1}, {"", 1}},
+	},
+	{
+		Test:  "CountDistinctSource",
+		Query: "SELECT count(DISTINCT SOURCE), count(*) FROM aln WHERE SOURCE = 'rg.sam' OR POS < 10",
+		Rows:  [][]interface{}{{2, 9}},
+	},
+}
+
+func TestBind(t *testing.T) {
+	inputs := []struct {
+		Name string
+		Data string
+	}{
+		{"a.sam", samData},
+		{"rg.sam", samDataRG},
+	}
+	for _, tt := range bindTests {
+		t.Run(tt.Test, func(t *testing.T) {
+			q, err := NewQuery(tt.Query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !q.NeedsInput() {
+				t.Error("expected query that needs input")
+			}
+			var agg *Aggregation
+			if q.IsAggregate() {
+				agg = q.NewAggregation()
+			}
+			var rows [][]interface{}
+			for _, in := range inputs {
+				sr, err := sam.NewReader(strings.NewReader(in.Data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				bq, err := q.Bind(in.Name, sr.Header())
+				if err != nil {
+					t.Fatal(err)
+				}
+				r := NewReader(sr)
+				if where := q.Where(); where != "" {
+					r.AppendFilter(Must(WhereHeader(where, in.Name, sr.Header())))
+				}
+				records, err := r.ReadAll()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, rec := range records {
+					if agg != nil {
+						agg.AddWith(bq, rec)
+						continue
+					}
+					rows = append(rows, bq.Values(rec))
+				}
+			}
+			if agg != nil {
+				rows = agg.Rows()
+			}
+			if !reflect.DeepEqual(rows, tt.Rows) {
+				t.Errorf("rows=%v want %v", rows, tt.Rows)
+			}
+		})
+	}
+}
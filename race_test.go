@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package samql
+
+// raceEnabled is true if the tests run with the race detector, under which
+// sync.Pool drops items at random, so pooled buffers can allocate.
+const raceEnabled = true
@@ -0,0 +1,161 @@
+package samql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+)
+
+// RefAliases holds the alternative names of references, e.g. 1 for chr1, to
+// match the references of files that use different naming conventions, such
+// as UCSC and Ensembl. Each name is mapped to all its aliases.
+type RefAliases map[string][]string
+
+// ReadRefAliases reads reference aliases from r. Each line has the tab
+// separated names of a reference, e.g. "chr1\t1", as in the chromAlias files
+// of UCSC. Empty lines and comments are skipped.
+func ReadRefAliases(r io.Reader) (RefAliases, error) {
+	a := make(RefAliases)
+	s := bufio.NewScanner(r)
+	n := 0
+	for s.Scan() {
+		n++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var names []string
+		for _, name := range strings.Split(line, "\t") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) < 2 {
+			return nil, fmt.Errorf("samql: line %d: expected a name and its aliases", n)
+		}
+		a.Add(names...)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ReadRefAliasesFile reads the reference aliases of the file at path, which
+// can be gzip compressed.
+func ReadRefAliasesFile(path string) (RefAliases, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		return nil, err
+	}
+	return ReadRefAliases(r)
+}
+
+// BuiltinRefAliases returns the aliases of the UCSC and Ensembl names of the
+// references of the human genome assembly name, GRCh38 (hg38) or GRCh37
+// (hg19), e.g. chr1 and 1 or chrM and MT. GRCh38 covers the chromosomes and
+// GRCh37 additionally the unlocalized and unplaced contigs, e.g.
+// chr1_gl000191_random and GL000191.1. It returns false for other names.
+func BuiltinRefAliases(name string) (RefAliases, bool) {
+	a := make(RefAliases)
+	switch strings.ToLower(name) {
+	case "grch38", "hg38":
+	case "grch37", "hg19":
+		for _, c := range grch37Contigs {
+			id := strconv.Itoa(c.id)
+			a.Add(c.chrom+"_gl000"+id+"_random", "GL000"+id+".1")
+		}
+		for id := 211; id <= 249; id++ {
+			a.Add("chrUn_gl000"+strconv.Itoa(id), "GL000"+strconv.Itoa(id)+".1")
+		}
+	default:
+		return nil, false
+	}
+	for i := 1; i <= 22; i++ {
+		a.Add("chr"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	a.Add("chrX", "X")
+	a.Add("chrY", "Y")
+	a.Add("chrM", "MT")
+	return a, true
+}
+
+// grch37Contigs are the unlocalized contigs of GRCh37 and their chromosomes,
+// which are named as chr1_gl000191_random in hg19.
+var grch37Contigs = []struct {
+	chrom string
+	id    int
+}{
+	{"chr1", 191}, {"chr1", 192}, {"chr4", 193}, {"chr4", 194}, {"chr7", 195},
+	{"chr8", 196}, {"chr8", 197}, {"chr9", 198}, {"chr9", 199}, {"chr9", 200},
+	{"chr9", 201}, {"chr11", 202}, {"chr17", 203}, {"chr17", 204},
+	{"chr17", 205}, {"chr17", 206}, {"chr18", 207}, {"chr19", 208},
+	{"chr19", 209}, {"chr21", 210},
+}
+
+// Add adds names as aliases of each other.
+func (a RefAliases) Add(names ...string) {
+	for _, name := range names {
+		for _, alias := range names {
+			if alias != name && !hasString(a[name], alias) {
+				a[name] = append(a[name], alias)
+			}
+		}
+	}
+}
+
+// hasString returns true if s is in list.
+func hasString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Renamer returns a function that renames the reference names that are not
+// in h to an alias that is in h, e.g. chr1 to 1 for a header with Ensembl
+// names. Other names are returned unchanged. It can be used with RenameRefs
+// to query files of a different naming convention.
+func (a RefAliases) Renamer(h *sam.Header) func(string) string {
+	refs := make(map[string]bool)
+	for _, r := range h.Refs() {
+		refs[r.Name()] = true
+	}
+	return func(name string) string {
+		if refs[name] {
+			return name
+		}
+		for _, alias := range a[name] {
+			if refs[alias] {
+				return alias
+			}
+		}
+		return name
+	}
+}
+
+// Normalize renames the references of h that are not in to, but have an alias
+// in to, to that alias, so that the headers of files of different naming
+// conventions can be merged. The records that refer to the references of h
+// are renamed as well. References whose alias is already in h are not
+// renamed.
+func (a RefAliases) Normalize(h, to *sam.Header) {
+	rename := a.Renamer(to)
+	for _, r := range h.Refs() {
+		if name := rename(r.Name()); name != r.Name() {
+			r.SetName(name) // Fails only if the alias is in h.
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package samql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestReadRefAliases(t *testing.T) {
+	data := "# ucsc\tensembl\tgenbank\n" +
+		"chr1\t1\tCM000663.2\n" +
+		"\n" +
+		"chrM\tMT\n"
+	a, err := ReadRefAliases(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := RefAliases{
+		"chr1":       {"1", "CM000663.2"},
+		"1":          {"chr1", "CM000663.2"},
+		"CM000663.2": {"chr1", "1"},
+		"chrM":       {"MT"},
+		"MT":         {"chrM"},
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("expected %v, got %v", want, a)
+	}
+	if _, err := ReadRefAliases(strings.NewReader("chr1\n")); err == nil {
+		t.Errorf("expected error for a line without aliases")
+	}
+}
+
+func TestBuiltinRefAliases(t *testing.T) {
+	for _, tt := range []struct {
+		Name  string
+		Alias string
+		Want  []string
+	}{
+		{Name: "GRCh38", Alias: "chr22", Want: []string{"22"}},
+		{Name: "hg38", Alias: "MT", Want: []string{"chrM"}},
+		{Name: "GRCh38", Alias: "chrUn_gl000220", Want: nil},
+		{Name: "GRCh37", Alias: "chrUn_gl000220", Want: []string{"GL000220.1"}},
+		{Name: "GRCh37", Alias: "GL000191.1", Want: []string{"chr1_gl000191_random"}},
+	} {
+		a, ok := BuiltinRefAliases(tt.Name)
+		if !ok {
+			t.Fatalf("%s: expected built-in aliases", tt.Name)
+		}
+		if got := a[tt.Alias]; !reflect.DeepEqual(got, tt.Want) {
+			t.Errorf("%s: %s: expected %v, got %v", tt.Name, tt.Alias, tt.Want, got)
+		}
+	}
+	if _, ok := BuiltinRefAliases("mm10"); ok {
+		t.Errorf("expected no built-in aliases for mm10")
+	}
+}
+
+func TestRefAliasesNormalize(t *testing.T) {
+	newHeader := func(names ...string) *sam.Header {
+		var refs []*sam.Reference
+		for _, name := range names {
+			ref, err := sam.NewReference(name, "", "", 1000, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			refs = append(refs, ref)
+		}
+		h, err := sam.NewHeader(nil, refs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	a, _ := BuiltinRefAliases("GRCh38")
+	ucsc := newHeader("chr1", "chr2", "chrM")
+	ensembl := newHeader("1", "2", "MT", "KI270728.1")
+
+	rename := a.Renamer(ensembl)
+	for name, want := range map[string]string{"chr1": "1", "2": "2", "chrM": "MT", "chr3": "chr3"} {
+		if got := rename(name); got != want {
+			t.Errorf("expected %s renamed to %s, got %s", name, want, got)
+		}
+	}
+
+	a.Normalize(ensembl, ucsc)
+	var names []string
+	for _, r := range ensembl.Refs() {
+		names = append(names, r.Name())
+	}
+	want := []string{"chr1", "chr2", "chrM", "KI270728.1"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("expected references %v, got %v", want, names)
+	}
+}
@@ -0,0 +1,520 @@
+package samql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// Region is a region on a reference sequence. Start is 0-based and End is
+// exclusive. An End that is not positive corresponds to the end of the
+// reference.
+type Region struct {
+	Rname      string
+	Start, End int
+}
+
+// String returns the region as [rname:start-end), e.g. [chr1:0-1000) or
+// [chr2:5000-end) for a region that extends to the end of the reference.
+func (r Region) String() string {
+	end := "end"
+	if r.End > 0 {
+		end = strconv.Itoa(r.End)
+	}
+	return "[" + r.Rname + ":" + strconv.Itoa(r.Start) + "-" + end + ")"
+}
+
+// ParseRegion parses a region in the syntax of samtools, e.g. chr1,
+// chr1:10000 or chr1:10,000-20,000. Positions are 1-based and inclusive and
+// the end is optional, as in samtools.
+func ParseRegion(s string) (Region, error) {
+	i := strings.LastIndex(s, ":")
+	if i == -1 {
+		if s == "" {
+			return Region{}, errors.New("samql: empty region")
+		}
+		return Region{Rname: s}, nil
+	}
+	r := Region{Rname: s[:i]}
+	if r.Rname == "" {
+		return Region{}, fmt.Errorf("samql: invalid region %q: no reference name", s)
+	}
+
+	bounds := strings.SplitN(strings.Replace(s[i+1:], ",", "", -1), "-", 2)
+	start, err := strconv.Atoi(bounds[0])
+	if err != nil || start < 1 {
+		return Region{}, fmt.Errorf("samql: invalid region %q: invalid start", s)
+	}
+	r.Start = start - 1
+	if len(bounds) == 2 && bounds[1] != "" {
+		end, err := strconv.Atoi(bounds[1])
+		if err != nil || end < start {
+			return Region{}, fmt.Errorf("samql: invalid region %q: invalid end", s)
+		}
+		r.End = end
+	}
+	return r, nil
+}
+
+// RegionsWhere returns a WHERE clause that matches the records that overlap
+// any of regions, e.g. "RNAME = 'chr1' AND POS < 2000 AND (END > 1000 OR POS
+// >= 1000)" for [chr1:1000-2000). Records without a CIGAR occupy one
+// position. Queries of the clause read only the regions from indexed files.
+func RegionsWhere(regions []Region) string {
+	var b strings.Builder
+	for i, r := range regions {
+		if i > 0 {
+			b.WriteString(" OR ")
+		}
+		if len(regions) > 1 {
+			b.WriteString("(")
+		}
+		b.WriteString("RNAME = " + (&ql.StringLiteral{Val: r.Rname}).String())
+		if r.End > 0 {
+			b.WriteString(" AND POS < " + strconv.Itoa(r.End))
+		}
+		if r.Start > 0 {
+			start := strconv.Itoa(r.Start)
+			b.WriteString(" AND (END > " + start + " OR POS >= " + start + ")")
+		}
+		if len(regions) > 1 {
+			b.WriteString(")")
+		}
+	}
+	return b.String()
+}
+
+// QueryRegions returns the regions that contain all records that can match
+// the WHERE clause query, e.g. the regions [chr1:0-1000) and [chr2:5000-end)
+// for "(RNAME = 'chr1' AND POS < 1000) OR (RNAME = 'chr2' AND POS > 5000)".
+// The regions can be used to read only part of an indexed BAM file. The
+// regions may contain records that do not match query, so records still need
+// to be filtered. It returns false if query cannot be restricted to regions,
+// e.g. because it does not constrain RNAME, or if query is invalid.
+func QueryRegions(query string) ([]Region, bool) {
+	stmt, err := parseWhere(query)
+	if err != nil {
+		return nil, false
+	}
+	return condRegions(stmt.Condition)
+}
+
+// RenameRefs returns the WHERE clause query with each reference name that is
+// compared to RNAME or RNEXT replaced by rename, e.g. to query files that name
+// the references differently, such as chr1 and 1. rename returns the name
+// unchanged for the references that are not renamed.
+func RenameRefs(query string, rename func(name string) string) (string, error) {
+	stmt, err := parseWhere(query)
+	if err != nil {
+		return "", err
+	}
+	ql.WalkFunc(stmt.Condition, func(n ql.Node) bool {
+		e, ok := n.(*ql.BinaryExpr)
+		if !ok || (e.Op != ql.EQ && e.Op != ql.NEQ && e.Op != ql.IN) {
+			return true
+		}
+		if ref, ok := e.LHS.(*ql.VarRef); !ok || (ref.Val != "RNAME" && ref.Val != "RNEXT") {
+			return true
+		}
+		if list, ok := e.RHS.(*ql.ListLiteral); ok {
+			for i, v := range list.Vals {
+				list.Vals[i] = renameRef(v, rename).(ql.Literal)
+			}
+			return false
+		}
+		e.RHS = renameRef(e.RHS, rename)
+		return false
+	})
+
+	cond := "true"
+	if stmt.Condition != nil {
+		cond = stmt.Condition.String()
+	}
+	if stmt.Sample > 0 {
+		cond += " SAMPLE " + strconv.FormatFloat(stmt.Sample, 'f', -1, 64)
+	}
+	if stmt.Limit > 0 {
+		cond += " LIMIT " + strconv.Itoa(stmt.Limit)
+	}
+	return cond, nil
+}
+
+// renameRef returns the string literal of the reference name of expr renamed
+// by rename or expr if it is not a reference name or is not renamed.
+func renameRef(expr ql.Expr, rename func(string) string) ql.Expr {
+	var name string
+	switch v := expr.(type) {
+	case *ql.StringLiteral:
+		name = v.Val
+	case *ql.IntegerLiteral:
+		name = strconv.FormatInt(v.Val, 10)
+	case *ql.VarRef:
+		if evalVarRef(v.Val) != v.Val || boundVars[v.Val] {
+			return expr
+		}
+		name = v.Val
+	default:
+		return expr
+	}
+	if name == "*" || name == "=" {
+		return expr
+	}
+	if renamed := rename(name); renamed != name {
+		return &ql.StringLiteral{Val: renamed}
+	}
+	return expr
+}
+
+// condRegions returns the regions that contain all records that can match the
+// condition expression cond. It returns false if cond cannot be restricted to
+// regions.
+func condRegions(cond ql.Expr) ([]Region, bool) {
+	regions, ok := exprRegions(cond)
+	if !ok {
+		return nil, false
+	}
+	for _, r := range regions {
+		if r.Rname == "" { // Position constraints alone require a full scan.
+			return nil, false
+		}
+	}
+	return regions, true
+}
+
+// exprRegions returns the regions that contain all records that match expr.
+// Regions with an empty Rname constrain only the position. It returns false
+// if expr cannot be restricted to regions.
+func exprRegions(expr ql.Expr) ([]Region, bool) {
+	switch e := expr.(type) {
+	case *ql.ParenExpr:
+		return exprRegions(e.Expr)
+
+	case *ql.BinaryExpr:
+		switch e.Op {
+		case ql.OR:
+			l, lok := exprRegions(e.LHS)
+			r, rok := exprRegions(e.RHS)
+			if !lok || !rok {
+				return nil, false
+			}
+			// Identical regions are read once.
+			for _, reg := range r {
+				if !hasRegion(l, reg) {
+					l = append(l, reg)
+				}
+			}
+			return l, true
+
+		case ql.AND:
+			l, lok := exprRegions(e.LHS)
+			r, rok := exprRegions(e.RHS)
+			switch {
+			case !lok && !rok:
+				return nil, false
+			case !lok:
+				return r, true
+			case !rok:
+				return l, true
+			}
+			var regions []Region
+			for _, a := range l {
+				for _, b := range r {
+					if reg, ok := intersectRegions(a, b); ok {
+						regions = append(regions, reg)
+					}
+				}
+			}
+			return regions, true
+		}
+		return comparisonRegions(e)
+	}
+	return nil, false
+}
+
+// comparisonRegions returns the region that corresponds to a comparison of
+// RNAME or POS with a constant.
+func comparisonRegions(e *ql.BinaryExpr) ([]Region, bool) {
+	ref, ok := e.LHS.(*ql.VarRef)
+	if !ok {
+		return nil, false
+	}
+
+	switch ref.Val {
+	case "RNAME":
+		if e.Op != ql.EQ {
+			return nil, false
+		}
+		switch v := e.RHS.(type) {
+		case *ql.StringLiteral:
+			return []Region{{Rname: v.Val}}, true
+		case *ql.IntegerLiteral:
+			return []Region{{Rname: strconv.FormatInt(v.Val, 10)}}, true
+		case *ql.VarRef:
+			// Unknown variable references are resolved to their name.
+			// Some are bound to values for specific inputs.
+			if evalVarRef(v.Val) == v.Val && !boundVars[v.Val] {
+				return []Region{{Rname: v.Val}}, true
+			}
+		}
+		return nil, false
+
+	case "END":
+		// Records that end after a position overlap the region that starts
+		// at the position.
+		v, ok := e.RHS.(*ql.IntegerLiteral)
+		if !ok {
+			return nil, false
+		}
+		start := int(v.Val)
+		switch e.Op {
+		case ql.GTE:
+			start--
+		case ql.GT:
+		default:
+			return nil, false
+		}
+		if start < 0 {
+			start = 0
+		}
+		return []Region{{Start: start}}, true
+
+	case "POS":
+		if e.Op == ql.BETWEEN {
+			rng, ok := e.RHS.(*ql.RangeExpr)
+			if !ok {
+				return nil, false
+			}
+			lower, lok := rng.Lower.(*ql.IntegerLiteral)
+			upper, uok := rng.Upper.(*ql.IntegerLiteral)
+			if !lok || !uok {
+				return nil, false
+			}
+			return []Region{{Start: int(lower.Val), End: int(upper.Val) + 1}}, true
+		}
+
+		v, ok := e.RHS.(*ql.IntegerLiteral)
+		if !ok {
+			return nil, false
+		}
+		pos := int(v.Val)
+		switch e.Op {
+		case ql.EQ:
+			return []Region{{Start: pos, End: pos + 1}}, true
+		case ql.GT, ql.GTE:
+			return []Region{{Start: pos}}, true
+		case ql.LT, ql.LTE:
+			return []Region{{End: pos + 1}}, true
+		}
+	}
+	return nil, false
+}
+
+// hasRegion returns true if regions contains r.
+func hasRegion(regions []Region, r Region) bool {
+	for _, reg := range regions {
+		if reg == r {
+			return true
+		}
+	}
+	return false
+}
+
+// intersectRegions returns the intersection of regions a and b. It returns
+// false if a and b do not overlap.
+func intersectRegions(a, b Region) (Region, bool) {
+	r := a
+	if b.Rname != "" {
+		if a.Rname != "" && a.Rname != b.Rname {
+			return Region{}, false
+		}
+		r.Rname = b.Rname
+	}
+	if b.Start > r.Start {
+		r.Start = b.Start
+	}
+	if b.End > 0 && (r.End <= 0 || b.End < r.End) {
+		r.End = b.End
+	}
+	if r.End > 0 && r.End <= r.Start {
+		return Region{}, false
+	}
+	return r, true
+}
+
+// QueryPlan is the plan to read the records that match a WHERE clause. It
+// holds the regions that need to be read from an indexed file and the filters
+// that need to be applied to the records read.
+type QueryPlan struct {
+	// UseIndex is true if only the records overlapping Regions need to be
+	// read. No records can match if UseIndex is true and Regions is empty.
+	UseIndex bool
+	// Regions contains all records that can match the query.
+	Regions []Region
+	// Filter evaluates the complete query. It is used when records are not
+	// restricted to Regions, e.g. for files without an index.
+	Filter FilterFunc
+	// Residual evaluates the part of the query that is not satisfied by
+	// reading only Regions from an indexed file. Reference name equalities
+	// that apply to the whole query are satisfied by the index. Position
+	// constraints are always evaluated, because the index returns all
+	// records that overlap a region.
+	Residual FilterFunc
+	// RegionOnly is true if the query consists only of the RNAME and POS
+	// constraints that define Regions, so the Residual reads only the
+	// reference and position of records. Records read from the index can
+	// then be decoded without their sequence, qualities and tags, e.g. to
+	// count them.
+	RegionOnly bool
+	// Limit is the maximum number of records that are read, as set by the
+	// LIMIT clause, or 0 for all records. It is not applied by the filters.
+	Limit int
+
+	cond, residual ql.Expr
+}
+
+// String returns a description of p with the query, the regions and the
+// residual query, e.g. "RNAME = chr1 AND POS > 100; regions [chr1:100-end);
+// residual POS > 100".
+func (p *QueryPlan) String() string {
+	if !p.UseIndex {
+		return p.cond.String() + "; no regions"
+	}
+	regions := make([]string, len(p.Regions))
+	for i, r := range p.Regions {
+		regions[i] = r.String()
+	}
+	residual := "TRUE"
+	if len(p.Regions) == 0 {
+		residual = "FALSE"
+	} else if p.residual != nil {
+		residual = p.residual.String()
+	}
+	if len(regions) == 0 {
+		regions = []string{"none"}
+	}
+	return p.cond.String() + "; regions " + strings.Join(regions, " ") +
+		"; residual " + residual
+}
+
+// Plan returns the QueryPlan for the SQL WHERE statement query, e.g. for
+// "RNAME = chr1 AND POS > 100 AND MAPQ > 10" only the region [chr1:100-end)
+// needs to be read and the Residual filter evaluates "POS > 100 AND MAPQ > 10".
+// The filters are created with opts, as Where.
+func Plan(query string, opts ...FilterOption) (*QueryPlan, error) {
+	return plan(query, withOptions(nil, opts))
+}
+
+// PlanInput is similar to Plan but additionally binds the SOURCE and FILE
+// keywords to input, as WhereInput.
+func PlanInput(query, input string, opts ...FilterOption) (*QueryPlan, error) {
+	return plan(query, withOptions(inputVars(input), opts))
+}
+
+// PlanHeader is similar to PlanInput but additionally resolves the read group
+// keywords using the read groups in h, as WhereHeader.
+func PlanHeader(query, input string, h *sam.Header, opts ...FilterOption) (*QueryPlan, error) {
+	return plan(query, withOptions(headerVars(input, h), opts))
+}
+
+// plan returns the QueryPlan for query. Variable references in query that
+// match a key in vars are resolved to the corresponding value.
+func plan(query string, vars map[string]interface{}) (*QueryPlan, error) {
+	stmt, err := parseWhere(query)
+	if err != nil {
+		return nil, err
+	}
+	cond := stmt.Condition
+	filter, err := newFilter(cond, vars)
+	if err != nil {
+		return nil, err
+	}
+	filter = withSample(filter, stmt.Sample, optionsOf(vars).seed)
+	p := &QueryPlan{Filter: filter, Residual: filter, Limit: stmt.Limit, cond: cond, residual: cond}
+
+	regions, ok := condRegions(cond)
+	if !ok {
+		return p, nil
+	}
+	p.UseIndex, p.Regions = true, regions
+	if len(regions) == 0 { // Contradicting constraints match no records.
+		p.Residual = func(*sam.Record) bool { return false }
+		return p, nil
+	}
+
+	// All regions are on the reference of a top level RNAME equality, so
+	// the equality holds for all records read from the index.
+	var residual ql.Expr
+	for _, c := range conjuncts(cond) {
+		if isRnameEq(c) {
+			continue
+		}
+		if residual == nil {
+			residual = c
+			continue
+		}
+		residual = &ql.BinaryExpr{Op: ql.AND, LHS: parenOR(residual), RHS: parenOR(c)}
+	}
+	p.residual = residual
+	if p.Residual, err = newFilter(residual, vars); err != nil {
+		return nil, err
+	}
+	p.Residual = withSample(p.Residual, stmt.Sample, optionsOf(vars).seed)
+	p.RegionOnly = stmt.Sample == 0 && regionOnly(residual)
+	return p, nil
+}
+
+// regionOnly returns true if the residual expr reads only the reference and
+// the position of records.
+func regionOnly(residual ql.Expr) bool {
+	fields, tags, funcs := references(residual)
+	if len(tags) > 0 || len(funcs) > 0 {
+		return false
+	}
+	for f := range fields {
+		if f != "RNAME" && f != "POS" {
+			return false
+		}
+	}
+	return true
+}
+
+// conjuncts returns the expressions that are combined with AND at the top
+// level of expr.
+func conjuncts(expr ql.Expr) []ql.Expr {
+	switch e := expr.(type) {
+	case *ql.ParenExpr:
+		return conjuncts(e.Expr)
+	case *ql.BinaryExpr:
+		if e.Op == ql.AND {
+			return append(conjuncts(e.LHS), conjuncts(e.RHS)...)
+		}
+	}
+	return []ql.Expr{expr}
+}
+
+// parenOR returns expr in parentheses if it is an OR expression, so that its
+// precedence is kept when it is combined with AND and printed.
+func parenOR(expr ql.Expr) ql.Expr {
+	if e, ok := expr.(*ql.BinaryExpr); ok && e.Op == ql.OR {
+		return &ql.ParenExpr{Expr: expr}
+	}
+	return expr
+}
+
+// isRnameEq returns true if expr compares RNAME with a reference name for
+// equality.
+func isRnameEq(expr ql.Expr) bool {
+	e, ok := expr.(*ql.BinaryExpr)
+	if !ok || e.Op != ql.EQ {
+		return false
+	}
+	if ref, ok := e.LHS.(*ql.VarRef); !ok || ref.Val != "RNAME" {
+		return false
+	}
+	_, ok = comparisonRegions(e)
+	return ok
+}
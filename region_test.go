@@ -0,0 +1,292 @@
+package samql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestQueryRegions(t *testing.T) {
+	for _, tt := range []struct {
+		Query   string
+		Regions []Region
+		OK      bool
+	}{
+		{
+			Query:   "RNAME = chr1",
+			Regions: []Region{{Rname: "chr1"}},
+			OK:      true,
+		},
+		{
+			Query:   "RNAME = 'chr1' AND POS > 100 AND POS < 200 AND MAPQ > 10",
+			Regions: []Region{{Rname: "chr1", Start: 100, End: 201}},
+			OK:      true,
+		},
+		{
+			Query:   "(RNAME = 'chr1' AND POS < 1000) OR (RNAME = 'chr2' AND POS > 5000)",
+			Regions: []Region{{Rname: "chr1", End: 1001}, {Rname: "chr2", Start: 5000}},
+			OK:      true,
+		},
+		{
+			Query:   "RNAME = '*' OR (RNAME = chr2 AND POS < 100)",
+			Regions: []Region{{Rname: "*"}, {Rname: "chr2", End: 101}},
+			OK:      true,
+		},
+		{
+			Query:   "RNAME IN ('chr1') OR RNAME = 1",
+			Regions: nil,
+			OK:      false,
+		},
+		{
+			Query:   "(RNAME = chr1 OR RNAME = 1) AND POS BETWEEN 10 AND 20",
+			Regions: []Region{{Rname: "chr1", Start: 10, End: 21}, {Rname: "1", Start: 10, End: 21}},
+			OK:      true,
+		},
+		{
+			Query:   "RNAME = chr1 AND RNAME = chr2",
+			Regions: nil,
+			OK:      true,
+		},
+		{
+			Query:   "RNAME = chr1 AND (END > 100 OR POS >= 100) AND END >= 50",
+			Regions: []Region{{Rname: "chr1", Start: 100}},
+			OK:      true,
+		},
+		{
+			Query:   "RNAME = chr1 OR RNAME = chr1",
+			Regions: []Region{{Rname: "chr1"}},
+			OK:      true,
+		},
+		{Query: "RNAME = chr1 OR END > 100", OK: false},
+		{Query: "RNAME = RNEXT", OK: false},
+		{Query: "RNAME = chr1 OR MAPQ > 10", OK: false},
+		{Query: "NOT RNAME = chr1", OK: false},
+		{Query: "POS > 100", OK: false},
+		{Query: "RNAME = ", OK: false},
+	} {
+		regions, ok := QueryRegions(tt.Query)
+		if ok != tt.OK {
+			t.Errorf("%s: ok=%t want %t", tt.Query, ok, tt.OK)
+			continue
+		}
+		if !reflect.DeepEqual(regions, tt.Regions) {
+			t.Errorf("%s: regions=%v want %v", tt.Query, regions, tt.Regions)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := NewReader(sr).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Query       string
+		UseIndex    bool
+		Regions     []Region
+		ResidualCnt int // Records that pass Residual.
+		String      string
+		RegionOnly  bool
+		Limit       int
+	}{
+		{
+			Query:       "RNAME = chr1",
+			UseIndex:    true,
+			Regions:     []Region{{Rname: "chr1"}},
+			ResidualCnt: 8,
+			String:      "RNAME = chr1; regions [chr1:0-end); residual TRUE",
+			RegionOnly:  true,
+		},
+		{
+			Query:       "RNAME = chr1 AND POS > 10 LIMIT 2",
+			UseIndex:    true,
+			Regions:     []Region{{Rname: "chr1", Start: 10}},
+			ResidualCnt: 4,
+			String:      "RNAME = chr1 AND POS > 10; regions [chr1:10-end); residual POS > 10",
+			RegionOnly:  true,
+			Limit:       2,
+		},
+		{
+			Query:       "RNAME = chr1 AND (MAPQ > 29 AND POS > 10)",
+			UseIndex:    true,
+			Regions:     []Region{{Rname: "chr1", Start: 10}},
+			ResidualCnt: 3,
+			String:      "RNAME = chr1 AND (MAPQ > 29 AND POS > 10); regions [chr1:10-end); residual MAPQ > 29 AND POS > 10",
+		},
+		{
+			Query:       "RNAME = chr1 OR RNAME = chr2",
+			UseIndex:    true,
+			Regions:     []Region{{Rname: "chr1"}, {Rname: "chr2"}},
+			ResidualCnt: 5,
+			String:      "RNAME = chr1 OR RNAME = chr2; regions [chr1:0-end) [chr2:0-end); residual RNAME = chr1 OR RNAME = chr2",
+			RegionOnly:  true,
+		},
+		{
+			Query:       "RNAME = chr2 AND RNAME = chr1",
+			UseIndex:    true,
+			Regions:     nil,
+			ResidualCnt: 0,
+			String:      "RNAME = chr2 AND RNAME = chr1; regions none; residual FALSE",
+		},
+		{
+			Query:       "MAPQ > 29",
+			UseIndex:    false,
+			ResidualCnt: 5,
+			String:      "MAPQ > 29; no regions",
+		},
+	} {
+		p, err := Plan(tt.Query)
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Query, err.Error())
+			continue
+		}
+		if p.UseIndex != tt.UseIndex {
+			t.Errorf("%s: UseIndex=%t want %t", tt.Query, p.UseIndex, tt.UseIndex)
+		}
+		if !reflect.DeepEqual(p.Regions, tt.Regions) {
+			t.Errorf("%s: regions=%v want %v", tt.Query, p.Regions, tt.Regions)
+		}
+		if s := p.String(); s != tt.String {
+			t.Errorf("%s: string=%q want %q", tt.Query, s, tt.String)
+		}
+		if p.RegionOnly != tt.RegionOnly {
+			t.Errorf("%s: RegionOnly=%t want %t", tt.Query, p.RegionOnly, tt.RegionOnly)
+		}
+		if p.Limit != tt.Limit {
+			t.Errorf("%s: Limit=%d want %d", tt.Query, p.Limit, tt.Limit)
+		}
+
+		// Records in the regions that pass Residual must match Filter.
+		overlap := OverlapFilter(p.Regions)
+		cnt := 0
+		for _, rec := range recs {
+			if p.Residual(rec) {
+				cnt++
+			}
+			if p.UseIndex && overlap(rec) && p.Residual(rec) != p.Filter(rec) {
+				t.Errorf("%s: residual and filter differ for %s", tt.Query, rec.Name)
+			}
+		}
+		if cnt != tt.ResidualCnt {
+			t.Errorf("%s: residual count=%d want %d", tt.Query, cnt, tt.ResidualCnt)
+		}
+	}
+
+	if _, err := Plan("RNAME = "); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestParseRegion(t *testing.T) {
+	for _, tt := range []struct {
+		Region string
+		Want   Region
+		OK     bool
+	}{
+		{Region: "chr1", Want: Region{Rname: "chr1"}, OK: true},
+		{Region: "chr1:1000", Want: Region{Rname: "chr1", Start: 999}, OK: true},
+		{Region: "chr1:1,000-2,000", Want: Region{Rname: "chr1", Start: 999, End: 2000}, OK: true},
+		{Region: "chr1:1-", Want: Region{Rname: "chr1"}, OK: true},
+		{Region: "HLA:A:5-5", Want: Region{Rname: "HLA:A", Start: 4, End: 5}, OK: true},
+		{Region: "", OK: false},
+		{Region: ":1-10", OK: false},
+		{Region: "chr1:0-10", OK: false},
+		{Region: "chr1:20-10", OK: false},
+		{Region: "chr1:a", OK: false},
+	} {
+		r, err := ParseRegion(tt.Region)
+		if (err == nil) != tt.OK {
+			t.Errorf("%s: error=%v want ok %t", tt.Region, err, tt.OK)
+			continue
+		}
+		if r != tt.Want {
+			t.Errorf("%s: region=%v want %v", tt.Region, r, tt.Want)
+		}
+	}
+}
+
+func TestRegionsWhere(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := NewReader(sr).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Regions []Region
+		Where   string
+	}{
+		{
+			Regions: []Region{{Rname: "chr1"}},
+			Where:   "RNAME = 'chr1'",
+		},
+		{
+			Regions: []Region{{Rname: "chr1", Start: 20, End: 30}, {Rname: "chr2", Start: 45}},
+			Where: "(RNAME = 'chr1' AND POS < 30 AND (END > 20 OR POS >= 20)) OR " +
+				"(RNAME = 'chr2' AND (END > 45 OR POS >= 45))",
+		},
+		{
+			Regions: []Region{{Rname: "chr1", End: 8}},
+			Where:   "RNAME = 'chr1' AND POS < 8",
+		},
+	} {
+		where := RegionsWhere(tt.Regions)
+		if where != tt.Where {
+			t.Errorf("%v: where=%s want %s", tt.Regions, where, tt.Where)
+		}
+		regions, ok := QueryRegions(where)
+		if !ok || len(regions) != len(tt.Regions) {
+			t.Errorf("%v: query regions=%v %t", tt.Regions, regions, ok)
+		}
+		filter, err := Where(where)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", where, err)
+		}
+		overlaps := OverlapFilter(tt.Regions)
+		for _, rec := range records {
+			if filter(rec) != overlaps(rec) {
+				t.Errorf("%s: %s at %d: match=%t want %t", where, rec.Name, rec.Pos, filter(rec), overlaps(rec))
+			}
+		}
+	}
+}
+
+func TestRenameRefs(t *testing.T) {
+	rename := func(name string) string {
+		if name == "chr1" || name == "chr2" {
+			return name[3:]
+		}
+		return name
+	}
+	for _, tt := range []struct {
+		Query string
+		Want  string
+	}{
+		{Query: "RNAME = chr1 AND POS < 100", Want: "RNAME = '1' AND POS < 100"},
+		{Query: "RNAME IN ('chr1', 'chr3') OR RNEXT != 'chr2'", Want: "RNAME IN ('1', 'chr3') OR RNEXT != '2'"},
+		{Query: "RNAME = '*' OR RNEXT = '='", Want: "RNAME = '*' OR RNEXT = '='"},
+		{Query: "RNAME = SOURCE AND QNAME = chr1", Want: "RNAME = SOURCE AND QNAME = chr1"},
+		{Query: "RNAME = chr1 SAMPLE 0.5 LIMIT 10", Want: "RNAME = '1' SAMPLE 0.5 LIMIT 10"},
+	} {
+		got, err := RenameRefs(tt.Query, rename)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", tt.Query, err)
+		}
+		if got != tt.Want {
+			t.Errorf("%s: got %s want %s", tt.Query, got, tt.Want)
+		}
+	}
+	if _, err := RenameRefs("RNAME = (", rename); err == nil {
+		t.Errorf("expected error for invalid query")
+	}
+}
@@ -0,0 +1,194 @@
+package samql
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// remoteBlockSize is the number of bytes requested from a remote file at a
+// time. Reads that fall within the last requested block do not issue a new
+// request.
+const remoteBlockSize = 1 << 20
+
+func init() {
+	for _, scheme := range []string{"http", "https", "s3", "gs"} {
+		RegisterScheme(scheme, openRemote)
+	}
+}
+
+// RemoteFile is an io.ReadSeeker that reads a file over HTTP(S) with range
+// requests, so that indexed BAM files are read only partially. S3 and GCS
+// URLs, e.g. s3://bucket/key.bam and gs://bucket/key.bam, are read from the
+// public HTTPS endpoints of the services. GCS requests are authorized with the
+// token in the GCS_OAUTH_TOKEN environment variable, if set. S3 requests are
+// not signed, so private S3 objects should be read with presigned HTTPS URLs.
+type RemoteFile struct {
+	url    string
+	client *http.Client
+	header http.Header
+	size   int64
+	off    int64
+	buf    []byte // The last block read, starting at bufOff.
+	bufOff int64
+}
+
+// IsRemote returns true if name is a URL that can be opened with OpenRemote.
+func IsRemote(name string) bool {
+	switch schemeOf(name) {
+	case "http", "https", "s3", "gs":
+		return true
+	}
+	return false
+}
+
+// OpenRemote opens the file at the http, https, s3 or gs URL name for
+// reading.
+func OpenRemote(name string) (*RemoteFile, error) {
+	url, err := remoteURL(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &RemoteFile{url: url, client: http.DefaultClient, header: make(http.Header)}
+	if token := os.Getenv("GCS_OAUTH_TOKEN"); token != "" && schemeOf(name) == "gs" {
+		f.header.Set("Authorization", "Bearer "+token)
+	}
+
+	req, err := f.newRequest(http.MethodHead)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("samql: cannot open %s: %s", name, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("samql: cannot open %s: unknown size", name)
+	}
+	f.size = resp.ContentLength
+	return f, nil
+}
+
+// remoteURL returns the HTTP(S) URL of the remote file name.
+func remoteURL(name string) (string, error) {
+	scheme := schemeOf(name)
+	path := strings.TrimPrefix(name, scheme+"://")
+	switch scheme {
+	case "http", "https":
+		return name, nil
+	case "s3", "gs":
+		i := strings.IndexByte(path, '/')
+		if i <= 0 || i == len(path)-1 {
+			return "", fmt.Errorf("samql: invalid %s URL %s; must be %s://bucket/key", scheme, name, scheme)
+		}
+		if scheme == "s3" {
+			return "https://" + path[:i] + ".s3.amazonaws.com" + path[i:], nil
+		}
+		return "https://storage.googleapis.com/" + path, nil
+	}
+	return "", fmt.Errorf("samql: %s is not a remote URL", name)
+}
+
+// newRequest returns a new request for the file with the headers of f.
+func (f *RemoteFile) newRequest(method string) (*http.Request, error) {
+	req, err := http.NewRequest(method, f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range f.header {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+// Size returns the size of the file in bytes.
+func (f *RemoteFile) Size() int64 {
+	return f.size
+}
+
+// Read reads up to len(p) bytes from the current offset of the file. It
+// requests a block of the file from the server when the offset is not within
+// the last block read.
+func (f *RemoteFile) Read(p []byte) (int, error) {
+	if f.off >= f.size {
+		return 0, io.EOF
+	}
+	if f.off < f.bufOff || f.off >= f.bufOff+int64(len(f.buf)) {
+		if err := f.fetch(f.off); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, f.buf[f.off-f.bufOff:])
+	f.off += int64(n)
+	return n, nil
+}
+
+// fetch reads the block of the file that starts at off.
+func (f *RemoteFile) fetch(off int64) error {
+	end := off + remoteBlockSize
+	if end > f.size {
+		end = f.size
+	}
+	req, err := f.newRequest(http.MethodGet)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end-1))
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("samql: range request to %s failed: %s", f.url, resp.Status)
+	}
+
+	buf := make([]byte, end-off)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return err
+	}
+	f.buf, f.bufOff = buf, off
+	return nil
+}
+
+// Seek sets the offset for the next Read to offset, interpreted according to
+// whence as in io.Seeker.
+func (f *RemoteFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("samql: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("samql: negative position")
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// Close releases the buffered data of the file.
+func (f *RemoteFile) Close() error {
+	f.buf = nil
+	return nil
+}
+
+// openRemote opens a remote SAM or BAM file. The file format is detected from
+// the file contents.
+func openRemote(name string) (Source, error) {
+	f, err := OpenRemote(name)
+	if err != nil {
+		return nil, err
+	}
+	return newSource(name, f)
+}
@@ -0,0 +1,119 @@
+package samql
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a server that serves content at /test.sam with
+// support for range requests and a pointer to the number of requests served.
+func newTestServer(content string) (*httptest.Server, *int) {
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/test.sam" {
+			http.NotFound(w, r)
+			return
+		}
+		n++
+		http.ServeContent(w, r, "test.sam", time.Time{}, strings.NewReader(content))
+	}))
+	return srv, &n
+}
+
+func TestRemoteFile(t *testing.T) {
+	srv, n := newTestServer(samData)
+	defer srv.Close()
+
+	f, err := OpenRemote(srv.URL + "/test.sam")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	defer f.Close()
+	if f.Size() != int64(len(samData)) {
+		t.Errorf("size=%d want %d", f.Size(), len(samData))
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	if !bytes.Equal(data, []byte(samData)) {
+		t.Errorf("data=%q want %q", data, samData)
+	}
+
+	// Reads within the block read do not issue new requests.
+	if _, err := f.Seek(-10, io.SeekEnd); err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	if want := samData[len(samData)-10 : len(samData)-6]; string(buf) != want {
+		t.Errorf("read=%q want %q", buf, want)
+	}
+	if *n != 2 { // HEAD and a single GET.
+		t.Errorf("requests=%d want 2", *n)
+	}
+
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Errorf("expected error for negative position")
+	}
+}
+
+func TestOpenSourceRemote(t *testing.T) {
+	srv, _ := newTestServer(samData)
+	defer srv.Close()
+
+	r, err := Open(srv.URL + "/test.sam")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	if l := len(records); l != 8 {
+		t.Errorf("record count=%d want 8", l)
+	}
+
+	if _, err := Open(srv.URL + "/missing.sam"); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
+func TestRemoteURL(t *testing.T) {
+	for _, tt := range []struct {
+		Name string
+		URL  string
+		Err  bool
+	}{
+		{Name: "https://host/a.bam", URL: "https://host/a.bam"},
+		{Name: "s3://bucket/dir/a.bam", URL: "https://bucket.s3.amazonaws.com/dir/a.bam"},
+		{Name: "gs://bucket/dir/a.bam", URL: "https://storage.googleapis.com/bucket/dir/a.bam"},
+		{Name: "s3://bucket", Err: true},
+		{Name: "gs://bucket/", Err: true},
+		{Name: "ftp://host/a.bam", Err: true},
+	} {
+		url, err := remoteURL(tt.Name)
+		if tt.Err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Name, err.Error())
+			continue
+		}
+		if url != tt.URL {
+			t.Errorf("%s: url=%s want %s", tt.Name, url, tt.URL)
+		}
+	}
+}
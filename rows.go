@@ -0,0 +1,185 @@
+package samql
+
+import (
+	"errors"
+	"io"
+
+	"github.com/biogo/hts/sam"
+)
+
+// Rows is the result of a SELECT statement over the records of a SAM or BAM
+// file. Its methods follow those of database/sql.Rows: Next advances to each
+// row, whose values are returned by Values.
+type Rows struct {
+	q    *Query
+	src  Source
+	r    *Reader
+	rows [][]interface{} // Remaining rows of aggregate queries.
+	vals []interface{}
+	n    int // Number of rows read from r.
+	err  error
+
+	started bool
+	closed  bool
+	sorted  Source
+}
+
+// QueryFile runs the SELECT statement query, e.g. "SELECT QNAME, POS FROM aln
+// WHERE MAPQ > 30", over the records of the SAM or BAM file at path, which is
+// opened with OpenSource. The query is bound to path and the header of the
+// file, as WhereHeader, so the SOURCE and read group keywords can be used in
+// the selected fields, GROUP BY and WHERE. The functions of query are
+// configured with opts, as NewQuery. Queries with SELECT * are not supported
+// as they return whole records; use Open to read them.
+func QueryFile(path, query string, opts ...FilterOption) (*Rows, error) {
+	q, err := NewQuery(query, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if !q.IsProjection() {
+		return nil, errors.New("samql: SELECT * returns records instead of rows; use Open")
+	}
+
+	src, err := OpenSource(path)
+	if err != nil {
+		return nil, err
+	}
+	if q, err = q.Bind(path, src.Header()); err != nil {
+		src.Close()
+		return nil, err
+	}
+	r := NewReader(src)
+	if where := q.Where(); where != "" {
+		filter, err := WhereHeader(where, path, src.Header(), opts...)
+		if err != nil {
+			src.Close()
+			return nil, err
+		}
+		r.AppendFilter(filter)
+	}
+	return &Rows{q: q, src: src, r: r}, nil
+}
+
+// Columns returns the names of the columns of rows.
+func (rows *Rows) Columns() []string {
+	return rows.q.ColumnNames()
+}
+
+// ColumnTypes returns the types of the columns of rows.
+func (rows *Rows) ColumnTypes() []ColumnType {
+	return rows.q.ColumnTypes()
+}
+
+// start prepares rows for reading. Aggregate queries read all records and
+// queries with ORDER BY sort them.
+func (rows *Rows) start() error {
+	rows.started = true
+	switch {
+	case rows.q.IsAggregate():
+		// Aggregations do not retain records, so they are read into a
+		// single record.
+		agg := rows.q.NewAggregation()
+		var rec sam.Record
+		for {
+			err := rows.r.ReadInto(&rec)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			agg.Add(&rec)
+		}
+		rows.rows = agg.Rows()
+	case rows.q.IsSorted():
+		s := rows.q.NewSorter(rows.r.Header())
+		for {
+			rec, err := rows.r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				s.Close()
+				return err
+			}
+			if err := s.Add(rec); err != nil {
+				s.Close()
+				return err
+			}
+		}
+		src, err := s.Sort()
+		if err != nil {
+			return err
+		}
+		rows.sorted = src
+		rows.r = NewReader(src)
+	}
+	return nil
+}
+
+// Next prepares the next row for reading with Values. It returns false when
+// there are no more rows or an error occurred, which is returned by Err.
+func (rows *Rows) Next() bool {
+	if rows.err != nil || rows.closed {
+		return false
+	}
+	if !rows.started {
+		if rows.err = rows.start(); rows.err != nil {
+			return false
+		}
+	}
+
+	if rows.q.IsAggregate() {
+		if len(rows.rows) == 0 {
+			rows.vals = nil
+			return false
+		}
+		rows.vals, rows.rows = rows.rows[0], rows.rows[1:]
+		return true
+	}
+
+	if limit := rows.q.Limit(); limit > 0 && rows.n >= limit {
+		rows.vals = nil
+		return false
+	}
+	rec, err := rows.r.Read()
+	if err != nil {
+		if err != io.EOF {
+			rows.err = err
+		}
+		rows.vals = nil
+		return false
+	}
+	rows.n++
+	rows.vals = rows.q.Values(rec)
+	return true
+}
+
+// Values returns the values of the current row, one for each column, with
+// the types described by ColumnType, e.g. int or int64 for IntColumn.
+func (rows *Rows) Values() []interface{} {
+	return rows.vals
+}
+
+// Err returns the error, if any, that was encountered during iteration.
+func (rows *Rows) Err() error {
+	return rows.err
+}
+
+// Close closes the underlying file and removes any temporary files of ORDER
+// BY. It is safe to call Close more than once.
+func (rows *Rows) Close() error {
+	if rows.closed {
+		return nil
+	}
+	rows.closed = true
+	rows.vals = nil
+	var err error
+	if rows.sorted != nil {
+		err = rows.sorted.Close()
+	}
+	if cerr := rows.src.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package samql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestQueryFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "samql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.sam")
+	if err := ioutil.WriteFile(path, []byte(samData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Test  string
+		Query string
+		Types []ColumnType
+		Rows  [][]interface{}
+		Err   bool
+	}{
+		{
+			Test:  "Columns",
+			Query: "SELECT QNAME, POS, REVERSE FROM aln WHERE RNAME = 'chr1' AND POS > 10",
+			Types: []ColumnType{StringColumn, IntColumn, BoolColumn},
+			Rows:  [][]interface{}{{"r003", 15, false}, {"r001", 36, true}},
+		},
+		{
+			Test:  "Source",
+			Query: "SELECT QNAME FROM aln WHERE SOURCE = '" + path + "' AND MAPQ = 29",
+			Types: []ColumnType{StringColumn},
+			Rows:  [][]interface{}{{"r005"}},
+		},
+		{
+			Test:  "File",
+			Query: "SELECT QNAME, FILE FROM aln WHERE MAPQ = 29",
+			Types: []ColumnType{StringColumn, StringColumn},
+			Rows:  [][]interface{}{{"r005", path}},
+		},
+		{
+			Test:  "Aggregate",
+			Query: "SELECT RNAME, count(*), min(POS) FROM aln WHERE RNAME =~ /^chr/ GROUP BY RNAME",
+			Types: []ColumnType{StringColumn, IntColumn, IntColumn},
+			Rows:  [][]interface{}{{"chr1", 4, 6}, {"chr2", 1, 39}},
+		},
+		{
+			Test:  "OrderBy",
+			Query: "SELECT QNAME, POS FROM aln WHERE RNAME = 'chr1' ORDER BY POS DESC",
+			Types: []ColumnType{StringColumn, IntColumn},
+			Rows:  [][]interface{}{{"r001", 36}, {"r003", 15}, {"r002", 8}, {"r001", 6}},
+		},
+		{
+			Test:  "Limit",
+			Query: "SELECT QNAME FROM aln WHERE MAPQ > 0 LIMIT 2",
+			Types: []ColumnType{StringColumn},
+			Rows:  [][]interface{}{{"r001"}, {"r002"}},
+		},
+		{
+			Test:  "OrderByLimit",
+			Query: "SELECT QNAME, POS FROM aln WHERE RNAME = 'chr1' ORDER BY POS DESC LIMIT 1",
+			Types: []ColumnType{StringColumn, IntColumn},
+			Rows:  [][]interface{}{{"r001", 36}},
+		},
+		{
+			Test:  "Wildcard",
+			Query: "SELECT * FROM aln",
+			Err:   true,
+		},
+		{
+			Test:  "Invalid",
+			Query: "SELECT MPAQ FROM aln",
+			Err:   true,
+		},
+	} {
+		rows, err := QueryFile(path, tt.Query)
+		if tt.Err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.Test)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			continue
+		}
+		if types := rows.ColumnTypes(); !reflect.DeepEqual(types, tt.Types) {
+			t.Errorf("%s: types=%v want %v", tt.Test, types, tt.Types)
+		}
+
+		var got [][]interface{}
+		for rows.Next() {
+			got = append(got, rows.Values())
+		}
+		if err := rows.Err(); err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+		if !reflect.DeepEqual(got, tt.Rows) {
+			t.Errorf("%s: rows=%v want %v", tt.Test, got, tt.Rows)
+		}
+		if err := rows.Close(); err != nil {
+			t.Errorf("%s: unexpected close error %q", tt.Test, err.Error())
+		}
+		if rows.Next() {
+			t.Errorf("%s: Next after Close", tt.Test)
+		}
+	}
+
+	if _, err := QueryFile(filepath.Join(dir, "missing.sam"), "SELECT QNAME FROM aln"); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
@@ -0,0 +1,59 @@
+package samql
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+
+	"github.com/biogo/hts/sam"
+)
+
+// WithSeed sets the seed that records are sampled with by the SAMPLE clause
+// of queries and the rand function. The default seed is 0. The SAMPLE clause
+// keeps the same records as Sample with the seed.
+func WithSeed(seed int64) FilterOption {
+	return func(o *filterOptions) { o.seed = seed }
+}
+
+// Sample returns a FilterFunc that keeps approximately fraction of the
+// records. Records are selected by hashing QNAME with seed, so that the
+// selection is deterministic for a given seed and mates are kept or dropped
+// together.
+func Sample(fraction float64, seed int64) FilterFunc {
+	if fraction >= 1 {
+		return func(*sam.Record) bool { return true }
+	}
+	// Records whose hash is below the threshold are kept.
+	threshold := uint64(fraction * math.MaxUint64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(seed))
+	return func(rec *sam.Record) bool {
+		h := fnv.New64a()
+		_, _ = h.Write(b[:])
+		_, _ = h.Write([]byte(rec.Name))
+		return mix64(h.Sum64()) < threshold
+	}
+}
+
+// mix64 returns the splitmix64 finalizer of x. It spreads the bits of the FNV
+// hash, whose high bits vary little for similar short strings.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// withSample returns a FilterFunc that returns true for records that pass f
+// and are sampled with fraction and seed. It returns f if fraction is zero.
+func withSample(f FilterFunc, fraction float64, seed int64) FilterFunc {
+	if fraction == 0 {
+		return f
+	}
+	s := Sample(fraction, seed)
+	return func(rec *sam.Record) bool {
+		return f(rec) && s(rec)
+	}
+}
@@ -0,0 +1,90 @@
+package samql
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestSample(t *testing.T) {
+	recs := make([]*sam.Record, 10000)
+	for i := range recs {
+		recs[i] = &sam.Record{Name: fmt.Sprintf("read%d", i)}
+	}
+
+	for _, tt := range []struct {
+		Fraction float64
+		Seed     int64
+	}{
+		{Fraction: 0.1, Seed: 0},
+		{Fraction: 0.1, Seed: 42},
+		{Fraction: 0.5, Seed: 1},
+		{Fraction: 1, Seed: 1},
+	} {
+		f := Sample(tt.Fraction, tt.Seed)
+		cnt := 0
+		for _, rec := range recs {
+			if f(rec) {
+				cnt++
+			}
+			// Mates have the same name and are sampled together.
+			if f(rec) != f(&sam.Record{Name: rec.Name, Flags: sam.Read2}) {
+				t.Errorf("%v: mates of %s sampled differently", tt, rec.Name)
+			}
+		}
+		want := tt.Fraction * float64(len(recs))
+		if d := float64(cnt) - want; d > want*0.1 || d < -want*0.1 {
+			t.Errorf("%v: sampled count=%d want about %.0f", tt, cnt, want)
+		}
+	}
+
+	// Different seeds select different records.
+	a, b := Sample(0.5, 1), Sample(0.5, 2)
+	same := 0
+	for _, rec := range recs {
+		if a(rec) == b(rec) {
+			same++
+		}
+	}
+	if same == len(recs) {
+		t.Errorf("seeds 1 and 2 selected the same records")
+	}
+}
+
+func TestWhereSample(t *testing.T) {
+	for _, tt := range []struct {
+		Query  string
+		RecCnt int
+	}{
+		{Query: "MAPQ > 0 SAMPLE 1", RecCnt: 6},
+		{Query: "RNAME = chr1 SAMPLE 0.000001", RecCnt: 0},
+	} {
+		r := newTestReader(t, tt.Query)
+		recs, err := r.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recs) != tt.RecCnt {
+			t.Errorf("%s: record count=%d want %d", tt.Query, len(recs), tt.RecCnt)
+		}
+	}
+
+	// The SAMPLE clause keeps the records of Sample with the seed of the
+	// filter.
+	f, s := Must(Where("true SAMPLE 0.5", WithSeed(7))), Sample(0.5, 7)
+	for i := 0; i < 100; i++ {
+		rec := &sam.Record{Name: fmt.Sprintf("read%d", i)}
+		if f(rec) != s(rec) {
+			t.Errorf("SAMPLE differs from Sample for %s", rec.Name)
+		}
+	}
+
+	q, err := NewQuery("SELECT * FROM aln SAMPLE 0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := q.Where(); w != "true SAMPLE 0.5" {
+		t.Errorf("where=%q want %q", w, "true SAMPLE 0.5")
+	}
+}
@@ -1,10 +1,13 @@
 package samql
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/biogo/hts/bam"
 	"github.com/biogo/hts/sam"
@@ -67,10 +70,37 @@ const (
 	SUPPLEMENTARY
 	// END corresponds to the alignment end.
 	END
+	// SOURCE corresponds to the name of the input the record was read from.
+	SOURCE
+	// ALIGNED_LENGTH corresponds to the alignment block length, i.e. the
+	// number of M, =, X, I and D bases in the CIGAR.
+	ALIGNED_LENGTH
+	// MISMATCHES corresponds to the number of mismatches, i.e. the NM tag
+	// without the inserted and deleted bases.
+	MISMATCHES
+	// IDENTITY corresponds to 1 - NM/ALIGNED_LENGTH. A missing NM tag is
+	// considered zero.
+	IDENTITY
+	// FILE is an alias of SOURCE.
+	FILE
+	// RG corresponds to the read group of the record, i.e. the RG:Z tag.
+	RG
+	// SAMPLE corresponds to the sample (SM) of the read group of the record.
+	SAMPLE
+	// LIBRARY corresponds to the library (LB) of the read group of the
+	// record.
+	LIBRARY
+	// PLATFORM corresponds to the platform (PL) of the read group of the
+	// record.
+	PLATFORM
+	// UMI corresponds to the unique molecular identifier of the record, i.e.
+	// the first of the UMI tags that is present, UB and RX unless set with
+	// WithUMITags.
+	UMI
 )
 
 // readerSAM is a common interface for SAM/BAM/Indexed BAM readers and is used
-// as input to Reader.
+// as input to Reader. It is the subset of Source that does not require Close.
 type readerSAM interface {
 	Header() *sam.Header
 	Read() (*sam.Record, error)
@@ -82,7 +112,11 @@ var _ readerSAM = (*bam.Reader)(nil)
 var _ readerSAM = (*bamx.Reader)(nil)
 
 // FilterFunc is a function that returns true for a SAM record that passes the
-// filter and false otherwise.
+// filter and false otherwise. The filters created by samql, e.g. by Where,
+// hold no mutable state, as regular expressions and options such as WithSeed
+// are resolved when they are created, so they are safe for concurrent
+// use by multiple goroutines. Filters that modify records, such as
+// BarcodeFilter, must not be called concurrently for the same record.
 type FilterFunc func(*sam.Record) bool
 
 // Reader is a filtering-enabled SAM reader. Provided filters are applied to
@@ -90,9 +124,27 @@ type FilterFunc func(*sam.Record) bool
 type Reader struct {
 	r       readerSAM
 	Filters []FilterFunc
+
+	// OnProgress, if not nil, is called with the progress of the Reader
+	// every ProgressEvery records read from the underlying reader and once
+	// when it is exhausted.
+	OnProgress    func(Progress)
+	ProgressEvery int
+
+	// OnCorrupt, if not nil, is called if the underlying reader is
+	// truncated or corrupt, which then ends the Reader as if it were
+	// exhausted, so that the records before the error are salvaged.
+	// Otherwise Read returns a *CorruptError.
+	OnCorrupt func(*CorruptError)
+
+	progress Progress
+	read     int64 // records read from the underlying reader
+	salvaged bool  // true if OnCorrupt ended the reader
 }
 
-// NewReader returns a new samql Reader that reads from r.
+// NewReader returns a new samql Reader that reads from r. r is typically a
+// Source but readers that cannot be closed, such as sam.Reader, are also
+// accepted.
 func NewReader(r readerSAM) *Reader {
 	return &Reader{
 		r:       r,
@@ -116,14 +168,75 @@ func (r *Reader) Read() (*sam.Record, error) {
 	for {
 		rec, err := r.r.Read()
 		if err != nil {
+			err = r.corrupt(err)
+			r.finish(err)
 			return rec, err
 		}
+		r.read++
+		if r.accept(rec) {
+			return rec, nil
+		}
+	}
+}
+
+// recordReader is implemented by readers that decode records into existing
+// sam.Records, such as BAMReader.
+type recordReader interface {
+	ReadInto(*sam.Record) error
+}
 
-		if !allTrue(rec, r.Filters) {
-			continue
+// ReadInto is similar to Read but reads the next record that passes all
+// filters into rec. If the underlying reader supports it, as the BAM sources
+// opened by OpenSource do, the memory of rec is reused so that reading does
+// not allocate for each record; otherwise the record is read with Read and
+// copied into rec. Callers that do not retain records, e.g. to count or
+// aggregate them, should read all records into a single rec, which must not
+// be retained across calls. Returns io.EOF when r is exhausted.
+func (r *Reader) ReadInto(rec *sam.Record) error {
+	into, reuse := r.r.(recordReader)
+	for {
+		var err error
+		if reuse {
+			err = into.ReadInto(rec)
+		} else {
+			var next *sam.Record
+			if next, err = r.r.Read(); err == nil {
+				*rec = *next
+			}
+		}
+		if err != nil {
+			err = r.corrupt(err)
+			r.finish(err)
+			return err
 		}
+		r.read++
+		if r.accept(rec) {
+			return nil
+		}
+	}
+}
 
-		return rec, nil
+// accept applies the filters of r to rec, counts the progress of r and
+// returns true if rec passes all filters.
+func (r *Reader) accept(rec *sam.Record) bool {
+	if r.OnProgress == nil {
+		return allTrue(rec, r.Filters)
+	}
+	for i, f := range r.Filters {
+		if !f(rec) {
+			r.countProgress(i)
+			return false
+		}
+	}
+	r.countProgress(-1)
+	return true
+}
+
+// finish reports the final progress of r if err is io.EOF.
+func (r *Reader) finish(err error) {
+	if err == io.EOF && r.OnProgress != nil && !r.progress.Done {
+		r.progress.Done = true
+		r.reportProgress()
 	}
 }
 
@@ -145,13 +258,47 @@ func (r *Reader) ReadAll() ([]*sam.Record, error) {
 	}
 }
 
-// Close closes the underlying BAM/Indexed BAM reader.
+// Records returns a channel that receives all remaining records from r that
+// pass all filters and a channel that receives any error. Both channels are
+// closed when r is exhausted, when an error occurs or when ctx is done, in
+// which case the error channel receives ctx.Err(). io.EOF is not reported.
+// The records channel should be drained or ctx cancelled so that the reading
+// goroutine exits.
+func (r *Reader) Records(ctx context.Context) (<-chan *sam.Record, <-chan error) {
+	recs := make(chan *sam.Record)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(recs)
+		for {
+			rec, err := r.Read()
+			if err != nil {
+				if err != io.EOF {
+					errc <- err
+				}
+				return
+			}
+			select {
+			case recs <- rec:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return recs, errc
+}
+
+// Close closes the underlying reader if it implements io.Closer, such as the
+// BAM and Indexed BAM readers. The error of a corrupt input that was passed
+// to OnCorrupt is not returned again.
 func (r *Reader) Close() error {
-	switch v := r.r.(type) {
-	case *bam.Reader:
-		return v.Close()
-	case *bamx.Reader:
-		return v.Close()
+	c, ok := r.r.(io.Closer)
+	if !ok {
+		return nil
+	}
+	if err := c.Close(); err != nil && !(r.salvaged && isCorrupt(err)) {
+		return err
 	}
 	return nil
 }
@@ -170,8 +317,9 @@ func allTrue(rec *sam.Record, fs []FilterFunc) bool {
 // record query name.
 func Qname(val string, op ql.Token) FilterFunc {
 	f := getPlaceholder["QNAME"].(placeholderStr)
+	comp := strComparer(val, op)
 	return func(rec *sam.Record) bool {
-		return CompStr(f(rec), val, op)
+		return comp(f(rec))
 	}
 }
 
@@ -179,8 +327,9 @@ func Qname(val string, op ql.Token) FilterFunc {
 // record reference name.
 func Rname(val string, op ql.Token) FilterFunc {
 	f := getPlaceholder["RNAME"].(placeholderStr)
+	comp := strComparer(val, op)
 	return func(rec *sam.Record) bool {
-		return CompStr(f(rec), val, op)
+		return comp(f(rec))
 	}
 }
 
@@ -203,14 +352,107 @@ func Length(val int, op ql.Token) FilterFunc {
 }
 
 // Where returns a FilterFunc that is constructed from an SQL WHERE statement.
-// The function assumes the WHERE keyword is not part of query.
-func Where(query string) (FilterFunc, error) {
+// The function assumes the WHERE keyword is not part of query. It is a
+// shorthand for the Match method of the Filter returned by Compile. The
+// keywords of specific inputs, e.g. SOURCE or SAMPLE, are an error; they
+// require WhereInput or WhereHeader. Options, e.g. WithFeatures, configure
+// the functions of query.
+func Where(query string, opts ...FilterOption) (FilterFunc, error) {
+	return where(query, withOptions(nil, opts))
+}
+
+// WhereInput is similar to Where but additionally binds the SOURCE and FILE
+// keywords to input. It is used to filter records that are read from input, typically a
+// file name, when multiple inputs are combined.
+func WhereInput(query, input string, opts ...FilterOption) (FilterFunc, error) {
+	return where(query, withOptions(inputVars(input), opts))
+}
+
+// WhereHeader is similar to WhereInput but additionally resolves the SAMPLE,
+// LIBRARY and PLATFORM keywords by joining the RG tag of records with the
+// read groups in h. It is used to filter records that are read from input
+// with header h.
+func WhereHeader(query, input string, h *sam.Header, opts ...FilterOption) (FilterFunc, error) {
+	return where(query, withOptions(headerVars(input, h), opts))
+}
+
+// boundVars holds the keywords that are bound to values only for specific
+// inputs, by inputVars and headerVars.
+var boundVars = map[string]bool{
+	"SOURCE":   true,
+	"FILE":     true,
+	"SAMPLE":   true,
+	"LIBRARY":  true,
+	"PLATFORM": true,
+}
+
+// unboundVars returns empty string values for the keywords of boundVars, e.g.
+// to check the types of a query before its input is known.
+func unboundVars() map[string]interface{} {
+	vars := make(map[string]interface{}, len(boundVars))
+	for name := range boundVars {
+		vars[name] = placeholderStr(func(*sam.Record) string { return "" })
+	}
+	return vars
+}
+
+// inputVars returns the variables that are bound for records read from input.
+func inputVars(input string) map[string]interface{} {
+	source := placeholderStr(func(*sam.Record) string { return input })
+	return map[string]interface{}{
+		"SOURCE": source,
+		"FILE":   source,
+	}
+}
+
+// headerVars returns the variables that are bound for records read from
+// input with header h. Records without a read group or with a read group
+// that is not in h have empty read group fields.
+func headerVars(input string, h *sam.Header) map[string]interface{} {
+	vars := inputVars(input)
+	for name, tag := range map[string]string{
+		"SAMPLE":   "SM",
+		"LIBRARY":  "LB",
+		"PLATFORM": "PL",
+	} {
+		vals := make(map[string]string)
+		for _, rg := range h.RGs() {
+			vals[rg.Name()] = rg.Get(sam.NewTag(tag))
+		}
+		vars[name] = placeholderStr(func(r *sam.Record) string {
+			return vals[readGroup(r)]
+		})
+	}
+	return vars
+}
+
+// where returns a FilterFunc that is constructed from an SQL WHERE statement.
+// Variable references in query that match a key in vars are resolved to the
+// corresponding value.
+func where(query string, vars map[string]interface{}) (FilterFunc, error) {
+	f, err := compile(query, vars)
+	if err != nil {
+		return nil, err
+	}
+	return f.Match, nil
+}
+
+// parseWhere parses the SQL WHERE statement query, which may be followed by
+// other clauses such as SAMPLE, and returns the parsed statement.
+func parseWhere(query string) (*ql.SelectStatement, error) {
+	return parseWhereParams(query, nil)
+}
+
+// parseWhereParams is similar to parseWhere but additionally replaces the
+// bound parameters of query, e.g. $minq, with the values in params.
+func parseWhereParams(query string, params map[string]interface{}) (*ql.SelectStatement, error) {
 	// A select statement is appended to the query for compatibility with ql
 	// parser. The appended statement is discarded after parsing.
 	query = "SELECT * FROM foo WHERE " + query
 
 	// Create a ql.Parser from query.
 	p := ql.NewParserFromStr(query)
+	p.Params = params
 
 	// Build the Abstract Syntax Tree.
 	stmt, err := p.ParseStatement()
@@ -222,16 +464,28 @@ func Where(query string) (FilterFunc, error) {
 		return nil, err
 	}
 
-	// Visit all nodes in the AST to build FilterFunc.
-	var v evalVisitor
-	ql.Walk(&v, stmt)
+	return stmt.(*ql.SelectStatement), nil
+}
+
+// newFilter returns a FilterFunc that evaluates the condition expression
+// cond. Variable references in cond that match a key in vars are resolved to
+// the corresponding value. A nil cond returns a filter that accepts all
+// records.
+func newFilter(cond ql.Expr, vars map[string]interface{}) (FilterFunc, error) {
+	if cond == nil {
+		return func(rec *sam.Record) bool { return true }, nil
+	}
+
+	// Visit all nodes in the optimized AST to build FilterFunc.
+	v := evalVisitor{vars: vars}
+	ql.Walk(&v, optimize(cond))
 	if v.Err() != nil {
 		return nil, v.Err()
 	}
 
-	// After the tree walk, v.filters should only contain one filter.
-	if len(v.nodes) > 1 {
-		panic("samql: filter creation failed for " + query)
+	// After the tree walk, v.nodes should only contain one filter.
+	if len(v.nodes) != 1 {
+		return nil, fmt.Errorf("samql: filter creation failed for %s", cond)
 	}
 
 	switch fil := v.nodes[0].(type) {
@@ -242,12 +496,13 @@ func Where(query string) (FilterFunc, error) {
 	case bool:
 		return func(rec *sam.Record) bool { return fil }, nil
 	default:
-		panic("samql: filterFunc creation failed for " + query)
+		return nil, fmt.Errorf("samql: condition %s is not boolean, found %s", cond, describe(fil))
 	}
 }
 
 type evalVisitor struct {
 	nodes []interface{}
+	vars  map[string]interface{}
 	err   error
 }
 
@@ -255,21 +510,82 @@ func (v *evalVisitor) Err() error {
 	return v.err
 }
 
-func (v *evalVisitor) pop2Nodes() (lhs, rhs interface{}) {
-	if len(v.nodes) < 2 {
-		panic("exprToFilterFuncVisitor: nodes stack empty")
+// popNode removes the last node of the stack and returns it. If the stack is
+// empty, e.g. because an operand of n resolved to no value, it sets v.err
+// and returns false.
+func (v *evalVisitor) popNode(n ql.Node) (interface{}, bool) {
+	if len(v.nodes) < 1 {
+		v.err = fmt.Errorf("samql: invalid expression %s", n)
+		return nil, false
 	}
+	val := v.nodes[len(v.nodes)-1]
+	v.nodes = v.nodes[:len(v.nodes)-1]
+	return val, true
+}
 
+// pop2Nodes removes the last two nodes of the stack and returns them in the
+// order they were added. If the stack has fewer than two nodes, it sets v.err
+// and returns false.
+func (v *evalVisitor) pop2Nodes(n ql.Node) (lhs, rhs interface{}, ok bool) {
+	if len(v.nodes) < 2 {
+		v.err = fmt.Errorf("samql: invalid expression %s", n)
+		return nil, nil, false
+	}
 	rhs = v.nodes[len(v.nodes)-1]
 	lhs = v.nodes[len(v.nodes)-2]
 	v.nodes = v.nodes[:len(v.nodes)-2]
-	return
+	return lhs, rhs, true
+}
+
+// checkScalar returns an error if an operand of the binary expression n is
+// an array, which must be indexed or passed to a function first.
+func checkScalar(n *ql.BinaryExpr, lhs, rhs interface{}) error {
+	for _, x := range []interface{}{lhs, rhs} {
+		if _, ok := x.(placeholderArray); ok {
+			return fmt.Errorf("array in %s must be indexed, e.g. ZC:B[0], or passed to a function, e.g. sum(ZC:B)", n)
+		}
+	}
+	return nil
 }
 
 func (v *evalVisitor) Visit(node ql.Node) ql.Visitor {
 	// log.Printf("%#v\n", node)
 	switch n := node.(type) {
 	case *ql.BinaryExpr:
+		// IS NULL and IS NOT NULL test whether a tag is missing or present,
+		// so the LHS is not resolved to its value.
+		if n.Op == ql.IS || n.Op == ql.ISNOT {
+			if _, ok := n.RHS.(*ql.NilLiteral); !ok {
+				v.err = fmt.Errorf("%s requires NULL, found %s", n.Op, n.RHS)
+				return nil
+			}
+			has, err := hasTag(n.LHS)
+			if err != nil {
+				v.err = err
+				return nil
+			}
+			if n.Op == ql.ISNOT {
+				v.nodes = append(v.nodes, has)
+				return nil
+			}
+			v.nodes = append(v.nodes, placeholderBool(func(rec *sam.Record) bool {
+				return !has(rec)
+			}))
+			return nil
+		}
+
+		// Combinations of flag tests are evaluated with two bit masks.
+		if fil, ok := flagComparison(n, v.vars); ok {
+			v.nodes = append(v.nodes, fil)
+			return nil
+		}
+
+		// Comparisons of SEQ and CIGAR with literals are evaluated on bytes,
+		// without formatting the fields as strings.
+		if fil, ok := byteComparison(n, v.vars); ok {
+			v.nodes = append(v.nodes, fil)
+			return nil
+		}
 
 		// Resolve the LHS.
 		ql.Walk(v, n.LHS)
@@ -288,10 +604,97 @@ func (v *evalVisitor) Visit(node ql.Node) ql.Visitor {
 		// final values.
 		switch n.Op {
 		case ql.EQ, ql.NEQ, ql.LT, ql.LTE, ql.GT, ql.GTE, ql.AND,
-			ql.OR, ql.BITWISEAND, ql.EQREGEX, ql.NEQREGEX:
+			ql.OR, ql.EQREGEX, ql.NEQREGEX, ql.EQFOLD, ql.NEQFOLD:
+
+			lhs, rhs, ok := v.pop2Nodes(n)
+			if !ok {
+				return nil
+			}
+			if err := checkScalar(n, lhs, rhs); err != nil {
+				v.err = err
+				return nil
+			}
+			val, err := eval(lhs, rhs, n.Op)
+			if err != nil {
+				v.err = fmt.Errorf("invalid comparison %s: %v", n, err)
+				return nil
+			}
+			v.nodes = append(v.nodes, val)
+
+		case ql.ADD, ql.SUB, ql.MUL, ql.DIV, ql.MOD, ql.BITWISEAND,
+			ql.BITWISEOR, ql.BITWISEXOR:
+
+			lhs, rhs, ok := v.pop2Nodes(n)
+			if !ok {
+				return nil
+			}
+			if err := checkScalar(n, lhs, rhs); err != nil {
+				v.err = err
+				return nil
+			}
+			val, err := evalArith(lhs, rhs, n.Op)
+			if err != nil {
+				v.err = err
+				return nil
+			}
+			v.nodes = append(v.nodes, val)
 
-			lhs, rhs := v.pop2Nodes()
-			v.nodes = append(v.nodes, eval(lhs, rhs, n.Op))
+		case ql.BETWEEN:
+			// The RHS range has resolved to its lower and upper bounds.
+			lower, upper, ok := v.pop2Nodes(n)
+			if !ok {
+				return nil
+			}
+			lhs, ok := v.popNode(n)
+			if !ok {
+				return nil
+			}
+			gte, err := eval(lhs, lower, ql.GTE)
+			if err != nil {
+				v.err = fmt.Errorf("invalid range %s: %v", n, err)
+				return nil
+			}
+			lte, err := eval(lhs, upper, ql.LTE)
+			if err != nil {
+				v.err = fmt.Errorf("invalid range %s: %v", n, err)
+				return nil
+			}
+			val, err := eval(gte, lte, ql.AND)
+			if err != nil {
+				v.err = fmt.Errorf("invalid range %s: %v", n, err)
+				return nil
+			}
+			v.nodes = append(v.nodes, val)
+
+		case ql.IN:
+			lhs, rhs, ok := v.pop2Nodes(n)
+			if !ok {
+				return nil
+			}
+			if file, ok := rhs.(*ql.FileLiteral); ok {
+				// The names of the file are compared as pair names
+				// to pair_name(...).
+				call, pairs := n.LHS.(*ql.Call)
+				pairs = pairs && call.Cmd == "pair_name"
+				fil, err := evalInFile(lhs, file.Path, pairs)
+				if err != nil {
+					v.err = err
+					return nil
+				}
+				v.nodes = append(v.nodes, fil)
+				return nil
+			}
+			list, ok := rhs.(*ql.ListLiteral)
+			if !ok {
+				v.err = fmt.Errorf("IN requires a list of values, found %s", n.RHS)
+				return nil
+			}
+			fil, err := evalIn(lhs, list)
+			if err != nil {
+				v.err = err
+				return nil
+			}
+			v.nodes = append(v.nodes, fil)
 
 		default:
 			v.err = fmt.Errorf("unsupported operator, %s", n.Op)
@@ -300,9 +703,48 @@ func (v *evalVisitor) Visit(node ql.Node) ql.Visitor {
 		return nil
 
 	case *ql.VarRef:
+		if val, ok := v.vars[n.Val]; ok {
+			v.nodes = append(v.nodes, val)
+			return nil
+		}
+		// Keywords of specific inputs would otherwise be compared as
+		// strings, e.g. SOURCE = 'x' with "SOURCE".
+		if boundVars[n.Val] {
+			v.err = fmt.Errorf("%s is not bound to an input; use WhereHeader", n.Val)
+			return nil
+		}
 		v.nodes = append(v.nodes, evalVarRef(n.Val))
 		return nil
 
+	case *ql.IndexExpr:
+		sub := evalVisitor{vars: v.vars}
+		ql.Walk(&sub, n.Expr)
+		if sub.err != nil {
+			v.err = sub.err
+			return nil
+		}
+		if len(sub.nodes) != 1 {
+			v.err = fmt.Errorf("samql: invalid expression %s", n)
+			return nil
+		}
+		arr, ok := sub.nodes[0].(placeholderArray)
+		if !ok {
+			v.err = fmt.Errorf("%s is not an array", n.Expr)
+			return nil
+		}
+		if n.Index < 0 {
+			v.err = fmt.Errorf("invalid index in %s", n)
+			return nil
+		}
+		i := n.Index
+		v.nodes = append(v.nodes, placeholderFloat(func(rec *sam.Record) float32 {
+			if vals := arr(rec); i < len(vals) {
+				return float32(vals[i])
+			}
+			return 0
+		}))
+		return nil
+
 	case *ql.ParenExpr:
 		ql.Walk(v, n.Expr)
 		if v.err != nil {
@@ -310,6 +752,94 @@ func (v *evalVisitor) Visit(node ql.Node) ql.Visitor {
 		}
 		return nil
 
+	case *ql.NilLiteral:
+		v.err = fmt.Errorf("NULL can only be used in IS NULL and IS NOT NULL")
+		return nil
+
+	case *ql.Wildcard:
+		// count(*) is compiled without visiting its argument.
+		v.err = fmt.Errorf("* can only be used in count(*)")
+		return nil
+
+	case *ql.Call:
+		// has(NM:i) tests the presence of a tag, so its argument is not
+		// resolved to its value.
+		if n.Cmd == "has" {
+			if len(n.Args) != 1 {
+				v.err = fmt.Errorf("has expects 1 argument, got %d", len(n.Args))
+				return nil
+			}
+			has, err := hasTag(n.Args[0])
+			if err != nil {
+				v.err = err
+				return nil
+			}
+			v.nodes = append(v.nodes, has)
+			return nil
+		}
+		if n.Distinct {
+			v.err = fmt.Errorf("DISTINCT is only supported by count: %s", n)
+			return nil
+		}
+		args := make([]interface{}, len(n.Args))
+		for i, arg := range n.Args {
+			if ref, ok := arg.(*ql.VarRef); ok && ref.Val == "CIGAR" {
+				args[i] = placeholderCigar(func(r *sam.Record) sam.Cigar { return r.Cigar })
+				continue
+			}
+			sub := evalVisitor{vars: v.vars}
+			ql.Walk(&sub, arg)
+			if sub.err != nil {
+				v.err = sub.err
+				return nil
+			}
+			if len(sub.nodes) != 1 {
+				v.err = fmt.Errorf("invalid argument %s in %s", arg, n)
+				return nil
+			}
+			args[i] = sub.nodes[0]
+		}
+		val, err := evalCall(n.Cmd, args, optionsOf(v.vars))
+		if err != nil {
+			v.err = err
+			return nil
+		}
+		v.nodes = append(v.nodes, val)
+		return nil
+
+	case *ql.RangeExpr:
+		ql.Walk(v, n.Lower)
+		if v.err != nil {
+			return nil
+		}
+		ql.Walk(v, n.Upper)
+		return nil
+
+	case *ql.NotExpr:
+		ql.Walk(v, n.Expr)
+		if v.err != nil {
+			return nil
+		}
+		val, ok := v.popNode(n)
+		if !ok {
+			return nil
+		}
+		switch val := val.(type) {
+		case FilterFunc:
+			v.nodes = append(v.nodes, FilterFunc(func(rec *sam.Record) bool {
+				return !val(rec)
+			}))
+		case placeholderBool:
+			v.nodes = append(v.nodes, FilterFunc(func(rec *sam.Record) bool {
+				return !val(rec)
+			}))
+		case bool:
+			v.nodes = append(v.nodes, !val)
+		default:
+			v.err = fmt.Errorf("NOT requires a boolean expression, found %s", n.Expr)
+		}
+		return nil
+
 	case *ql.StringLiteral:
 		v.nodes = append(v.nodes, n.Val)
 		return nil
@@ -334,6 +864,14 @@ func (v *evalVisitor) Visit(node ql.Node) ql.Visitor {
 		v.nodes = append(v.nodes, n.Val)
 		return nil
 
+	case *ql.ListLiteral:
+		v.nodes = append(v.nodes, n)
+		return nil
+
+	case *ql.FileLiteral:
+		v.nodes = append(v.nodes, n)
+		return nil
+
 	default:
 		return v
 	}
@@ -351,8 +889,13 @@ type placeholderStr func(*sam.Record) string
 // placeholderBool is a function that returns a boolean given a sam.Record.
 type placeholderBool func(*sam.Record) bool
 
-// getPlaceholderr associates a SamField with a placeholder.
-var getPlaceholder = map[string]interface{}{
+// placeholderArray is a function that returns the values of an array tag
+// given a sam.Record.
+type placeholderArray func(*sam.Record) []float64
+
+// getPlaceholderr associates a SamField with a placeholder. The flag keywords
+// are added from FlagNames.
+var getPlaceholder = withFlags(map[string]interface{}{
 	// getPlaceholderStr associates a SamField with a placeholderStr.
 	"QNAME": placeholderStr(func(r *sam.Record) string { return r.Name }),
 	"RNAME": placeholderStr(func(r *sam.Record) string { return r.Ref.Name() }),
@@ -370,23 +913,238 @@ var getPlaceholder = map[string]interface{}{
 	"LENGTH": placeholderInt(func(r *sam.Record) int { return r.Len() }),
 	"END":    placeholderInt(func(r *sam.Record) int { return r.End() }),
 
-	// getPlaceholderBool associates a sam flag Keyword with a placeholderBool.
-	"PAIRED":        placeholderBool(func(r *sam.Record) bool { return r.Flags&sam.Paired == sam.Paired }),
-	"PROPERPAIR":    placeholderBool(func(r *sam.Record) bool { return r.Flags&sam.ProperPair == sam.ProperPair }),
-	"UNMAPPED":      placeholderBool(func(r *sam.Record) bool { return r.Flags&sam.Unmapped == sam.Unmapped }),
-	"MATEUNMAPPED":  placeholderBool(func(r *sam.Record) bool { return r.Flags&sam.MateUnmapped == sam.MateUnmapped }),
-	"REVERSE":       placeholderBool(func(r *sam.Record) bool { return r.Flags&sam.Reverse == sam.Reverse }),
-	"MATEREVERSE":   placeholderBool(func(r *sam.Record) bool { return r.Flags&sam.MateReverse == sam.MateReverse }),
-	"READ1":         placeholderBool(func(r *sam.Record) bool { return r.Flags&sam.Read1 == sam.Read1 }),
-	"READ2":         placeholderBool(func(r *sam.Record) bool { return r.Flags&sam.Read2 == sam.Read2 }),
-	"SECONDARY":     placeholderBool(func(r *sam.Record) bool { return r.Flags&sam.Secondary == sam.Secondary }),
-	"QCFAIL":        placeholderBool(func(r *sam.Record) bool { return r.Flags&sam.QCFail == sam.QCFail }),
-	"DUPLICATE":     placeholderBool(func(r *sam.Record) bool { return r.Flags&sam.Duplicate == sam.Duplicate }),
-	"SUPPLEMENTARY": placeholderBool(func(r *sam.Record) bool { return r.Flags&sam.Supplementary == sam.Supplementary }),
+	// RG is the read group of the record.
+	"RG": placeholderStr(readGroup),
+	// UMI is the unique molecular identifier of the record.
+	"UMI": newUMI(defaultUMITags),
+	// STRAND is the strand of the record, + or -.
+	"STRAND": placeholderStr(strand),
+	// HAPLOTYPE and PHASESET are the haplotype and the phase set of a
+	// phased record, i.e. the HP and PS tags, or zero if it is not phased.
+	"HAPLOTYPE": getPlaceholderTag("HP:i"),
+	"PHASESET":  getPlaceholderTag("PS:i"),
+
+	// Keywords derived from the NM tag and the CIGAR.
+	"ALIGNED_LENGTH": placeholderInt(alignedLength),
+	"MISMATCHES":     placeholderInt(mismatches),
+	"IDENTITY":       placeholderFloat(identity),
+	"SOFTCLIP_FRAC":  placeholderFloat(softClipFrac),
+	"QUERY_COVERAGE": placeholderFloat(queryCoverage),
+
+	// Keywords of the CIGAR operations, e.g. for long reads with large
+	// indels or fragmented alignments.
+	"NUM_CIGAR_OPS": placeholderInt(func(r *sam.Record) int { return len(r.Cigar) }),
+	"MAX_DEL":       placeholderInt(maxCigarOpLen(sam.CigarDeletion)),
+	"MAX_INS":       placeholderInt(maxCigarOpLen(sam.CigarInsertion)),
+
+	// Keywords of the fragment of a read pair, e.g. for size selection.
+	"ABSTLEN":        placeholderInt(absTempLen),
+	"FRAGMENT_START": placeholderInt(fragmentStart),
+	"FRAGMENT_END":   placeholderInt(fragmentEnd),
+})
+
+// FlagNames associates the flag keywords, e.g. DUPLICATE, with their SAM
+// flag bits.
+var FlagNames = map[string]sam.Flags{
+	"PAIRED":        sam.Paired,
+	"PROPERPAIR":    sam.ProperPair,
+	"UNMAPPED":      sam.Unmapped,
+	"MATEUNMAPPED":  sam.MateUnmapped,
+	"REVERSE":       sam.Reverse,
+	"MATEREVERSE":   sam.MateReverse,
+	"READ1":         sam.Read1,
+	"READ2":         sam.Read2,
+	"SECONDARY":     sam.Secondary,
+	"QCFAIL":        sam.QCFail,
+	"DUPLICATE":     sam.Duplicate,
+	"SUPPLEMENTARY": sam.Supplementary,
+}
+
+// withFlags adds the placeholders of the flag keywords of FlagNames to m,
+// which are true if their bit is set, and returns m.
+func withFlags(m map[string]interface{}) map[string]interface{} {
+	for name, bit := range FlagNames {
+		bit := bit
+		m[name] = placeholderBool(func(r *sam.Record) bool { return r.Flags&bit == bit })
+	}
+	return m
+}
+
+// readGroup returns the value of the RG tag of a record or an empty string if
+// it is missing.
+var readGroup = getPlaceholderTag("RG:Z").(placeholderStr)
+
+// strand returns "-" if r is reverse complemented and "+" otherwise.
+func strand(r *sam.Record) string {
+	if r.Flags&sam.Reverse == sam.Reverse {
+		return "-"
+	}
+	return "+"
+}
+
+// defaultUMITags are the tags of type Z that the UMI keyword is read from,
+// in order of preference, unless set with WithUMITags: UB, the corrected UMI,
+// and RX, the raw UMI.
+var defaultUMITags = []string{"UB", "RX"}
+
+// WithUMITags sets the tags of type Z that the UMI keyword is read from, in
+// order of preference.
+func WithUMITags(tags ...string) FilterOption {
+	return func(o *filterOptions) { o.umiTags = tags }
+}
+
+// newUMI returns a placeholderStr of the value of the first of tags that is
+// present in a record or an empty string if none is. The tags are copied, so
+// that later changes of tags do not affect the filters already created.
+func newUMI(tags []string) placeholderStr {
+	keys := make([][]byte, len(tags))
+	for i, tag := range tags {
+		keys[i] = []byte(tag)
+	}
+	return func(r *sam.Record) string {
+		for _, key := range keys {
+			if aux, ok := r.Tag(key); ok {
+				if v, ok := aux.Value().(string); ok {
+					return v
+				}
+			}
+		}
+		return ""
+	}
+}
+
+// alignedLength returns the alignment block length of r, i.e. the number of
+// M, =, X, I and D bases in the CIGAR.
+func alignedLength(r *sam.Record) int {
+	n := 0
+	for _, co := range r.Cigar {
+		switch co.Type() {
+		case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch,
+			sam.CigarInsertion, sam.CigarDeletion:
+			n += co.Len()
+		}
+	}
+	return n
+}
+
+// maxCigarOpLen returns a function that returns the length of the longest
+// CIGAR operation of type typ of a record or zero if it has none.
+func maxCigarOpLen(typ sam.CigarOpType) func(*sam.Record) int {
+	return func(r *sam.Record) int {
+		n := 0
+		for _, co := range r.Cigar {
+			if co.Type() == typ && co.Len() > n {
+				n = co.Len()
+			}
+		}
+		return n
+	}
+}
+
+// editDistance returns the value of the NM tag of a record or zero if it is
+// missing.
+var editDistance = getPlaceholderTag("NM:i").(placeholderInt)
+
+// mismatches returns the number of mismatches in r, i.e. the NM tag without
+// the inserted and deleted bases.
+func mismatches(r *sam.Record) int {
+	n := editDistance(r)
+	for _, co := range r.Cigar {
+		switch co.Type() {
+		case sam.CigarInsertion, sam.CigarDeletion:
+			n -= co.Len()
+		}
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// identity returns 1 - NM/ALIGNED_LENGTH for r or zero if r is not aligned.
+func identity(r *sam.Record) float32 {
+	l := alignedLength(r)
+	if l == 0 {
+		return 0
+	}
+	return 1 - float32(editDistance(r))/float32(l)
+}
+
+// readLength returns the length of the read of r, including the soft and
+// hard clipped bases, and the number of its aligned and soft clipped bases.
+func readLength(r *sam.Record) (n, aligned, soft int) {
+	for _, co := range r.Cigar {
+		switch co.Type() {
+		case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch, sam.CigarInsertion:
+			aligned += co.Len()
+		case sam.CigarSoftClipped:
+			soft += co.Len()
+		case sam.CigarHardClipped:
+			n += co.Len()
+		}
+	}
+	return n + aligned + soft, aligned, soft
+}
+
+// softClipFrac returns the fraction of the read of r that is soft clipped or
+// zero if r has no CIGAR.
+func softClipFrac(r *sam.Record) float32 {
+	n, _, soft := readLength(r)
+	if n == 0 {
+		return 0
+	}
+	return float32(soft) / float32(n)
+}
+
+// queryCoverage returns the fraction of the read of r that is aligned, i.e.
+// M, =, X and I bases, or zero if r has no CIGAR. Hard clipped bases are part
+// of the read, e.g. for supplementary alignments.
+func queryCoverage(r *sam.Record) float32 {
+	n, aligned, _ := readLength(r)
+	if n == 0 {
+		return 0
+	}
+	return float32(aligned) / float32(n)
+}
+
+// absTempLen returns the absolute template length of r.
+func absTempLen(r *sam.Record) int {
+	if r.TempLen < 0 {
+		return -r.TempLen
+	}
+	return r.TempLen
+}
+
+// isFragment returns true if r is a mapped proper pair with both mates on
+// the same reference and a template length.
+func isFragment(r *sam.Record) bool {
+	return r.Flags&(sam.Paired|sam.ProperPair) == sam.Paired|sam.ProperPair &&
+		r.Flags&(sam.Unmapped|sam.MateUnmapped) == 0 &&
+		r.Ref != nil && r.MateRef != nil && r.Ref.ID() == r.MateRef.ID() &&
+		r.TempLen != 0
+}
+
+// fragmentStart returns the leftmost position of the pair of r, if r is a
+// proper pair, or the position of r otherwise.
+func fragmentStart(r *sam.Record) int {
+	if isFragment(r) && r.MatePos < r.Pos {
+		return r.MatePos
+	}
+	return r.Pos
+}
+
+// fragmentEnd returns the end of the pair of r, i.e. its start plus the
+// absolute template length, if r is a proper pair, or the end of r otherwise.
+func fragmentEnd(r *sam.Record) int {
+	if isFragment(r) {
+		return fragmentStart(r) + absTempLen(r)
+	}
+	return r.End()
 }
 
 // getPlaceholderTag returns a placeholder corresponding to the requested sam
-// tag.
+// tag. Hex arrays (H) are upper case hex strings. A tag with an unsupported
+// type is returned as is.
 func getPlaceholderTag(aval string) interface{} {
 	switch typ := aval[3]; typ {
 	case 'i':
@@ -433,9 +1191,75 @@ func getPlaceholderTag(aval string) interface{} {
 			}
 			return 0.0
 		})
-	default:
-		panic("type " + string(typ) + " in " + aval + " is not supported")
+	case 'B':
+		return placeholderArray(func(rec *sam.Record) []float64 {
+			if aux, ok := rec.Tag([]byte(aval[0:2])); ok {
+				return arrayValues(aux.Value())
+			}
+			return nil
+		})
+	case 'H':
+		return placeholderStr(func(rec *sam.Record) string {
+			if aux, ok := rec.Tag([]byte(aval[0:2])); ok {
+				v, _ := aux.Value().([]byte)
+				return strings.ToUpper(hex.EncodeToString(v))
+			}
+			return ""
+		})
+	}
+	// Unsupported types are not tags, as in evalVarRef.
+	return aval
+}
+
+// hasTag returns a placeholderBool that returns true for records with the
+// tag expr, e.g. NM:i or NM. The type of the tag is not checked, so that a
+// missing tag can be distinguished from a tag with a zero or empty value.
+func hasTag(expr ql.Expr) (placeholderBool, error) {
+	ref, ok := expr.(*ql.VarRef)
+	if !ok || (len(ref.Val) != 2 && !validTag.MatchString(ref.Val)) {
+		return nil, fmt.Errorf("%s is not a tag, e.g. NM:i", expr)
+	}
+	tag := []byte(ref.Val[:2])
+	return placeholderBool(func(rec *sam.Record) bool {
+		_, ok := rec.Tag(tag)
+		return ok
+	}), nil
+}
+
+// arrayValues returns the values of the array v of an aux field of type B.
+func arrayValues(v interface{}) []float64 {
+	var vals []float64
+	switch v := v.(type) {
+	case []int8:
+		for _, e := range v {
+			vals = append(vals, float64(e))
+		}
+	case []uint8:
+		for _, e := range v {
+			vals = append(vals, float64(e))
+		}
+	case []int16:
+		for _, e := range v {
+			vals = append(vals, float64(e))
+		}
+	case []uint16:
+		for _, e := range v {
+			vals = append(vals, float64(e))
+		}
+	case []int32:
+		for _, e := range v {
+			vals = append(vals, float64(e))
+		}
+	case []uint32:
+		for _, e := range v {
+			vals = append(vals, float64(e))
+		}
+	case []float32:
+		for _, e := range v {
+			vals = append(vals, float64(e))
+		}
 	}
+	return vals
 }
 
 var validTag = regexp.MustCompile(`^[A-Za-z][A-Za-z]:[AifZHB]`)
@@ -453,58 +1277,64 @@ func evalVarRef(varRefVal string) interface{} {
 }
 
 // eval evaluates the inferred values of a and b using the operator op. eval
-// returns a concrete value, a placeholder or a FilterFunc.
-func eval(a, b interface{}, op ql.Token) interface{} {
+// returns a concrete value, a placeholder or a FilterFunc, or an error if a
+// and b cannot be compared.
+func eval(a, b interface{}, op ql.Token) (interface{}, error) {
+	// Case-insensitive comparisons are only defined for strings.
+	if op == ql.EQFOLD || op == ql.NEQFOLD {
+		switch a.(type) {
+		case placeholderStr, string:
+		default:
+			return nil, fmt.Errorf("operator %s requires strings, found %s", op, describe(a))
+		}
+	}
+
+	// AND and OR of booleans are short-circuit.
+	if op == ql.AND || op == ql.OR {
+		if val, ok := logical(a, b, op); ok {
+			return val, nil
+		}
+	}
+
 	switch a := a.(type) {
 	case FilterFunc:
 		switch b := b.(type) {
 		case FilterFunc:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompBool(a(rec), b(rec), op)
-			})
+			}), nil
 		case placeholderBool:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompBool(a(rec), b(rec), op)
-			})
+			}), nil
 		case bool:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompBool(a(rec), b, op)
-			})
+			}), nil
 		default:
-			panic("boolean filter can only be compared to other booleans")
+			return nil, fmt.Errorf("boolean expression can only be compared to booleans, found %s", describe(b))
 		}
 
 	case placeholderInt:
 		switch b := b.(type) {
 		case int64:
-			switch op {
-			case ql.BITWISEAND:
-				return placeholderInt(func(rec *sam.Record) int {
-					return a(rec) & int(b)
-				})
-			case ql.BITWISEOR:
-				return placeholderInt(func(rec *sam.Record) int {
-					return a(rec) | int(b)
-				})
-			default:
-				return FilterFunc(func(rec *sam.Record) bool {
-					return CompInt(a(rec), int(b), op)
-				})
-			}
+			return FilterFunc(func(rec *sam.Record) bool {
+				return CompInt(a(rec), int(b), op)
+			}), nil
 		case placeholderInt:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompInt(a(rec), b(rec), op)
-			})
+			}), nil
 		case placeholderFloat:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompInt(a(rec), int(b(rec)), op)
-			})
+			}), nil
 		case float64:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompInt(a(rec), int(b), op)
-			})
+			}), nil
 		default:
-			panic("integer placeholder can only be compared to other integers or floats")
+			return nil, fmt.Errorf("integer field can only be compared to numbers, found %s", describe(b))
 		}
 
 	case placeholderFloat:
@@ -512,43 +1342,53 @@ func eval(a, b interface{}, op ql.Token) interface{} {
 		case float64:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompFloat(a(rec), float32(b), op)
-			})
+			}), nil
 		case int64:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompFloat(a(rec), float32(b), op)
-			})
+			}), nil
 		case placeholderInt:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompFloat(a(rec), float32(b(rec)), op)
-			})
+			}), nil
 		case placeholderFloat:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompFloat(a(rec), b(rec), op)
-			})
+			}), nil
 		default:
-			panic("float placeholder can only be compared to other floats or integers")
+			return nil, fmt.Errorf("float field can only be compared to numbers, found %s", describe(b))
 		}
 
 	case placeholderStr:
 		switch b := b.(type) {
 		case string:
+			comp := strComparer(b, op)
 			return FilterFunc(func(rec *sam.Record) bool {
-				return CompStr(a(rec), b, op)
-			})
+				return comp(a(rec))
+			}), nil
 		case placeholderStr:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompStr(a(rec), b(rec), op)
-			})
+			}), nil
 		case *regexp.Regexp:
-			return FilterFunc(func(rec *sam.Record) bool {
-				return CompStr(a(rec), b.String(), op)
-			})
+			switch op {
+			case ql.EQREGEX:
+				return FilterFunc(func(rec *sam.Record) bool {
+					return b.MatchString(a(rec))
+				}), nil
+			case ql.NEQREGEX:
+				return FilterFunc(func(rec *sam.Record) bool {
+					return !b.MatchString(a(rec))
+				}), nil
+			}
+			return nil, fmt.Errorf("regex requires operator =~ or !~, found %s", op)
 		case int64:
+			comp := strComparer(strconv.FormatInt(b, 10), op)
 			return FilterFunc(func(rec *sam.Record) bool {
-				return CompStr(a(rec), strconv.FormatInt(b, 10), op)
-			})
+				return comp(a(rec))
+			}), nil
 		default:
-			panic("string placeholder can only be compared to other strings")
+			return nil, fmt.Errorf("string field can only be compared to strings, found %s", describe(b))
 		}
 
 	case placeholderBool:
@@ -556,25 +1396,25 @@ func eval(a, b interface{}, op ql.Token) interface{} {
 		case bool:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompBool(a(rec), b, op)
-			})
+			}), nil
 		case placeholderBool:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompBool(a(rec), b(rec), op)
-			})
+			}), nil
 		case FilterFunc:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompBool(a(rec), b(rec), op)
-			})
+			}), nil
 		default:
-			panic("boolean placeholder can only be compared to other booleans")
+			return nil, fmt.Errorf("boolean field can only be compared to booleans, found %s", describe(b))
 		}
 
 	case string:
 		switch b := b.(type) {
 		case string:
-			return a == b
+			return CompStr(a, b, op), nil
 		default:
-			panic("string can only be compared to other strings")
+			return nil, fmt.Errorf("string can only be compared to strings, found %s", describe(b))
 		}
 
 	case bool:
@@ -582,21 +1422,183 @@ func eval(a, b interface{}, op ql.Token) interface{} {
 		case bool:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompBool(a, b, op)
-			})
+			}), nil
 		case placeholderBool:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompBool(a, b(rec), op)
-			})
+			}), nil
 		case FilterFunc:
 			return FilterFunc(func(rec *sam.Record) bool {
 				return CompBool(a, b(rec), op)
-			})
+			}), nil
 		default:
-			panic("boolean can only be compared to other booleans")
+			return nil, fmt.Errorf("boolean can only be compared to booleans, found %s", describe(b))
 		}
 	}
 
-	panic("unknown value type")
+	return nil, fmt.Errorf("%s cannot be compared to %s", describe(a), describe(b))
+}
+
+// describe returns a description of the type of the value v for error
+// messages.
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case FilterFunc, placeholderBool, bool:
+		return "boolean"
+	case placeholderInt, int64:
+		return "integer"
+	case placeholderFloat, float64:
+		return "float"
+	case placeholderStr:
+		return "string"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case *regexp.Regexp:
+		return "regex"
+	case placeholderArray:
+		return "array"
+	case nil:
+		return "NULL"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// evalArith evaluates the arithmetic or bitwise operation op between the
+// numeric values a and b and returns a placeholder. Operations between
+// integers return a placeholderInt, except for division that always returns a
+// placeholderFloat. Bitwise operations and modulo require integers. Modulo by
+// zero evaluates to zero.
+func evalArith(a, b interface{}, op ql.Token) (interface{}, error) {
+	ai, aok := intOperand(a)
+	bi, bok := intOperand(b)
+	if aok && bok {
+		switch op {
+		case ql.ADD:
+			return placeholderInt(func(r *sam.Record) int { return ai(r) + bi(r) }), nil
+		case ql.SUB:
+			return placeholderInt(func(r *sam.Record) int { return ai(r) - bi(r) }), nil
+		case ql.MUL:
+			return placeholderInt(func(r *sam.Record) int { return ai(r) * bi(r) }), nil
+		case ql.MOD:
+			return placeholderInt(func(r *sam.Record) int {
+				if d := bi(r); d != 0 {
+					return ai(r) % d
+				}
+				return 0
+			}), nil
+		case ql.BITWISEAND:
+			return placeholderInt(func(r *sam.Record) int { return ai(r) & bi(r) }), nil
+		case ql.BITWISEOR:
+			return placeholderInt(func(r *sam.Record) int { return ai(r) | bi(r) }), nil
+		case ql.BITWISEXOR:
+			return placeholderInt(func(r *sam.Record) int { return ai(r) ^ bi(r) }), nil
+		}
+	}
+
+	af, aok := floatOperand(a)
+	bf, bok := floatOperand(b)
+	if !aok || !bok {
+		return nil, fmt.Errorf("operator %s requires numeric operands", op)
+	}
+	switch op {
+	case ql.ADD:
+		return placeholderFloat(func(r *sam.Record) float32 { return af(r) + bf(r) }), nil
+	case ql.SUB:
+		return placeholderFloat(func(r *sam.Record) float32 { return af(r) - bf(r) }), nil
+	case ql.MUL:
+		return placeholderFloat(func(r *sam.Record) float32 { return af(r) * bf(r) }), nil
+	case ql.DIV:
+		return placeholderFloat(func(r *sam.Record) float32 { return af(r) / bf(r) }), nil
+	}
+	return nil, fmt.Errorf("operator %s requires integer operands", op)
+}
+
+// intOperand returns a placeholderInt for integer values and placeholders.
+func intOperand(v interface{}) (placeholderInt, bool) {
+	switch v := v.(type) {
+	case placeholderInt:
+		return v, true
+	case int64:
+		return func(*sam.Record) int { return int(v) }, true
+	}
+	return nil, false
+}
+
+// floatOperand returns a placeholderFloat for numeric values and
+// placeholders.
+func floatOperand(v interface{}) (placeholderFloat, bool) {
+	switch v := v.(type) {
+	case placeholderFloat:
+		return v, true
+	case placeholderInt:
+		return func(r *sam.Record) float32 { return float32(v(r)) }, true
+	case int64:
+		return func(*sam.Record) float32 { return float32(v) }, true
+	case float64:
+		return func(*sam.Record) float32 { return float32(v) }, true
+	}
+	return nil, false
+}
+
+// evalIn returns a FilterFunc that checks whether the value of a is one of the
+// values in list.
+func evalIn(a interface{}, list *ql.ListLiteral) (FilterFunc, error) {
+	switch a := a.(type) {
+	case placeholderInt:
+		set := make(map[int]bool, len(list.Vals))
+		for _, l := range list.Vals {
+			switch l := l.(type) {
+			case *ql.IntegerLiteral:
+				set[int(l.Val)] = true
+			case *ql.NumberLiteral:
+				set[int(l.Val)] = true
+			default:
+				return nil, fmt.Errorf("integer field cannot be compared to %s", l)
+			}
+		}
+		return func(rec *sam.Record) bool { return set[a(rec)] }, nil
+
+	case placeholderFloat:
+		set := make(map[float32]bool, len(list.Vals))
+		for _, l := range list.Vals {
+			switch l := l.(type) {
+			case *ql.IntegerLiteral:
+				set[float32(l.Val)] = true
+			case *ql.NumberLiteral:
+				set[float32(l.Val)] = true
+			default:
+				return nil, fmt.Errorf("float field cannot be compared to %s", l)
+			}
+		}
+		return func(rec *sam.Record) bool { return set[a(rec)] }, nil
+
+	case placeholderStr:
+		set := make(map[string]bool, len(list.Vals))
+		for _, l := range list.Vals {
+			switch l := l.(type) {
+			case *ql.StringLiteral:
+				set[l.Val] = true
+			case *ql.IntegerLiteral:
+				set[strconv.FormatInt(l.Val, 10)] = true
+			default:
+				return nil, fmt.Errorf("string field cannot be compared to %s", l)
+			}
+		}
+		return func(rec *sam.Record) bool { return set[a(rec)] }, nil
+
+	case placeholderBool:
+		set := make(map[bool]bool, len(list.Vals))
+		for _, l := range list.Vals {
+			b, ok := l.(*ql.BooleanLiteral)
+			if !ok {
+				return nil, fmt.Errorf("boolean field cannot be compared to %s", l)
+			}
+			set[b.Val] = true
+		}
+		return func(rec *sam.Record) bool { return set[a(rec)] }, nil
+	}
+
+	return nil, fmt.Errorf("IN is not supported for %v", a)
 }
 
 // CompInt compares two integers using the provided operator op.
@@ -639,7 +1641,10 @@ func CompFloat(a, b float32, op ql.Token) bool {
 	}
 }
 
-// CompStr compares two strings using the provided operator op.
+// CompStr compares two strings using the provided operator op. EQFOLD and
+// NEQFOLD compare strings case-insensitively. For the regex
+// operators b is compiled as a regular expression and invalid expressions
+// match no string.
 func CompStr(a, b string, op ql.Token) bool {
 	switch op {
 	case ql.EQ:
@@ -654,16 +1659,20 @@ func CompStr(a, b string, op ql.Token) bool {
 		return a > b
 	case ql.GTE:
 		return a >= b
+	case ql.EQFOLD:
+		return strings.EqualFold(a, b)
+	case ql.NEQFOLD:
+		return !strings.EqualFold(a, b)
 	case ql.EQREGEX:
 		re, err := regexp.Compile(b)
 		if err != nil {
-			panic(err) //TODO error handling
+			return false
 		}
 		return re.MatchString(a)
 	case ql.NEQREGEX:
 		re, err := regexp.Compile(b)
 		if err != nil {
-			panic(err) //TODO error handling
+			return false
 		}
 		return !re.MatchString(a)
 	default:
@@ -671,6 +1680,23 @@ func CompStr(a, b string, op ql.Token) bool {
 	}
 }
 
+// strComparer returns a function that compares a string to b using op, as
+// CompStr. For the regex operators b is compiled once, instead of for each
+// comparison, so the function is safe for concurrent use and fast.
+func strComparer(b string, op ql.Token) func(a string) bool {
+	if op != ql.EQREGEX && op != ql.NEQREGEX {
+		return func(a string) bool { return CompStr(a, b, op) }
+	}
+	re, err := regexp.Compile(b)
+	if err != nil {
+		return func(string) bool { return false }
+	}
+	if op == ql.NEQREGEX {
+		return func(a string) bool { return !re.MatchString(a) }
+	}
+	return re.MatchString
+}
+
 // CompBool compares two booleans using the provided operator op.
 func CompBool(a, b bool, op ql.Token) bool {
 	switch op {
@@ -1,6 +1,7 @@
 package samql
 
 import (
+	"context"
 	"io"
 	"strings"
 	"testing"
@@ -32,6 +33,13 @@ r006	77	*	0	0	*	*	0	0	CAGCGTGCATGCTACGATAGCAT	*
 r006	141	*	0	0	*	*	0	0	CGATCGATCGAGCTAGCTAGCT	*
 `
 
+const samDataPhased = `@SQ	SN:chr1	LN:45
+r001	0	chr1	7	30	5M	*	0	0	TTAGA	*	HP:i:1	PS:i:5
+r002	0	chr1	9	30	5M	*	0	0	AAAAG	*	HP:i:2	PS:i:5
+r003	0	chr1	16	30	5M	*	0	0	ATAGC	*	HP:i:1	PS:i:12
+r004	0	chr1	20	30	5M	*	0	0	ATAGC	*
+`
+
 var readTests = []struct {
 	Test    string
 	Data    string
@@ -427,6 +435,334 @@ var readTests = []struct {
 			Must(Where("PAIRED = FALSE")),
 		},
 	},
+	{
+		Test:   "Test34",
+		Data:   samData,
+		RecCnt: 5,
+		Filters: []FilterFunc{
+			Must(Where("RNAME IN ('chr1', 'chr2')")),
+		},
+	},
+	{
+		Test:   "Test35",
+		Data:   samData,
+		RecCnt: 3,
+		Filters: []FilterFunc{
+			Must(Where("FLAG IN (0, 16)")),
+		},
+	},
+	{
+		Test:   "Test36",
+		Data:   samData,
+		RecCnt: 1,
+		Filters: []FilterFunc{
+			Must(Where("RNAME IN (1)")),
+		},
+	},
+	{
+		Test:   "Test37",
+		Data:   samData,
+		RecCnt: 2,
+		Filters: []FilterFunc{
+			Must(Where("QNAME IN ('r001', 'r009') AND POS IN (6, 36)")),
+		},
+	},
+	{
+		Test:   "Test38",
+		Data:   samData,
+		RecCnt: 3,
+		Filters: []FilterFunc{
+			Must(Where("READ1 IN (true) OR MD:Z IN ('TAT')")),
+		},
+	},
+	{
+		Test:   "Test39",
+		Data:   samData,
+		RecCnt: 4,
+		Filters: []FilterFunc{
+			Must(Where("NOT RNAME = 'chr1'")),
+		},
+	},
+	{
+		Test:   "Test40",
+		Data:   samData,
+		RecCnt: 6,
+		Filters: []FilterFunc{
+			Must(Where("NOT (RNAME = 'chr1' AND QNAME = 'r001')")),
+		},
+	},
+	{
+		Test:   "Test41",
+		Data:   samData,
+		RecCnt: 2,
+		Filters: []FilterFunc{
+			Must(Where("NOT REVERSE AND NOT PAIRED AND RNAME = chr1")),
+		},
+	},
+	{
+		Test:   "Test42",
+		Data:   samData,
+		RecCnt: 3,
+		Filters: []FilterFunc{
+			Must(Where("NOT NOT RNAME IN ('chr2', 1) OR NOT QNAME != r002")),
+		},
+	},
+	{
+		Test:   "Test43",
+		Data:   samData,
+		RecCnt: 3,
+		Filters: []FilterFunc{
+			Must(Where("POS BETWEEN 8 AND 36")),
+		},
+	},
+	{
+		Test:   "Test44",
+		Data:   samData,
+		RecCnt: 2,
+		Filters: []FilterFunc{
+			Must(Where("RNAME = chr1 AND POS BETWEEN 8 AND 36 AND MAPQ = 30 AND NOT QNAME = r001")),
+		},
+	},
+	{
+		Test:   "Test45",
+		Data:   samData,
+		RecCnt: 5,
+		Filters: []FilterFunc{
+			Must(Where("NOT POS BETWEEN 8 AND 36")),
+		},
+	},
+	{
+		Test:   "Test46",
+		Data:   samData,
+		RecCnt: 4,
+		Filters: []FilterFunc{
+			Must(Where("END - POS > 10")),
+		},
+	},
+	{
+		Test:   "Test47",
+		Data:   samData,
+		RecCnt: 1,
+		Filters: []FilterFunc{
+			Must(Where("TLEN / LENGTH > 2")),
+		},
+	},
+	{
+		Test:   "Test48",
+		Data:   samData,
+		RecCnt: 7,
+		Filters: []FilterFunc{
+			Must(Where("NM:i * 2 < LENGTH")),
+		},
+	},
+	{
+		Test:   "Test49",
+		Data:   samData,
+		RecCnt: 1,
+		Filters: []FilterFunc{
+			Must(Where("POS % 2 = 0 AND FLAG ^ 16 = 16")),
+		},
+	},
+	{
+		Test:   "Test50",
+		Data:   samData,
+		RecCnt: 5,
+		Filters: []FilterFunc{
+			Must(Where("MAPQ + 0.5 > 30")),
+		},
+	},
+	{
+		Test:   "Test51",
+		Data:   samData,
+		RecCnt: 3,
+		Filters: []FilterFunc{
+			Must(Where("(FLAG | 64) - 64 = 0 AND POS % 0 = 0")),
+		},
+	},
+	{
+		Test:   "Test52",
+		Data:   samData,
+		RecCnt: 3,
+		Filters: []FilterFunc{
+			Must(Where("gc_content(SEQ) > 0.5")),
+		},
+	},
+	{
+		Test:   "Test53",
+		Data:   samData,
+		RecCnt: 2,
+		Filters: []FilterFunc{
+			Must(Where("length(SEQ) > 20 AND mean_qual(QUAL) = 0")),
+		},
+	},
+	{
+		Test:   "Test54",
+		Data:   samData,
+		RecCnt: 2,
+		Filters: []FilterFunc{
+			Must(Where("soft_clipped(CIGAR) > 0 OR insertions() > 1")),
+		},
+	},
+	{
+		Test:   "Test55",
+		Data:   samData,
+		RecCnt: 4,
+		Filters: []FilterFunc{
+			Must(Where("deletions(CIGAR) = 0 AND aligned_fraction() >= 0.9")),
+		},
+	},
+	{
+		Test:   "Test56",
+		Data:   samData,
+		RecCnt: 4,
+		Filters: []FilterFunc{
+			Must(Where("IDENTITY >= 0.95")),
+		},
+	},
+	{
+		Test:   "Test57",
+		Data:   samData,
+		RecCnt: 1,
+		Filters: []FilterFunc{
+			Must(Where("MISMATCHES > 0 AND IDENTITY < 0.9 AND ALIGNED_LENGTH = 9")),
+		},
+	},
+	{
+		Test:   "Test58",
+		Data:   samData,
+		RecCnt: 4,
+		Filters: []FilterFunc{
+			Must(Where("RNAME =* 'ChR1'")),
+		},
+	},
+	{
+		Test:   "Test59",
+		Data:   samData,
+		RecCnt: 4,
+		Filters: []FilterFunc{
+			Must(Where("RNAME !* 'CHR1'")),
+		},
+	},
+	{
+		Test:   "Test60",
+		Data:   samData,
+		RecCnt: 2,
+		Filters: []FilterFunc{
+			Must(Where("QNAME =* 'R001'")),
+		},
+	},
+	{
+		Test:   "Test61",
+		Data:   samData,
+		RecCnt: 2,
+		Filters: []FilterFunc{
+			Must(Where("upper(QNAME) = 'R001'")),
+		},
+	},
+	{
+		Test:   "Test62",
+		Data:   samData,
+		RecCnt: 3,
+		Filters: []FilterFunc{
+			Must(Where("lower(SEQ) =~ /^at/")),
+		},
+	},
+	{
+		Test:   "Test63",
+		Data:   samData,
+		RecCnt: 2,
+		Filters: []FilterFunc{
+			Must(Where("ABSTLEN BETWEEN 30 AND 40")),
+		},
+	},
+	{
+		Test:   "Test64",
+		Data:   samData,
+		RecCnt: 2,
+		Filters: []FilterFunc{
+			Must(Where("FRAGMENT_START = 6 AND FRAGMENT_END = 45")),
+		},
+	},
+	{
+		Test:   "Test65",
+		Data:   samData,
+		RecCnt: 1,
+		Filters: []FilterFunc{
+			Must(Where("FRAGMENT_START = 8 AND FRAGMENT_END = 18")),
+		},
+	},
+	{
+		Test:   "Test66",
+		Data:   samData,
+		RecCnt: 1,
+		Filters: []FilterFunc{
+			Must(Where("STRAND = '-'")),
+		},
+	},
+	{
+		Test:   "Test67",
+		Data:   samData,
+		RecCnt: 3,
+		Filters: []FilterFunc{
+			Must(Where("QUERY_COVERAGE < 0.8")),
+		},
+	},
+	{
+		Test:   "Test68",
+		Data:   samData,
+		RecCnt: 1,
+		Filters: []FilterFunc{
+			Must(Where("SOFTCLIP_FRAC > 0.2 AND QUERY_COVERAGE > 0.7")),
+		},
+	},
+	{
+		Test:   "Test69",
+		Data:   samData,
+		RecCnt: 1,
+		Filters: []FilterFunc{
+			Must(Where("MAX_INS = 2 AND MAX_DEL = 1")),
+		},
+	},
+	{
+		Test:   "Test70",
+		Data:   samData,
+		RecCnt: 2,
+		Filters: []FilterFunc{
+			Must(Where("NUM_CIGAR_OPS >= 5 AND MAX_INS > 0")),
+		},
+	},
+	{
+		Test:   "Test71",
+		Data:   samData,
+		RecCnt: 4,
+		Filters: []FilterFunc{
+			Must(Where("MAX_DEL < 1 AND NUM_CIGAR_OPS BETWEEN 1 AND 4")),
+		},
+	},
+	{
+		Test:   "Test72",
+		Data:   samDataPhased,
+		RecCnt: 2,
+		Filters: []FilterFunc{
+			Must(Where("HAPLOTYPE = 1")),
+		},
+	},
+	{
+		Test:   "Test73",
+		Data:   samDataPhased,
+		RecCnt: 1,
+		Filters: []FilterFunc{
+			Must(Where("HAPLOTYPE = 2 AND PHASESET = 5")),
+		},
+	},
+	{
+		Test:   "Test74",
+		Data:   samDataPhased,
+		RecCnt: 1,
+		Filters: []FilterFunc{
+			Must(Where("HAPLOTYPE = 0 AND PHASESET = 0")),
+		},
+	},
 }
 
 // const samData = `@HD	VN:1.5	SO:coordinate
@@ -474,3 +810,358 @@ func TestRead(t *testing.T) {
 		}
 	}
 }
+
+var readInputTests = []struct {
+	Test   string
+	Data   string
+	Input  string
+	Query  string
+	RecCnt int
+}{
+	{
+		Test:   "TestInput1",
+		Data:   samData,
+		Input:  "tumor.sam",
+		Query:  "SOURCE = 'tumor.sam'",
+		RecCnt: 8,
+	},
+	{
+		Test:   "TestInput2",
+		Data:   samData,
+		Input:  "normal.sam",
+		Query:  "SOURCE =~ /tumor/",
+		RecCnt: 0,
+	},
+	{
+		Test:   "TestInput3",
+		Data:   samData,
+		Input:  "tumor.sam",
+		Query:  "SOURCE != 'normal.sam' AND RNAME = 'chr1'",
+		RecCnt: 4,
+	},
+	{
+		Test:   "TestInputFile",
+		Data:   samData,
+		Input:  "tumor.sam",
+		Query:  "FILE =~ /tumor/ AND RNAME = FILE",
+		RecCnt: 0,
+	},
+	{
+		Test:   "TestInputFile2",
+		Data:   samData,
+		Input:  "tumor.sam",
+		Query:  "FILE =~ /tumor/",
+		RecCnt: 8,
+	},
+}
+
+func TestReadInput(t *testing.T) {
+	for _, tt := range readInputTests {
+		sr, err := sam.NewReader(strings.NewReader(tt.Data))
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			continue
+		}
+
+		r := NewReader(sr)
+		r.AppendFilter(Must(WhereInput(tt.Query, tt.Input)))
+
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			continue
+		}
+
+		if l := len(records); l != tt.RecCnt {
+			t.Errorf("%s: record count=%d want %d", tt.Test, l, tt.RecCnt)
+		}
+	}
+}
+
+func TestUnboundKeywords(t *testing.T) {
+	for _, tt := range []struct {
+		Test string
+		Err  error
+	}{
+		{Test: "Where", Err: errOf(Where("SOURCE = 'tumor.sam'"))},
+		{Test: "Compile", Err: errOf(Compile("FILE =~ /tumor/"))},
+		{Test: "Plan", Err: errOf(Plan("MAPQ > 10 AND SAMPLE = 'NA12878'"))},
+		{Test: "WhereInput", Err: errOf(WhereInput("LIBRARY = 'lib1'", "tumor.sam"))},
+	} {
+		if tt.Err == nil {
+			t.Errorf("%s: expected error for unbound keyword", tt.Test)
+		}
+	}
+
+	// Queries are checked before their keywords are bound.
+	if _, err := NewQuery("SELECT QNAME FROM aln WHERE SOURCE = 'tumor.sam'"); err != nil {
+		t.Errorf("NewQuery: unexpected error %q", err.Error())
+	}
+	if diags := Validate("SAMPLE = 'NA12878'", nil); diags != nil {
+		t.Errorf("Validate: unexpected diagnostics %v", diags)
+	}
+}
+
+// errOf returns the error of a function that also returns a value.
+func errOf(_ interface{}, err error) error {
+	return err
+}
+
+const samDataRG = `@HD	VN:1.5	SO:coordinate
+@SQ	SN:chr1	LN:45
+@RG	ID:g1	SM:NA12878	LB:lib1	PL:ILLUMINA
+@RG	ID:g2	SM:NA12878	LB:lib2	PL:PACBIO
+@RG	ID:g3	SM:NA12891	LB:lib3	PL:ILLUMINA
+r001	0	chr1	7	30	5M	*	0	0	TTAGA	*	RG:Z:g1
+r002	0	chr1	9	30	5M	*	0	0	AAAAG	*	RG:Z:g2
+r003	0	chr1	16	30	5M	*	0	0	ATAGC	*	RG:Z:g3
+r004	0	chr1	20	30	5M	*	0	0	ATAGC	*	RG:Z:g4
+r005	0	chr1	30	30	5M	*	0	0	ATAGC	*
+`
+
+func TestReadHeader(t *testing.T) {
+	for _, tt := range []struct {
+		Query  string
+		RecCnt int
+	}{
+		{Query: "RG = 'g1'", RecCnt: 1},
+		{Query: "SAMPLE = 'NA12878'", RecCnt: 2},
+		{Query: "SAMPLE = 'NA12878' AND PLATFORM = 'ILLUMINA'", RecCnt: 1},
+		{Query: "LIBRARY IN ('lib2', 'lib3')", RecCnt: 2},
+		{Query: "SAMPLE = ''", RecCnt: 2},
+		{Query: "SAMPLE != 'NA12878' SAMPLE 1", RecCnt: 3},
+		{Query: "SOURCE = 'rg.sam' AND PLATFORM = 'PACBIO'", RecCnt: 1},
+	} {
+		sr, err := sam.NewReader(strings.NewReader(samDataRG))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := NewReader(sr)
+		r.AppendFilter(Must(WhereHeader(tt.Query, "rg.sam", sr.Header())))
+
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Query, err.Error())
+			continue
+		}
+		if l := len(records); l != tt.RecCnt {
+			t.Errorf("%s: record count=%d want %d", tt.Query, l, tt.RecCnt)
+		}
+	}
+}
+
+func TestUMI(t *testing.T) {
+	const data = "@SQ\tSN:chr1\tLN:45\n" +
+		"u1\t0\tchr1\t7\t30\t5M\t*\t0\t0\tTTAGA\t*\tUB:Z:AAC\tRX:Z:AAA\n" +
+		"u2\t0\tchr1\t9\t30\t5M\t*\t0\t0\tAAAAG\t*\tRX:Z:AAA\n" +
+		"u3\t0\tchr1\t9\t30\t5M\t*\t0\t0\tAAAAG\t*\n"
+
+	for _, tt := range []struct {
+		Tags   []string
+		Query  string
+		RecCnt int
+	}{
+		{Query: "UMI = 'AAC'", RecCnt: 1},
+		{Query: "UMI = 'AAA'", RecCnt: 1},
+		{Query: "UMI = ''", RecCnt: 1},
+		{Tags: []string{"RX"}, Query: "UMI = 'AAA'", RecCnt: 2},
+		{Tags: []string{"RX", "UB"}, Query: "UMI = 'AAC'", RecCnt: 0},
+	} {
+		var opts []FilterOption
+		if tt.Tags != nil {
+			opts = append(opts, WithUMITags(tt.Tags...))
+		}
+		sr, err := sam.NewReader(strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := NewReader(sr)
+		r.AppendFilter(Must(Where(tt.Query, opts...)))
+		// The tags are resolved when the filter is created.
+		if tt.Tags != nil {
+			tt.Tags[0] = "XX"
+		}
+
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Errorf("%v %s: unexpected error %q", tt.Tags, tt.Query, err.Error())
+			continue
+		}
+		if l := len(records); l != tt.RecCnt {
+			t.Errorf("%v %s: record count=%d want %d", tt.Tags, tt.Query, l, tt.RecCnt)
+		}
+	}
+
+	q, err := NewQuery("SELECT UMI FROM aln", WithUMITags("RX"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := sam.NewReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := sr.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := q.Values(rec); len(got) != 1 || got[0] != "AAA" {
+		t.Errorf("values=%v want [AAA]", got)
+	}
+}
+
+func TestTagPresence(t *testing.T) {
+	const data = "@SQ\tSN:chr1\tLN:45\n" +
+		"t1\t0\tchr1\t7\t30\t5M\t*\t0\t0\tTTAGA\t*\tNM:i:0\tXS:Z:x\n" +
+		"t2\t0\tchr1\t9\t30\t5M\t*\t0\t0\tAAAAG\t*\tNM:i:2\n" +
+		"t3\t0\tchr1\t9\t30\t5M\t*\t0\t0\tAAAAG\t*\n"
+
+	for _, tt := range []struct {
+		Query string
+		Names string
+	}{
+		{Query: "NM:i = 0", Names: "t1,t3"},
+		{Query: "NM:i IS NULL", Names: "t3"},
+		{Query: "NM:i IS NOT NULL", Names: "t1,t2"},
+		{Query: "NM:i IS NOT NULL AND NM:i = 0", Names: "t1"},
+		{Query: "has(NM:i)", Names: "t1,t2"},
+		{Query: "has(XS)", Names: "t1"},
+		{Query: "NOT has(XS:Z) AND NM:i IS NOT NULL", Names: "t2"},
+	} {
+		sr, err := sam.NewReader(strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := NewReader(sr)
+		r.AppendFilter(Must(Where(tt.Query)))
+
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Query, err.Error())
+			continue
+		}
+		var names []string
+		for _, rec := range records {
+			names = append(names, rec.Name)
+		}
+		if got := strings.Join(names, ","); got != tt.Names {
+			t.Errorf("%s: records=%s want %s", tt.Query, got, tt.Names)
+		}
+	}
+}
+
+func TestWhereTypeError(t *testing.T) {
+	for _, query := range []string{
+		"FOO",
+		"MAPQ",
+		"QNAME",
+		"1 = MAPQ",
+		"'a' = QNAME",
+		"MAPQ = 'a'",
+		"QNAME = 1.5",
+		"PAIRED = 1",
+		"PAIRED = 'yes'",
+		"IDENTITY = 'x'",
+		"(MAPQ > 1) = 2",
+		"true = 'a'",
+		"MAPQ BETWEEN 'a' AND 'b'",
+	} {
+		if _, err := Where(query); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
+// TestWhereWildcard tests that * outside count(*) returns an error instead
+// of panicking. The queries were found by fuzzing.
+func TestWhereWildcard(t *testing.T) {
+	for _, query := range []string{
+		"MAPQ > *",
+		"sum(*%0)00",
+		"* = 1",
+		"NOT *",
+		"NOT (MAPQ > *)",
+		"*",
+		"POS BETWEEN * AND 10",
+		"length(*) > 1",
+		"count(*) > 1",
+	} {
+		if _, err := Where(query); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+	if _, err := NewQuery("SELECT sum(*%0) FROM aln"); err == nil {
+		t.Error("expected error for * in an aggregate argument")
+	}
+}
+
+func TestHexTag(t *testing.T) {
+	rec := &sam.Record{Name: "r001"}
+	aux, err := sam.ParseAux([]byte("XH:H:1AE3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.AuxFields = append(rec.AuxFields, aux)
+	for query, want := range map[string]bool{
+		"XH:H = '1AE3'": true,
+		"XH:H = '1ae3'": false,
+		"YH:H = ''":     true,
+	} {
+		if got := Must(Where(query))(rec); got != want {
+			t.Errorf("%s: got %t want %t", query, got, want)
+		}
+	}
+}
+
+func TestWhereInError(t *testing.T) {
+	for _, query := range []string{
+		"MAPQ IN ('a', 'b')",
+		"RNAME IN (true)",
+		"PAIRED IN (1)",
+		"NOT MAPQ",
+		"QNAME + 1 > 2",
+		"MAPQ % 1.5 = 0",
+		"length(MAPQ) > 1",
+		"length(SEQ, QUAL) > 1",
+		"foo(SEQ) > 1",
+		"count(*) > 1",
+		"length(DISTINCT SEQ) > 1",
+		"POS IS NULL",
+		"has(POS)",
+		"has(NM:i, MD:Z)",
+		"MAPQ =* 'a'",
+		"lower(MAPQ) = 'a'",
+		"deletions(CIGAR, CIGAR) > 1",
+		"matches(MAPQ) > 1",
+	} {
+		if _, err := Where(query); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
+func TestRecords(t *testing.T) {
+	r := newTestReader(t, "RNAME = chr1")
+	recs, errc := r.Records(context.Background())
+	cnt := 0
+	for range recs {
+		cnt++
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("unexpected error %q", err.Error())
+	}
+	if cnt != 4 {
+		t.Errorf("record count=%d want %d", cnt, 4)
+	}
+
+	// Cancelling stops reading and reports the context error.
+	ctx, cancel := context.WithCancel(context.Background())
+	recs, errc = newTestReader(t, "").Records(ctx)
+	<-recs
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("error=%v want %v", err, context.Canceled)
+	}
+}
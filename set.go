@@ -0,0 +1,184 @@
+package samql
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// Setter sets aux tags and fields of records to the values of expressions. It
+// is used to annotate or fix records, e.g. those that match a filter, instead
+// of removing them.
+type Setter struct {
+	sets []assignment
+}
+
+// assignment sets a single tag of type typ, or the field name, to the value
+// of val.
+type assignment struct {
+	name string
+	tag  sam.Tag
+	typ  byte
+	val  valueFunc
+}
+
+// settableFields associates the fields that can be set with the type of their
+// values.
+var settableFields = map[string]byte{
+	"QNAME": 'Z',
+	"MAPQ":  'i',
+	"FLAG":  'i',
+}
+
+// NewSetter returns a new Setter for assignments of the form TAG:TYPE=EXPR,
+// e.g. "XF:Z=pass" or "XL:i=LENGTH*2", or FIELD=EXPR, e.g. "MAPQ=0" or
+// "FLAG=FLAG|1024". TYPE is one of A, i, f and Z and FIELD one of QNAME, MAPQ
+// and FLAG. An EXPR that is an unknown identifier is resolved to its name, so
+// that string values can be given without quotes. Existing tags are replaced.
+func NewSetter(assignments ...string) (*Setter, error) {
+	s := &Setter{}
+	for _, a := range assignments {
+		expr, err := ql.NewParserFromStr(a).ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		var ref *ql.VarRef
+		e, ok := expr.(*ql.BinaryExpr)
+		if ok && e.Op == ql.EQ {
+			ref, ok = e.LHS.(*ql.VarRef)
+		}
+		if !ok || ref == nil {
+			return nil, fmt.Errorf("samql: invalid assignment %q; must be TAG:TYPE=EXPR or FIELD=EXPR", a)
+		}
+
+		as := assignment{name: ref.Val}
+		if typ, ok := settableFields[ref.Val]; ok {
+			as.typ = typ
+		} else if len(ref.Val) == 4 && validTag.MatchString(ref.Val) {
+			as.tag, as.typ = sam.NewTag(ref.Val[:2]), ref.Val[3]
+			switch as.typ {
+			case 'A', 'i', 'f', 'Z':
+			default:
+				return nil, fmt.Errorf("samql: cannot set tag of type %c", as.typ)
+			}
+		} else {
+			return nil, fmt.Errorf("samql: cannot set %s", ref.Val)
+		}
+
+		if v, ok := e.RHS.(*ql.VarRef); ok && evalVarRef(v.Val) == v.Val {
+			name := v.Val
+			as.val = func(*sam.Record) interface{} { return name }
+		} else if as.val, err = newValueFunc(e.RHS, nil); err != nil {
+			return nil, err
+		}
+		s.sets = append(s.sets, as)
+	}
+	return s, nil
+}
+
+// Set sets the tags and fields of rec. Values are computed before any tag or
+// field is set, so expressions see the original values of rec.
+func (s *Setter) Set(rec *sam.Record) error {
+	vals := make([]interface{}, len(s.sets))
+	for i, as := range s.sets {
+		v, err := convertAux(as.val(rec), as.typ)
+		if err == nil {
+			vals[i], err = as.checkValue(v)
+		}
+		if err != nil {
+			return fmt.Errorf("samql: cannot set %s: %v", as.name, err)
+		}
+	}
+	for i, as := range s.sets {
+		switch as.name {
+		case "QNAME":
+			rec.Name = vals[i].(string)
+		case "MAPQ":
+			rec.MapQ = byte(vals[i].(int))
+		case "FLAG":
+			rec.Flags = sam.Flags(vals[i].(int))
+		default:
+			setAux(rec, vals[i].(sam.Aux))
+		}
+	}
+	return nil
+}
+
+// checkValue checks that the converted value v is valid for as and returns
+// the value that is set, i.e. an aux field for tags.
+func (as assignment) checkValue(v interface{}) (interface{}, error) {
+	switch as.name {
+	case "QNAME":
+		if v.(string) == "" {
+			return nil, fmt.Errorf("empty name")
+		}
+		return v, nil
+	case "MAPQ":
+		if n := v.(int); n < 0 || n > 255 {
+			return nil, fmt.Errorf("%d is out of range", n)
+		}
+		return v, nil
+	case "FLAG":
+		if n := v.(int); n < 0 || n > 0xffff {
+			return nil, fmt.Errorf("%d is out of range", n)
+		}
+		return v, nil
+	}
+	return sam.NewAux(as.tag, v)
+}
+
+// convertAux converts v to a value that is encoded by sam.NewAux as an aux
+// field of type typ.
+func convertAux(v interface{}, typ byte) (interface{}, error) {
+	switch typ {
+	case 'Z':
+		return fmt.Sprint(v), nil
+	case 'A':
+		s := fmt.Sprint(v)
+		if len(s) != 1 {
+			return nil, fmt.Errorf("%q is not a single character", s)
+		}
+		return sam.ASCII(s[0]), nil
+	case 'i':
+		switch v := v.(type) {
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case float32:
+			return int(v), nil
+		case float64:
+			return int(v), nil
+		case string:
+			return strconv.Atoi(v)
+		}
+	case 'f':
+		switch v := v.(type) {
+		case int:
+			return float32(v), nil
+		case int64:
+			return float32(v), nil
+		case float32:
+			return v, nil
+		case float64:
+			return float32(v), nil
+		case string:
+			f, err := strconv.ParseFloat(v, 32)
+			return float32(f), err
+		}
+	}
+	return nil, fmt.Errorf("cannot convert %v to type %c", v, typ)
+}
+
+// setAux sets aux in rec, replacing any existing field with the same tag.
+func setAux(rec *sam.Record, aux sam.Aux) {
+	for i, a := range rec.AuxFields {
+		if a.Tag() == aux.Tag() {
+			rec.AuxFields[i] = aux
+			return
+		}
+	}
+	rec.AuxFields = append(rec.AuxFields, aux)
+}
@@ -0,0 +1,99 @@
+package samql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestSetter(t *testing.T) {
+	const data = "@SQ\tSN:chr1\tLN:45\n" +
+		"r001\t0\tchr1\t7\t30\t8M\t*\t0\t0\tTTAGATAA\t*\tNM:i:1\tXF:Z:old\n"
+
+	for _, tt := range []struct {
+		Set  []string
+		Want string
+		Err  bool
+	}{
+		{Set: []string{"XF:Z=pass"}, Want: "NM:i:1\tXF:Z:pass"},
+		{Set: []string{"XG:Z='a b'"}, Want: "NM:i:1\tXF:Z:old\tXG:Z:a b"},
+		{Set: []string{"XL:i=LENGTH*2", "XP:i=POS"}, Want: "NM:i:1\tXF:Z:old\tXL:i:16\tXP:i:6"},
+		{Set: []string{"NM:i=NM:i+1", "XN:i=NM:i"}, Want: "NM:i:2\tXF:Z:old\tXN:i:1"},
+		{Set: []string{"XI:f=IDENTITY"}, Want: "NM:i:1\tXF:Z:old\tXI:f:0.875"},
+		{Set: []string{"XA:A=y"}, Want: "NM:i:1\tXF:Z:old\tXA:A:y"},
+		{Set: []string{"XS:Z=RNAME"}, Want: "NM:i:1\tXF:Z:old\tXS:Z:chr1"},
+		{Set: []string{"QNAME=replace(QNAME, '^r', 'read')", "XQ:Z=QNAME"}, Want: "NM:i:1\tXF:Z:old\tXQ:Z:r001"},
+		{Set: []string{"MAPQ=0", "XM:i=MAPQ"}, Want: "NM:i:1\tXF:Z:old\tXM:i:30"},
+		{Set: []string{"FLAG=FLAG|1024"}, Want: "NM:i:1\tXF:Z:old"},
+		{Set: []string{"XA:A=yes"}, Err: true},
+		{Set: []string{"MAPQ=256"}, Err: true},
+		{Set: []string{"QNAME=''"}, Err: true},
+		{Set: []string{"XI:i=SEQ"}, Err: true},
+	} {
+		sr, err := sam.NewReader(strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := sr.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := NewSetter(tt.Set...)
+		if err != nil {
+			t.Fatalf("%v: unexpected error %q", tt.Set, err.Error())
+		}
+		err = s.Set(rec)
+		if tt.Err {
+			if err == nil {
+				t.Errorf("%v: expected error", tt.Set)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %q", tt.Set, err.Error())
+			continue
+		}
+		var tags []string
+		for _, aux := range rec.AuxFields {
+			tags = append(tags, aux.String())
+		}
+		if got := strings.Join(tags, "\t"); got != tt.Want {
+			t.Errorf("%v: tags=%q want %q", tt.Set, got, tt.Want)
+		}
+	}
+}
+
+func TestSetterFields(t *testing.T) {
+	rec := &sam.Record{Name: "r001/1", MapQ: 30, Flags: sam.Paired | sam.Duplicate}
+	s, err := NewSetter(
+		"QNAME=replace(QNAME, '/[12]$', '')",
+		"MAPQ=0",
+		"FLAG=(FLAG|1024)^1024",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Name != "r001" || rec.MapQ != 0 || rec.Flags != sam.Paired {
+		t.Errorf("record=%s %d %d want r001 0 %d", rec.Name, rec.MapQ, rec.Flags, sam.Paired)
+	}
+}
+
+func TestSetterInvalid(t *testing.T) {
+	for _, set := range []string{
+		"XF:Z",
+		"XF:Z > 1",
+		"RNAME=chr1",
+		"POS=1",
+		"XF:B=1",
+		"XF:Z=",
+	} {
+		if _, err := NewSetter(set); err == nil {
+			t.Errorf("%q: expected error", set)
+		}
+	}
+}
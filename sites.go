@@ -0,0 +1,235 @@
+package samql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+)
+
+// Site is a variant site of a VCF file. The region is 0-based and half-open
+// and spans the reference allele.
+type Site struct {
+	Region
+	Ref  string
+	Alts []string
+}
+
+// SiteSet is a set of variant sites that records are compared to by the
+// overlaps_site and allele_at_site functions.
+type SiteSet struct {
+	sites []Site
+	tree  intervalTree
+}
+
+// NewSiteSet returns a new SiteSet with sites.
+func NewSiteSet(sites []Site) *SiteSet {
+	regions := make([]Region, len(sites))
+	for i, s := range sites {
+		regions[i] = s.Region
+	}
+	return &SiteSet{sites: sites, tree: newIntervalTree(regions)}
+}
+
+// WithSites sets the variant sites of the overlaps_site and allele_at_site
+// functions.
+func WithSites(ss *SiteSet) FilterOption {
+	return func(o *filterOptions) { o.sites = ss }
+}
+
+// ReadVCF reads the variant sites of a VCF file from r. Only the CHROM, POS,
+// REF and ALT columns are used. Header lines are skipped.
+func ReadVCF(r io.Reader) ([]Site, error) {
+	var sites []Site
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<24) // Lines with many samples can be long.
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 6)
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("samql: VCF line %d: expected at least 5 columns", n)
+		}
+		pos, err := strconv.Atoi(fields[1])
+		if err != nil || pos < 1 {
+			return nil, fmt.Errorf("samql: VCF line %d: invalid position %s", n, fields[1])
+		}
+		ref := strings.ToUpper(fields[3])
+		if ref == "" || ref == "." {
+			return nil, fmt.Errorf("samql: VCF line %d: missing reference allele", n)
+		}
+		var alts []string
+		if fields[4] != "." {
+			alts = strings.Split(strings.ToUpper(fields[4]), ",")
+		}
+		sites = append(sites, Site{
+			Region: Region{Rname: fields[0], Start: pos - 1, End: pos - 1 + len(ref)},
+			Ref:    ref,
+			Alts:   alts,
+		})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return sites, nil
+}
+
+// ReadVCFFile reads the variant sites of the VCF file at path, which can be
+// gzip or bgzip compressed.
+func ReadVCFFile(path string) ([]Site, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		return nil, err
+	}
+	return ReadVCF(r)
+}
+
+// overlapping calls fn with each site that overlaps the alignment of rec, in
+// decreasing order of start, until fn returns false.
+func (s *SiteSet) overlapping(rec *sam.Record, fn func(*Site) bool) {
+	if rec.Ref == nil || rec.Pos < 0 {
+		return
+	}
+	end := rec.End()
+	if end <= rec.Pos { // Records without a CIGAR occupy one position.
+		end = rec.Pos + 1
+	}
+	s.tree.each(rec.Ref.Name(), rec.Pos, end, func(id int) bool {
+		return fn(&s.sites[id])
+	})
+}
+
+// allele returns "ref" or "alt" if rec supports the reference or an
+// alternative allele of site, "other" if it supports neither and false if
+// rec does not cover site.
+func (site *Site) allele(rec *sam.Record) (string, bool) {
+	seq, ok := readAllele(rec, site.Start, site.End)
+	if !ok {
+		return "", false
+	}
+	if seq == site.Ref {
+		return "ref", true
+	}
+	for _, alt := range site.Alts {
+		if seq == alt {
+			return "alt", true
+		}
+	}
+	return "other", true
+}
+
+// readAllele returns the bases of rec that are aligned to [start, end) on
+// the reference in upper case, including the bases inserted after these
+// positions, e.g. "ATT" for an insertion of TT after A. Deleted positions
+// have no bases. It returns false if rec does not align to all positions,
+// e.g. because it ends or skips an intron within the region.
+func readAllele(rec *sam.Record, start, end int) (string, bool) {
+	seq := rec.Seq.Expand()
+	ref, q, covered := rec.Pos, 0, 0
+	var b []byte
+	for _, co := range rec.Cigar {
+		n := co.Len()
+		switch co.Type() {
+		case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch:
+			for i := 0; i < n; i++ {
+				if ref >= start && ref < end {
+					if q >= len(seq) {
+						return "", false
+					}
+					b = append(b, upperBase(seq[q]))
+					covered++
+				}
+				ref++
+				q++
+			}
+		case sam.CigarDeletion:
+			for i := 0; i < n; i++ {
+				if ref >= start && ref < end {
+					covered++
+				}
+				ref++
+			}
+		case sam.CigarSkipped:
+			if ref < end && ref+n > start {
+				return "", false
+			}
+			ref += n
+		case sam.CigarInsertion:
+			if ref > start && ref <= end {
+				if q+n > len(seq) {
+					return "", false
+				}
+				for _, c := range seq[q : q+n] {
+					b = append(b, upperBase(c))
+				}
+			}
+			q += n
+		case sam.CigarSoftClipped:
+			q += n
+		}
+	}
+	return string(b), covered == end-start
+}
+
+// sitesArg returns the sites of o for function name, which takes no
+// arguments, or an error if they are not set.
+func sitesArg(name string, o *filterOptions, args []interface{}) (*SiteSet, error) {
+	if o.sites == nil {
+		return nil, fmt.Errorf("%s requires sites, e.g. --sites calls.vcf.gz", name)
+	}
+	if len(args) != 0 {
+		return nil, fmt.Errorf("%s expects 0 arguments, got %d", name, len(args))
+	}
+	return o.sites, nil
+}
+
+// overlapsSite returns a placeholderBool that is true if a record overlaps a
+// variant site, e.g. overlaps_site().
+func overlapsSite(o *filterOptions, args []interface{}) (interface{}, error) {
+	sites, err := sitesArg("overlaps_site", o, args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderBool(func(rec *sam.Record) bool {
+		found := false
+		sites.overlapping(rec, func(*Site) bool {
+			found = true
+			return false
+		})
+		return found
+	}), nil
+}
+
+// alleleAtSite returns a placeholderStr with the allele that a record
+// supports at the first variant site it covers, "ref", "alt" or "other", or
+// an empty string if it covers no site, e.g. allele_at_site() = 'alt'.
+// Alleles are compared to the bases of the record aligned to the reference
+// allele, so both substitutions and indels are classified.
+func alleleAtSite(o *filterOptions, args []interface{}) (interface{}, error) {
+	sites, err := sitesArg("allele_at_site", o, args)
+	if err != nil {
+		return nil, err
+	}
+	return placeholderStr(func(rec *sam.Record) string {
+		allele, start := "", -1
+		sites.overlapping(rec, func(site *Site) bool {
+			if a, ok := site.allele(rec); ok && (start < 0 || site.Start <= start) {
+				allele, start = a, site.Start
+			}
+			return true
+		})
+		return allele
+	}), nil
+}
@@ -0,0 +1,112 @@
+package samql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+const vcfData = `##fileformat=VCFv4.2
+#CHROM	POS	ID	REF	ALT	QUAL	FILTER	INFO
+chr1	8	.	T	A	50	PASS	.
+chr1	9	.	g	A,C	50	PASS	.
+chr2	45	rs1	A	.	.	.	.
+`
+
+func TestReadVCF(t *testing.T) {
+	sites, err := ReadVCF(strings.NewReader(vcfData))
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	want := []Site{
+		{Region: Region{Rname: "chr1", Start: 7, End: 8}, Ref: "T", Alts: []string{"A"}},
+		{Region: Region{Rname: "chr1", Start: 8, End: 9}, Ref: "G", Alts: []string{"A", "C"}},
+		{Region: Region{Rname: "chr2", Start: 44, End: 45}, Ref: "A"},
+	}
+	if !reflect.DeepEqual(sites, want) {
+		t.Errorf("sites=%v want %v", sites, want)
+	}
+
+	for _, data := range []string{
+		"chr1\t8\t.\tT\n",
+		"chr1\t0\t.\tT\tA\n",
+		"chr1\tx\t.\tT\tA\n",
+		"chr1\t8\t.\t.\tA\n",
+	} {
+		if _, err := ReadVCF(strings.NewReader(data)); err == nil {
+			t.Errorf("%q: expected error", data)
+		}
+	}
+}
+
+func TestSiteFunctions(t *testing.T) {
+	if _, err := Where("overlaps_site()"); err == nil {
+		t.Errorf("expected error without sites")
+	}
+
+	// The first records are r001 at 6, 8M2I4M1D3M, TTAGATAA|AG|GATA|-|CTG,
+	// r002 at 8, 3S6M1P1I4M, AAA|AGATAA|G|GATA, and r003 at 15, 6M14N5M.
+	for _, tt := range []struct {
+		Site    string
+		Alleles []string
+	}{
+		{Site: "chr1\t8\t.\tT\tA", Alleles: []string{"ref", "", ""}},
+		{Site: "chr1\t9\t.\tG\tA", Alleles: []string{"alt", "alt", ""}},
+		{Site: "chr1\t14\t.\tA\tAAG", Alleles: []string{"alt", "other", ""}},
+		{Site: "chr1\t18\t.\tAT\tA", Alleles: []string{"alt", "", "other"}},
+		{Site: "chr1\t20\t.\tCT\tC", Alleles: []string{"ref", "", "ref"}},
+		{Site: "chr1\t30\t.\tA\tT", Alleles: []string{"", "", ""}},
+	} {
+		sites, err := ReadVCF(strings.NewReader(tt.Site + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		vars := withOptions(nil, []FilterOption{WithSites(NewSiteSet(sites))})
+		expr, err := ql.NewParserFromStr("allele_at_site()").ParseExpr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn, err := newValueFunc(expr, vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sr, err := sam.NewReader(strings.NewReader(samData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var alleles []string
+		for range tt.Alleles {
+			rec, err := sr.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			alleles = append(alleles, fn(rec).(string))
+		}
+		if !reflect.DeepEqual(alleles, tt.Alleles) {
+			t.Errorf("%q: alleles=%q want %q", tt.Site, alleles, tt.Alleles)
+		}
+	}
+
+	sites, err := ReadVCF(strings.NewReader(vcfData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSites := WithSites(NewSiteSet(sites))
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(sr)
+	r.AppendFilter(Must(Where("overlaps_site() AND allele_at_site() = 'ref'", withSites)))
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Name != "r001" {
+		t.Errorf("records=%v want r001", records)
+	}
+}
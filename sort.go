@@ -0,0 +1,335 @@
+package samql
+
+import (
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// DefaultSortBuffer is the default number of records that a Sorter holds in
+// memory before writing them to a temporary file.
+const DefaultSortBuffer = 1000000
+
+// sortKey compares two records by a single ORDER BY field.
+type sortKey struct {
+	val valueFunc
+	ref func(*sam.Record) int // reference ID, used for RNAME and RNEXT.
+	asc bool
+}
+
+// compare returns -1, 0 or 1 if a sorts before, together with or after b.
+func (k sortKey) compare(a, b *sam.Record) int {
+	var c int
+	if k.ref != nil {
+		c = compareRefIDs(k.ref(a), k.ref(b))
+	} else {
+		c = compareValues(k.val(a), k.val(b))
+	}
+	if !k.asc {
+		c = -c
+	}
+	return c
+}
+
+// compareRefIDs compares two reference IDs. Unmapped records (ID -1) are
+// sorted after all mapped records as in coordinate sorted files.
+func compareRefIDs(a, b int) int {
+	if a < 0 {
+		a = math.MaxInt32
+	}
+	if b < 0 {
+		b = math.MaxInt32
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// newSortKey returns a sortKey for the ORDER BY field f. RNAME and RNEXT are
+// sorted by the order of the references in the header instead of
+// alphabetically, so that ORDER BY RNAME, POS produces coordinate sorted
+// output.
+func newSortKey(f *ql.SortField) (sortKey, error) {
+	if ref, ok := f.Expr.(*ql.VarRef); ok {
+		switch ref.Val {
+		case "RNAME":
+			return sortKey{ref: (*sam.Record).RefID, asc: f.Ascending}, nil
+		case "RNEXT":
+			return sortKey{ref: func(r *sam.Record) int {
+				return r.MateRef.ID()
+			}, asc: f.Ascending}, nil
+		}
+	}
+
+	fn, err := newValueFunc(f.Expr, nil)
+	if err != nil {
+		return sortKey{}, err
+	}
+	return sortKey{val: fn, asc: f.Ascending}, nil
+}
+
+// Less returns true if a sorts before b according to the ORDER BY fields of q.
+func (q *Query) Less(a, b *sam.Record) bool {
+	for _, k := range q.sorts {
+		if c := k.compare(a, b); c != 0 {
+			return c < 0
+		}
+	}
+	return false
+}
+
+// IsSorted returns true if q has an ORDER BY clause.
+func (q *Query) IsSorted() bool {
+	return len(q.sorts) > 0
+}
+
+// SortOrder returns the SAM header sort order of records sorted by q. It
+// returns sam.Coordinate for ORDER BY RNAME, POS, sam.QueryName for ORDER BY
+// QNAME and sam.Unsorted otherwise.
+func (q *Query) SortOrder() sam.SortOrder {
+	// isField returns true if the i-th ORDER BY field sorts by name ascending.
+	isField := func(i int, name string) bool {
+		fs := q.Stmt.SortFields
+		return i < len(fs) && fs[i].Ascending && fs[i].Expr.String() == name
+	}
+	switch {
+	case isField(0, "RNAME") && isField(1, "POS"):
+		return sam.Coordinate
+	case isField(0, "QNAME"):
+		return sam.QueryName
+	}
+	return sam.Unsorted
+}
+
+// Sorter sorts records by the ORDER BY fields of a Query. Records are held
+// in memory and, when BufferSize is exceeded, they are sorted and written to
+// a temporary BAM file. The sorted records are returned by merging the
+// temporary files with the records that remain in memory.
+type Sorter struct {
+	// BufferSize is the maximum number of records held in memory.
+	BufferSize int
+
+	// TempDir is the directory for temporary files. The default directory
+	// for temporary files is used if empty.
+	TempDir string
+
+	h    *sam.Header
+	less func(a, b *sam.Record) bool
+	buf  []*sam.Record
+	runs []string // paths of the temporary files.
+}
+
+// NewSorter returns a new Sorter for records with header h that sorts by the
+// ORDER BY fields of q.
+func (q *Query) NewSorter(h *sam.Header) *Sorter {
+	return &Sorter{
+		BufferSize: DefaultSortBuffer,
+		h:          h,
+		less:       q.Less,
+	}
+}
+
+// Add adds rec to the records to be sorted.
+func (s *Sorter) Add(rec *sam.Record) error {
+	s.buf = append(s.buf, rec)
+	if len(s.buf) >= s.BufferSize {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill sorts the records in memory and writes them to a temporary file.
+func (s *Sorter) spill() error {
+	sort.SliceStable(s.buf, func(i, j int) bool {
+		return s.less(s.buf[i], s.buf[j])
+	})
+
+	f, err := ioutil.TempFile(s.TempDir, "samql-sort-*.bam")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f.Name())
+
+	bw, err := bam.NewWriter(f, s.h, 1)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	for _, rec := range s.buf {
+		if err := bw.Write(rec); err != nil {
+			bw.Close()
+			f.Close()
+			return err
+		}
+	}
+	if err := bw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	s.buf = s.buf[:0]
+	return f.Close()
+}
+
+// Sort returns a Source that reads the added records in sorted order. The
+// returned Source must be closed to remove any temporary files. No records
+// should be added after calling Sort.
+func (s *Sorter) Sort() (Source, error) {
+	sort.SliceStable(s.buf, func(i, j int) bool {
+		return s.less(s.buf[i], s.buf[j])
+	})
+
+	m := &mergeSource{h: s.h, less: s.less, sorter: s}
+	for i, path := range s.runs {
+		f, err := os.Open(path)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.closers = append(m.closers, f)
+		br, err := bam.NewReader(f, 1)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.closers = append(m.closers, br)
+		if err := m.push(&run{r: br, idx: i}); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+	mem := &sliceReader{recs: s.buf}
+	if err := m.push(&run{r: mem, idx: len(s.runs)}); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close removes any temporary files created by s.
+func (s *Sorter) Close() error {
+	var err error
+	for _, path := range s.runs {
+		if e := os.Remove(path); e != nil && err == nil {
+			err = e
+		}
+	}
+	s.runs = nil
+	s.buf = nil
+	return err
+}
+
+// sliceReader reads records from a slice.
+type sliceReader struct {
+	recs []*sam.Record
+}
+
+// Read returns the next record in the slice.
+func (r *sliceReader) Read() (*sam.Record, error) {
+	if len(r.recs) == 0 {
+		return nil, io.EOF
+	}
+	rec := r.recs[0]
+	r.recs = r.recs[1:]
+	return rec, nil
+}
+
+// run is a sorted sequence of records and its current record.
+type run struct {
+	r   interface{ Read() (*sam.Record, error) }
+	rec *sam.Record
+	idx int // used to keep the sort stable across runs.
+}
+
+// mergeSource merges sorted runs of records, e.g. the temporary files of a
+// Sorter or sorted inputs. It implements Source.
+type mergeSource struct {
+	h       *sam.Header
+	less    func(a, b *sam.Record) bool
+	runs    []*run
+	closers multiCloser
+	sorter  *Sorter
+}
+
+// push reads the first record of r and adds it to the merge heap if r is not
+// empty.
+func (m *mergeSource) push(r *run) error {
+	rec, err := r.r.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	r.rec = rec
+	heap.Push(m, r)
+	return nil
+}
+
+// Header returns the header of the sorted records.
+func (m *mergeSource) Header() *sam.Header {
+	return m.h
+}
+
+// Read returns the next record in sorted order.
+func (m *mergeSource) Read() (*sam.Record, error) {
+	if len(m.runs) == 0 {
+		return nil, io.EOF
+	}
+	r := m.runs[0]
+	rec := r.rec
+
+	next, err := r.r.Read()
+	if err != nil {
+		if err != io.EOF {
+			return nil, err
+		}
+		heap.Pop(m)
+	} else {
+		r.rec = next
+		heap.Fix(m, 0)
+	}
+	return rec, nil
+}
+
+// Close closes the temporary files and removes them.
+func (m *mergeSource) Close() error {
+	err := m.closers.Close()
+	if m.sorter == nil {
+		return err
+	}
+	if e := m.sorter.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// heap.Interface implementation for mergeSource.
+func (m *mergeSource) Len() int { return len(m.runs) }
+func (m *mergeSource) Less(i, j int) bool {
+	a, b := m.runs[i], m.runs[j]
+	if m.less(a.rec, b.rec) {
+		return true
+	}
+	if m.less(b.rec, a.rec) {
+		return false
+	}
+	return a.idx < b.idx
+}
+func (m *mergeSource) Swap(i, j int)      { m.runs[i], m.runs[j] = m.runs[j], m.runs[i] }
+func (m *mergeSource) Push(x interface{}) { m.runs = append(m.runs, x.(*run)) }
+func (m *mergeSource) Pop() interface{} {
+	r := m.runs[len(m.runs)-1]
+	m.runs = m.runs[:len(m.runs)-1]
+	return r
+}
@@ -0,0 +1,185 @@
+package samql
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/bamx"
+)
+
+// Source is a source of SAM records. Third party packages can provide record
+// sources, e.g. CRAM, htsget or database backed sources, by implementing
+// Source and registering an OpenFunc for a URL scheme with RegisterScheme.
+type Source interface {
+	// Header returns the SAM header of the source.
+	Header() *sam.Header
+	// Read returns the next record. It returns nil and io.EOF when the
+	// source is exhausted.
+	Read() (*sam.Record, error)
+	// Close releases any resources associated with the source.
+	Close() error
+}
+
+// The github.com/biogo/hts BAM readers satisfy Source.
+var _ Source = (*bam.Reader)(nil)
+var _ Source = (*bamx.Reader)(nil)
+
+// OpenFunc opens the record source identified by name. name is the complete
+// string passed to OpenSource, including the scheme.
+type OpenFunc func(name string) (Source, error)
+
+var (
+	schemesMu sync.RWMutex
+	schemes   = make(map[string]OpenFunc)
+)
+
+func init() {
+	RegisterScheme("file", openFile)
+}
+
+// RegisterScheme makes a record source available for names with the provided
+// URL scheme (e.g. "ftp" for "ftp://host/key.bam"). If RegisterScheme is
+// called twice with the same scheme or if fn is nil, it panics.
+func RegisterScheme(scheme string, fn OpenFunc) {
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+	if fn == nil {
+		panic("samql: RegisterScheme open function is nil")
+	}
+	if _, dup := schemes[scheme]; dup {
+		panic("samql: RegisterScheme called twice for scheme " + scheme)
+	}
+	schemes[scheme] = fn
+}
+
+// Schemes returns a sorted list of the registered URL schemes.
+func Schemes() []string {
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+	list := make([]string, 0, len(schemes))
+	for s := range schemes {
+		list = append(list, s)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// OpenSource opens the record source identified by name. If name starts with
+// a URL scheme (e.g. "s3://") the OpenFunc registered for the scheme is used.
+// Otherwise, name is treated as a path to a local file and opened with the
+// "file" scheme.
+func OpenSource(name string) (Source, error) {
+	scheme := schemeOf(name)
+	if scheme == "" {
+		scheme = "file"
+	}
+
+	schemesMu.RLock()
+	fn, ok := schemes[scheme]
+	schemesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("samql: unknown scheme %q in %s", scheme, name)
+	}
+	return fn(name)
+}
+
+// schemeOf returns the URL scheme of name or an empty string if name has no
+// scheme.
+func schemeOf(name string) string {
+	i := strings.Index(name, "://")
+	if i <= 0 {
+		return ""
+	}
+	return name[:i]
+}
+
+// openFile opens a local SAM or BAM file. The file format is detected from the
+// file contents. The name "-" corresponds to STDIN.
+func openFile(name string) (Source, error) {
+	path := strings.TrimPrefix(name, "file://")
+
+	var f *os.File
+	if path == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return newSource(path, f)
+}
+
+// newSource returns a Source that reads the SAM or BAM data of the file name
+// from f and closes f on Close. The format is detected from the data.
+func newSource(name string, f io.ReadCloser) (Source, error) {
+	format, r, err := DetectFormat(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	switch format {
+	case SAM:
+		sr, err := sam.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &closerSource{readerSAM: sr, c: f}, nil
+	case BAM:
+		br, err := NewBAMReader(r, 0)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &bamSource{BAMReader: br, f: f}, nil
+	}
+	f.Close()
+	return nil, errFormat(name, format)
+}
+
+// closerSource turns a reader that may not be closed, such as sam.Reader, into
+// a Source by closing c on Close.
+type closerSource struct {
+	readerSAM
+	c io.Closer
+}
+
+// Close closes the underlying io.Closer.
+func (s *closerSource) Close() error {
+	return s.c.Close()
+}
+
+// bamSource is a BAMReader that closes the file it reads from on Close. The
+// methods of BAMReader, such as ReadInto, are promoted.
+type bamSource struct {
+	*BAMReader
+	f io.Closer
+}
+
+// Close closes the BAMReader and the underlying file.
+func (s *bamSource) Close() error {
+	return multiCloser{s.BAMReader, s.f}.Close()
+}
+
+// multiCloser closes all io.Closers in order and returns the first error.
+type multiCloser []io.Closer
+
+// Close closes all io.Closers in m.
+func (m multiCloser) Close() error {
+	var err error
+	for _, c := range m {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package samql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func init() {
+	// mem:// sources read from samData and are used for testing.
+	RegisterScheme("mem", func(name string) (Source, error) {
+		sr, err := sam.NewReader(strings.NewReader(samData))
+		if err != nil {
+			return nil, err
+		}
+		return &closerSource{readerSAM: sr, c: ioutil.NopCloser(nil)}, nil
+	})
+}
+
+func TestOpenSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "samql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.sam")
+	if err := ioutil.WriteFile(path, []byte(samData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		Test   string
+		Name   string
+		RecCnt int
+		Err    bool
+	}{
+		{Test: "Mem", Name: "mem://foo", RecCnt: 8},
+		{Test: "File", Name: path, RecCnt: 8},
+		{Test: "FileScheme", Name: "file://" + path, RecCnt: 8},
+		{Test: "Unknown", Name: "foo://bar", Err: true},
+		{Test: "Missing", Name: filepath.Join(dir, "missing.sam"), Err: true},
+	} {
+		src, err := OpenSource(tt.Name)
+		if tt.Err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.Test)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+			continue
+		}
+
+		r := NewReader(src)
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+		if l := len(records); l != tt.RecCnt {
+			t.Errorf("%s: record count=%d want %d", tt.Test, l, tt.RecCnt)
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("%s: unexpected close error %q", tt.Test, err.Error())
+		}
+	}
+}
+
+func TestRegisterSchemeTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic for duplicate scheme")
+		}
+	}()
+	RegisterScheme("mem", openFile)
+}
@@ -0,0 +1,230 @@
+package samql
+
+import (
+	"bufio"
+	"compress/gzip"
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// DefaultMaxOpen is the default maximum number of files that a SplitWriter
+// keeps open.
+const DefaultMaxOpen = 256
+
+// SplitWriter writes records to a separate SAM or BAM file for each distinct
+// value of an expression, e.g. RNAME or CB:Z. Files are created when the
+// first record of a value is written. At most MaxOpen files are kept open;
+// the least recently used file is closed when another one is needed and is
+// reopened for appending when it is written again. This allows splitting by
+// values with many distinct values, such as cell barcodes.
+type SplitWriter struct {
+	// MaxOpen is the maximum number of open files.
+	MaxOpen int
+	// Level is the compression level of BAM files, as in
+	// NewBAMWriterLevel.
+	Level   int
+	Filters []FilterFunc
+
+	by      valueFunc
+	h       *sam.Header
+	format  Format
+	path    func(value string) string
+	writers map[string]*splitWriter
+	open    *list.List // Open files, most recently used first.
+}
+
+// splitWriter is the writer of a single value.
+type splitWriter struct {
+	w   writerSAM
+	buf *bufio.Writer
+	f   *pooledFile
+}
+
+// NewSplitWriter returns a new SplitWriter that writes records with header h
+// to the file returned by path for the value of expression by, in format
+// SAM or BAM. Values are sanitized before they are passed to path, so that
+// they can be used as file names; characters other than letters, digits,
+// dots, dashes and underscores are replaced by underscores.
+func NewSplitWriter(by string, h *sam.Header, format Format,
+	path func(value string) string) (*SplitWriter, error) {
+
+	if format != SAM && format != BAM {
+		return nil, fmt.Errorf("samql: cannot split to %s format", format)
+	}
+	expr, err := ql.NewParserFromStr(by).ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	fn, err := newValueFunc(expr, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SplitWriter{
+		MaxOpen: DefaultMaxOpen,
+		Level:   gzip.DefaultCompression,
+		Filters: make([]FilterFunc, 0),
+		by:      fn,
+		h:       h,
+		format:  format,
+		path:    path,
+		writers: make(map[string]*splitWriter),
+		open:    list.New(),
+	}, nil
+}
+
+// AppendFilter appends the provided filter to writer w.
+func (w *SplitWriter) AppendFilter(f FilterFunc) {
+	w.Filters = append(w.Filters, f)
+}
+
+// Write writes rec to the file of its value if rec passes all filters.
+func (w *SplitWriter) Write(rec *sam.Record) error {
+	if !allTrue(rec, w.Filters) {
+		return nil
+	}
+	value := sanitize(fmt.Sprint(w.by(rec)))
+	sw, ok := w.writers[value]
+	if !ok {
+		var err error
+		if sw, err = w.newWriter(value); err != nil {
+			return err
+		}
+		w.writers[value] = sw
+	}
+	return sw.w.Write(rec)
+}
+
+// newWriter returns the writer for value, which writes to a new file.
+func (w *SplitWriter) newWriter(value string) (*splitWriter, error) {
+	f := &pooledFile{path: w.path(value), sw: w}
+	// The file is created even if the header is buffered so that path
+	// errors are reported early.
+	if err := f.reopen(); err != nil {
+		return nil, err
+	}
+	// Buffered data is written to the file when flushed, even if the file
+	// was closed in the meantime.
+	sw := &splitWriter{buf: bufio.NewWriter(f), f: f}
+	var err error
+	switch w.format {
+	case SAM:
+		sw.w, err = sam.NewWriter(sw.buf, w.h, sam.FlagDecimal)
+	case BAM:
+		sw.w, err = bam.NewWriterLevel(sw.buf, w.h, w.Level, 1)
+	}
+	if err != nil {
+		f.close()
+		return nil, err
+	}
+	return sw, nil
+}
+
+// Values returns the sanitized values that were written.
+func (w *SplitWriter) Values() []string {
+	values := make([]string, 0, len(w.writers))
+	for v := range w.writers {
+		values = append(values, v)
+	}
+	return values
+}
+
+// Close closes all writers and files.
+func (w *SplitWriter) Close() error {
+	var err error
+	for _, sw := range w.writers {
+		if c, ok := sw.w.(io.Closer); ok {
+			if e := c.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+		if e := sw.buf.Flush(); e != nil && err == nil {
+			err = e
+		}
+		if e := sw.f.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	w.writers = make(map[string]*splitWriter)
+	return err
+}
+
+// pooledFile is a file that is opened when written and may be closed by its
+// SplitWriter at any time to limit the number of open files.
+type pooledFile struct {
+	path    string
+	sw      *SplitWriter
+	f       *os.File
+	elem    *list.Element
+	created bool
+}
+
+// Write writes p to the file, reopening it if needed.
+func (f *pooledFile) Write(p []byte) (int, error) {
+	if f.f == nil {
+		if err := f.reopen(); err != nil {
+			return 0, err
+		}
+	} else {
+		f.sw.open.MoveToFront(f.elem)
+	}
+	return f.f.Write(p)
+}
+
+// reopen opens the file, truncating it the first time, and closes the least
+// recently used files if more than MaxOpen files are open.
+func (f *pooledFile) reopen() error {
+	for f.sw.MaxOpen > 0 && f.sw.open.Len() >= f.sw.MaxOpen {
+		if err := f.sw.open.Back().Value.(*pooledFile).close(); err != nil {
+			return err
+		}
+	}
+	flag := os.O_WRONLY | os.O_APPEND
+	if !f.created {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(f.path, flag, 0666)
+	if err != nil {
+		return err
+	}
+	f.f, f.created = file, true
+	f.elem = f.sw.open.PushFront(f)
+	return nil
+}
+
+// close closes the file, if open.
+func (f *pooledFile) close() error {
+	if f.f == nil {
+		return nil
+	}
+	f.sw.open.Remove(f.elem)
+	err := f.f.Close()
+	f.f, f.elem = nil, nil
+	return err
+}
+
+// sanitize returns s with characters other than letters, digits, dots,
+// dashes and underscores replaced by underscores. Empty strings are returned
+// as a single underscore and the special names "." and ".." as underscores.
+func sanitize(s string) string {
+	switch s {
+	case "":
+		return "_"
+	case ".", "..":
+		return strings.Repeat("_", len(s))
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
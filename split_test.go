@@ -0,0 +1,139 @@
+package samql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+func TestSplitWriter(t *testing.T) {
+	for _, tt := range []struct {
+		Test    string
+		By      string
+		Format  Format
+		MaxOpen int
+		Query   string
+		Counts  map[string]int
+	}{
+		{
+			Test:   "SAM",
+			By:     "RNAME",
+			Format: SAM,
+			Counts: map[string]int{"chr1": 4, "chr2": 1, "1": 1, "_": 2},
+		},
+		{
+			Test:    "SAMMaxOpen",
+			By:      "RNAME",
+			Format:  SAM,
+			MaxOpen: 1,
+			Counts:  map[string]int{"chr1": 4, "chr2": 1, "1": 1, "_": 2},
+		},
+		{
+			Test:    "BAMMaxOpen",
+			By:      "RNAME",
+			Format:  BAM,
+			MaxOpen: 2,
+			Counts:  map[string]int{"chr1": 4, "chr2": 1, "1": 1, "_": 2},
+		},
+		{
+			Test:   "Filter",
+			By:     "QNAME",
+			Format: SAM,
+			Query:  "RNAME = chr1",
+			Counts: map[string]int{"r001": 2, "r002": 1, "r003": 1},
+		},
+	} {
+		dir, err := ioutil.TempDir("", "samql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := func(v string) string { return filepath.Join(dir, v) }
+
+		r := newTestReader(t, "")
+		w, err := NewSplitWriter(tt.By, r.Header(), tt.Format, path)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+		if tt.MaxOpen > 0 {
+			w.MaxOpen = tt.MaxOpen
+		}
+		if tt.Query != "" {
+			w.AppendFilter(Must(Where(tt.Query)))
+		}
+		records, err := r.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range records {
+			if err := w.Write(rec); err != nil {
+				t.Fatalf("%s: unexpected write error %q", tt.Test, err.Error())
+			}
+		}
+		values := w.Values()
+		if err := w.Close(); err != nil {
+			t.Errorf("%s: unexpected close error %q", tt.Test, err.Error())
+		}
+
+		if len(values) != len(tt.Counts) {
+			sort.Strings(values)
+			t.Errorf("%s: values=%v want %d values", tt.Test, values, len(tt.Counts))
+		}
+		for v, want := range tt.Counts {
+			f, err := os.Open(path(v))
+			if err != nil {
+				t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+				continue
+			}
+			var sr readerSAM
+			if tt.Format == BAM {
+				sr, err = bam.NewReader(f, 1)
+			} else {
+				sr, err = sam.NewReader(f)
+			}
+			if err != nil {
+				t.Fatalf("%s: unexpected error %q", tt.Test, err.Error())
+			}
+			got, err := NewReader(sr).ReadAll()
+			if err != nil {
+				t.Errorf("%s: unexpected read error %q", tt.Test, err.Error())
+			}
+			f.Close()
+			if len(got) != want {
+				t.Errorf("%s: %s records=%d want %d", tt.Test, v, len(got), want)
+			}
+		}
+	}
+}
+
+func TestSplitWriterFormat(t *testing.T) {
+	r := newTestReader(t, "")
+	_, err := NewSplitWriter("RNAME", r.Header(), CRAM, nil)
+	if err == nil || !strings.Contains(err.Error(), "CRAM") {
+		t.Errorf("error=%v want CRAM format error", err)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	for _, tt := range []struct {
+		In, Want string
+	}{
+		{"chr1", "chr1"},
+		{"ACGT-1", "ACGT-1"},
+		{"*", "_"},
+		{"", "_"},
+		{"a/b c", "a_b_c"},
+		{"../x", ".._x"},
+		{"..", "__"},
+	} {
+		if got := sanitize(tt.In); got != tt.Want {
+			t.Errorf("sanitize(%q)=%q want %q", tt.In, got, tt.Want)
+		}
+	}
+}
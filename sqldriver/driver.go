@@ -0,0 +1,176 @@
+// Package sqldriver registers samql as a database/sql driver named "samql".
+// The data source name is the SAM or BAM file, or URL, that SELECT
+// statements read from; the table name after FROM is ignored.
+//
+//	db, err := sql.Open("samql", "test.bam")
+//	rows, err := db.Query("SELECT QNAME, POS FROM aln WHERE MAPQ > $minq",
+//		sql.Named("minq", 30))
+//
+// Each query reads the file anew. Parameters are bound by name with
+// sql.Named, as with samql.BindParams. Transactions and statements other
+// than SELECT are not supported.
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+
+	"github.com/maragkakislab/samql"
+)
+
+func init() {
+	sql.Register("samql", Driver{})
+}
+
+// Driver is the samql database/sql driver.
+type Driver struct{}
+
+// Open returns a connection that runs queries over the records of the SAM
+// or BAM file name.
+func (Driver) Open(name string) (driver.Conn, error) {
+	if name == "" {
+		return nil, errors.New("sqldriver: missing file name")
+	}
+	return &conn{path: name}, nil
+}
+
+// conn is a connection to a SAM or BAM file.
+type conn struct {
+	path string
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{c: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, errors.New("sqldriver: transactions are not supported")
+}
+
+// QueryContext implements driver.QueryerContext so queries are run without
+// preparing them first.
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(args) > 0 {
+		params := make(map[string]interface{}, len(args))
+		for _, a := range args {
+			if a.Name == "" {
+				return nil, errors.New("sqldriver: positional parameters are not supported; use sql.Named")
+			}
+			params[a.Name] = a.Value
+		}
+		var err error
+		if query, err = samql.BindParams(query, params); err != nil {
+			return nil, err
+		}
+	}
+	r, err := samql.QueryFile(c.path, query)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{r: r}, nil
+}
+
+// stmt is a prepared statement. Queries are parsed when they are run.
+type stmt struct {
+	c     *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+// NumInput returns -1 as parameters are bound by name.
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("sqldriver: only SELECT statements are supported")
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return s.c.QueryContext(context.Background(), s.query, named)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.c.QueryContext(ctx, s.query, args)
+}
+
+// rows adapts samql.Rows to driver.Rows.
+type rows struct {
+	r *samql.Rows
+}
+
+func (r *rows) Columns() []string {
+	return r.r.Columns()
+}
+
+func (r *rows) Close() error {
+	return r.r.Close()
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if !r.r.Next() {
+		if err := r.r.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for i, v := range r.r.Values() {
+		dest[i] = driverValue(v)
+	}
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName returns the SQL name of the type of column i,
+// e.g. INTEGER.
+func (r *rows) ColumnTypeDatabaseTypeName(i int) string {
+	return r.r.ColumnTypes()[i].String()
+}
+
+// scanTypes are the Go types that the values of each column type are
+// scanned into.
+var scanTypes = map[samql.ColumnType]reflect.Type{
+	samql.BoolColumn:   reflect.TypeOf(false),
+	samql.IntColumn:    reflect.TypeOf(int64(0)),
+	samql.FloatColumn:  reflect.TypeOf(float64(0)),
+	samql.DoubleColumn: reflect.TypeOf(float64(0)),
+	samql.StringColumn: reflect.TypeOf(""),
+}
+
+// ColumnTypeScanType returns the Go type that the values of column i are
+// scanned into.
+func (r *rows) ColumnTypeScanType(i int) reflect.Type {
+	if t, ok := scanTypes[r.r.ColumnTypes()[i]]; ok {
+		return t
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+// driverValue returns v as one of the types that drivers return, e.g.
+// int64 for int.
+func driverValue(v interface{}) driver.Value {
+	switch x := v.(type) {
+	case int:
+		return int64(x)
+	case float32:
+		return float64(x)
+	}
+	return v
+}
@@ -0,0 +1,152 @@
+package sqldriver
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const samData = `@HD	VN:1.5	SO:coordinate
+@SQ	SN:chr1	LN:45
+r001	99	chr1	7	30	8M2I4M1D3M	=	37	39	TTAGATAAAGGATACTG	*
+r002	0	chr1	9	20	3S6M1P1I4M	*	0	0	AAAAGATAAGGATA	*
+r003	16	chr1	16	10	6M14N5M	*	0	0	ATAGCTTCAGC	*
+`
+
+func testDB(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "samql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.sam")
+	if err := ioutil.WriteFile(path, []byte(samData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("samql", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	rows, err := db.Query("SELECT QNAME, POS, IDENTITY, REVERSE FROM aln WHERE MAPQ >= $minq",
+		sql.Named("minq", 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	var kinds []reflect.Kind
+	for _, ct := range types {
+		names = append(names, ct.DatabaseTypeName())
+		kinds = append(kinds, ct.ScanType().Kind())
+	}
+	if want := []string{"VARCHAR", "INTEGER", "FLOAT", "BOOLEAN"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("type names=%v want %v", names, want)
+	}
+	if want := []reflect.Kind{reflect.String, reflect.Int64, reflect.Float64, reflect.Bool}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("scan types=%v want %v", kinds, want)
+	}
+
+	var got []string
+	var positions []int
+	for rows.Next() {
+		var (
+			name     string
+			pos      int
+			identity float64
+			reverse  bool
+		)
+		if err := rows.Scan(&name, &pos, &identity, &reverse); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, name)
+		positions = append(positions, pos)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"r001", "r002"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("names=%v want %v", got, want)
+	}
+	if want := []int{6, 8}; !reflect.DeepEqual(positions, want) {
+		t.Errorf("positions=%v want %v", positions, want)
+	}
+}
+
+func TestQueryAggregate(t *testing.T) {
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	var (
+		count int64
+		mean  float64
+		min   sql.NullInt64
+	)
+	err := db.QueryRow("SELECT count(*), mean(MAPQ), min(POS) FROM aln WHERE REVERSE").
+		Scan(&count, &mean, &min)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || mean != 10 || !min.Valid || min.Int64 != 15 {
+		t.Errorf("count=%d mean=%v min=%v want 1 10 15", count, mean, min)
+	}
+
+	err = db.QueryRow("SELECT min(POS) FROM aln WHERE MAPQ > 100").Scan(&min)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if min.Valid {
+		t.Errorf("min=%v want NULL", min)
+	}
+}
+
+func TestQueryInvalid(t *testing.T) {
+	db, cleanup := testDB(t)
+	defer cleanup()
+
+	for _, tt := range []struct {
+		Query string
+		Args  []interface{}
+	}{
+		{Query: "SELECT * FROM aln"},
+		{Query: "SELECT MPAQ FROM aln"},
+		{Query: "SELECT QNAME FROM aln WHERE MAPQ > $minq", Args: []interface{}{30}},
+		{Query: "SELECT QNAME FROM aln WHERE MAPQ > $minq"},
+	} {
+		if rows, err := db.Query(tt.Query, tt.Args...); err == nil {
+			rows.Close()
+			t.Errorf("%s: expected error", tt.Query)
+		}
+	}
+	if _, err := db.Exec("SELECT QNAME FROM aln"); err == nil {
+		t.Errorf("expected error for Exec")
+	}
+	if _, err := db.Begin(); err == nil {
+		t.Errorf("expected error for Begin")
+	}
+
+	missing, err := sql.Open("samql", "missing.sam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer missing.Close()
+	if _, err := missing.Query("SELECT QNAME FROM aln"); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
@@ -0,0 +1,132 @@
+package samql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/biogo/hts/sam"
+)
+
+// Stats holds flagstat-like summary statistics of SAM records.
+type Stats struct {
+	Total          int `json:"total"`
+	Mapped         int `json:"mapped"`
+	Paired         int `json:"paired"`
+	ProperlyPaired int `json:"properly_paired"`
+	Duplicates     int `json:"duplicates"`
+	Secondary      int `json:"secondary"`
+	Supplementary  int `json:"supplementary"`
+	QCFail         int `json:"qc_fail"`
+
+	// MapQ is the histogram of the mapping qualities of mapped records.
+	MapQ map[int]int `json:"mapq"`
+	// Length is the histogram of the read lengths, i.e. the length of SEQ.
+	Length map[int]int `json:"length"`
+	// Refs holds the number of records for each reference. Records without
+	// a reference are counted as "*".
+	Refs map[string]int `json:"refs"`
+}
+
+// NewStats returns new empty Stats.
+func NewStats() *Stats {
+	return &Stats{
+		MapQ:   make(map[int]int),
+		Length: make(map[int]int),
+		Refs:   make(map[string]int),
+	}
+}
+
+// Add adds rec to the statistics.
+func (s *Stats) Add(rec *sam.Record) {
+	s.Total++
+	if rec.Flags&sam.Unmapped == 0 {
+		s.Mapped++
+		s.MapQ[int(rec.MapQ)]++
+	}
+	if rec.Flags&sam.Paired != 0 {
+		s.Paired++
+	}
+	if rec.Flags&sam.ProperPair != 0 {
+		s.ProperlyPaired++
+	}
+	if rec.Flags&sam.Duplicate != 0 {
+		s.Duplicates++
+	}
+	if rec.Flags&sam.Secondary != 0 {
+		s.Secondary++
+	}
+	if rec.Flags&sam.Supplementary != 0 {
+		s.Supplementary++
+	}
+	if rec.Flags&sam.QCFail != 0 {
+		s.QCFail++
+	}
+	s.Length[rec.Seq.Length]++
+	s.Refs[rec.Ref.Name()]++
+}
+
+// WriteText writes the statistics to w as tab separated sections. Each line
+// starts with the section name, i.e. SN for summary numbers, MAPQ and LEN
+// for the histograms and REF for the reference counts.
+func (s *Stats) WriteText(w io.Writer) error {
+	for _, sn := range []struct {
+		name string
+		val  int
+	}{
+		{"total", s.Total},
+		{"mapped", s.Mapped},
+		{"paired", s.Paired},
+		{"properly_paired", s.ProperlyPaired},
+		{"duplicates", s.Duplicates},
+		{"secondary", s.Secondary},
+		{"supplementary", s.Supplementary},
+		{"qc_fail", s.QCFail},
+	} {
+		if _, err := fmt.Fprintf(w, "SN\t%s\t%d\n", sn.name, sn.val); err != nil {
+			return err
+		}
+	}
+	if err := writeHistogram(w, "MAPQ", s.MapQ); err != nil {
+		return err
+	}
+	if err := writeHistogram(w, "LEN", s.Length); err != nil {
+		return err
+	}
+
+	refs := make([]string, 0, len(s.Refs))
+	for ref := range s.Refs {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		if _, err := fmt.Fprintf(w, "REF\t%s\t%d\n", ref, s.Refs[ref]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the statistics to w as a JSON object.
+func (s *Stats) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// writeHistogram writes the histogram h to w in ascending order of values.
+// Each line starts with name.
+func writeHistogram(w io.Writer, name string, h map[int]int) error {
+	vals := make([]int, 0, len(h))
+	for v := range h {
+		vals = append(vals, v)
+	}
+	sort.Ints(vals)
+	for _, v := range vals {
+		if _, err := fmt.Fprintf(w, "%s\t%d\t%d\n", name, v, h[v]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
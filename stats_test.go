@@ -0,0 +1,59 @@
+package samql
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	recs, err := newTestReader(t, "").ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStats()
+	for _, rec := range recs {
+		s.Add(rec)
+	}
+
+	want := &Stats{
+		Total:          8,
+		Mapped:         6,
+		Paired:         4,
+		ProperlyPaired: 2,
+		Duplicates:     1,
+		Secondary:      1,
+		Supplementary:  1,
+		QCFail:         1,
+		MapQ:           map[int]int{29: 1, 30: 5},
+		Length:         map[int]int{9: 1, 11: 3, 14: 1, 17: 1, 22: 1, 23: 1},
+		Refs:           map[string]int{"chr1": 4, "chr2": 1, "1": 1, "*": 2},
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("stats=%+v want %+v", s, want)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"SN\ttotal\t8\n", "MAPQ\t30\t5\n", "LEN\t11\t3\n", "REF\t*\t2\n"} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("text output missing %q", line)
+		}
+	}
+
+	buf.Reset()
+	if err := s.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := NewStats()
+	if err := json.Unmarshal(buf.Bytes(), got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("json stats=%+v want %+v", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+package samql
+
+import (
+	"fmt"
+
+	"github.com/biogo/hts/sam"
+)
+
+// TagFilter removes aux tags from records, e.g. large per-base tags such as
+// OQ, BI and BD that are not needed downstream.
+type TagFilter struct {
+	tags map[sam.Tag]bool
+	keep bool
+}
+
+// StripTags returns a TagFilter that removes the provided tags.
+func StripTags(tags ...string) (*TagFilter, error) {
+	return newTagFilter(tags, false)
+}
+
+// KeepTags returns a TagFilter that removes all tags except the provided
+// ones.
+func KeepTags(tags ...string) (*TagFilter, error) {
+	return newTagFilter(tags, true)
+}
+
+// newTagFilter returns a TagFilter for tags that are kept if keep is true and
+// removed otherwise.
+func newTagFilter(tags []string, keep bool) (*TagFilter, error) {
+	f := &TagFilter{tags: make(map[sam.Tag]bool), keep: keep}
+	for _, t := range tags {
+		if len(t) != 2 {
+			return nil, fmt.Errorf("samql: invalid tag %q", t)
+		}
+		f.tags[sam.NewTag(t)] = true
+	}
+	return f, nil
+}
+
+// Apply removes the tags of rec according to f.
+func (f *TagFilter) Apply(rec *sam.Record) {
+	n := 0
+	for _, aux := range rec.AuxFields {
+		if f.tags[aux.Tag()] == f.keep {
+			rec.AuxFields[n] = aux
+			n++
+		}
+	}
+	rec.AuxFields = rec.AuxFields[:n]
+}
@@ -0,0 +1,56 @@
+package samql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestTagFilter(t *testing.T) {
+	const data = "@SQ\tSN:chr1\tLN:45\n" +
+		"r001\t0\tchr1\t7\t30\t4M\t*\t0\t0\tTTAG\t*\tNM:i:1\tOQ:Z:IIII\tMD:Z:3A0\tBI:Z:@@@@\n"
+
+	for _, tt := range []struct {
+		Strip []string
+		Keep  []string
+		Want  string
+	}{
+		{Strip: []string{"OQ", "BI", "BD"}, Want: "NM:i:1\tMD:Z:3A0"},
+		{Strip: []string{"XX"}, Want: "NM:i:1\tOQ:Z:IIII\tMD:Z:3A0\tBI:Z:@@@@"},
+		{Keep: []string{"NM", "MD"}, Want: "NM:i:1\tMD:Z:3A0"},
+		{Keep: []string{}, Want: ""},
+	} {
+		sr, err := sam.NewReader(strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := sr.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var f *TagFilter
+		if tt.Keep != nil {
+			f, err = KeepTags(tt.Keep...)
+		} else {
+			f, err = StripTags(tt.Strip...)
+		}
+		if err != nil {
+			t.Fatalf("%v: unexpected error %q", tt, err.Error())
+		}
+		f.Apply(rec)
+
+		var tags []string
+		for _, aux := range rec.AuxFields {
+			tags = append(tags, aux.String())
+		}
+		if got := strings.Join(tags, "\t"); got != tt.Want {
+			t.Errorf("%v: tags=%q want %q", tt, got, tt.Want)
+		}
+	}
+
+	if _, err := StripTags("NM:i"); err == nil {
+		t.Errorf("expected error for invalid tag")
+	}
+}
@@ -0,0 +1,63 @@
+package samql
+
+import (
+	"io"
+
+	"github.com/biogo/hts/sam"
+)
+
+// TeeWriter writes each record to several writers, typically a Writer with
+// different filters for each output, so that several queries are evaluated
+// in a single pass over the records. Writers must not modify the records.
+type TeeWriter struct {
+	Filters []FilterFunc
+
+	writers []writerSAM
+}
+
+// NewTeeWriter returns a new TeeWriter that writes to writers. Writers that
+// implement io.Closer are closed when the TeeWriter is closed.
+func NewTeeWriter(writers ...writerSAM) *TeeWriter {
+	return &TeeWriter{
+		Filters: make([]FilterFunc, 0),
+		writers: writers,
+	}
+}
+
+// Add adds writer tw to w.
+func (w *TeeWriter) Add(tw writerSAM) {
+	w.writers = append(w.writers, tw)
+}
+
+// AppendFilter appends the provided filter to writer w. Records that do not
+// pass the filters of w are not written to any writer.
+func (w *TeeWriter) AppendFilter(f FilterFunc) {
+	w.Filters = append(w.Filters, f)
+}
+
+// Write writes rec to all writers if rec passes all filters.
+func (w *TeeWriter) Write(rec *sam.Record) error {
+	if !allTrue(rec, w.Filters) {
+		return nil
+	}
+	for _, tw := range w.writers {
+		if err := tw.Write(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes all writers that implement io.Closer. It returns the first
+// error but closes all writers.
+func (w *TeeWriter) Close() error {
+	var err error
+	for _, tw := range w.writers {
+		if c, ok := tw.(io.Closer); ok {
+			if e := c.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package samql
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestTeeWriter(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []string{"RNAME = chr1", "RNAME != chr1", "POS > 20"}
+	bufs := make([]bytes.Buffer, len(queries))
+	tee := NewTeeWriter()
+	for i, q := range queries {
+		w, err := NewSAMWriter(&bufs[i], sr.Header())
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := Where(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.AppendFilter(filter)
+		tee.Add(w)
+	}
+	filter, err := Where("FLAG >= 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tee.AppendFilter(filter)
+
+	records, err := NewReader(sr).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		if err := tee.Write(rec); err != nil {
+			t.Fatalf("unexpected write error %q", err.Error())
+		}
+	}
+	if err := tee.Close(); err != nil {
+		t.Fatalf("unexpected close error %q", err.Error())
+	}
+
+	for i, want := range []int{4, 4, 3} {
+		r, err := sam.NewReader(&bufs[i])
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", queries[i], err.Error())
+		}
+		got, err := NewReader(r).ReadAll()
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", queries[i], err.Error())
+		}
+		if len(got) != want {
+			t.Errorf("%s: records=%d want %d", queries[i], len(got), want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package samql
+
+import (
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// TraceStep is the value of a subexpression of a WHERE clause for a record.
+type TraceStep struct {
+	// Expr is the subexpression, e.g. MAPQ > 10.
+	Expr string
+	// Depth is the nesting level of Expr in the condition; the condition
+	// itself is at depth 0.
+	Depth int
+	// Value is the value of Expr for the record, e.g. true or 30.
+	Value interface{}
+}
+
+// Tracer evaluates each subexpression of a WHERE clause for records, e.g.
+// to show why records unexpectedly match or not. Unlike a filter, a Tracer
+// evaluates all subexpressions, in the written order, and is slow.
+// Subexpressions that do not depend on the record, e.g. literals, are not
+// traced.
+type Tracer struct {
+	steps  []TraceStep
+	values []valueFunc
+}
+
+// NewTracer returns a Tracer for the condition of the WHERE clause query
+// that is evaluated with opts, as the filters of Where.
+func NewTracer(query string, opts ...FilterOption) (*Tracer, error) {
+	return newTracer(query, withOptions(inputVars(""), opts))
+}
+
+// NewTracerHeader is similar to NewTracer but binds the keywords of records
+// read from input with header h, as WhereHeader.
+func NewTracerHeader(query, input string, h *sam.Header, opts ...FilterOption) (*Tracer, error) {
+	return newTracer(query, withOptions(headerVars(input, h), opts))
+}
+
+// newTracer returns a Tracer for the condition of the WHERE clause query.
+// Variable references that match a key in vars are resolved to the
+// corresponding value.
+func newTracer(query string, vars map[string]interface{}) (*Tracer, error) {
+	// Invalid queries report the errors of compile.
+	if _, err := compile(query, vars); err != nil {
+		return nil, err
+	}
+	stmt, err := parseWhere(query)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tracer{}
+	if stmt.Condition != nil {
+		t.add(stmt.Condition, 0, vars)
+	}
+	return t, nil
+}
+
+// add adds the steps of expr and its subexpressions at depth. The operands
+// of a chain of AND or OR are at the same depth.
+func (t *Tracer) add(expr ql.Expr, depth int, vars map[string]interface{}) {
+	var children []ql.Expr
+	switch e := expr.(type) {
+	case *ql.ParenExpr:
+		t.add(e.Expr, depth, vars)
+		return
+	case *ql.BinaryExpr:
+		switch {
+		case e.Op == ql.AND || e.Op == ql.OR:
+			children = operands(e, e.Op)
+		default:
+			children = []ql.Expr{e.LHS, e.RHS}
+			if r, ok := e.RHS.(*ql.RangeExpr); ok {
+				children = []ql.Expr{e.LHS, r.Lower, r.Upper}
+			}
+		}
+	case *ql.NotExpr:
+		children = []ql.Expr{e.Expr}
+	case *ql.Call:
+		children = e.Args
+	case *ql.IndexExpr, *ql.VarRef:
+	default:
+		return
+	}
+
+	if fn, ok := traceValueFunc(expr, vars); ok {
+		t.steps = append(t.steps, TraceStep{Expr: expr.String(), Depth: depth})
+		t.values = append(t.values, fn)
+		depth++
+	}
+	for _, c := range children {
+		t.add(c, depth, vars)
+	}
+}
+
+// traceValueFunc returns a valueFunc that evaluates expr for a record and
+// true if the value of expr depends on the record.
+func traceValueFunc(expr ql.Expr, vars map[string]interface{}) (valueFunc, bool) {
+	v := evalVisitor{vars: vars}
+	ql.Walk(&v, expr)
+	if v.err != nil || len(v.nodes) != 1 {
+		return nil, false
+	}
+	switch n := v.nodes[0].(type) {
+	case placeholderInt:
+		return func(rec *sam.Record) interface{} { return n(rec) }, true
+	case placeholderFloat:
+		return func(rec *sam.Record) interface{} { return n(rec) }, true
+	case placeholderStr:
+		return func(rec *sam.Record) interface{} { return n(rec) }, true
+	case placeholderBool:
+		return func(rec *sam.Record) interface{} { return n(rec) }, true
+	case FilterFunc:
+		return func(rec *sam.Record) interface{} { return n(rec) }, true
+	}
+	return nil, false
+}
+
+// Trace returns the values of the subexpressions of the condition for rec,
+// each followed by its subexpressions.
+func (t *Tracer) Trace(rec *sam.Record) []TraceStep {
+	steps := make([]TraceStep, len(t.steps))
+	for i, s := range t.steps {
+		s.Value = t.values[i](rec)
+		steps[i] = s
+	}
+	return steps
+}
@@ -0,0 +1,53 @@
+package samql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestTracer(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := NewReader(sr).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := NewTracer("RNAME = chr1 AND (MAPQ > 10 OR NOT has(NM)) AND length(SEQ) BETWEEN 5 AND 4 * POS")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	want := []TraceStep{
+		{Expr: "RNAME = chr1 AND (MAPQ > 10 OR NOT has(NM)) AND length(SEQ) BETWEEN 5 AND 4 * POS", Depth: 0, Value: true},
+		{Expr: "RNAME = chr1", Depth: 1, Value: true},
+		{Expr: "RNAME", Depth: 2, Value: "chr1"},
+		{Expr: "MAPQ > 10 OR NOT has(NM)", Depth: 1, Value: true},
+		{Expr: "MAPQ > 10", Depth: 2, Value: true},
+		{Expr: "MAPQ", Depth: 3, Value: 30},
+		{Expr: "NOT has(NM)", Depth: 2, Value: true},
+		{Expr: "has(NM)", Depth: 3, Value: false},
+		{Expr: "length(SEQ) BETWEEN 5 AND 4 * POS", Depth: 1, Value: true},
+		{Expr: "length(SEQ)", Depth: 2, Value: 17},
+		{Expr: "SEQ", Depth: 3, Value: "TTAGATAAAGGATACTG"},
+		{Expr: "4 * POS", Depth: 2, Value: 24},
+		{Expr: "POS", Depth: 3, Value: 6},
+	}
+	if got := tr.Trace(records[0]); !reflect.DeepEqual(got, want) {
+		t.Errorf("trace=%v want %v", got, want)
+	}
+
+	// The trace of r004 on chr2 has the same steps with other values.
+	got := tr.Trace(records[4])
+	if len(got) != len(want) || got[0].Value != false || got[2].Value != "chr2" {
+		t.Errorf("trace=%v", got)
+	}
+
+	if _, err := NewTracer("MAPQ > "); err == nil {
+		t.Errorf("expected error")
+	}
+}
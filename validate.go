@@ -0,0 +1,306 @@
+package samql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+// Diagnostic is a problem found in a query by Validate. Pos is the position
+// of the problem in the query.
+type Diagnostic struct {
+	Message string
+	Pos     ql.Pos
+}
+
+// Error returns the message and the position of d, as ql.ParseError.
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s at line %d, char %d", d.Message, d.Pos.Line+1, d.Pos.Char+1)
+}
+
+// Validate checks the SQL WHERE statement query for problems that would
+// otherwise be found only when records are read or that make it silently
+// match no records: syntax errors, unknown fields, e.g. MPAQ for MAPQ,
+// invalid tags, comparisons of incompatible types and, if h is not nil,
+// reference names that are not in h. It returns nil if query is valid.
+// Functions that depend on options, e.g. feature_name, are checked with opts.
+func Validate(query string, h *sam.Header, opts ...FilterOption) []*Diagnostic {
+	vars := unboundVars()
+	if h != nil {
+		vars = headerVars("", h)
+	}
+	return validate(query, withOptions(vars, opts), h)
+}
+
+// validate is similar to Validate but resolves the variable references in
+// query that match a key in vars to the corresponding value.
+func validate(query string, vars map[string]interface{}, h *sam.Header) []*Diagnostic {
+	stmt, err := parseWhere(query)
+	if err != nil {
+		if e, ok := err.(*ql.ParseError); ok {
+			msg := e.Message
+			if msg == "" {
+				msg = fmt.Sprintf("found %s, expected %s", e.Found, strings.Join(e.Expected, ", "))
+			}
+			return []*Diagnostic{{Message: msg, Pos: e.Pos}}
+		}
+		return []*Diagnostic{{Message: err.Error()}}
+	}
+
+	v := &validator{vars: vars, h: h, pos: tokenPositions(query)}
+	ql.WalkFunc(stmt.Condition, v.visit)
+
+	// Problems that are not found in a single comparison, e.g. a condition
+	// that is not boolean, are reported at the start of the query.
+	if len(v.diags) == 0 {
+		if _, err := plan(query, vars); err != nil {
+			v.diags = append(v.diags, &Diagnostic{Message: err.Error()})
+		}
+	}
+	return v.diags
+}
+
+// validator collects the diagnostics of the nodes of a query.
+type validator struct {
+	vars  map[string]interface{}
+	h     *sam.Header
+	pos   map[string]ql.Pos
+	diags []*Diagnostic
+}
+
+// addf adds a diagnostic at the position of the token lit.
+func (v *validator) addf(lit string, format string, args ...interface{}) {
+	v.diags = append(v.diags, &Diagnostic{
+		Message: fmt.Sprintf(format, args...), Pos: v.pos[lit]})
+}
+
+// visit checks node and returns false if its children should not be
+// checked.
+func (v *validator) visit(node ql.Node) bool {
+	switch n := node.(type) {
+	case *ql.VarRef:
+		if strings.Contains(n.Val, ":") && !v.isField(n.Val) {
+			v.addf(n.Val, "invalid tag %s; tags are written as XX:T with type T one of A, i, f, Z, H or B", n.Val)
+		}
+	case *ql.Call:
+		if n.Cmd == "has" {
+			return false
+		}
+		if !isFunction(n.Cmd) {
+			v.addf(n.Cmd, "unknown function %s", n.Cmd)
+		}
+	case *ql.BinaryExpr:
+		if !isComparison(n.Op) || v.hasNameError(n) {
+			return true
+		}
+		// A comparison of an unknown word with a value is usually a
+		// misspelled field.
+		if ref, ok := n.LHS.(*ql.VarRef); ok && !v.isField(ref.Val) &&
+			!strings.Contains(ref.Val, ":") && !v.hasField(n.RHS) {
+			msg := "unknown field " + ref.Val
+			if s := suggestField(ref.Val); s != "" {
+				msg += "; did you mean " + s + "?"
+			}
+			v.addf(ref.Val, "%s", msg)
+			return false
+		}
+		if _, err := newFilter(n, v.vars); err != nil {
+			v.addf(firstRef(n), "%v", err)
+			return false
+		}
+		v.checkRefs(n)
+	}
+	return true
+}
+
+// checkRefs adds a diagnostic for each reference name that is compared to
+// RNAME or RNEXT in n and is not in the header.
+func (v *validator) checkRefs(n *ql.BinaryExpr) {
+	if v.h == nil || (n.Op != ql.EQ && n.Op != ql.NEQ && n.Op != ql.IN) {
+		return
+	}
+	if ref, ok := n.LHS.(*ql.VarRef); !ok || (ref.Val != "RNAME" && ref.Val != "RNEXT") {
+		return
+	}
+	var names []string
+	switch rhs := n.RHS.(type) {
+	case *ql.VarRef:
+		names = append(names, rhs.Val)
+	case *ql.StringLiteral:
+		names = append(names, rhs.Val)
+	case *ql.ListLiteral:
+		for _, e := range rhs.Vals {
+			if e, ok := e.(*ql.StringLiteral); ok {
+				names = append(names, e.Val)
+			}
+		}
+	}
+	for _, name := range names {
+		if name == "*" || name == "=" {
+			continue
+		}
+		found := false
+		for _, r := range v.h.Refs() {
+			if r.Name() == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.addf(name, "reference %s is not in the header", name)
+		}
+	}
+}
+
+// isField returns true if name is a keyword, a variable or an aux tag.
+func (v *validator) isField(name string) bool {
+	if _, ok := getPlaceholder[name]; ok {
+		return true
+	}
+	if _, ok := v.vars[name]; ok {
+		return true
+	}
+	return len(name) == 4 && validTag.MatchString(name)
+}
+
+// hasNameError returns true if expr contains an invalid tag or an unknown
+// function, which are reported when its nodes are visited.
+func (v *validator) hasNameError(expr ql.Expr) bool {
+	found := false
+	ql.WalkFunc(expr, func(n ql.Node) bool {
+		switch n := n.(type) {
+		case *ql.VarRef:
+			found = found || (strings.Contains(n.Val, ":") && !v.isField(n.Val))
+		case *ql.Call:
+			if n.Cmd == "has" {
+				return false
+			}
+			found = found || !isFunction(n.Cmd)
+		}
+		return !found
+	})
+	return found
+}
+
+// hasField returns true if expr refers to a field or calls a function.
+func (v *validator) hasField(expr ql.Expr) bool {
+	found := false
+	ql.WalkFunc(expr, func(n ql.Node) bool {
+		switch n := n.(type) {
+		case *ql.VarRef:
+			found = found || v.isField(n.Val)
+		case *ql.Call, *ql.IndexExpr:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// isComparison returns true if op compares two values.
+func isComparison(op ql.Token) bool {
+	switch op {
+	case ql.EQ, ql.NEQ, ql.LT, ql.LTE, ql.GT, ql.GTE, ql.EQREGEX, ql.NEQREGEX,
+		ql.EQFOLD, ql.NEQFOLD, ql.IN, ql.BETWEEN, ql.IS, ql.ISNOT:
+		return true
+	}
+	return false
+}
+
+// firstRef returns the first variable reference in expr or an empty string.
+func firstRef(expr ql.Expr) string {
+	name := ""
+	ql.WalkFunc(expr, func(n ql.Node) bool {
+		if ref, ok := n.(*ql.VarRef); ok && name == "" {
+			name = ref.Val
+		}
+		return name == ""
+	})
+	return name
+}
+
+// tokenPositions returns the position of the first occurrence of each
+// identifier, string and function name in query.
+func tokenPositions(query string) map[string]ql.Pos {
+	pos := make(map[string]ql.Pos)
+	s := ql.NewScanner(strings.NewReader(query))
+	for {
+		tok, p, lit := s.Scan()
+		if tok == ql.EOF {
+			return pos
+		}
+		if _, ok := pos[lit]; ok {
+			continue
+		}
+		switch tok {
+		case ql.IDENT:
+			pos[lit] = p
+		case ql.STRING:
+			// The scanner does not return the position of the opening
+			// quote, so the string is located in the query.
+			if i := strings.Index(query, "'"+lit+"'"); i >= 0 {
+				pos[lit] = offsetPos(query[:i])
+			}
+		}
+	}
+}
+
+// offsetPos returns the position of the end of the query prefix s.
+func offsetPos(s string) ql.Pos {
+	line := strings.Count(s, "\n")
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		s = s[i+1:]
+	}
+	return ql.Pos{Line: line, Char: len([]rune(s))}
+}
+
+// suggestField returns the keyword that is closest to the unknown field name,
+// if it differs by at most two edits, or one for short names, or an empty
+// string.
+func suggestField(name string) string {
+	upper := strings.ToUpper(name)
+	best, bestDist := "", 3
+	if len(name) <= 3 {
+		bestDist = 2
+	}
+	for kw := range getPlaceholder {
+		if d := levenshtein(upper, kw); d < bestDist || (d == bestDist && kw < best) {
+			best, bestDist = kw, d
+		}
+	}
+	return best
+}
+
+// levenshtein returns the Levenshtein distance of a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// min3 returns the minimum of a, b and c.
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,76 @@
+package samql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/maragkakislab/samql/ql"
+)
+
+func TestValidate(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader("@SQ\tSN:chr1\tLN:300\n@SQ\tSN:chr2\tLN:100\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sr.Header()
+
+	for _, tt := range []struct {
+		Query string
+		Diags []string
+	}{
+		{Query: "MAPQ > 30 AND RNAME = chr1"},
+		{Query: "NH:i = 1 AND has(XS) AND length(SEQ) > 10"},
+		{Query: "RNAME IN ('chr1', 'chr2') AND RNEXT = '='"},
+		{Query: "mapq > 30", Diags: []string{"unknown field mapq; did you mean MAPQ? at line 1, char 1"}},
+		{
+			Query: "MPAQ > 30",
+			Diags: []string{"unknown field MPAQ; did you mean MAPQ? at line 1, char 1"},
+		},
+		{
+			Query: "RNAME = chr1 AND FOO = 1",
+			Diags: []string{"unknown field FOO at line 1, char 18"},
+		},
+		{
+			Query: "MAPQ > 30 AND NH:x = 1",
+			Diags: []string{"invalid tag NH:x; tags are written as XX:T with type T one of A, i, f, Z, H or B at line 1, char 15"},
+		},
+		{
+			Query: "POS > 1 AND MAPQ = 'a'",
+			Diags: []string{`invalid comparison MAPQ = 'a': integer field can only be compared to numbers, found string "a" at line 1, char 13`},
+		},
+		{
+			Query: "RNAME = chr3 OR RNEXT IN ('chr4')",
+			Diags: []string{
+				"reference chr3 is not in the header at line 1, char 9",
+				"reference chr4 is not in the header at line 1, char 27",
+			},
+		},
+		{
+			Query: "foo(SEQ) > 1",
+			Diags: []string{"unknown function foo at line 1, char 1"},
+		},
+		{
+			Query: "MAPQ >",
+			Diags: []string{"found EOF, expected identifier, string, number, bool at line 1, char 7"},
+		},
+	} {
+		diags := Validate(tt.Query, h)
+		if len(diags) != len(tt.Diags) {
+			t.Errorf("%s: diagnostics=%v want %v", tt.Query, diags, tt.Diags)
+			continue
+		}
+		for i, d := range diags {
+			if d.Error() != tt.Diags[i] {
+				t.Errorf("%s: diagnostic=%q want %q", tt.Query, d.Error(), tt.Diags[i])
+			}
+		}
+	}
+
+	if diags := Validate("RNAME = chr3", nil); diags != nil {
+		t.Errorf("unexpected diagnostics without header %v", diags)
+	}
+	if diags := Validate("MPAQ > 30", nil); len(diags) != 1 || diags[0].Pos != (ql.Pos{}) {
+		t.Errorf("diagnostics=%v want one at the start", diags)
+	}
+}
@@ -0,0 +1,149 @@
+package samql
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/sam"
+)
+
+// writerSAM is the interface for SAM/BAM writers, such as sam.Writer and
+// bam.Writer.
+type writerSAM interface {
+	Write(*sam.Record) error
+}
+
+// Writer implements a SAM/BAM record writer that writes only the records
+// that pass all filters.
+type Writer struct {
+	w       writerSAM
+	buf     *bufio.Writer
+	Filters []FilterFunc
+}
+
+// NewWriter returns a new samql Writer that writes to w. w is typically a
+// sam.Writer or a bam.Writer. Writers that implement io.Closer are closed when
+// the Writer is closed.
+func NewWriter(w writerSAM) *Writer {
+	return &Writer{
+		w:       w,
+		Filters: make([]FilterFunc, 0),
+	}
+}
+
+// NewSAMWriter returns a new samql Writer that writes SAM records and header
+// h to w. Output is buffered and is written to w on Flush or Close.
+func NewSAMWriter(w io.Writer, h *sam.Header) (*Writer, error) {
+	buf := bufio.NewWriter(w)
+	sw, err := sam.NewWriter(buf, h, sam.FlagDecimal)
+	if err != nil {
+		return nil, err
+	}
+	sqw := NewWriter(sw)
+	sqw.buf = buf
+	return sqw, nil
+}
+
+// NewBAMWriter returns a new samql Writer that writes BAM records and header
+// h to w using wc concurrent compressors. If wc is zero all available cores
+// are used. Output is buffered and is written to w on Flush or Close. As BGZF
+// blocks are completed only when full, a BAM stream is complete only after
+// Close.
+func NewBAMWriter(w io.Writer, h *sam.Header, wc int) (*Writer, error) {
+	return NewBAMWriterLevel(w, h, gzip.DefaultCompression, wc)
+}
+
+// NewBAMWriterLevel returns a new samql Writer as NewBAMWriter that
+// compresses with the provided compression level, from gzip.NoCompression,
+// for fast piping between tools, to gzip.BestCompression, or
+// gzip.DefaultCompression.
+func NewBAMWriterLevel(w io.Writer, h *sam.Header, level, wc int) (*Writer, error) {
+	buf := bufio.NewWriter(w)
+	bw, err := bam.NewWriterLevel(buf, h, level, wc)
+	if err != nil {
+		return nil, err
+	}
+	sqw := NewWriter(bw)
+	sqw.buf = buf
+	return sqw, nil
+}
+
+// NewSAMGzipWriter returns a new samql Writer that writes SAM records and
+// header h to w compressed with gzip, as gzip does for .sam.gz files. The
+// compressed stream is complete only after Close.
+func NewSAMGzipWriter(w io.Writer, h *sam.Header) (*Writer, error) {
+	buf := bufio.NewWriter(w)
+	return newCompressedSAMWriter(buf, gzip.NewWriter(buf), h)
+}
+
+// NewSAMBGZFWriter returns a new samql Writer that writes SAM records and
+// header h to w compressed with BGZF using wc concurrent compressors, as
+// bgzip does. The compressed
+// stream is complete only after Close.
+func NewSAMBGZFWriter(w io.Writer, h *sam.Header, wc int) (*Writer, error) {
+	buf := bufio.NewWriter(w)
+	return newCompressedSAMWriter(buf, bgzf.NewWriter(buf, wc), h)
+}
+
+// newCompressedSAMWriter returns a new samql Writer that writes SAM records
+// and header h to the compressor zw, which writes to buf.
+func newCompressedSAMWriter(buf *bufio.Writer, zw io.WriteCloser, h *sam.Header) (*Writer, error) {
+	sw, err := sam.NewWriter(zw, h, sam.FlagDecimal)
+	if err != nil {
+		return nil, err
+	}
+	sqw := NewWriter(&compressedSAMWriter{Writer: sw, zw: zw})
+	sqw.buf = buf
+	return sqw, nil
+}
+
+// compressedSAMWriter is a SAM writer that closes its compressor on Close.
+type compressedSAMWriter struct {
+	*sam.Writer
+	zw io.WriteCloser
+}
+
+// Close closes the compressor, which writes the end of the compressed
+// stream. It does not close the underlying io.Writer.
+func (w *compressedSAMWriter) Close() error {
+	return w.zw.Close()
+}
+
+// AppendFilter appends the provided filter to writer w.
+func (w *Writer) AppendFilter(f FilterFunc) {
+	w.Filters = append(w.Filters, f)
+}
+
+// Write writes rec to the underlying writer if rec passes all filters.
+// Records that do not pass are silently skipped.
+func (w *Writer) Write(rec *sam.Record) error {
+	if !allTrue(rec, w.Filters) {
+		return nil
+	}
+	return w.w.Write(rec)
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (w *Writer) Flush() error {
+	if w.buf == nil {
+		return nil
+	}
+	return w.buf.Flush()
+}
+
+// Close closes the underlying writer if it implements io.Closer, such as the
+// BAM writer, and flushes any buffered data. It does not close the
+// underlying io.Writer.
+func (w *Writer) Close() error {
+	var err error
+	if c, ok := w.w.(io.Closer); ok {
+		err = c.Close()
+	}
+	if e := w.Flush(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
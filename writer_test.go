@@ -0,0 +1,147 @@
+package samql
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+func TestWriter(t *testing.T) {
+	for _, tt := range []struct {
+		Test   string
+		BAM    bool
+		Gzip   bool
+		BGZF   bool
+		Query  string
+		RecCnt int
+	}{
+		{Test: "SAM", Query: "", RecCnt: 8},
+		{Test: "SAMFilter", Query: "RNAME = chr1", RecCnt: 4},
+		{Test: "BAM", BAM: true, Query: "", RecCnt: 8},
+		{Test: "BAMFilter", BAM: true, Query: "POS > 20", RecCnt: 3},
+		{Test: "SAMGzip", Gzip: true, Query: "", RecCnt: 8},
+		{Test: "SAMBGZF", BGZF: true, Query: "RNAME = chr1", RecCnt: 4},
+	} {
+		sr, err := sam.NewReader(strings.NewReader(samData))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		var w *Writer
+		switch {
+		case tt.BAM:
+			w, err = NewBAMWriter(&buf, sr.Header(), 1)
+		case tt.Gzip:
+			w, err = NewSAMGzipWriter(&buf, sr.Header())
+		case tt.BGZF:
+			w, err = NewSAMBGZFWriter(&buf, sr.Header(), 1)
+		default:
+			w, err = NewSAMWriter(&buf, sr.Header())
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+		if tt.Query != "" {
+			filter, err := Where(tt.Query)
+			if err != nil {
+				t.Fatalf("%s: unexpected error %q", tt.Test, err.Error())
+			}
+			w.AppendFilter(filter)
+		}
+
+		records, err := NewReader(sr).ReadAll()
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+		for _, rec := range records {
+			if err := w.Write(rec); err != nil {
+				t.Errorf("%s: unexpected write error %q", tt.Test, err.Error())
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("%s: unexpected close error %q", tt.Test, err.Error())
+		}
+
+		// Read back the written records.
+		format, r, err := DetectFormat(&buf)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+		if want := map[bool]Format{false: SAM, true: BAM}[tt.BAM]; format != want {
+			t.Errorf("%s: format=%s want %s", tt.Test, format, want)
+		}
+		if _, ok := r.(*gzip.Reader); ok != (tt.Gzip || tt.BGZF) {
+			t.Errorf("%s: decompressed=%v want %v", tt.Test, ok, tt.Gzip || tt.BGZF)
+		}
+		var src readerSAM
+		if tt.BAM {
+			src, err = bam.NewReader(r, 1)
+		} else {
+			src, err = sam.NewReader(r)
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+		written, err := NewReader(src).ReadAll()
+		if err != nil {
+			t.Errorf("%s: unexpected error %q", tt.Test, err.Error())
+		}
+		if l := len(written); l != tt.RecCnt {
+			t.Errorf("%s: record count=%d want %d", tt.Test, l, tt.RecCnt)
+		}
+	}
+}
+
+func TestBAMWriterLevel(t *testing.T) {
+	sr, err := sam.NewReader(strings.NewReader(samData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := NewReader(sr).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int
+	for _, level := range []int{gzip.NoCompression, gzip.BestCompression} {
+		var buf bytes.Buffer
+		w, err := NewBAMWriterLevel(&buf, sr.Header(), level, 1)
+		if err != nil {
+			t.Fatalf("level %d: unexpected error %q", level, err.Error())
+		}
+		for _, rec := range records {
+			if err := w.Write(rec); err != nil {
+				t.Errorf("level %d: unexpected write error %q", level, err.Error())
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("level %d: unexpected close error %q", level, err.Error())
+		}
+		sizes = append(sizes, buf.Len())
+
+		br, err := bam.NewReader(&buf, 1)
+		if err != nil {
+			t.Fatalf("level %d: unexpected error %q", level, err.Error())
+		}
+		written, err := NewReader(br).ReadAll()
+		if err != nil {
+			t.Errorf("level %d: unexpected error %q", level, err.Error())
+		}
+		if len(written) != len(records) {
+			t.Errorf("level %d: record count=%d want %d", level, len(written), len(records))
+		}
+	}
+	if sizes[0] <= sizes[1] {
+		t.Errorf("uncompressed size=%d want more than %d", sizes[0], sizes[1])
+	}
+
+	if _, err := NewBAMWriterLevel(ioutil.Discard, sr.Header(), 10, 1); err == nil {
+		t.Errorf("level 10: expected error")
+	}
+}